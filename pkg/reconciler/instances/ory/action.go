@@ -2,6 +2,11 @@ package ory
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/hydra"
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s"
@@ -9,7 +14,6 @@ import (
 
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/chart"
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/db"
-	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/jwks"
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/service"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -26,6 +30,30 @@ const (
 	jwksAlg         = "RSA256"
 	jwksBits        = 2048
 	hydraDeployment = "ory-hydra"
+	// hydraMaesterDeployment and postgresqlStatefulSet are only checked for readiness
+	hydraMaesterDeployment = "ory-hydra-maester"
+	postgresqlStatefulSet  = "ory-postgresql"
+	// skipFinalizerCleanupKey disables the finalizer cleanup during deletion for clusters where operators manage
+	// the finalizers of ory custom resources themselves
+	skipFinalizerCleanupKey = "ory.skipFinalizerCleanup"
+	// hydraPersistenceAnnotation records on the hydra deployment the persistence mode its pods were last started with
+	hydraPersistenceAnnotation = "reconciler.kyma-project.io/hydra-persistence"
+	// deleteHelmReleaseSecretsKey enables deleting the helm release secrets of ory left behind by a forced removal
+	deleteHelmReleaseSecretsKey = "ory.deleteHelmReleaseSecrets"
+	// stuckWorkloadsThresholdKey enables force deleting ory pods and jobs terminating for longer than the given duration
+	stuckWorkloadsThresholdKey = "ory.stuckWorkloadsThreshold"
+	// allowDataLossKey enables deleting the persistent volume claims of ory, which destroys the OAuth2 client database
+	allowDataLossKey   = "ory.allowDataLoss"
+	pvcDeletionTimeout = 2 * time.Minute
+	// readinessTimeoutKey overrides how long to wait for the ory workloads to become ready after a reconciliation
+	readinessTimeoutKey     = "ory.readinessTimeout"
+	defaultReadinessTimeout = 5 * time.Minute
+	// skipHydraHealthCheckKey disables calling the health endpoints of hydra after a reconciliation, for clusters
+	// where network policies keep the API server proxy from reaching the hydra admin service
+	skipHydraHealthCheckKey = "ory.skipHydraHealthCheck"
+	// hydraHealthCheckTimeoutKey overrides how long the health endpoints of hydra are retried after a reconciliation
+	hydraHealthCheckTimeoutKey     = "ory.hydraHealthCheckTimeout"
+	defaultHydraHealthCheckTimeout = 2 * time.Minute
 )
 
 type oryAction struct {
@@ -34,23 +62,64 @@ type oryAction struct {
 
 type preReconcileAction struct {
 	*oryAction
+	jwksHandler k8s.JWKSHandler
 }
 
 type postReconcileAction struct {
 	*oryAction
-	hydraSyncer    hydra.Syncer
-	rolloutHandler k8s.RolloutHandler
+	hydraSyncer      hydra.Syncer
+	rolloutHandler   k8s.RolloutHandler
+	readinessChecker k8s.ReadinessChecker
+	healthChecker    hydra.HealthChecker
+}
+
+type preDeleteAction struct {
+	*oryAction
+	newOryFinalizersHandler oryFinalizersHandlerFactory
 }
 
 type postDeleteAction struct {
 	*oryAction
-	oryFinalizersHandler k8s.OryFinalizersHandler
+	newOryFinalizersHandler oryFinalizersHandlerFactory
+}
+
+// oryFinalizersHandlerFactory creates a finalizers handler logging to the logger of the running reconciliation
+type oryFinalizersHandlerFactory func(logger *zap.SugaredLogger) k8s.OryFinalizersHandler
+
+func newDefaultOryFinalizersHandler(logger *zap.SugaredLogger) k8s.OryFinalizersHandler {
+	return k8s.NewDefaultOryFinalizersHandler(k8s.WithLogger(logger))
+}
+
+// finalizerCleanupError distinguishes a failed removal of ory finalizers from a failed deletion of the chart. It
+// carries the result of the cleanup, whose summary becomes part of the error reported for the reconciliation.
+type finalizerCleanupError struct {
+	err    error
+	result *k8s.CleanupResult
+}
+
+func (e *finalizerCleanupError) Error() string {
+	if e.result == nil {
+		return fmt.Sprintf("cleanup of ory finalizers failed: %s", e.err)
+	}
+	return fmt.Sprintf("cleanup of ory finalizers failed (%s): %s", summarizeCleanup(e.result), e.err)
+}
+
+func (e *finalizerCleanupError) Unwrap() error {
+	return e.err
+}
+
+// summarizeCleanup describes the outcome of removing ory finalizers for the log and status of the reconciliation
+func summarizeCleanup(result *k8s.CleanupResult) string {
+	return fmt.Sprintf("removed %d finalizers of %d crds in %s with %d failures",
+		result.RemovedFinalizers(), len(result.CRDs), result.Elapsed.Round(time.Millisecond), len(result.Failures()))
 }
 
 var (
 	jwksNamespacedName = types.NamespacedName{Name: "ory-oathkeeper-jwks-secret", Namespace: oryNamespace}
 	dbNamespacedName   = types.NamespacedName{Name: "ory-hydra-credentials", Namespace: oryNamespace}
-	rolloutHydra       = false
+	// the access rules of oathkeeper-maester are only removed once this deployment is gone or being deleted
+	oathkeeperNamespacedName = types.NamespacedName{Name: "ory-oathkeeper", Namespace: oryNamespace}
+	rolloutHydra             = false
 )
 
 func (a *postReconcileAction) Run(context *service.ActionContext) error {
@@ -66,6 +135,10 @@ func (a *postReconcileAction) Run(context *service.ActionContext) error {
 		}
 	}
 
+	if err := a.recordPersistenceMode(context.Context, kubeclient, cfg, logger); err != nil {
+		return err
+	}
+
 	if isInMemoryMode(cfg) {
 		logger.Debug("Detected in hydra in memory mode, triggering synchronization")
 		err = a.hydraSyncer.TriggerSynchronization(context.Context, kubeclient, logger, oryNamespace, rolloutHydra)
@@ -76,13 +149,29 @@ func (a *postReconcileAction) Run(context *service.ActionContext) error {
 		logger.Debug("Hydra is in persistence mode, no synchronization needed")
 	}
 
+	if err := a.waitForWorkloads(context.Context, kubeclient, cfg, logger, readinessTimeout(context.Task.Configuration, logger)); err != nil {
+		return err
+	}
+
+	if isEnabled(context.Task.Configuration, skipHydraHealthCheckKey) {
+		logger.Infof("Skipping health check of hydra as '%s' is set", skipHydraHealthCheckKey)
+	} else {
+		timeout := configuredDuration(context.Task.Configuration, hydraHealthCheckTimeoutKey, logger)
+		if timeout == 0 {
+			timeout = defaultHydraHealthCheckTimeout
+		}
+		if err := a.healthChecker.CheckHealth(context.Context, kubeclient, logger, oryNamespace, timeout); err != nil {
+			return errors.Wrap(err, "Hydra is not healthy after the reconciliation")
+		}
+	}
+
 	logger.Debugf("Action '%s' executed (passed version was '%s')", a.step, context.Task.Version)
 
 	return nil
 }
 
 func (a *preReconcileAction) Run(context *service.ActionContext) error {
-	logger, kubeClient, _, values, err := readActionContext(context)
+	logger, kubeClient, cfg, values, err := readActionContext(context)
 	if err != nil {
 		return errors.Wrap(err, "Failed to read preReconcileAction context")
 	}
@@ -90,38 +179,30 @@ func (a *preReconcileAction) Run(context *service.ActionContext) error {
 	if err != nil {
 		return errors.Wrap(err, "Failed to retrieve clientset")
 	}
-	_, err = getSecret(context.Context, client, jwksNamespacedName)
-	if err != nil {
-		if !kerrors.IsNotFound(err) {
-			return errors.Wrap(err, "Could not get JWKS secret")
-		}
-
-		logger.Info("Ory JWKS secret does not exist, creating it now")
-		jwksSecretObject, err := jwks.Get(jwksNamespacedName, jwksAlg, jwksBits)
-		if err != nil {
-			return errors.Wrap(err, "failed to create jwks secret for ORY OathKeeper")
-		}
-		if err := createSecret(context.Context, client, jwksNamespacedName, *jwksSecretObject, logger); err != nil {
-			return err
-		}
+	_, err = a.jwksHandler.EnsureJWKSSecret(context.Context, client, logger, jwksNamespacedName)
+	if k8s.IsMalformedJWKSSecretError(err) {
+		// the secret is left untouched, replacing it would invalidate all tokens issued by oathkeeper
+		logger.Warnf("Keeping the existing Ory JWKS secret: %v", err)
+	} else if err != nil {
+		return err
 	}
 
-	dbSecretObject, err := getSecret(context.Context, client, dbNamespacedName)
+	_, err = getSecret(context.Context, client, dbNamespacedName)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.Wrap(err, "Could not get DB secret")
+	}
+	dbSecretExisted := err == nil
+	// an absent secret is created, an existing one only gets the keys it misses, keeping the credentials in use
+	completed, err := k8s.EnsureSecret(context.Context, client, logger, dbNamespacedName, dbSecretData(values, logger), false)
 	if err != nil {
-		if !kerrors.IsNotFound(err) {
-			return errors.Wrap(err, "Could not get DB secret")
-		}
+		return errors.Wrap(err, "failed to ensure Ory DB secret")
+	}
 
-		logger.Info("Ory DB secret does not exist, creating it now")
-		dbSecretObject, err = db.Get(dbNamespacedName, values, logger)
+	if dbSecretExisted {
+		dbSecretObject, err := getSecret(context.Context, client, dbNamespacedName)
 		if err != nil {
-			return errors.Wrap(err, "failed to create db credentials data for Ory Hydra")
-		}
-		if err := createSecret(context.Context, client, dbNamespacedName, *dbSecretObject, logger); err != nil {
-			return err
+			return errors.Wrap(err, "Could not get DB secret")
 		}
-
-	} else {
 		logger.Debug("Ory DB secret exists, looking for differences")
 		newSecretData, err := db.Update(values, dbSecretObject, logger)
 		if err != nil {
@@ -130,9 +211,10 @@ func (a *preReconcileAction) Run(context *service.ActionContext) error {
 
 		if !isUpdate(newSecretData) {
 			logger.Debug("Ory DB secret is the same as values, no need to update")
-			rolloutHydra = false
+			rolloutHydra = completed
 		} else {
-			logger.Info("Ory DB secret is different than values, updating it")
+			logger.Infof("Ory DB secret is different than values, updating keys %s",
+				strings.Join(changedSecretKeys(dbSecretObject, newSecretData), ", "))
 			dbSecretObject.StringData = newSecretData
 			rolloutHydra = true
 
@@ -142,11 +224,66 @@ func (a *preReconcileAction) Run(context *service.ActionContext) error {
 		}
 	}
 
+	if err := a.detectPersistenceSwitch(context.Context, client, cfg, logger); err != nil {
+		return err
+	}
+
 	logger.Debugf("Action '%s' executed (passed version was '%s')", a.step, context.Task.Version)
 
 	return nil
 }
 
+func (a *preDeleteAction) Run(context *service.ActionContext) error {
+	logger := context.Logger
+	// webhooks of the deleted ory services would deny the finalizer updates
+	a.deleteWebhookConfigurations(context)
+
+	if isEnabled(context.Task.Configuration, skipFinalizerCleanupKey) {
+		logger.Infof("Skipping cleanup of ory finalizers as configured by '%s'", skipFinalizerCleanupKey)
+	} else {
+		oryFinalizersHandler := a.newOryFinalizersHandler(logger)
+		defer oryFinalizersHandler.Close()
+		result, err := oryFinalizersHandler.FindAndDeleteOryFinalizers(context.KubeClient.Kubeconfig())
+		if err != nil {
+			// the chart is not deleted, as its deletion would hang on the remaining finalizers
+			return &finalizerCleanupError{err: err, result: result}
+		}
+		if result != nil {
+			logger.Infof("Cleanup of ory finalizers before deleting the chart %s", summarizeCleanup(result))
+		}
+	}
+
+	// the access rules are removed by the post-delete action, oathkeeper-maester would recreate them until then
+	logger.Debugf("Action '%s' executed (passed version was '%s')", a.step, context.Task.Version)
+	return nil
+}
+
+// deleteWebhookConfigurations removes the webhook configurations calling ory services. A failure does not block the
+// deletion, as the finalizer cleanup may still succeed if the webhooks do not match the ory custom resources.
+func (a *oryAction) deleteWebhookConfigurations(context *service.ActionContext) {
+	client, err := context.KubeClient.Clientset()
+	if err != nil {
+		context.Logger.Warnf("Failed to retrieve native Kubernetes GO client, keeping ory webhook configurations: %v", err)
+		return
+	}
+	if _, err := k8s.DeleteWebhookConfigurations(context.Context, client, context.Logger, oryNamespace); err != nil {
+		context.Logger.Warnf("Failed to delete webhook configurations of ory: %v", err)
+	}
+}
+
+// deleteAccessRules removes the access rules left behind by oathkeeper-maester. A failure does not block the
+// deletion, as the leftover ConfigMaps do not prevent a reinstallation.
+func (a *oryAction) deleteAccessRules(context *service.ActionContext) {
+	client, err := context.KubeClient.Clientset()
+	if err != nil {
+		context.Logger.Warnf("Failed to retrieve native Kubernetes GO client, keeping oathkeeper access rules: %v", err)
+		return
+	}
+	if _, err := k8s.DeleteAccessRulesConfigMaps(context.Context, client, context.Logger, oathkeeperNamespacedName); err != nil {
+		context.Logger.Warnf("Failed to delete access rules of oathkeeper: %v", err)
+	}
+}
+
 func (a *postDeleteAction) Run(context *service.ActionContext) error {
 	logger := context.Logger
 	client, err := context.KubeClient.Clientset()
@@ -154,11 +291,17 @@ func (a *postDeleteAction) Run(context *service.ActionContext) error {
 		return errors.Wrap(err, "failed to retrieve native Kubernetes GO client")
 	}
 
-	kubeconfig := context.KubeClient.Kubeconfig()
-	err = a.oryFinalizersHandler.FindAndDeleteOryFinalizers(kubeconfig, logger)
-	if err != nil {
-		logger.Errorf("failed to delete finalizers from ory CRDs, %s", err.Error())
+	// catches ory custom resources which were created while the chart was deleted
+	if !isEnabled(context.Task.Configuration, skipFinalizerCleanupKey) {
+		kubeconfig := context.KubeClient.Kubeconfig()
+		oryFinalizersHandler := a.newOryFinalizersHandler(logger)
+		defer oryFinalizersHandler.Close()
+		_, err = oryFinalizersHandler.FindAndDeleteOryFinalizers(kubeconfig)
+		if err != nil {
+			logger.Errorf("failed to delete finalizers from ory CRDs, %s", err.Error())
+		}
 	}
+	a.deleteAccessRules(context)
 
 	secretExists, err := a.secretExists(context.Context, client, dbNamespacedName)
 	if err != nil {
@@ -186,10 +329,45 @@ func (a *postDeleteAction) Run(context *service.ActionContext) error {
 		logger.Infof("JWKS Secret %s does not exist", jwksNamespacedName.Name)
 	}
 
+	if isEnabled(context.Task.Configuration, deleteHelmReleaseSecretsKey) {
+		if _, err := k8s.DeleteHelmReleaseSecrets(context.Context, client, logger, oryNamespace, oryChart); err != nil {
+			return errors.Wrap(err, "failed to delete helm release secrets of ory")
+		}
+	}
+
+	if threshold := configuredDuration(context.Task.Configuration, stuckWorkloadsThresholdKey, logger); threshold > 0 {
+		if _, err := k8s.ForceDeleteStuckWorkloads(context.Context, client, logger, []string{oryNamespace}, threshold); err != nil {
+			return errors.Wrap(err, "failed to force delete stuck ory workloads")
+		}
+	}
+
+	if isEnabled(context.Task.Configuration, allowDataLossKey) {
+		claims, err := k8s.DeletePersistentVolumeClaims(context.Context, client, logger, oryNamespace, true, pvcDeletionTimeout)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete persistent volume claims of ory")
+		}
+		logger.Infof("Deleted %d persistent volume claims of ory: %v", len(claims), claims)
+	}
+
 	logger.Debugf("Action '%s' executed (passed version was '%s')", a.step, context.Task.Version)
 	return nil
 }
 
+// dbSecretData generates the data of the hydra DB secret for the chart values, see db.Get
+func dbSecretData(values map[string]interface{}, logger *zap.SugaredLogger) k8s.SecretDataGenerator {
+	return func() (map[string][]byte, error) {
+		secret, err := db.Get(dbNamespacedName, values, logger)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create db credentials data for Ory Hydra")
+		}
+		data := make(map[string][]byte, len(secret.StringData))
+		for key, value := range secret.StringData {
+			data[key] = []byte(value)
+		}
+		return data, nil
+	}
+}
+
 func getSecret(ctx context.Context, client kubernetes.Interface, name types.NamespacedName) (*v1.Secret, error) {
 	secret, err := client.CoreV1().Secrets(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
 	if err != nil {
@@ -209,6 +387,55 @@ func (a *preReconcileAction) updateSecret(ctx context.Context, client kubernetes
 	return err
 }
 
+// detectPersistenceSwitch restarts hydra if it still runs with another persistence mode than configured, which is the
+// case if the DB secret was updated by an earlier reconciliation which failed before hydra was restarted
+func (a *preReconcileAction) detectPersistenceSwitch(ctx context.Context, client kubernetes.Interface, cfg *db.Config, logger *zap.SugaredLogger) error {
+	deployment, err := client.AppsV1().Deployments(oryNamespace).Get(ctx, hydraDeployment, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		logger.Debug("Hydra deployment does not exist yet, no persistence switch to detect")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Could not get hydra deployment")
+	}
+
+	running, desired := deployment.Annotations[hydraPersistenceAnnotation], cfg.PersistenceMode()
+	if running == "" || running == desired {
+		return nil
+	}
+	logger.Infof("Hydra runs with %s persistence but %s persistence is configured, restarting it", running, desired)
+	rolloutHydra = true
+	return nil
+}
+
+// recordPersistenceMode annotates the hydra deployment with the persistence mode its pods are running with
+func (a *postReconcileAction) recordPersistenceMode(ctx context.Context, kubeClient internalKubernetes.Client, cfg *db.Config, logger *zap.SugaredLogger) error {
+	client, err := kubeClient.Clientset()
+	if err != nil {
+		return errors.Wrap(err, "Failed to retrieve clientset")
+	}
+	deployment, err := client.AppsV1().Deployments(oryNamespace).Get(ctx, hydraDeployment, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		logger.Debug("Hydra deployment does not exist, not recording its persistence mode")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Could not get hydra deployment")
+	}
+
+	mode := cfg.PersistenceMode()
+	if deployment.Annotations[hydraPersistenceAnnotation] == mode {
+		return nil
+	}
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, hydraPersistenceAnnotation, mode)
+	_, err = client.AppsV1().Deployments(oryNamespace).Patch(ctx, hydraDeployment, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to record the persistence mode of hydra")
+	}
+	logger.Debugf("Recorded %s persistence on hydra deployment", mode)
+	return nil
+}
+
 func (a *postReconcileAction) rolloutHydraDeployment(ctx context.Context, client internalKubernetes.Client, deployment string, logger *zap.SugaredLogger) error {
 	err := a.rolloutHandler.RolloutAndWaitForDeployment(ctx, deployment, oryNamespace, client, logger)
 	if err != nil {
@@ -244,16 +471,6 @@ func (a *postDeleteAction) deleteSecret(ctx context.Context, client kubernetes.I
 	return err
 }
 
-func createSecret(ctx context.Context, client kubernetes.Interface, name types.NamespacedName, secret v1.Secret, logger *zap.SugaredLogger) error {
-	_, err := client.CoreV1().Secrets(name.Namespace).Create(ctx, &secret, metav1.CreateOptions{})
-	if err != nil {
-		return errors.Wrap(err, "failed to create the secret")
-	}
-	logger.Debugf("Secret %s created", name.String())
-
-	return err
-}
-
 func readActionContext(context *service.ActionContext) (*zap.SugaredLogger, internalKubernetes.Client, *db.Config, map[string]interface{}, error) {
 	logger := context.Logger
 	component := chart.NewComponentBuilder(context.Task.Version, oryChart).
@@ -273,6 +490,83 @@ func readActionContext(context *service.ActionContext) (*zap.SugaredLogger, inte
 	return logger, client, cfg, chartValues, nil
 }
 
+// isEnabled reports whether the boolean flag is set in the configuration, either as boolean or as string
+func isEnabled(configuration map[string]interface{}, key string) bool {
+	switch flag := configuration[key].(type) {
+	case bool:
+		return flag
+	case string:
+		enabled, err := strconv.ParseBool(flag)
+		return err == nil && enabled
+	default:
+		return false
+	}
+}
+
+// changedSecretKeys returns the sorted keys whose value differs between the secret and the new data, without any values
+func changedSecretKeys(secret *v1.Secret, data map[string]string) []string {
+	var changed []string
+	for key, value := range data {
+		if string(secret.Data[key]) != value {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// oryWorkloads returns the workloads which have to be ready for ory to serve requests, only those which the chart
+// values deploy
+func oryWorkloads(cfg *db.Config) []k8s.Workload {
+	workloads := []k8s.Workload{
+		{Kind: k8s.WorkloadKindDeployment, Name: hydraDeployment},
+		{Kind: k8s.WorkloadKindDeployment, Name: oathkeeperNamespacedName.Name},
+	}
+	if maester := cfg.Hydra.Maester.Enabled; maester == nil || *maester {
+		workloads = append(workloads, k8s.Workload{Kind: k8s.WorkloadKindDeployment, Name: hydraMaesterDeployment})
+	}
+	if cfg.Global.Ory.Hydra.Persistence.Enabled && cfg.Global.Ory.Hydra.Persistence.PostgresqlFlag.Enabled {
+		workloads = append(workloads, k8s.Workload{Kind: k8s.WorkloadKindStatefulSet, Name: postgresqlStatefulSet})
+	}
+	return workloads
+}
+
+func (a *postReconcileAction) waitForWorkloads(ctx context.Context, kubeClient internalKubernetes.Client, cfg *db.Config,
+	logger *zap.SugaredLogger, timeout time.Duration) error {
+	client, err := kubeClient.Clientset()
+	if err != nil {
+		return errors.Wrap(err, "failed to retrieve native Kubernetes GO client")
+	}
+	if err := a.readinessChecker.WaitForReadiness(ctx, client, logger, oryNamespace, oryWorkloads(cfg), timeout); err != nil {
+		return errors.Wrap(err, "ory workloads did not become ready")
+	}
+	return nil
+}
+
+// readinessTimeout returns the configured timeout of the readiness check, falling back to the default if it is
+// missing or invalid
+func readinessTimeout(configuration map[string]interface{}, logger *zap.SugaredLogger) time.Duration {
+	timeout := configuredDuration(configuration, readinessTimeoutKey, logger)
+	if timeout == 0 {
+		return defaultReadinessTimeout
+	}
+	return timeout
+}
+
+// configuredDuration parses a positive duration like "90s" from the configuration, 0 if it is missing or invalid
+func configuredDuration(configuration map[string]interface{}, key string, logger *zap.SugaredLogger) time.Duration {
+	value, ok := configuration[key].(string)
+	if !ok {
+		return 0
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		logger.Warnf("Ignoring invalid '%s' %q, expected a positive duration", key, value)
+		return 0
+	}
+	return duration
+}
+
 func isInMemoryMode(cfg *db.Config) bool {
 	return !cfg.Global.Ory.Hydra.Persistence.Enabled
 }
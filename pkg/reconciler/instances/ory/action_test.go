@@ -4,6 +4,7 @@ import (
 	"context"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/kyma-incubator/reconciler/pkg/reconciler"
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes"
@@ -13,7 +14,9 @@ import (
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/chart"
 	chartmocks "github.com/kyma-incubator/reconciler/pkg/reconciler/chart/mocks"
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/db"
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/hydra"
 	hydramocks "github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/hydra/mocks"
+	oryk8s "github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s"
 	oryk8smock "github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s/mocks"
 	k8smocks "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes/mocks"
 	"github.com/kyma-incubator/reconciler/pkg/reconciler/service"
@@ -21,7 +24,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	v1admission "k8s.io/api/admissionregistration/v1"
 	v1apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
@@ -73,7 +78,7 @@ func Test_PostReconcile_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock}
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, fixReadyWorkloads(), fixHealthyHydra()}
 
 		// when
 		err = action.Run(actionContext)
@@ -96,7 +101,7 @@ func Test_PostReconcile_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock}
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, fixReadyWorkloads(), fixHealthyHydra()}
 
 		// when
 		err = action.Run(actionContext)
@@ -107,6 +112,135 @@ func Test_PostReconcile_Run(t *testing.T) {
 
 	})
 
+	t.Run("should record the persistence mode on the hydra deployment", func(t *testing.T) {
+		// given
+		factory := chartmocks.Factory{}
+		provider := chartmocks.Provider{}
+		hydraClient := hydramocks.Syncer{}
+		rolloutMock := oryk8smock.RolloutHandler{}
+		values, err := unmarshalTestValues(postgresqlYaml)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		clientSet := fake.NewSimpleClientset(fixOryHydraDeployment())
+		kubeClient := newFakeKubeClient(clientSet)
+		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, fixReadyWorkloads(), fixHealthyHydra()}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		deployment, err := clientSet.AppsV1().Deployments(oryNamespace).Get(actionContext.Context, hydraDeployment, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "postgresql", deployment.Annotations[hydraPersistenceAnnotation])
+	})
+
+	t.Run("should wait for the ory workloads with the configured timeout", func(t *testing.T) {
+		// given
+		factory := chartmocks.Factory{}
+		provider := chartmocks.Provider{}
+		hydraClient := hydramocks.Syncer{}
+		rolloutMock := oryk8smock.RolloutHandler{}
+		values, err := unmarshalTestValues(postgresqlYaml)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
+		actionContext.Task.Configuration = map[string]interface{}{readinessTimeoutKey: "30s"}
+		readinessMock := oryk8smock.ReadinessChecker{}
+		readinessMock.On("WaitForReadiness", mock.Anything, mock.Anything, mock.Anything, oryNamespace, mock.Anything, 30*time.Second).
+			Return(&oryk8s.WorkloadsNotReadyError{Namespace: oryNamespace, Timeout: 30 * time.Second,
+				Unready: []oryk8s.WorkloadStatus{{Workload: oryk8s.Workload{Kind: oryk8s.WorkloadKindDeployment, Name: hydraDeployment}, Reason: "0/1 replicas ready"}}})
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, &readinessMock, fixHealthyHydra()}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.True(t, oryk8s.IsWorkloadsNotReadyError(err))
+		require.Contains(t, err.Error(), `Deployment "ory-hydra": 0/1 replicas ready`)
+		workloads := readinessMock.Calls[0].Arguments.Get(4).([]oryk8s.Workload)
+		require.Contains(t, workloads, oryk8s.Workload{Kind: oryk8s.WorkloadKindStatefulSet, Name: postgresqlStatefulSet})
+		require.Contains(t, workloads, oryk8s.Workload{Kind: oryk8s.WorkloadKindDeployment, Name: hydraMaesterDeployment})
+	})
+
+	t.Run("should not wait for hydra-maester when the chart disables it", func(t *testing.T) {
+		// given
+		factory := chartmocks.Factory{}
+		provider := chartmocks.Provider{}
+		hydraClient := hydramocks.Syncer{}
+		rolloutMock := oryk8smock.RolloutHandler{}
+		values, err := unmarshalTestValues(postgresqlYaml + `
+    hydra:
+      maester:
+        enabled: false`)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
+		readinessMock := fixReadyWorkloads()
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, readinessMock, fixHealthyHydra()}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		workloads := readinessMock.Calls[0].Arguments.Get(4).([]oryk8s.Workload)
+		require.NotContains(t, workloads, oryk8s.Workload{Kind: oryk8s.WorkloadKindDeployment, Name: hydraMaesterDeployment})
+		require.Contains(t, workloads, oryk8s.Workload{Kind: oryk8s.WorkloadKindDeployment, Name: hydraDeployment})
+	})
+
+	t.Run("should fail when hydra stays unhealthy after the reconciliation", func(t *testing.T) {
+		// given
+		factory := chartmocks.Factory{}
+		provider := chartmocks.Provider{}
+		hydraClient := hydramocks.Syncer{}
+		rolloutMock := oryk8smock.RolloutHandler{}
+		values, err := unmarshalTestValues(postgresqlYaml)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
+		actionContext.Task.Configuration = map[string]interface{}{hydraHealthCheckTimeoutKey: "45s"}
+		healthMock := hydramocks.HealthChecker{}
+		healthMock.On("CheckHealth", mock.Anything, mock.Anything, mock.Anything, oryNamespace, 45*time.Second).
+			Return(&hydra.UnhealthyError{Endpoint: hydra.HealthReadyPath, Timeout: 45 * time.Second,
+				Body: `{"errors":{"database":"connection refused"}}`})
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, fixReadyWorkloads(), &healthMock}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.True(t, hydra.IsUnhealthyError(err))
+		require.Contains(t, err.Error(), `{"errors":{"database":"connection refused"}}`)
+	})
+
+	t.Run("should skip the health check of hydra if configured", func(t *testing.T) {
+		// given
+		factory := chartmocks.Factory{}
+		provider := chartmocks.Provider{}
+		hydraClient := hydramocks.Syncer{}
+		rolloutMock := oryk8smock.RolloutHandler{}
+		values, err := unmarshalTestValues(postgresqlYaml)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
+		actionContext.Task.Configuration = map[string]interface{}{skipHydraHealthCheckKey: true}
+		healthMock := hydramocks.HealthChecker{}
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, fixReadyWorkloads(), &healthMock}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		healthMock.AssertNotCalled(t, "CheckHealth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
 	t.Run("should return error when synchronization failed", func(t *testing.T) {
 		// given
 		factory := chartmocks.Factory{}
@@ -121,7 +255,7 @@ func Test_PostReconcile_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock}
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, fixReadyWorkloads(), fixHealthyHydra()}
 
 		// when
 		err = action.Run(actionContext)
@@ -142,7 +276,7 @@ func Test_PostReconcile_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock}
+		action := postReconcileAction{&oryAction{step: "post-reconcile"}, &hydraClient, &rolloutMock, fixReadyWorkloads(), fixHealthyHydra()}
 
 		// when
 		err := action.Run(actionContext)
@@ -165,7 +299,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := preReconcileAction{&oryAction{step: "pre-install"}}
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
 
 		// when
 		err := action.Run(actionContext)
@@ -186,7 +320,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		kubeClient := k8smocks.Client{}
 		kubeClient.On("Clientset").Return(nil, errors.New("cannot get secret"))
 		actionContext := newFakeServiceContext(&factory, &provider, &kubeClient)
-		action := preReconcileAction{&oryAction{step: "pre-install"}}
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
 
 		// when
 		err := action.Run(actionContext)
@@ -207,7 +341,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := preReconcileAction{&oryAction{step: "pre-install"}}
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
 
 		// when
 		err := action.Run(actionContext)
@@ -233,7 +367,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset(existingJwksSecret)
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := preReconcileAction{&oryAction{step: "pre-install"}}
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
 
 		// when
 		err := action.Run(actionContext)
@@ -249,6 +383,72 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		require.Equal(t, getJWKSData(), secret.Data)
 	})
 
+	t.Run("should fail when the jwks secret cannot be ensured", func(t *testing.T) {
+		// given
+		provider := chartmocks.Provider{}
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(make(map[string]interface{}), nil)
+		clientSet := fake.NewSimpleClientset()
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &provider, newFakeKubeClient(clientSet))
+		jwksMock := oryk8smock.JWKSHandler{}
+		jwksMock.On("EnsureJWKSSecret", mock.Anything, clientSet, mock.Anything, jwksNamespacedName).
+			Return(false, errors.New("forbidden"))
+		action := preReconcileAction{&oryAction{step: "pre-install"}, &jwksMock}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.EqualError(t, err, "forbidden")
+		_, err = clientSet.CoreV1().Secrets(dbNamespacedName.Namespace).Get(actionContext.Context, dbNamespacedName.Name, metav1.GetOptions{})
+		require.True(t, kerrors.IsNotFound(err))
+	})
+
+	t.Run("should keep a malformed jwks secret", func(t *testing.T) {
+		// given
+		provider := chartmocks.Provider{}
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(make(map[string]interface{}), nil)
+		clientSet := fake.NewSimpleClientset()
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &provider, newFakeKubeClient(clientSet))
+		jwksMock := oryk8smock.JWKSHandler{}
+		jwksMock.On("EnsureJWKSSecret", mock.Anything, clientSet, mock.Anything, jwksNamespacedName).
+			Return(false, &oryk8s.MalformedJWKSSecretError{Secret: jwksNamespacedName, Reason: "key set contains no keys"})
+		action := preReconcileAction{&oryAction{step: "pre-install"}, &jwksMock}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		jwksMock.AssertExpectations(t)
+	})
+
+	t.Run("should add missing keys to the ory secret and restart hydra", func(t *testing.T) {
+		// given
+		provider := chartmocks.Provider{}
+		values, err := unmarshalTestValues(memoryYaml)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		existingSecret := fixSecretMemory()
+		delete(existingSecret.Data, "secretsCookie")
+		hydraDeployment := fixOryHydraDeployment()
+		hydraDeployment.Annotations = map[string]string{hydraPersistenceAnnotation: "in-memory"}
+		clientSet := fake.NewSimpleClientset(existingSecret, hydraDeployment)
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &provider, newFakeKubeClient(clientSet))
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, rolloutHydra)
+		secret, err := clientSet.CoreV1().Secrets(dbNamespacedName.Namespace).Get(actionContext.Context, dbNamespacedName.Name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotEmpty(t, secret.Data["secretsCookie"])
+		require.Equal(t, []byte("somesecretsystem"), secret.Data["secretsSystem"])
+		require.Equal(t, []byte(inMemoryURL), secret.Data["dsn"])
+	})
+
 	t.Run("should create ory secret when secret does not exist", func(t *testing.T) {
 		// given
 		factory := chartmocks.Factory{}
@@ -258,7 +458,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := preReconcileAction{&oryAction{step: "pre-install"}}
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
 
 		// when
 		err := action.Run(actionContext)
@@ -271,7 +471,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, dbNamespacedName.Name, secret.Name)
 		require.Equal(t, dbNamespacedName.Namespace, secret.Namespace)
-		require.Equal(t, inMemoryURL, secret.StringData["dsn"])
+		require.Equal(t, []byte(inMemoryURL), secret.Data["dsn"])
 	})
 
 	t.Run("should not update ory secret when secret exist and has a valid data", func(t *testing.T) {
@@ -285,7 +485,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset(existingSecret)
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := preReconcileAction{&oryAction{step: "pre-install"}}
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
 
 		// when
 		err = action.Run(actionContext)
@@ -316,7 +516,7 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		clientSet := fake.NewSimpleClientset(existingSecret, hydraDeployment)
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := preReconcileAction{&oryAction{step: "pre-install"}}
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
 
 		// when
 		err = action.Run(actionContext)
@@ -332,6 +532,166 @@ func Test_PreInstallAction_Run(t *testing.T) {
 		require.Contains(t, secret.StringData["dsn"], "postgres")
 		require.NotContains(t, secret.StringData["dsn"], inMemoryURL)
 	})
+
+	t.Run("should restart hydra when it still runs with another persistence mode", func(t *testing.T) {
+		// given
+		factory := chartmocks.Factory{}
+		provider := chartmocks.Provider{}
+		values, err := unmarshalTestValues(memoryYaml)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		hydraDeployment := fixOryHydraDeployment()
+		hydraDeployment.Annotations = map[string]string{hydraPersistenceAnnotation: "postgresql"}
+		clientSet := fake.NewSimpleClientset(fixSecretMemory(), hydraDeployment)
+		actionContext := newFakeServiceContext(&factory, &provider, newFakeKubeClient(clientSet))
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, rolloutHydra)
+	})
+
+	t.Run("should not restart hydra when it runs with the configured persistence mode", func(t *testing.T) {
+		// given
+		factory := chartmocks.Factory{}
+		provider := chartmocks.Provider{}
+		values, err := unmarshalTestValues(memoryYaml)
+		require.NoError(t, err)
+		provider.On("Configuration", mock.AnythingOfType("*chart.Component")).Return(values, nil)
+		hydraDeployment := fixOryHydraDeployment()
+		hydraDeployment.Annotations = map[string]string{hydraPersistenceAnnotation: "in-memory"}
+		clientSet := fake.NewSimpleClientset(fixSecretMemory(), hydraDeployment)
+		actionContext := newFakeServiceContext(&factory, &provider, newFakeKubeClient(clientSet))
+		action := preReconcileAction{&oryAction{step: "pre-install"}, oryk8s.NewDefaultJWKSHandler(jwksAlg, jwksBits)}
+
+		// when
+		err = action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		require.False(t, rolloutHydra)
+	})
+}
+
+func Test_ChangedSecretKeys(t *testing.T) {
+	// given
+	secret := fixSecretMemory()
+
+	// when
+	changed := changedSecretKeys(secret, map[string]string{
+		"dsn":           "postgres://hydra",
+		"secretsCookie": "somesecretcookie",
+		"dbPassword":    "secretpw",
+	})
+
+	// then
+	require.Equal(t, []string{"dbPassword", "dsn"}, changed)
+}
+
+func Test_PreDeleteAction_Run(t *testing.T) {
+	t.Run("should remove ory finalizers before the chart is deleted", func(t *testing.T) {
+		// given
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		oryFinalizersMock.On("FindAndDeleteOryFinalizers", "kubeconfig").Return(&oryk8s.CleanupResult{}, nil)
+		oryFinalizersMock.On("Close").Return()
+		kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, kubeClient)
+		action := preDeleteAction{&oryAction{step: "pre-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		oryFinalizersMock.AssertExpectations(t)
+	})
+
+	t.Run("should report a failed cleanup", func(t *testing.T) {
+		// given
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		oryFinalizersMock.On("FindAndDeleteOryFinalizers", "kubeconfig").Return(&oryk8s.CleanupResult{
+			Elapsed: 2 * time.Second,
+			CRDs:    []oryk8s.CRDResult{{RemovedFinalizers: 3}},
+		}, errors.New("FindAndDeleteOryFinalizers error"))
+		oryFinalizersMock.On("Close").Return()
+		kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, kubeClient)
+		action := preDeleteAction{&oryAction{step: "pre-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		var cleanupErr *finalizerCleanupError
+		require.True(t, errors.As(err, &cleanupErr))
+		require.Equal(t, 3, cleanupErr.result.RemovedFinalizers())
+		require.EqualError(t, err, "cleanup of ory finalizers failed (removed 3 finalizers of 1 crds in 2s with 0 failures): "+
+			"FindAndDeleteOryFinalizers error")
+		oryFinalizersMock.AssertCalled(t, "Close")
+	})
+
+	t.Run("should delete the webhook configurations of ory before the finalizers", func(t *testing.T) {
+		// given
+		webhook := &v1admission.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "ory-oathkeeper", Labels: map[string]string{oryk8s.OryInstanceLabel: oryChart}}}
+		clientSet := fake.NewSimpleClientset(webhook)
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		oryFinalizersMock.On("FindAndDeleteOryFinalizers", "kubeconfig").Run(func(mock.Arguments) {
+			_, err := clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), webhook.Name, metav1.GetOptions{})
+			require.True(t, kerrors.IsNotFound(err))
+		}).Return(&oryk8s.CleanupResult{}, nil)
+		oryFinalizersMock.On("Close").Return()
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, newFakeKubeClient(clientSet))
+		action := preDeleteAction{&oryAction{step: "pre-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		oryFinalizersMock.AssertExpectations(t)
+	})
+
+	t.Run("should keep the access rules while oathkeeper-maester may recreate them", func(t *testing.T) {
+		// given
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		oryFinalizersMock.On("FindAndDeleteOryFinalizers", "kubeconfig").Return(&oryk8s.CleanupResult{}, nil)
+		oryFinalizersMock.On("Close").Return()
+		accessRules := fixAccessRulesConfigMap()
+		clientSet := fake.NewSimpleClientset(accessRules)
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, newFakeKubeClient(clientSet))
+		action := preDeleteAction{&oryAction{step: "pre-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		_, err = clientSet.CoreV1().ConfigMaps(oryNamespace).Get(actionContext.Context, accessRules.Name, metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should skip the cleanup when configured", func(t *testing.T) {
+		for _, skip := range []interface{}{true, "true"} {
+			// given
+			oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+			kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+			actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, kubeClient)
+			actionContext.Task.Configuration = map[string]interface{}{skipFinalizerCleanupKey: skip}
+			action := preDeleteAction{&oryAction{step: "pre-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+			// when
+			err := action.Run(actionContext)
+
+			// then
+			require.NoError(t, err)
+			oryFinalizersMock.AssertNotCalled(t, "FindAndDeleteOryFinalizers", mock.Anything)
+			kubeClient.AssertNotCalled(t, "Kubeconfig")
+		}
+	})
 }
 
 func Test_PostDeleteAction_Run(t *testing.T) {
@@ -343,7 +703,7 @@ func Test_PostDeleteAction_Run(t *testing.T) {
 		kubeClient := k8smocks.Client{}
 		kubeClient.On("Clientset").Return(nil, errors.New("failed to retrieve native Kubernetes GO client"))
 		actionContext := newFakeServiceContext(&factory, &provider, &kubeClient)
-		action := postDeleteAction{&oryAction{step: "post-delete"}, &oryFinalizersMock}
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
 
 		// when
 		err := action.Run(actionContext)
@@ -359,14 +719,15 @@ func Test_PostDeleteAction_Run(t *testing.T) {
 		// given
 		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
 		oryFinalizersMock.On("FindAndDeleteOryFinalizers",
-			mock.AnythingOfType("string"), mock.AnythingOfType("*zap.SugaredLogger")).
-			Return(errors.New("FindAndDeleteOryFinalizers error"))
+			mock.AnythingOfType("string")).
+			Return(nil, errors.New("FindAndDeleteOryFinalizers error"))
+		oryFinalizersMock.On("Close").Return()
 		factory := chartmocks.Factory{}
 		provider := chartmocks.Provider{}
 		clientSet := fake.NewSimpleClientset()
 		kubeClient := newFakeKubeClient(clientSet)
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
-		action := postDeleteAction{&oryAction{step: "post-delete"}, &oryFinalizersMock}
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
 
 		// when
 		err := action.Run(actionContext)
@@ -381,8 +742,9 @@ func Test_PostDeleteAction_Run(t *testing.T) {
 		// given
 		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
 		oryFinalizersMock.On("FindAndDeleteOryFinalizers",
-			mock.AnythingOfType("string"), mock.AnythingOfType("*zap.SugaredLogger")).
-			Return(errors.New("FindAndDeleteOryFinalizers error"))
+			mock.AnythingOfType("string")).
+			Return(nil, errors.New("FindAndDeleteOryFinalizers error"))
+		oryFinalizersMock.On("Close").Return()
 		factory := chartmocks.Factory{}
 		provider := chartmocks.Provider{}
 		existingSecret := fixSecretJwks()
@@ -391,7 +753,7 @@ func Test_PostDeleteAction_Run(t *testing.T) {
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
 		_, err := clientSet.CoreV1().Secrets(jwksNamespacedName.Namespace).Get(actionContext.Context, jwksNamespacedName.Name, metav1.GetOptions{})
 		require.False(t, kerrors.IsNotFound(err))
-		action := postDeleteAction{&oryAction{step: "post-delete"}, &oryFinalizersMock}
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
 
 		// when
 		err = action.Run(actionContext)
@@ -408,8 +770,9 @@ func Test_PostDeleteAction_Run(t *testing.T) {
 		// given
 		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
 		oryFinalizersMock.On("FindAndDeleteOryFinalizers",
-			mock.AnythingOfType("string"), mock.AnythingOfType("*zap.SugaredLogger")).
-			Return(errors.New("FindAndDeleteOryFinalizers error"))
+			mock.AnythingOfType("string")).
+			Return(nil, errors.New("FindAndDeleteOryFinalizers error"))
+		oryFinalizersMock.On("Close").Return()
 		factory := chartmocks.Factory{}
 		provider := chartmocks.Provider{}
 		existingSecret := fixSecretMemory()
@@ -418,7 +781,7 @@ func Test_PostDeleteAction_Run(t *testing.T) {
 		actionContext := newFakeServiceContext(&factory, &provider, kubeClient)
 		_, err := clientSet.CoreV1().Secrets(dbNamespacedName.Namespace).Get(actionContext.Context, dbNamespacedName.Name, metav1.GetOptions{})
 		require.False(t, kerrors.IsNotFound(err))
-		action := postDeleteAction{&oryAction{step: "post-delete"}, &oryFinalizersMock}
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
 
 		// when
 		err = action.Run(actionContext)
@@ -430,6 +793,115 @@ func Test_PostDeleteAction_Run(t *testing.T) {
 		_, err = clientSet.CoreV1().Secrets(dbNamespacedName.Namespace).Get(actionContext.Context, dbNamespacedName.Name, metav1.GetOptions{})
 		require.True(t, kerrors.IsNotFound(err))
 	})
+
+	t.Run("should delete helm release secrets of ory when configured", func(t *testing.T) {
+		// given
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		oryFinalizersMock.On("FindAndDeleteOryFinalizers", mock.AnythingOfType("string")).Return(nil, nil)
+		oryFinalizersMock.On("Close").Return()
+		releaseSecret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "sh.helm.release.v1.ory.v1", Namespace: oryNamespace},
+			Type:       oryk8s.HelmReleaseSecretType,
+		}
+		clientSet := fake.NewSimpleClientset(releaseSecret)
+		kubeClient := newFakeKubeClient(clientSet)
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, kubeClient)
+		actionContext.Task.Configuration = map[string]interface{}{deleteHelmReleaseSecretsKey: "true"}
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		_, err = clientSet.CoreV1().Secrets(oryNamespace).Get(actionContext.Context, releaseSecret.Name, metav1.GetOptions{})
+		require.True(t, kerrors.IsNotFound(err))
+	})
+
+	t.Run("should force delete ory pods stuck longer than the configured threshold", func(t *testing.T) {
+		// given
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		oryFinalizersMock.On("FindAndDeleteOryFinalizers", mock.AnythingOfType("string")).Return(nil, nil)
+		oryFinalizersMock.On("Close").Return()
+		deleted := metav1.NewTime(time.Now().Add(-time.Hour))
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ory-oathkeeper-abc", Namespace: oryNamespace,
+			Labels: map[string]string{oryk8s.OryInstanceLabel: oryChart}, DeletionTimestamp: &deleted}}
+		clientSet := fake.NewSimpleClientset(pod)
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, newFakeKubeClient(clientSet))
+		actionContext.Task.Configuration = map[string]interface{}{stuckWorkloadsThresholdKey: "30m"}
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		_, err = clientSet.CoreV1().Pods(oryNamespace).Get(actionContext.Context, pod.Name, metav1.GetOptions{})
+		require.True(t, kerrors.IsNotFound(err))
+	})
+
+	t.Run("should delete persistent volume claims of ory only if data loss is allowed", func(t *testing.T) {
+		for _, allowDataLoss := range []bool{false, true} {
+			// given
+			oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+			oryFinalizersMock.On("FindAndDeleteOryFinalizers", mock.AnythingOfType("string")).Return(nil, nil)
+			oryFinalizersMock.On("Close").Return()
+			claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-ory-postgresql-0", Namespace: oryNamespace,
+				Labels: map[string]string{oryk8s.OryInstanceLabel: oryChart}}}
+			clientSet := fake.NewSimpleClientset(claim)
+			actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, newFakeKubeClient(clientSet))
+			actionContext.Task.Configuration = map[string]interface{}{allowDataLossKey: allowDataLoss}
+			action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+			// when
+			err := action.Run(actionContext)
+
+			// then
+			require.NoError(t, err)
+			_, err = clientSet.CoreV1().PersistentVolumeClaims(oryNamespace).Get(actionContext.Context, claim.Name, metav1.GetOptions{})
+			require.Equal(t, allowDataLoss, kerrors.IsNotFound(err))
+		}
+	})
+
+	t.Run("should delete the access rules of a removed oathkeeper", func(t *testing.T) {
+		// given
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		oryFinalizersMock.On("FindAndDeleteOryFinalizers", mock.AnythingOfType("string")).Return(nil, nil)
+		oryFinalizersMock.On("Close").Return()
+		accessRules := fixAccessRulesConfigMap()
+		clientSet := fake.NewSimpleClientset(accessRules)
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, newFakeKubeClient(clientSet))
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		_, err = clientSet.CoreV1().ConfigMaps(oryNamespace).Get(actionContext.Context, accessRules.Name, metav1.GetOptions{})
+		require.True(t, kerrors.IsNotFound(err))
+	})
+
+	t.Run("should not remove ory finalizers when the cleanup is skipped", func(t *testing.T) {
+		// given
+		oryFinalizersMock := oryk8smock.OryFinalizersHandler{}
+		kubeClient := newFakeKubeClient(fake.NewSimpleClientset())
+		actionContext := newFakeServiceContext(&chartmocks.Factory{}, &chartmocks.Provider{}, kubeClient)
+		actionContext.Task.Configuration = map[string]interface{}{skipFinalizerCleanupKey: true}
+		action := postDeleteAction{&oryAction{step: "post-delete"}, fixOryFinalizersHandlerFactory(&oryFinalizersMock)}
+
+		// when
+		err := action.Run(actionContext)
+
+		// then
+		require.NoError(t, err)
+		oryFinalizersMock.AssertNotCalled(t, "FindAndDeleteOryFinalizers", mock.Anything)
+	})
+}
+
+func fixAccessRulesConfigMap() *v1.ConfigMap {
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ory-oathkeeper-rules", Namespace: oryNamespace,
+		Labels: map[string]string{"app.kubernetes.io/name": "oathkeeper-maester"}}}
 }
 
 func TestOryDbSecret(t *testing.T) {
@@ -462,16 +934,13 @@ func TestOryDbSecret(t *testing.T) {
 			values, err := helm.Configuration(component)
 			require.NoError(t, err)
 
-			secretObject, err := db.Get(name, values, logger)
-			require.NoError(t, err)
-
 			if test.PreCreateSecret {
 				existingSecret, err := preCreateSecret(ctx, k8sClient, name)
 				assert.NoError(t, err)
 				existingUID = existingSecret.UID
 			}
 
-			err = createSecret(ctx, k8sClient, name, *secretObject, logger)
+			_, err = oryk8s.EnsureSecret(ctx, k8sClient, logger, name, dbSecretData(values, logger), false)
 			assert.NoError(t, err)
 
 			secret, err := k8sClient.CoreV1().Secrets(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
@@ -479,8 +948,8 @@ func TestOryDbSecret(t *testing.T) {
 				require.NoError(t, err)
 				assert.Equal(t, name.Name, secret.Name)
 				assert.Equal(t, name.Namespace, secret.Namespace)
-				assert.NotNil(t, secret.StringData)
-				assert.Equal(t, secret.StringData["postgresql-password"], "testerpw")
+				assert.NotNil(t, secret.Data)
+				assert.Equal(t, string(secret.Data["postgresql-password"]), "testerpw")
 
 			} else {
 				require.NoError(t, err)
@@ -508,23 +977,12 @@ func TestOryDbSecret_Update(t *testing.T) {
 		// given
 		k8sClient := fake.NewSimpleClientset()
 
-		dbSecretObject, err := db.Get(name, values, logger)
-		require.NoError(t, err)
-
-		err = createSecret(ctx, k8sClient, name, *dbSecretObject, logger)
+		_, err := oryk8s.EnsureSecret(ctx, k8sClient, logger, name, dbSecretData(values, logger), false)
 		require.NoError(t, err)
 
 		existingSecret, err := getSecret(ctx, k8sClient, name)
 		require.NoError(t, err)
 
-		existingSecret.Data = map[string][]byte{
-			"secretsSystem":                   []byte(existingSecret.StringData["secretsSystem"]),
-			"secretsCookie":                   []byte(existingSecret.StringData["secretsCookie"]),
-			"dsn":                             []byte(existingSecret.StringData["dsn"]),
-			"postgresql-password":             []byte(existingSecret.StringData["postgresql-password"]),
-			"postgresql-replication-password": []byte(existingSecret.StringData["postgresql-replication-password"]),
-		}
-
 		newSecretData, err := db.Update(values, existingSecret, logger)
 		require.NoError(t, err)
 
@@ -569,6 +1027,26 @@ func preCreateSecret(ctx context.Context, client k8s.Interface, name types.Names
 	return client.CoreV1().Secrets(name.Namespace).Create(ctx, secret, metav1.CreateOptions{})
 }
 
+func fixOryFinalizersHandlerFactory(handler oryk8s.OryFinalizersHandler) oryFinalizersHandlerFactory {
+	return func(logger *zap.SugaredLogger) oryk8s.OryFinalizersHandler {
+		return handler
+	}
+}
+
+func fixReadyWorkloads() *oryk8smock.ReadinessChecker {
+	readinessMock := &oryk8smock.ReadinessChecker{}
+	readinessMock.On("WaitForReadiness", mock.Anything, mock.Anything, mock.Anything, oryNamespace, mock.Anything, defaultReadinessTimeout).
+		Return(nil)
+	return readinessMock
+}
+
+func fixHealthyHydra() *hydramocks.HealthChecker {
+	healthMock := &hydramocks.HealthChecker{}
+	healthMock.On("CheckHealth", mock.Anything, mock.Anything, mock.Anything, oryNamespace, defaultHydraHealthCheckTimeout).
+		Return(nil)
+	return healthMock
+}
+
 func newFakeKubeClient(clientSet *fake.Clientset) *k8smocks.Client {
 	mockClient := &k8smocks.Client{}
 	mockClient.On("Clientset").Return(clientSet, nil)
@@ -38,6 +38,23 @@ func NewDBConfig(chartValues map[string]interface{}) (*Config, error) {
 	return val, nil
 }
 
+// PersistenceMode returns the storage backend configured for Ory Hydra, like in-memory or postgresql.
+func (c *Config) PersistenceMode() string {
+	persistence := c.Global.Ory.Hydra.Persistence
+	switch {
+	case !persistence.Enabled:
+		return "in-memory"
+	case persistence.PostgresqlFlag.Enabled:
+		return "postgresql"
+	case persistence.DBType == "mysql":
+		return "mysql"
+	case persistence.Gcloud.Enabled:
+		return "gcloud"
+	default:
+		return persistence.DBType
+	}
+}
+
 // Get fetches Kubernetes Secret object with data matching the provided Helm values to the Reconciler.
 func Get(name types.NamespacedName, chartValues map[string]interface{}, logger *zap.SugaredLogger) (*v1.Secret, error) {
 	logger.Debugf("Fetching secret")
@@ -429,3 +429,25 @@ func fixSecretWithNameDsn(name, dsn string) *v1.Secret {
 	}
 	return &secret
 }
+
+func TestPersistenceMode(t *testing.T) {
+	for values, expected := range map[string]string{
+		noDBYaml:     "in-memory",
+		postgresYaml: "postgresql",
+		mysqlDBYaml:  "mysql",
+		gcloudYaml:   "gcloud",
+		customDBYaml: "cockroach",
+	} {
+		// given
+		chartValues, err := unmarshalTestValues(values)
+		require.NoError(t, err)
+		cfg, err := NewDBConfig(chartValues)
+		require.NoError(t, err)
+
+		// when
+		mode := cfg.PersistenceMode()
+
+		// then
+		require.Equal(t, expected, mode)
+	}
+}
@@ -1,8 +1,19 @@
 package db
 
-// Config holds the database configuration values of Ory Hydra.
+// Config holds the database configuration values of Ory Hydra and the values of the hydra subchart.
 type Config struct {
 	Global Global
+	Hydra  HydraChart `yaml:"hydra"`
+}
+
+// HydraChart holds the values of the hydra subchart
+type HydraChart struct {
+	Maester MaesterFlag `yaml:"maester"`
+}
+
+// MaesterFlag controls whether hydra-maester is deployed, which it is unless Enabled is set to false
+type MaesterFlag struct {
+	Enabled *bool `yaml:"enabled"`
 }
 
 // Global configuration of Ory Hydra and PostgresSQL
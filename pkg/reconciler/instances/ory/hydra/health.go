@@ -0,0 +1,99 @@
+package hydra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	internalKubernetes "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	hydraAdminService = "ory-hydra-admin"
+	hydraAdminPort    = "4445"
+	// HealthAlivePath reports whether the hydra process is running
+	HealthAlivePath = "/health/alive"
+	// HealthReadyPath reports whether hydra can serve requests, which includes reaching its database
+	HealthReadyPath = "/health/ready"
+)
+
+// HealthChecker exposes functionality to verify that hydra is able to serve requests
+//
+//go:generate mockery --name=HealthChecker --outpkg=mock --case=underscore
+type HealthChecker interface {
+	// CheckHealth calls the health endpoints of hydra through the API server proxy of the hydra admin service
+	// until both succeed or the timeout expires
+	CheckHealth(ctx context.Context, client internalKubernetes.Client, logger *zap.SugaredLogger, namespace string, timeout time.Duration) error
+}
+
+type DefaultHealthChecker struct {
+	interval time.Duration
+}
+
+// NewDefaultHealthChecker returns an instance of DefaultHealthChecker
+func NewDefaultHealthChecker() *DefaultHealthChecker {
+	return &DefaultHealthChecker{interval: 5 * time.Second}
+}
+
+// UnhealthyError is returned when a health endpoint of hydra did not succeed within the timeout
+type UnhealthyError struct {
+	Endpoint string
+	Timeout  time.Duration
+	// Body is the response of the last failed call, which names the failing check like an unreachable database
+	Body string
+	err  error
+}
+
+func (e *UnhealthyError) Error() string {
+	reason := e.Body
+	if reason == "" && e.err != nil {
+		reason = e.err.Error()
+	}
+	return fmt.Sprintf("hydra is unhealthy, %s of service %s did not succeed within %s: %s",
+		e.Endpoint, hydraAdminService, e.Timeout, reason)
+}
+
+func (e *UnhealthyError) Unwrap() error {
+	return e.err
+}
+
+func IsUnhealthyError(err error) bool {
+	var target *UnhealthyError
+	return errors.As(err, &target)
+}
+
+func (c *DefaultHealthChecker) CheckHealth(ctx context.Context, client internalKubernetes.Client, logger *zap.SugaredLogger,
+	namespace string, timeout time.Duration) error {
+	clientset, err := client.Clientset()
+	if err != nil {
+		return errors.Wrap(err, "Failed to read clientset")
+	}
+
+	var failed *UnhealthyError
+	err = wait.PollImmediateWithContext(ctx, c.interval, timeout, func(ctx context.Context) (bool, error) {
+		failed = nil
+		for _, endpoint := range []string{HealthAlivePath, HealthReadyPath} {
+			body, err := clientset.CoreV1().Services(namespace).
+				ProxyGet("http", hydraAdminService, hydraAdminPort, endpoint, nil).
+				DoRaw(ctx)
+			if err != nil {
+				failed = &UnhealthyError{Endpoint: endpoint, Timeout: timeout, Body: strings.TrimSpace(string(body)), err: err}
+				logger.Debugf("Hydra health check %s failed: %v", endpoint, err)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) && failed != nil {
+		return failed
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to check hydra health")
+	}
+	logger.Debugf("Hydra health endpoints of service %s succeeded", hydraAdminService)
+	return nil
+}
@@ -0,0 +1,129 @@
+package hydra
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	k8smocks "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_CheckHealth(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	checker := &DefaultHealthChecker{interval: time.Millisecond}
+
+	t.Run("Should succeed when both health endpoints of hydra succeed", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		calls := fixHealthEndpoints(clientset, map[string][]healthResponse{})
+
+		// when
+		err := checker.CheckHealth(context.Background(), fakeHealthClient(clientset), logger, testNamespace, time.Second)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{HealthAlivePath, HealthReadyPath}, *calls)
+	})
+
+	t.Run("Should retry until hydra becomes ready", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		calls := fixHealthEndpoints(clientset, map[string][]healthResponse{
+			HealthReadyPath: {{body: `{"errors":{"database":"connection refused"}}`, err: errors.New("503")}},
+		})
+
+		// when
+		err := checker.CheckHealth(context.Background(), fakeHealthClient(clientset), logger, testNamespace, time.Second)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{HealthAlivePath, HealthReadyPath, HealthAlivePath, HealthReadyPath}, *calls)
+	})
+
+	t.Run("Should fail with the response of hydra when it stays unhealthy", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		unhealthy := make([]healthResponse, 1000)
+		for i := range unhealthy {
+			unhealthy[i] = healthResponse{body: `{"errors":{"database":"connection refused"}}` + "\n", err: errors.New("503")}
+		}
+		fixHealthEndpoints(clientset, map[string][]healthResponse{HealthReadyPath: unhealthy})
+
+		// when
+		err := checker.CheckHealth(context.Background(), fakeHealthClient(clientset), logger, testNamespace, 20*time.Millisecond)
+
+		// then
+		require.True(t, IsUnhealthyError(err))
+		require.Equal(t, `hydra is unhealthy, /health/ready of service ory-hydra-admin did not succeed within 20ms: `+
+			`{"errors":{"database":"connection refused"}}`, err.Error())
+	})
+
+	t.Run("Should stop checking when the context is cancelled", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		unhealthy := make([]healthResponse, 1000)
+		for i := range unhealthy {
+			unhealthy[i] = healthResponse{err: errors.New("connection refused")}
+		}
+		fixHealthEndpoints(clientset, map[string][]healthResponse{HealthAlivePath: unhealthy})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		err := checker.CheckHealth(ctx, fakeHealthClient(clientset), logger, testNamespace, time.Hour)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "connection refused")
+	})
+}
+
+type healthResponse struct {
+	body string
+	err  error
+}
+
+// healthResponseWrapper is the response of a proxied call to the hydra admin service
+type healthResponseWrapper struct {
+	healthResponse
+}
+
+func (w healthResponseWrapper) DoRaw(context.Context) ([]byte, error) {
+	return []byte(w.body), w.err
+}
+
+func (w healthResponseWrapper) Stream(context.Context) (io.ReadCloser, error) {
+	return nil, errors.New("not supported")
+}
+
+// fixHealthEndpoints answers the proxied calls of each endpoint with the given responses, then succeeds.
+// It returns the called endpoints.
+func fixHealthEndpoints(clientset *fake.Clientset, responses map[string][]healthResponse) *[]string {
+	var calls []string
+	clientset.PrependProxyReactor("services", func(action k8stesting.Action) (bool, restclient.ResponseWrapper, error) {
+		proxy := action.(k8stesting.ProxyGetAction)
+		if proxy.GetName() != hydraAdminService || proxy.GetPort() != hydraAdminPort || proxy.GetNamespace() != testNamespace {
+			return false, nil, nil
+		}
+		calls = append(calls, proxy.GetPath())
+		if pending := responses[proxy.GetPath()]; len(pending) > 0 {
+			responses[proxy.GetPath()] = pending[1:]
+			return true, healthResponseWrapper{pending[0]}, nil
+		}
+		return true, healthResponseWrapper{healthResponse{body: `{"status":"ok"}`}}, nil
+	})
+	return &calls
+}
+
+func fakeHealthClient(clientset *fake.Clientset) *k8smocks.Client {
+	client := &k8smocks.Client{}
+	client.On("Clientset").Return(clientset, nil)
+	return client
+}
@@ -0,0 +1,12 @@
+package mock_test
+
+import (
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/hydra"
+	mock "github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/hydra/mocks"
+)
+
+// The mocks are regenerated with go generate, these assertions make go test fail as soon as one is stale
+var (
+	_ hydra.HealthChecker = &mock.HealthChecker{}
+	_ hydra.Syncer        = &mock.Syncer{}
+)
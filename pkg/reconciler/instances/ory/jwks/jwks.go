@@ -2,6 +2,8 @@ package jwks
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -31,7 +33,8 @@ func newJwks(alg string, bits int) *JWKS {
 	return &JWKS{alg, bits}
 }
 
-// Get generates a JSON Web Key Set with RSA Signature Algorithm and returns the entire jwks secret.
+// Get generates a JSON Web Key Set with an RSA or ECDSA Signature Algorithm and returns the entire jwks secret.
+// The key size only applies to RSA algorithms, ECDSA algorithms determine the curve.
 func Get(name types.NamespacedName, alg string, bits int) (*v1.Secret, error) {
 	cfg := newJwks(alg, bits)
 	jwksSecret, err := cfg.generateJwksSecret()
@@ -56,7 +59,7 @@ func Get(name types.NamespacedName, alg string, bits int) (*v1.Secret, error) {
 
 func (j *JWKS) generateJwksSecret() ([]byte, error) {
 	id := uuid.New().String()
-	key, err := j.generateRSAKey()
+	key, err := j.generateKey()
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +83,28 @@ func (j *JWKS) generateJwksSecret() ([]byte, error) {
 	return data, nil
 }
 
+func (j *JWKS) generateKey() (crypto.PrivateKey, error) {
+	switch jose.SignatureAlgorithm(j.alg) {
+	case jose.ES256:
+		return generateECDSAKey(elliptic.P256())
+	case jose.ES384:
+		return generateECDSAKey(elliptic.P384())
+	case jose.ES512:
+		return generateECDSAKey(elliptic.P521())
+	default:
+		return j.generateRSAKey()
+	}
+}
+
+// generateECDSAKey generates keypair for the curve of the corresponding ECDSA Signature Algorithm.
+func generateECDSAKey(curve elliptic.Curve) (crypto.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "jwks: unable to generate ECDSA key")
+	}
+	return key, nil
+}
+
 // generateRSAKey generates keypair for corresponding RSA Signature Algorithm.
 func (j *JWKS) generateRSAKey() (crypto.PrivateKey, error) {
 	if j.bits == 0 {
@@ -23,5 +23,6 @@ func TestGenerateJwks(t *testing.T) {
 func signingKeysAvailableAlgorithms() []string {
 	return []string{
 		string(jose.RS256), string(jose.RS384), string(jose.RS512), string(jose.PS256), string(jose.PS384), string(jose.PS512),
+		string(jose.ES256), string(jose.ES384), string(jose.ES512),
 	}
 }
@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AccessRulesConfigMapSelector selects the ConfigMaps into which oathkeeper-maester writes the access rules
+const AccessRulesConfigMapSelector = "app.kubernetes.io/name=oathkeeper-maester"
+
+var (
+	configMapResource  = corev1.Resource("configmaps")
+	deploymentResource = appsv1.Resource("deployments")
+)
+
+// DeleteAccessRulesConfigMaps deletes the access rules ConfigMaps of oathkeeper-maester in all namespaces and returns
+// the deleted ones. Nothing is deleted while the oathkeeper deployment exists and is not being deleted,
+// so that the rules are never removed from a live gateway.
+func DeleteAccessRulesConfigMaps(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger,
+	oathkeeper types.NamespacedName) ([]types.NamespacedName, error) {
+	deployment, err := client.AppsV1().Deployments(oathkeeper.Namespace).Get(ctx, oathkeeper.Name, metav1.GetOptions{})
+	if apierr.IsForbidden(err) {
+		return nil, &MissingPermissionError{Verb: "get", Resource: deploymentResource, Namespace: oathkeeper.Namespace, err: err}
+	}
+	if err != nil && !apierr.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && deployment.DeletionTimestamp == nil {
+		logger.Debugf("Oathkeeper deployment %s is running, keeping its access rules", oathkeeper)
+		return nil, nil
+	}
+
+	configMaps, err := client.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: AccessRulesConfigMapSelector})
+	if apierr.IsForbidden(err) {
+		return nil, &MissingPermissionError{Verb: "list", Resource: configMapResource, err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []types.NamespacedName
+	for _, configMap := range configMaps.Items {
+		name := types.NamespacedName{Namespace: configMap.Namespace, Name: configMap.Name}
+		err := client.CoreV1().ConfigMaps(name.Namespace).Delete(ctx, name.Name, metav1.DeleteOptions{})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if apierr.IsForbidden(err) {
+			return deleted, &MissingPermissionError{Verb: "delete", Resource: configMapResource, Namespace: name.Namespace, err: err}
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	if len(deleted) > 0 {
+		logger.Infof("Deleted access rules ConfigMaps %v of oathkeeper", deleted)
+	}
+	return deleted, nil
+}
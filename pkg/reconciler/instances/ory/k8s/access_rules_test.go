@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var oathkeeperName = types.NamespacedName{Namespace: "kyma-system", Name: "ory-oathkeeper"}
+
+func Test_DeleteAccessRulesConfigMaps(t *testing.T) {
+	t.Run("should delete the access rules in all namespaces when oathkeeper is gone", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(
+			fixAccessRulesConfigMap("kyma-system", "ory-oathkeeper-rules", map[string]string{"app.kubernetes.io/name": "oathkeeper-maester"}),
+			fixAccessRulesConfigMap("other", "rules", map[string]string{"app.kubernetes.io/name": "oathkeeper-maester"}),
+			fixAccessRulesConfigMap("kyma-system", "unrelated", map[string]string{"app.kubernetes.io/name": "hydra"}))
+
+		// when
+		deleted, err := DeleteAccessRulesConfigMaps(context.Background(), clientset, logger.NewTestLogger(t), oathkeeperName)
+
+		// then
+		require.NoError(t, err)
+		require.ElementsMatch(t, []types.NamespacedName{
+			{Namespace: "kyma-system", Name: "ory-oathkeeper-rules"},
+			{Namespace: "other", Name: "rules"},
+		}, deleted)
+		_, err = clientset.CoreV1().ConfigMaps("kyma-system").Get(context.Background(), "unrelated", metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should delete the access rules when oathkeeper is being deleted", func(t *testing.T) {
+		// given
+		deployment := fixOathkeeperDeployment()
+		deleted := metav1.Now()
+		deployment.DeletionTimestamp = &deleted
+		clientset := fake.NewSimpleClientset(deployment,
+			fixAccessRulesConfigMap("kyma-system", "ory-oathkeeper-rules", map[string]string{"app.kubernetes.io/name": "oathkeeper-maester"}))
+
+		// when
+		removed, err := DeleteAccessRulesConfigMaps(context.Background(), clientset, logger.NewTestLogger(t), oathkeeperName)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, removed, 1)
+	})
+
+	t.Run("should keep the access rules of a running oathkeeper", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixOathkeeperDeployment(),
+			fixAccessRulesConfigMap("kyma-system", "ory-oathkeeper-rules", map[string]string{"app.kubernetes.io/name": "oathkeeper-maester"}))
+
+		// when
+		deleted, err := DeleteAccessRulesConfigMaps(context.Background(), clientset, logger.NewTestLogger(t), oathkeeperName)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+		_, err = clientset.CoreV1().ConfigMaps("kyma-system").Get(context.Background(), "ory-oathkeeper-rules", metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should report missing permissions", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(corev1.Resource("configmaps"), "", errors.New("forbidden"))
+		})
+
+		// when
+		_, err := DeleteAccessRulesConfigMaps(context.Background(), clientset, logger.NewTestLogger(t), oathkeeperName)
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), "grant list on configmaps cluster-wide")
+	})
+}
+
+func fixAccessRulesConfigMap(namespace, name string, labels map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+}
+
+func fixOathkeeperDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: oathkeeperName.Namespace, Name: oathkeeperName.Name}}
+}
@@ -0,0 +1,178 @@
+package k8s
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	apixv1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
+	apixv1beta1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// ClientProvider provides the clients of a single cluster. Handlers take their clients from it instead of building
+// them from a kubeconfig, so that they can share one set of clients and tests can inject fakes of all kinds at once.
+//
+//go:generate mockery --name=ClientProvider --outpkg=mock --case=underscore
+type ClientProvider interface {
+	ApixClient() (apixv1beta1client.ApiextensionsV1beta1Interface, error)
+	ApixV1Client() (apixv1client.ApiextensionsV1Interface, error)
+	Dynamic() (dynamic.Interface, error)
+	Metadata() (metadata.Interface, error)
+	Clientset() (kubernetes.Interface, error)
+	Discovery() (discovery.DiscoveryInterface, error)
+	RESTMapper() (meta.RESTMapper, error)
+}
+
+// DefaultClientProvider builds the clients of a rest configuration when they are first requested and keeps them.
+// All clients share one HTTP client and its connections.
+type DefaultClientProvider struct {
+	config     *rest.Config
+	httpClient *http.Client
+
+	mu           sync.Mutex
+	apixClient   apixv1beta1client.ApiextensionsV1beta1Interface
+	apixV1Client apixv1client.ApiextensionsV1Interface
+	dynamic      dynamic.Interface
+	metadata     metadata.Interface
+	clientset    kubernetes.Interface
+	discovery    discovery.DiscoveryInterface
+	restMapper   meta.RESTMapper
+}
+
+var _ ClientProvider = &DefaultClientProvider{}
+
+// NewClientProvider creates a provider for the cluster of the rest configuration, which is copied. Clients of a
+// configuration without a User-Agent send DefaultUserAgent instead of the generic client-go one.
+func NewClientProvider(cfg *rest.Config) (*DefaultClientProvider, error) {
+	if cfg == nil {
+		return nil, errors.New("rest config must not be nil")
+	}
+	config := rest.CopyConfig(cfg)
+	if config.UserAgent == "" {
+		config.UserAgent = DefaultUserAgent()
+	}
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the HTTP client")
+	}
+	return &DefaultClientProvider{config: config, httpClient: httpClient}, nil
+}
+
+func (p *DefaultClientProvider) ApixClient() (apixv1beta1client.ApiextensionsV1beta1Interface, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.apixClient == nil {
+		client, err := apixv1beta1client.NewForConfigAndClient(p.config, p.httpClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the apiextensions v1beta1 client")
+		}
+		p.apixClient = client
+	}
+	return p.apixClient, nil
+}
+
+func (p *DefaultClientProvider) ApixV1Client() (apixv1client.ApiextensionsV1Interface, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.apixV1Client == nil {
+		client, err := apixv1client.NewForConfigAndClient(p.config, p.httpClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the apiextensions v1 client")
+		}
+		p.apixV1Client = client
+	}
+	return p.apixV1Client, nil
+}
+
+func (p *DefaultClientProvider) Dynamic() (dynamic.Interface, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dynamic == nil {
+		client, err := dynamic.NewForConfigAndClient(p.config, p.httpClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the dynamic client")
+		}
+		p.dynamic = client
+	}
+	return p.dynamic, nil
+}
+
+// Metadata returns a client which reads the metadata of resources only, see PartialObjectMetadata
+func (p *DefaultClientProvider) Metadata() (metadata.Interface, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata == nil {
+		client, err := metadata.NewForConfigAndClient(p.config, p.httpClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the metadata client")
+		}
+		p.metadata = client
+	}
+	return p.metadata, nil
+}
+
+func (p *DefaultClientProvider) Clientset() (kubernetes.Interface, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clientset == nil {
+		client, err := kubernetes.NewForConfigAndClient(p.config, p.httpClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the clientset")
+		}
+		p.clientset = client
+	}
+	return p.clientset, nil
+}
+
+func (p *DefaultClientProvider) Discovery() (discovery.DiscoveryInterface, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discoveryClient()
+}
+
+// RESTMapper returns a mapper which discovers the resources of the cluster on first use and caches them
+func (p *DefaultClientProvider) RESTMapper() (meta.RESTMapper, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.restMapper == nil {
+		discoveryClient, err := p.discoveryClient()
+		if err != nil {
+			return nil, err
+		}
+		p.restMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	}
+	return p.restMapper, nil
+}
+
+// Host returns the API server of the cluster
+func (p *DefaultClientProvider) Host() string {
+	return p.config.Host
+}
+
+// Close shuts down the idle connections of the clients, which stay usable
+func (p *DefaultClientProvider) Close() {
+	p.httpClient.CloseIdleConnections()
+}
+
+// discoveryClient must be called with the lock held. Discovery stays on JSON like the dynamic client, the discovery
+// client is not set up to negotiate protobuf.
+func (p *DefaultClientProvider) discoveryClient() (discovery.DiscoveryInterface, error) {
+	if p.discovery == nil {
+		config := rest.CopyConfig(p.config)
+		config.ContentType, config.AcceptContentTypes = "", ""
+		client, err := discovery.NewDiscoveryClientForConfigAndClient(config, p.httpClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the discovery client")
+		}
+		p.discovery = client
+	}
+	return p.discovery, nil
+}
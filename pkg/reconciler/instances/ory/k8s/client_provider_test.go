@@ -0,0 +1,218 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apixv1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
+	apixv1beta1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_DefaultClientProvider(t *testing.T) {
+	t.Run("should build the clients on first use and keep them", func(t *testing.T) {
+		// given
+		provider, err := NewClientProvider(&rest.Config{Host: "https://127.0.0.1:6443"})
+		require.NoError(t, err)
+		require.Nil(t, provider.dynamic)
+		require.Nil(t, provider.clientset)
+
+		// when
+		dynamicClient, err := provider.Dynamic()
+		require.NoError(t, err)
+		again, err := provider.Dynamic()
+		require.NoError(t, err)
+
+		// then
+		require.Same(t, dynamicClient, again)
+		require.Nil(t, provider.clientset)
+		require.Equal(t, "https://127.0.0.1:6443", provider.Host())
+	})
+
+	t.Run("should build all kinds of clients", func(t *testing.T) {
+		// given
+		provider, err := NewClientProvider(&rest.Config{Host: "https://127.0.0.1:6443"})
+		require.NoError(t, err)
+
+		// when
+		apixClient, apixErr := provider.ApixClient()
+		apixV1Client, apixV1Err := provider.ApixV1Client()
+		metadataClient, metadataErr := provider.Metadata()
+		clientset, clientsetErr := provider.Clientset()
+		discoveryClient, discoveryErr := provider.Discovery()
+		restMapper, restMapperErr := provider.RESTMapper()
+
+		// then
+		require.NoError(t, apixErr)
+		require.NotNil(t, apixClient)
+		require.NoError(t, apixV1Err)
+		require.NotNil(t, apixV1Client)
+		require.NoError(t, metadataErr)
+		require.NotNil(t, metadataClient)
+		require.NoError(t, clientsetErr)
+		require.NotNil(t, clientset)
+		require.NoError(t, discoveryErr)
+		require.Same(t, discoveryClient, provider.discovery)
+		require.NoError(t, restMapperErr)
+		require.NotNil(t, restMapper)
+	})
+
+	t.Run("should send the default user agent unless the config has one", func(t *testing.T) {
+		for _, tt := range []struct {
+			userAgent string
+			expected  string
+		}{
+			{userAgent: "", expected: DefaultUserAgent()},
+			{userAgent: "custom/1.0", expected: "custom/1.0"},
+		} {
+			// given
+			userAgents := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userAgents <- r.Header.Get("User-Agent")
+				fakeAPIServerHandler("")(w, r)
+			}))
+			provider, err := NewClientProvider(&rest.Config{Host: server.URL, UserAgent: tt.userAgent})
+			require.NoError(t, err)
+			dynamicClient, err := provider.Dynamic()
+			require.NoError(t, err)
+
+			// when
+			_, err = dynamicClient.Resource(oauth2ClientGVR).List(context.Background(), metav1.ListOptions{})
+
+			// then
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, <-userAgents)
+			server.Close()
+		}
+	})
+
+	t.Run("should reject a nil config", func(t *testing.T) {
+		// when
+		_, err := NewClientProvider(nil)
+
+		// then
+		require.EqualError(t, err, "rest config must not be nil")
+	})
+
+	t.Run("should reject an invalid config", func(t *testing.T) {
+		// when
+		_, err := NewClientProvider(&rest.Config{Host: "https://127.0.0.1:6443", TLSClientConfig: rest.TLSClientConfig{CAData: []byte("invalid")}})
+
+		// then
+		require.Error(t, err)
+	})
+}
+
+func Test_WithClientProvider(t *testing.T) {
+	t.Run("should take all clients from the provider", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		provider := newFakeClientProvider(apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+		handler := NewDefaultOryFinalizersHandler(WithClientProvider(provider))
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers("ignored")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireFinalizers(t, dyn, "default", "client")
+		require.Same(t, provider.restMapper, handler.restMapper)
+	})
+
+	t.Run("should take the clients from the provider again after close", func(t *testing.T) {
+		// given
+		provider := newFakeClientProvider(apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), newFakeDynamicClient())
+		handler := NewDefaultOryFinalizersHandler(WithClientProvider(provider))
+		require.NoError(t, handler.initClients(context.Background(), ""))
+
+		// when
+		handler.Close()
+
+		// then
+		require.Nil(t, handler.dynamic)
+		require.NoError(t, handler.initClients(context.Background(), ""))
+		require.Equal(t, 2, provider.calls)
+	})
+
+	t.Run("should create a namespace sweeper with the clients of the provider", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixSweptObject(sweptRuleGVR, "Rule", "kyma-system", "rule", "oathkeeper.ory.sh/finalizer")))
+		provider := newFakeClientProvider(apixfake.NewSimpleClientset(), dyn)
+		provider.discovery = newSweeperDiscovery()
+
+		// when
+		sweeper, err := NewNamespaceFinalizerSweeperForProvider(provider)
+		require.NoError(t, err)
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+	})
+}
+
+// fakeClientProvider provides fake clients and counts how often the dynamic client was requested. It provides no
+// metadata client unless one is set, so that the handler lists with the dynamic client.
+type fakeClientProvider struct {
+	apix       *apixfake.Clientset
+	dynamic    dynamic.Interface
+	metadata   metadata.Interface
+	clientset  kubernetes.Interface
+	discovery  discovery.DiscoveryInterface
+	restMapper meta.RESTMapper
+	calls      int
+}
+
+func newFakeClientProvider(apix *apixfake.Clientset, dyn dynamic.Interface) *fakeClientProvider {
+	return &fakeClientProvider{
+		apix:       apix,
+		dynamic:    dyn,
+		clientset:  k8sfake.NewSimpleClientset(),
+		discovery:  &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}},
+		restMapper: newTestRESTMapper(),
+	}
+}
+
+func (p *fakeClientProvider) ApixClient() (apixv1beta1client.ApiextensionsV1beta1Interface, error) {
+	return p.apix.ApiextensionsV1beta1(), nil
+}
+
+func (p *fakeClientProvider) ApixV1Client() (apixv1client.ApiextensionsV1Interface, error) {
+	return p.apix.ApiextensionsV1(), nil
+}
+
+func (p *fakeClientProvider) Dynamic() (dynamic.Interface, error) {
+	p.calls++
+	return p.dynamic, nil
+}
+
+func (p *fakeClientProvider) Metadata() (metadata.Interface, error) {
+	return p.metadata, nil
+}
+
+func (p *fakeClientProvider) Clientset() (kubernetes.Interface, error) {
+	return p.clientset, nil
+}
+
+func (p *fakeClientProvider) Discovery() (discovery.DiscoveryInterface, error) {
+	return p.discovery, nil
+}
+
+func (p *fakeClientProvider) RESTMapper() (meta.RESTMapper, error) {
+	return p.restMapper, nil
+}
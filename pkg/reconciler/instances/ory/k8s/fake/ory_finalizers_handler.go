@@ -0,0 +1,121 @@
+// Package fake provides a hand-maintained fake of the ory finalizers handler for tests which do not use mockery.
+package fake
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s"
+)
+
+const (
+	FindAndDeleteOryFinalizersMethod = "FindAndDeleteOryFinalizers"
+	StreamOryFinalizersMethod        = "StreamOryFinalizers"
+	DiscoverOryCRDsMethod            = "DiscoverOryCRDs"
+	CountStuckOryResourcesMethod     = "CountStuckOryResources"
+	WaitForCRDDeletedMethod          = "WaitForCRDDeleted"
+	CloseMethod                      = "Close"
+)
+
+// Call is a recorded call of the fake, KubeconfigData is empty for Close and WaitForCRDDeleted
+type Call struct {
+	Method         string
+	KubeconfigData string
+}
+
+// FakeOryFinalizersHandler returns the configured values and records all calls. The zero value returns an empty
+// CleanupResult and no error. The fields must not be changed while the fake is in use.
+type FakeOryFinalizersHandler struct {
+	// Result and Err are returned by FindAndDeleteOryFinalizers
+	Result *k8s.CleanupResult
+	Err    error
+	// ResourceResults are streamed by StreamOryFinalizers, followed by Err
+	ResourceResults []k8s.ResourceResult
+	// CRDs and DiscoverErr are returned by DiscoverOryCRDs
+	CRDs        []k8s.OryCRD
+	DiscoverErr error
+	// StuckResources and CountErr are returned by CountStuckOryResources
+	StuckResources int
+	CountErr       error
+	// WaitErr is returned by WaitForCRDDeleted
+	WaitErr error
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+var _ k8s.OryFinalizersHandler = &FakeOryFinalizersHandler{}
+
+func (f *FakeOryFinalizersHandler) FindAndDeleteOryFinalizers(kubeconfigData string) (*k8s.CleanupResult, error) {
+	f.record(FindAndDeleteOryFinalizersMethod, kubeconfigData)
+	if f.Result == nil {
+		return &k8s.CleanupResult{}, f.Err
+	}
+	return f.Result, f.Err
+}
+
+func (f *FakeOryFinalizersHandler) StreamOryFinalizers(ctx context.Context, kubeconfigData string) (<-chan k8s.ResourceResult, <-chan error) {
+	f.record(StreamOryFinalizersMethod, kubeconfigData)
+	results := make(chan k8s.ResourceResult)
+	errs := make(chan error, 1)
+	go func() {
+		err := f.Err
+	send:
+		for _, result := range f.ResourceResults {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				break send
+			case results <- result:
+			}
+		}
+		close(results)
+		errs <- err
+		close(errs)
+	}()
+	return results, errs
+}
+
+func (f *FakeOryFinalizersHandler) DiscoverOryCRDs(_ context.Context, kubeconfigData string) ([]k8s.OryCRD, error) {
+	f.record(DiscoverOryCRDsMethod, kubeconfigData)
+	return f.CRDs, f.DiscoverErr
+}
+
+func (f *FakeOryFinalizersHandler) CountStuckOryResources(_ context.Context, kubeconfigData string) (int, error) {
+	f.record(CountStuckOryResourcesMethod, kubeconfigData)
+	return f.StuckResources, f.CountErr
+}
+
+func (f *FakeOryFinalizersHandler) WaitForCRDDeleted(_ context.Context, _ string, _ time.Duration) error {
+	f.record(WaitForCRDDeletedMethod, "")
+	return f.WaitErr
+}
+
+func (f *FakeOryFinalizersHandler) Close() {
+	f.record(CloseMethod, "")
+}
+
+// Calls returns all calls in the order they were made
+func (f *FakeOryFinalizersHandler) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallCount returns how often the given method was called
+func (f *FakeOryFinalizersHandler) CallCount(method string) int {
+	count := 0
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *FakeOryFinalizersHandler) record(method, kubeconfigData string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Method: method, KubeconfigData: kubeconfigData})
+}
@@ -0,0 +1,81 @@
+package fake
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// cleanup stands for a caller of the handler, like the ory delete actions
+func cleanup(handler k8s.OryFinalizersHandler, kubeconfig string) error {
+	defer handler.Close()
+	_, err := handler.FindAndDeleteOryFinalizers(kubeconfig)
+	return err
+}
+
+func Test_FakeOryFinalizersHandler(t *testing.T) {
+	t.Run("should record the calls of a caller", func(t *testing.T) {
+		// given
+		handler := &FakeOryFinalizersHandler{}
+
+		// when
+		err := cleanup(handler, "kubeconfig")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []Call{
+			{Method: FindAndDeleteOryFinalizersMethod, KubeconfigData: "kubeconfig"},
+			{Method: CloseMethod},
+		}, handler.Calls())
+		require.Equal(t, 1, handler.CallCount(CloseMethod))
+	})
+
+	t.Run("should return the configured values", func(t *testing.T) {
+		// given
+		cleanupErr := errors.New("cleanup failed")
+		handler := &FakeOryFinalizersHandler{
+			Result:         &k8s.CleanupResult{CRDs: []k8s.CRDResult{{Instances: 2}}},
+			Err:            cleanupErr,
+			CRDs:           []k8s.OryCRD{{Name: "oauth2clients.hydra.ory.sh"}},
+			StuckResources: 3,
+		}
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers("kubeconfig")
+		crds, discoverErr := handler.DiscoverOryCRDs(context.Background(), "kubeconfig")
+		stuck, countErr := handler.CountStuckOryResources(context.Background(), "kubeconfig")
+
+		// then
+		require.Equal(t, cleanupErr, err)
+		require.Equal(t, 2, result.CRDs[0].Instances)
+		require.NoError(t, discoverErr)
+		require.Equal(t, "oauth2clients.hydra.ory.sh", crds[0].Name)
+		require.NoError(t, countErr)
+		require.Equal(t, 3, stuck)
+		require.Equal(t, 0, handler.CallCount(CloseMethod))
+	})
+
+	t.Run("should stream the configured results followed by the error", func(t *testing.T) {
+		// given
+		cleanupErr := errors.New("cleanup failed")
+		handler := &FakeOryFinalizersHandler{
+			ResourceResults: []k8s.ResourceResult{{Name: "a", State: k8s.ResourceProcessed}, {Name: "b", State: k8s.ResourceSkipped}},
+			Err:             cleanupErr,
+		}
+
+		// when
+		results, errs := handler.StreamOryFinalizers(context.Background(), "kubeconfig")
+		var names []string
+		for result := range results {
+			names = append(names, result.Name)
+		}
+
+		// then
+		require.Equal(t, []string{"a", "b"}, names)
+		require.Equal(t, cleanupErr, <-errs)
+		require.Equal(t, 1, handler.CallCount(StreamOryFinalizersMethod))
+	})
+}
@@ -0,0 +1,76 @@
+package k8s
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// HelmReleaseSecretType is the type of the secrets in which helm stores the revisions of a release
+const HelmReleaseSecretType corev1.SecretType = "helm.sh/release.v1"
+
+var secretResource = corev1.Resource("secrets")
+
+// DeleteHelmReleaseSecrets deletes the secrets storing the revisions of the helm release in the namespace and returns
+// how many were deleted. Secrets of other releases are never deleted, and a missing namespace is not an error.
+func DeleteHelmReleaseSecrets(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger, namespace, release string) (int, error) {
+	secrets, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", string(HelmReleaseSecretType)).String(),
+	})
+	if apierr.IsNotFound(err) {
+		logger.Debugf("Namespace %s does not exist, no helm release secrets to delete", namespace)
+		return 0, nil
+	}
+	if apierr.IsForbidden(err) {
+		return 0, &MissingPermissionError{Verb: "list", Resource: secretResource, Namespace: namespace, err: err}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for i := range secrets.Items {
+		secret := secrets.Items[i]
+		if !isHelmReleaseSecret(secret, release) {
+			continue
+		}
+		err := client.CoreV1().Secrets(namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if apierr.IsForbidden(err) {
+			return deleted, &MissingPermissionError{Verb: "delete", Resource: secretResource, Namespace: namespace, err: err}
+		}
+		if err != nil {
+			return deleted, err
+		}
+		logger.Debugf("Deleted helm release secret %s/%s", namespace, secret.Name)
+		deleted++
+	}
+	logger.Infof("Deleted %d helm release secrets of release %s in namespace %s", deleted, release, namespace)
+	return deleted, nil
+}
+
+// isHelmReleaseSecret reports whether the secret stores a revision of the release, identified by the labels helm sets
+// or by the name helm generates. Names of other releases sharing the prefix like "ory-extra" do not match.
+func isHelmReleaseSecret(secret corev1.Secret, release string) bool {
+	if secret.Type != HelmReleaseSecretType {
+		return false
+	}
+	if secret.Labels["owner"] == "helm" && secret.Labels["name"] != "" {
+		return secret.Labels["name"] == release
+	}
+	revision := strings.TrimPrefix(secret.Name, "sh.helm.release.v1."+release+".v")
+	if revision == secret.Name || revision == "" {
+		return false
+	}
+	_, err := strconv.Atoi(revision)
+	return err == nil
+}
@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_DeleteHelmReleaseSecrets(t *testing.T) {
+	t.Run("should delete only the release secrets of the release", func(t *testing.T) {
+		// given
+		unlabeled := fixHelmReleaseSecret("kyma-system", "sh.helm.release.v1.ory.v2", nil)
+		clientset := fake.NewSimpleClientset(
+			fixHelmReleaseSecret("kyma-system", "sh.helm.release.v1.ory.v1", map[string]string{"owner": "helm", "name": "ory"}),
+			unlabeled,
+			fixHelmReleaseSecret("kyma-system", "sh.helm.release.v1.ory-extra.v1", map[string]string{"owner": "helm", "name": "ory-extra"}),
+			fixHelmReleaseSecret("kyma-system", "sh.helm.release.v1.ory-extra.v2", nil),
+			fixHelmReleaseSecret("kyma-system", "sh.helm.release.v1.istio.v1", map[string]string{"owner": "helm", "name": "istio"}),
+			fixHelmReleaseSecret("other", "sh.helm.release.v1.ory.v1", map[string]string{"owner": "helm", "name": "ory"}),
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: "sh.helm.release.v1.ory.v3"}},
+		)
+
+		// when
+		deleted, err := DeleteHelmReleaseSecrets(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", "ory")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, deleted)
+		require.ElementsMatch(t, []string{
+			"kyma-system/sh.helm.release.v1.ory-extra.v1",
+			"kyma-system/sh.helm.release.v1.ory-extra.v2",
+			"kyma-system/sh.helm.release.v1.istio.v1",
+			"kyma-system/sh.helm.release.v1.ory.v3",
+			"other/sh.helm.release.v1.ory.v1",
+		}, listSecretNames(t, clientset))
+	})
+
+	t.Run("should tolerate a missing namespace", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewNotFound(corev1.Resource("namespaces"), "kyma-system")
+		})
+
+		// when
+		deleted, err := DeleteHelmReleaseSecrets(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", "ory")
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, deleted)
+	})
+
+	t.Run("should report missing permissions", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(
+			fixHelmReleaseSecret("kyma-system", "sh.helm.release.v1.ory.v1", map[string]string{"owner": "helm", "name": "ory"}))
+		clientset.PrependReactor("delete", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(corev1.Resource("secrets"), "sh.helm.release.v1.ory.v1", errors.New("forbidden"))
+		})
+
+		// when
+		deleted, err := DeleteHelmReleaseSecrets(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", "ory")
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), `grant delete on secrets in namespace "kyma-system"`)
+		require.Zero(t, deleted)
+	})
+}
+
+func listSecretNames(t *testing.T, clientset *fake.Clientset) []string {
+	secrets, err := clientset.CoreV1().Secrets("").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var names []string
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Namespace+"/"+secret.Name)
+	}
+	return names
+}
+
+func fixHelmReleaseSecret(namespace, name string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Type:       HelmReleaseSecretType,
+	}
+}
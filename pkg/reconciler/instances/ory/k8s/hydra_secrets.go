@@ -0,0 +1,154 @@
+package k8s
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	internalKubernetes "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// SecretsRotationAnnotation holds the ID of the last rotation applied to the hydra credentials secret
+	SecretsRotationAnnotation = "reconciler.kyma-project.io/hydra-secrets-rotation"
+
+	secretsSystemKey   = "secretsSystem"
+	secretsCookieKey   = "secretsCookie"
+	hydraSecretsLength = 32
+)
+
+// HydraSecretsRotator exposes functionality to rotate the system and cookie secrets of hydra
+//
+//go:generate mockery --name=HydraSecretsRotator --outpkg=mock --case=underscore
+type HydraSecretsRotator interface {
+	// RotateSecrets replaces the system and cookie secrets in the hydra credentials secret and restarts the hydra
+	// deployment in the same namespace. The rotation ID makes reruns idempotent: a rotation which was already applied
+	// to the secret is not generated again, only the rollout is repeated.
+	RotateSecrets(ctx context.Context, client internalKubernetes.Client, logger *zap.SugaredLogger,
+		secret types.NamespacedName, deployment, rotationID string) error
+}
+
+type DefaultHydraSecretsRotator struct {
+	rolloutHandler RolloutHandler
+}
+
+// NewDefaultHydraSecretsRotator returns an instance of DefaultHydraSecretsRotator
+func NewDefaultHydraSecretsRotator(rolloutHandler RolloutHandler) *DefaultHydraSecretsRotator {
+	return &DefaultHydraSecretsRotator{rolloutHandler: rolloutHandler}
+}
+
+// HydraSecretNotFoundError is returned when the hydra credentials secret to rotate does not exist
+type HydraSecretNotFoundError struct {
+	Secret types.NamespacedName
+	err    error
+}
+
+func (e *HydraSecretNotFoundError) Error() string {
+	return fmt.Sprintf("hydra credentials secret \"%s\" not found", e.Secret)
+}
+
+func (e *HydraSecretNotFoundError) Unwrap() error {
+	return e.err
+}
+
+func IsHydraSecretNotFoundError(err error) bool {
+	var target *HydraSecretNotFoundError
+	return errors.As(err, &target)
+}
+
+// HydraDeploymentNotFoundError is returned when the hydra deployment to restart after a rotation does not exist
+type HydraDeploymentNotFoundError struct {
+	Deployment types.NamespacedName
+	err        error
+}
+
+func (e *HydraDeploymentNotFoundError) Error() string {
+	return fmt.Sprintf("hydra deployment \"%s\" not found", e.Deployment)
+}
+
+func (e *HydraDeploymentNotFoundError) Unwrap() error {
+	return e.err
+}
+
+func IsHydraDeploymentNotFoundError(err error) bool {
+	var target *HydraDeploymentNotFoundError
+	return errors.As(err, &target)
+}
+
+func (r *DefaultHydraSecretsRotator) RotateSecrets(ctx context.Context, client internalKubernetes.Client, logger *zap.SugaredLogger,
+	secretName types.NamespacedName, deployment, rotationID string) error {
+	if rotationID == "" {
+		return errors.New("rotation ID must not be empty")
+	}
+	clientset, err := client.Clientset()
+	if err != nil {
+		return errors.Wrap(err, "Failed to read clientset")
+	}
+
+	// the deployment is checked first, so that no secrets are rotated which would never be picked up
+	deploymentName := types.NamespacedName{Namespace: secretName.Namespace, Name: deployment}
+	_, err = clientset.AppsV1().Deployments(deploymentName.Namespace).Get(ctx, deploymentName.Name, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		return &HydraDeploymentNotFoundError{Deployment: deploymentName, err: err}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get hydra deployment %s", deploymentName)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(secretName.Namespace).Get(ctx, secretName.Name, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		return &HydraSecretNotFoundError{Secret: secretName, err: err}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get hydra credentials secret %s", secretName)
+	}
+
+	if secret.Annotations[SecretsRotationAnnotation] == rotationID {
+		logger.Infof("Hydra secrets were already rotated by rotation '%s', only restarting hydra", rotationID)
+	} else {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for _, key := range []string{secretsSystemKey, secretsCookieKey} {
+			rotated, err := rotatedSecret(string(secret.Data[key]))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to generate %s", key)
+			}
+			secret.Data[key] = []byte(rotated)
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[SecretsRotationAnnotation] = rotationID
+		if _, err := clientset.CoreV1().Secrets(secretName.Namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "Failed to update hydra credentials secret %s", secretName)
+		}
+		logger.Infof("Rotated hydra secrets in %s by rotation '%s'", secretName, rotationID)
+	}
+
+	if err := r.rolloutHandler.RolloutAndWaitForDeployment(ctx, deployment, secretName.Namespace, client, logger); err != nil {
+		return errors.Wrapf(err, "Failed to restart hydra deployment %s after rotating its secrets", deploymentName)
+	}
+	return nil
+}
+
+// rotatedSecret prepends a new secret to the current one. Hydra signs and encrypts with the first secret
+// and still accepts the previous one, older secrets are dropped.
+func rotatedSecret(current string) (string, error) {
+	b := make([]byte, hydraSecretsLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := base64.URLEncoding.EncodeToString(b)
+	if previous := strings.Split(current, ",")[0]; previous != "" {
+		secret += "," + previous
+	}
+	return secret, nil
+}
@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	internalKubernetes "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes"
+	k8smocks "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var hydraSecretName = types.NamespacedName{Namespace: "kyma-system", Name: "ory-hydra-credentials"}
+
+func Test_RotateSecrets(t *testing.T) {
+	t.Run("should rotate the secrets and keep the previous ones", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixHydraSecret("old-system,older-system", "old-cookie"), fixHydraDeployment())
+		rollout := &fakeRolloutHandler{}
+		rotator := NewDefaultHydraSecretsRotator(rollout)
+
+		// when
+		err := rotator.RotateSecrets(context.Background(), newFakeKubeClient(clientset), logger.NewTestLogger(t),
+			hydraSecretName, "ory-hydra", "rotation-1")
+
+		// then
+		require.NoError(t, err)
+		secret := getHydraSecret(t, clientset)
+		system := strings.Split(string(secret.Data[secretsSystemKey]), ",")
+		require.Len(t, system, 2)
+		require.NotEqual(t, "old-system", system[0])
+		require.Len(t, system[0], 44)
+		require.Equal(t, "old-system", system[1])
+		cookie := strings.Split(string(secret.Data[secretsCookieKey]), ",")
+		require.Len(t, cookie, 2)
+		require.Equal(t, "old-cookie", cookie[1])
+		require.Equal(t, "rotation-1", secret.Annotations[SecretsRotationAnnotation])
+		require.Equal(t, "dsn", string(secret.Data["dsn"]))
+		require.Equal(t, 1, rollout.calls)
+	})
+
+	t.Run("should not rotate again when rerun after a failed rollout", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixHydraSecret("old-system", "old-cookie"), fixHydraDeployment())
+		rollout := &fakeRolloutHandler{err: errors.New("rollout timed out")}
+		rotator := NewDefaultHydraSecretsRotator(rollout)
+		client := newFakeKubeClient(clientset)
+		err := rotator.RotateSecrets(context.Background(), client, logger.NewTestLogger(t), hydraSecretName, "ory-hydra", "rotation-1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "rollout timed out")
+		rotated := getHydraSecret(t, clientset)
+
+		// when
+		rollout.err = nil
+		err = rotator.RotateSecrets(context.Background(), client, logger.NewTestLogger(t), hydraSecretName, "ory-hydra", "rotation-1")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, rotated.Data, getHydraSecret(t, clientset).Data)
+		require.Equal(t, 2, rollout.calls)
+	})
+
+	t.Run("should generate secrets when none exist yet", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixHydraSecret("", ""), fixHydraDeployment())
+		rotator := NewDefaultHydraSecretsRotator(&fakeRolloutHandler{})
+
+		// when
+		err := rotator.RotateSecrets(context.Background(), newFakeKubeClient(clientset), logger.NewTestLogger(t),
+			hydraSecretName, "ory-hydra", "rotation-1")
+
+		// then
+		require.NoError(t, err)
+		secret := getHydraSecret(t, clientset)
+		require.NotContains(t, string(secret.Data[secretsSystemKey]), ",")
+		require.NotEmpty(t, secret.Data[secretsSystemKey])
+	})
+
+	t.Run("should return a typed error when the secret is missing", func(t *testing.T) {
+		// given
+		rollout := &fakeRolloutHandler{}
+		rotator := NewDefaultHydraSecretsRotator(rollout)
+
+		// when
+		err := rotator.RotateSecrets(context.Background(), newFakeKubeClient(fake.NewSimpleClientset(fixHydraDeployment())),
+			logger.NewTestLogger(t), hydraSecretName, "ory-hydra", "rotation-1")
+
+		// then
+		require.True(t, IsHydraSecretNotFoundError(err))
+		require.Contains(t, err.Error(), hydraSecretName.String())
+		require.Zero(t, rollout.calls)
+	})
+
+	t.Run("should return a typed error and keep the secrets when the deployment is missing", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixHydraSecret("old-system", "old-cookie"))
+		rotator := NewDefaultHydraSecretsRotator(&fakeRolloutHandler{})
+
+		// when
+		err := rotator.RotateSecrets(context.Background(), newFakeKubeClient(clientset), logger.NewTestLogger(t),
+			hydraSecretName, "ory-hydra", "rotation-1")
+
+		// then
+		require.True(t, IsHydraDeploymentNotFoundError(err))
+		require.False(t, IsHydraSecretNotFoundError(err))
+		require.Equal(t, "old-system", string(getHydraSecret(t, clientset).Data[secretsSystemKey]))
+	})
+
+	t.Run("should require a rotation ID", func(t *testing.T) {
+		// given
+		rotator := NewDefaultHydraSecretsRotator(&fakeRolloutHandler{})
+
+		// when
+		err := rotator.RotateSecrets(context.Background(), newFakeKubeClient(fake.NewSimpleClientset()),
+			logger.NewTestLogger(t), hydraSecretName, "ory-hydra", "")
+
+		// then
+		require.Error(t, err)
+	})
+}
+
+type fakeRolloutHandler struct {
+	calls int
+	err   error
+}
+
+func (h *fakeRolloutHandler) RolloutAndWaitForDeployment(_ context.Context, _, _ string, _ internalKubernetes.Client, _ *zap.SugaredLogger) error {
+	h.calls++
+	return h.err
+}
+
+func newFakeKubeClient(clientset *fake.Clientset) *k8smocks.Client {
+	client := &k8smocks.Client{}
+	client.On("Clientset").Return(clientset, nil)
+	return client
+}
+
+func getHydraSecret(t *testing.T, clientset *fake.Clientset) *corev1.Secret {
+	secret, err := clientset.CoreV1().Secrets(hydraSecretName.Namespace).Get(context.Background(), hydraSecretName.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
+
+func fixHydraSecret(system, cookie string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: hydraSecretName.Namespace, Name: hydraSecretName.Name},
+		Data: map[string][]byte{
+			secretsSystemKey: []byte(system),
+			secretsCookieKey: []byte(cookie),
+			"dsn":            []byte("dsn"),
+		},
+	}
+}
+
+func fixHydraDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: hydraSecretName.Namespace, Name: "ory-hydra"}}
+}
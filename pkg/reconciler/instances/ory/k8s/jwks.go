@@ -0,0 +1,112 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/jwks"
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/service"
+	"github.com/pkg/errors"
+	jose "github.com/square/go-jose/v3"
+	"go.uber.org/zap"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// JWKSKey is the key of the JSON Web Key Set in the oathkeeper JWKS secret
+const JWKSKey = "jwks.json"
+
+// JWKSHandler exposes functionality to provide the JSON Web Key Set used by the oathkeeper id_token mutator
+//
+//go:generate mockery --name=JWKSHandler --outpkg=mock --case=underscore
+type JWKSHandler interface {
+	// EnsureJWKSSecret creates the JWKS secret with a new key set if it does not exist and reports whether it was created.
+	// An existing secret is never modified, so that issued tokens keep validating.
+	EnsureJWKSSecret(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger, name types.NamespacedName) (bool, error)
+}
+
+type DefaultJWKSHandler struct {
+	alg  string
+	bits int
+}
+
+// NewDefaultJWKSHandler returns an instance of DefaultJWKSHandler generating keys for the given signature algorithm.
+// The key size only applies to RSA algorithms.
+func NewDefaultJWKSHandler(alg string, bits int) *DefaultJWKSHandler {
+	return &DefaultJWKSHandler{alg: alg, bits: bits}
+}
+
+// MalformedJWKSSecretError is returned when the existing JWKS secret does not contain a usable key set
+type MalformedJWKSSecretError struct {
+	Secret types.NamespacedName
+	Reason string
+	err    error
+}
+
+func (e *MalformedJWKSSecretError) Error() string {
+	return fmt.Sprintf("JWKS secret \"%s\" is malformed: %s", e.Secret, e.Reason)
+}
+
+func (e *MalformedJWKSSecretError) Unwrap() error {
+	return e.err
+}
+
+func IsMalformedJWKSSecretError(err error) bool {
+	var target *MalformedJWKSSecretError
+	return errors.As(err, &target)
+}
+
+func (h *DefaultJWKSHandler) EnsureJWKSSecret(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger,
+	name types.NamespacedName) (bool, error) {
+	secret, err := client.CoreV1().Secrets(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
+	if err == nil {
+		logger.Debugf("JWKS secret %s exists, leaving it untouched", name)
+		return false, validateJWKS(name, secret.Data[JWKSKey])
+	}
+	if !apierr.IsNotFound(err) {
+		return false, errors.Wrapf(err, "Could not get JWKS secret %s", name)
+	}
+
+	logger.Infof("JWKS secret %s does not exist, creating it now", name)
+	secret, err = jwks.Get(name, h.alg, h.bits)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create jwks secret for ORY OathKeeper")
+	}
+	secret.Labels = map[string]string{service.ManagedByLabel: service.LabelReconcilerValue}
+	_, err = client.CoreV1().Secrets(name.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if apierr.IsAlreadyExists(err) {
+		logger.Infof("JWKS secret %s was created concurrently, leaving it untouched", name)
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to create JWKS secret %s", name)
+	}
+	return true, nil
+}
+
+// validateJWKS checks that the key set contains private keys, which oathkeeper needs to sign tokens
+func validateJWKS(name types.NamespacedName, data []byte) error {
+	malformed := func(err error, reason string) error {
+		return &MalformedJWKSSecretError{Secret: name, Reason: reason, err: err}
+	}
+
+	if len(data) == 0 {
+		return malformed(nil, fmt.Sprintf("key \"%s\" is missing or empty", JWKSKey))
+	}
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return malformed(err, "key set cannot be parsed")
+	}
+	if len(keySet.Keys) == 0 {
+		return malformed(nil, "key set contains no keys")
+	}
+	for _, key := range keySet.Keys {
+		if !key.Valid() || key.IsPublic() {
+			return malformed(nil, fmt.Sprintf("key \"%s\" is not a valid private key", key.KeyID))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/service"
+	jose "github.com/square/go-jose/v3"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var jwksSecretName = types.NamespacedName{Namespace: "kyma-system", Name: "ory-oathkeeper-jwks-secret"}
+
+func Test_EnsureJWKSSecret(t *testing.T) {
+	t.Run("should create the secret with a private key set", func(t *testing.T) {
+		for _, alg := range []jose.SignatureAlgorithm{jose.RS256, jose.ES256} {
+			// given
+			clientset := fake.NewSimpleClientset()
+			handler := NewDefaultJWKSHandler(string(alg), 2048)
+
+			// when
+			created, err := handler.EnsureJWKSSecret(context.Background(), clientset, logger.NewTestLogger(t), jwksSecretName)
+
+			// then
+			require.NoError(t, err)
+			require.True(t, created)
+			secret, err := clientset.CoreV1().Secrets(jwksSecretName.Namespace).Get(context.Background(), jwksSecretName.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, service.LabelReconcilerValue, secret.Labels[service.ManagedByLabel])
+			var keySet jose.JSONWebKeySet
+			require.NoError(t, json.Unmarshal(secret.Data[JWKSKey], &keySet))
+			require.Len(t, keySet.Keys, 1)
+			require.Equal(t, string(alg), keySet.Keys[0].Algorithm)
+			require.False(t, keySet.Keys[0].IsPublic())
+		}
+	})
+
+	t.Run("should leave an existing secret untouched", func(t *testing.T) {
+		// given
+		existing := fixJWKSSecret(t)
+		clientset := fake.NewSimpleClientset(existing)
+		handler := NewDefaultJWKSHandler(string(jose.RS256), 2048)
+
+		// when
+		created, err := handler.EnsureJWKSSecret(context.Background(), clientset, logger.NewTestLogger(t), jwksSecretName)
+
+		// then
+		require.NoError(t, err)
+		require.False(t, created)
+		secret, err := clientset.CoreV1().Secrets(jwksSecretName.Namespace).Get(context.Background(), jwksSecretName.Name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, existing.Data, secret.Data)
+		for _, action := range clientset.Actions() {
+			require.Equal(t, "get", action.GetVerb())
+		}
+	})
+
+	t.Run("should report a malformed existing secret without modifying it", func(t *testing.T) {
+		publicKeySet := func() []byte {
+			var keySet jose.JSONWebKeySet
+			require.NoError(t, json.Unmarshal(fixJWKSSecret(t).Data[JWKSKey], &keySet))
+			keySet.Keys[0] = keySet.Keys[0].Public()
+			data, err := json.Marshal(keySet)
+			require.NoError(t, err)
+			return data
+		}
+		for name, data := range map[string][]byte{
+			"missing key set":  nil,
+			"unparsable":       []byte("{not json"),
+			"empty key set":    []byte(`{"keys":[]}`),
+			"public keys only": publicKeySet(),
+		} {
+			t.Run(name, func(t *testing.T) {
+				// given
+				existing := fixJWKSSecret(t)
+				existing.Data[JWKSKey] = data
+				clientset := fake.NewSimpleClientset(existing)
+				handler := NewDefaultJWKSHandler(string(jose.RS256), 2048)
+
+				// when
+				created, err := handler.EnsureJWKSSecret(context.Background(), clientset, logger.NewTestLogger(t), jwksSecretName)
+
+				// then
+				require.False(t, created)
+				require.True(t, IsMalformedJWKSSecretError(err))
+				require.Contains(t, err.Error(), jwksSecretName.String())
+				for _, action := range clientset.Actions() {
+					require.Equal(t, "get", action.GetVerb())
+				}
+			})
+		}
+	})
+}
+
+func fixJWKSSecret(t *testing.T) *corev1.Secret {
+	clientset := fake.NewSimpleClientset()
+	_, err := NewDefaultJWKSHandler(string(jose.RS256), 2048).EnsureJWKSSecret(context.Background(), clientset, logger.NewTestLogger(t), jwksSecretName)
+	require.NoError(t, err)
+	secret, err := clientset.CoreV1().Secrets(jwksSecretName.Namespace).Get(context.Background(), jwksSecretName.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	k8sRetry "k8s.io/client-go/util/retry"
+)
+
+// PatchMetadata sets and removes annotations and sets labels of an object with a merge patch, which leaves all other
+// fields and concurrent changes of other annotations and labels untouched. Objects without annotations or labels are
+// patched alike. Transient errors are retried. It returns the patched object, or nil without a request if there is
+// nothing to change.
+func PatchMetadata(ctx context.Context, dyn dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string,
+	setAnnotations map[string]string, removeAnnotations []string, labels map[string]string) (*unstructured.Unstructured, error) {
+	patch, err := metadataPatch(setAnnotations, removeAnnotations, labels)
+	if err != nil || patch == nil {
+		return nil, err
+	}
+
+	var patched *unstructured.Unstructured
+	err = RetryOnTransient(ctx, k8sRetry.DefaultBackoff, func() (err error) {
+		patched, err = dyn.Resource(gvr).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, permissionError(err, "patch", gvr.GroupResource(), namespace)
+	}
+	return patched, nil
+}
+
+// metadataPatch builds the merge patch of PatchMetadata, a removed annotation is set to null. The keys are ordered,
+// so that equal changes result in equal patches.
+func metadataPatch(setAnnotations map[string]string, removeAnnotations []string, labels map[string]string) ([]byte, error) {
+	metadata := map[string]interface{}{}
+	annotations := map[string]interface{}{}
+	for key, value := range setAnnotations {
+		annotations[key] = value
+	}
+	for _, key := range removeAnnotations {
+		if _, ok := setAnnotations[key]; ok {
+			return nil, errors.Errorf("annotation \"%s\" cannot be set and removed at once", key)
+		}
+		annotations[key] = nil
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+
+	var patch bytes.Buffer
+	encoder := json.NewEncoder(&patch)
+	// values like "<none>" are kept readable in audit logs
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(map[string]interface{}{"metadata": metadata}); err != nil {
+		return nil, errors.Wrap(err, "failed to encode metadata patch")
+	}
+	return bytes.TrimSuffix(patch.Bytes(), []byte("\n")), nil
+}
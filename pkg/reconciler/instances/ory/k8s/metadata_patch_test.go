@@ -0,0 +1,177 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_PatchMetadata(t *testing.T) {
+	t.Run("should build a patch touching only the changed metadata", func(t *testing.T) {
+		tests := []struct {
+			name              string
+			setAnnotations    map[string]string
+			removeAnnotations []string
+			labels            map[string]string
+			expected          string
+		}{
+			{
+				name:           "set annotation with slashes",
+				setAnnotations: map[string]string{"reconciler.kyma-project.io/audit": "removed finalizers"},
+				expected:       `{"metadata":{"annotations":{"reconciler.kyma-project.io/audit":"removed finalizers"}}}`,
+			},
+			{
+				name:              "remove annotation",
+				removeAnnotations: []string{"reconciler.kyma-project.io/opt-out"},
+				expected:          `{"metadata":{"annotations":{"reconciler.kyma-project.io/opt-out":null}}}`,
+			},
+			{
+				name:              "set and remove annotations in order",
+				setAnnotations:    map[string]string{"b/done": "true", "a/started": "2022-11-03T10:00:00Z"},
+				removeAnnotations: []string{"c/pending"},
+				expected:          `{"metadata":{"annotations":{"a/started":"2022-11-03T10:00:00Z","b/done":"true","c/pending":null}}}`,
+			},
+			{
+				name:     "set labels",
+				labels:   map[string]string{"app.kubernetes.io/managed-by": "reconciler", "cleanup": ""},
+				expected: `{"metadata":{"labels":{"app.kubernetes.io/managed-by":"reconciler","cleanup":""}}}`,
+			},
+			{
+				name:           "set annotations and labels without escaping",
+				setAnnotations: map[string]string{"note": `<none> & "quoted"`},
+				labels:         map[string]string{"cleanup": "done"},
+				expected:       `{"metadata":{"annotations":{"note":"<none> & \"quoted\""},"labels":{"cleanup":"done"}}}`,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				// when
+				patch, err := metadataPatch(tt.setAnnotations, tt.removeAnnotations, tt.labels)
+
+				// then
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, string(patch))
+			})
+		}
+	})
+
+	t.Run("should send the patch as merge patch and return the patched object", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client")))
+		patches := recordPatches(dyn)
+
+		// when
+		patched, err := PatchMetadata(context.Background(), dyn, oauth2ClientGVR, "default", "client",
+			map[string]string{"reconciler.kyma-project.io/audit": "true"}, nil, map[string]string{"cleanup": "done"})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{`{"metadata":{"annotations":{"reconciler.kyma-project.io/audit":"true"},"labels":{"cleanup":"done"}}}`}, *patches)
+		require.Equal(t, map[string]string{"reconciler.kyma-project.io/audit": "true"}, patched.GetAnnotations())
+		require.Equal(t, map[string]string{"cleanup": "done"}, patched.GetLabels())
+	})
+
+	t.Run("should keep other annotations and remove the given ones", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client")
+		instance.SetAnnotations(map[string]string{"kept": "yes", "reconciler.kyma-project.io/opt-out": "true"})
+		dyn := newFakeDynamicClient(withObjects(instance))
+
+		// when
+		patched, err := PatchMetadata(context.Background(), dyn, oauth2ClientGVR, "default", "client",
+			nil, []string{"reconciler.kyma-project.io/opt-out"}, nil)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"kept": "yes"}, patched.GetAnnotations())
+	})
+
+	t.Run("should remove annotations from an object without annotations", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client")))
+
+		// when
+		patched, err := PatchMetadata(context.Background(), dyn, oauth2ClientGVR, "default", "client", nil, []string{"missing"}, nil)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, patched.GetAnnotations())
+	})
+
+	t.Run("should not send a request without changes", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client")))
+		patches := recordPatches(dyn)
+
+		// when
+		patched, err := PatchMetadata(context.Background(), dyn, oauth2ClientGVR, "default", "client", map[string]string{}, nil, nil)
+
+		// then
+		require.NoError(t, err)
+		require.Nil(t, patched)
+		require.Empty(t, *patches)
+	})
+
+	t.Run("should reject an annotation which is set and removed", func(t *testing.T) {
+		// when
+		_, err := PatchMetadata(context.Background(), newFakeDynamicClient(), oauth2ClientGVR, "default", "client",
+			map[string]string{"a/b": "c"}, []string{"a/b"}, nil)
+
+		// then
+		require.EqualError(t, err, `annotation "a/b" cannot be set and removed at once`)
+	})
+
+	t.Run("should retry transient errors", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client")))
+		calls := failFirstCalls(dyn, "patch", 2, apierr.NewServiceUnavailable("etcd leader changed"))
+
+		// when
+		_, err := PatchMetadata(context.Background(), dyn, oauth2ClientGVR, "default", "client", map[string]string{"a": "b"}, nil, nil)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 3, *calls)
+	})
+
+	t.Run("should name the missing permission", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client")))
+		failFirstCalls(dyn, "patch", 1, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "client", errors.New("rbac")))
+
+		// when
+		_, err := PatchMetadata(context.Background(), dyn, oauth2ClientGVR, "default", "client", map[string]string{"a": "b"}, nil, nil)
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+	})
+
+	t.Run("should return not found errors", func(t *testing.T) {
+		// when
+		_, err := PatchMetadata(context.Background(), newFakeDynamicClient(), oauth2ClientGVR, "default", "client",
+			map[string]string{"a": "b"}, nil, nil)
+
+		// then
+		require.True(t, apierr.IsNotFound(err))
+	})
+}
+
+// recordPatches records the merge patches of oauth2clients, which still reach the tracker
+func recordPatches(dyn *dynamicfake.FakeDynamicClient) *[]string {
+	var patches []string
+	dyn.PrependReactor("patch", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(k8stesting.PatchAction)
+		if patchAction.GetPatchType() == types.MergePatchType {
+			patches = append(patches, string(patchAction.GetPatch()))
+		}
+		return false, nil, nil
+	})
+	return &patches
+}
@@ -0,0 +1,42 @@
+package mock_test
+
+import (
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s"
+	mock "github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// The mocks are regenerated with go generate, these assertions make go test fail as soon as one is stale
+var (
+	_ k8s.ClientProvider       = &mock.ClientProvider{}
+	_ k8s.HydraSecretsRotator  = &mock.HydraSecretsRotator{}
+	_ k8s.JWKSHandler          = &mock.JWKSHandler{}
+	_ k8s.OryFinalizersHandler = &mock.OryFinalizersHandler{}
+	_ k8s.ReadinessChecker     = &mock.ReadinessChecker{}
+	_ k8s.RolloutHandler       = &mock.RolloutHandler{}
+)
+
+func Test_ClientProvider(t *testing.T) {
+	t.Run("should return the configured clients", func(t *testing.T) {
+		// given
+		dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+		provider := mock.NewClientProvider(t)
+		provider.On("Dynamic").Return(dyn, nil)
+		provider.On("Clientset").Return(nil, errors.New("unreachable"))
+
+		// when
+		gotDynamic, dynamicErr := provider.Dynamic()
+		gotClientset, clientsetErr := provider.Clientset()
+
+		// then
+		require.NoError(t, dynamicErr)
+		require.Same(t, dyn, gotDynamic)
+		require.EqualError(t, clientsetErr, "unreachable")
+		require.Nil(t, gotClientset)
+	})
+}
@@ -3,8 +3,12 @@
 package mock
 
 import (
+	context "context"
+
+	k8s "github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s"
 	mock "github.com/stretchr/testify/mock"
-	zap "go.uber.org/zap"
+
+	time "time"
 )
 
 // OryFinalizersHandler is an autogenerated mock type for the OryFinalizersHandler type
@@ -12,13 +16,110 @@ type OryFinalizersHandler struct {
 	mock.Mock
 }
 
-// FindAndDeleteOryFinalizers provides a mock function with given fields: kubeconfigData, logger
-func (_m *OryFinalizersHandler) FindAndDeleteOryFinalizers(kubeconfigData string, logger *zap.SugaredLogger) error {
-	ret := _m.Called(kubeconfigData, logger)
+// Close provides a mock function with given fields:
+func (_m *OryFinalizersHandler) Close() {
+	_m.Called()
+}
+
+// CountStuckOryResources provides a mock function with given fields: ctx, kubeconfigData
+func (_m *OryFinalizersHandler) CountStuckOryResources(ctx context.Context, kubeconfigData string) (int, error) {
+	ret := _m.Called(ctx, kubeconfigData)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, string) int); ok {
+		r0 = rf(ctx, kubeconfigData)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, kubeconfigData)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DiscoverOryCRDs provides a mock function with given fields: ctx, kubeconfigData
+func (_m *OryFinalizersHandler) DiscoverOryCRDs(ctx context.Context, kubeconfigData string) ([]k8s.OryCRD, error) {
+	ret := _m.Called(ctx, kubeconfigData)
+
+	var r0 []k8s.OryCRD
+	if rf, ok := ret.Get(0).(func(context.Context, string) []k8s.OryCRD); ok {
+		r0 = rf(ctx, kubeconfigData)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]k8s.OryCRD)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, kubeconfigData)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindAndDeleteOryFinalizers provides a mock function with given fields: kubeconfigData
+func (_m *OryFinalizersHandler) FindAndDeleteOryFinalizers(kubeconfigData string) (*k8s.CleanupResult, error) {
+	ret := _m.Called(kubeconfigData)
+
+	var r0 *k8s.CleanupResult
+	if rf, ok := ret.Get(0).(func(string) *k8s.CleanupResult); ok {
+		r0 = rf(kubeconfigData)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*k8s.CleanupResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(kubeconfigData)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// StreamOryFinalizers provides a mock function with given fields: ctx, kubeconfigData
+func (_m *OryFinalizersHandler) StreamOryFinalizers(ctx context.Context, kubeconfigData string) (<-chan k8s.ResourceResult, <-chan error) {
+	ret := _m.Called(ctx, kubeconfigData)
+
+	var r0 <-chan k8s.ResourceResult
+	if rf, ok := ret.Get(0).(func(context.Context, string) <-chan k8s.ResourceResult); ok {
+		r0 = rf(ctx, kubeconfigData)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan k8s.ResourceResult)
+		}
+	}
+
+	var r1 <-chan error
+	if rf, ok := ret.Get(1).(func(context.Context, string) <-chan error); ok {
+		r1 = rf(ctx, kubeconfigData)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(<-chan error)
+		}
+	}
+
+	return r0, r1
+}
+
+// WaitForCRDDeleted provides a mock function with given fields: ctx, name, timeout
+func (_m *OryFinalizersHandler) WaitForCRDDeleted(ctx context.Context, name string, timeout time.Duration) error {
+	ret := _m.Called(ctx, name, timeout)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, *zap.SugaredLogger) error); ok {
-		r0 = rf(kubeconfigData, logger)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration) error); ok {
+		r0 = rf(ctx, name, timeout)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sRetry "k8s.io/client-go/util/retry"
+)
+
+var namespaceFinalizeResource = corev1.Resource("namespaces/finalize")
+
+// NamespaceNotTerminatingError is returned by FinalizeNamespace for a namespace which is not being deleted
+type NamespaceNotTerminatingError struct {
+	Namespace string
+	Phase     corev1.NamespacePhase
+}
+
+func (e *NamespaceNotTerminatingError) Error() string {
+	return fmt.Sprintf("namespace \"%s\" is in phase %s, only terminating namespaces are finalized", e.Namespace, e.Phase)
+}
+
+func IsNamespaceNotTerminatingError(err error) bool {
+	var target *NamespaceNotTerminatingError
+	return errors.As(err, &target)
+}
+
+// FinalizeNamespace clears spec.finalizers of a terminating namespace, like the kubernetes finalizer, through the
+// finalize subresource, as they cannot be removed by an update of the namespace. The namespace is deleted right away
+// then, leaving any remaining content behind. That is why Sweep should be called before, which unblocks the content
+// first. Namespaces not in the Terminating phase are refused with a NamespaceNotTerminatingError, a namespace which is
+// gone already is no error.
+func (s *NamespaceFinalizerSweeper) FinalizeNamespace(ctx context.Context, name string) error {
+	resource := s.dynamic.Resource(namespaceGVR)
+	var removed []corev1.FinalizerName
+	err := k8sRetry.RetryOnConflict(k8sRetry.DefaultRetry, func() error {
+		res, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return permissionError(err, "get", namespaceGVR.GroupResource(), "")
+		}
+		var ns corev1.Namespace
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(res.Object, &ns); err != nil {
+			return errors.Wrapf(err, "failed to read namespace \"%s\"", name)
+		}
+		if ns.Status.Phase != corev1.NamespaceTerminating {
+			return &NamespaceNotTerminatingError{Namespace: name, Phase: ns.Status.Phase}
+		}
+		removed = ns.Spec.Finalizers
+		if len(removed) == 0 {
+			return nil
+		}
+
+		ns.Spec.Finalizers = nil
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&ns)
+		if err != nil {
+			return errors.Wrapf(err, "failed to convert namespace \"%s\"", name)
+		}
+		_, err = resource.Update(ctx, &unstructured.Unstructured{Object: object}, metav1.UpdateOptions{}, "finalize")
+		return permissionError(err, "update", namespaceFinalizeResource, "")
+	})
+	if apierr.IsNotFound(err) {
+		s.logger.Debugf("Namespace \"%s\" is gone, nothing to finalize", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		s.logger.Infof("Removed the finalizers %v of namespace \"%s\"", removed, name)
+	}
+	return nil
+}
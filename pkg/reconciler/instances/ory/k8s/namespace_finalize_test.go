@@ -0,0 +1,167 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_FinalizeNamespace(t *testing.T) {
+	t.Run("should clear the finalizers through the finalize subresource", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixFinalizedNamespace("kyma-system", corev1.NamespaceTerminating, corev1.FinalizerKubernetes)))
+		subresources := recordNamespaceUpdates(dyn)
+
+		// when
+		err := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn).FinalizeNamespace(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{"finalize"}, *subresources)
+		require.Empty(t, namespaceFinalizers(t, dyn, "kyma-system"))
+	})
+
+	t.Run("should refuse namespaces which are not terminating", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixFinalizedNamespace("kyma-system", corev1.NamespaceActive, corev1.FinalizerKubernetes)))
+		subresources := recordNamespaceUpdates(dyn)
+
+		// when
+		err := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn).FinalizeNamespace(context.Background(), "kyma-system")
+
+		// then
+		require.True(t, IsNamespaceNotTerminatingError(err))
+		require.EqualError(t, err, "namespace \"kyma-system\" is in phase Active, only terminating namespaces are finalized")
+		require.Empty(t, *subresources)
+		require.Equal(t, []corev1.FinalizerName{corev1.FinalizerKubernetes}, namespaceFinalizers(t, dyn, "kyma-system"))
+	})
+
+	t.Run("should retry on conflict", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixFinalizedNamespace("kyma-system", corev1.NamespaceTerminating, corev1.FinalizerKubernetes)))
+		conflicts := 0
+		dyn.PrependReactor("update", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicts++; conflicts == 1 {
+				return true, nil, apierr.NewConflict(namespaceGVR.GroupResource(), "kyma-system", errors.New("modified"))
+			}
+			return false, nil, nil
+		})
+
+		// when
+		err := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn).FinalizeNamespace(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, conflicts)
+		require.Empty(t, namespaceFinalizers(t, dyn, "kyma-system"))
+	})
+
+	t.Run("should not send an update without finalizers", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixFinalizedNamespace("kyma-system", corev1.NamespaceTerminating)))
+		subresources := recordNamespaceUpdates(dyn)
+
+		// when
+		err := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn).FinalizeNamespace(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, *subresources)
+	})
+
+	t.Run("should accept a namespace which is gone", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds))
+
+		// when
+		err := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn).FinalizeNamespace(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("should name the missing permission", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixFinalizedNamespace("kyma-system", corev1.NamespaceTerminating, corev1.FinalizerKubernetes)))
+		dyn.PrependReactor("update", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(namespaceFinalizeResource, "kyma-system", errors.New("rbac"))
+		})
+
+		// when
+		err := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn).FinalizeNamespace(context.Background(), "kyma-system")
+
+		// then
+		var permissionErr *MissingPermissionError
+		require.True(t, errors.As(err, &permissionErr))
+		require.Equal(t, namespaceFinalizeResource, permissionErr.Resource)
+	})
+
+	t.Run("should finalize the namespace after sweeping its content", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixFinalizedNamespace("kyma-system", corev1.NamespaceTerminating, corev1.FinalizerKubernetes),
+			fixSweptObject(sweptRuleGVR, "Rule", "kyma-system", "rule", "oathkeeper.ory.sh/finalizer")))
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn)
+
+		// when
+		result, sweepErr := sweeper.Sweep(context.Background(), "kyma-system")
+		finalizeErr := sweeper.FinalizeNamespace(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, sweepErr)
+		require.NoError(t, finalizeErr)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireSweptFinalizers(t, dyn, sweptRuleGVR, "kyma-system", "rule")
+		require.Empty(t, namespaceFinalizers(t, dyn, "kyma-system"))
+	})
+}
+
+func fixFinalizedNamespace(name string, phase corev1.NamespacePhase, finalizers ...corev1.FinalizerName) *unstructured.Unstructured {
+	ns := fixTerminatingNamespace(name, "")
+	if err := unstructured.SetNestedField(ns.Object, string(phase), "status", "phase"); err != nil {
+		panic(err)
+	}
+	if phase != corev1.NamespaceTerminating {
+		ns.SetDeletionTimestamp(nil)
+	}
+	spec := make([]interface{}, 0, len(finalizers))
+	for _, finalizer := range finalizers {
+		spec = append(spec, string(finalizer))
+	}
+	if err := unstructured.SetNestedSlice(ns.Object, spec, "spec", "finalizers"); err != nil {
+		panic(err)
+	}
+	return ns
+}
+
+// recordNamespaceUpdates records the subresources of all namespace updates
+func recordNamespaceUpdates(dyn *dynamicfake.FakeDynamicClient) *[]string {
+	var subresources []string
+	dyn.PrependReactor("update", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		subresources = append(subresources, action.GetSubresource())
+		return false, nil, nil
+	})
+	return &subresources
+}
+
+func namespaceFinalizers(t *testing.T, dyn *dynamicfake.FakeDynamicClient, name string) []corev1.FinalizerName {
+	res, err := dyn.Resource(namespaceGVR).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	var ns corev1.Namespace
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(res.Object, &ns))
+	return ns.Spec.Finalizers
+}
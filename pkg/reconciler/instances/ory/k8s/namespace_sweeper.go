@@ -0,0 +1,246 @@
+package k8s
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	k8sFinalizers "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes/finalizers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// AllFinalizersPattern matches every finalizer, it is the default matcher of a NamespaceFinalizerSweeper
+var AllFinalizersPattern = regexp.MustCompile(`.*`)
+
+// DefaultSweepExclusions are the resources a NamespaceFinalizerSweeper never touches. Events carry no finalizers
+// worth removing, but are by far the most numerous objects of a namespace.
+var DefaultSweepExclusions = []schema.GroupResource{
+	{Group: "", Resource: "events"},
+	{Group: "events.k8s.io", Resource: "events"},
+}
+
+// NamespaceFinalizerSweeper removes the finalizers from all objects of a namespace, whatever their resource and
+// whoever added the finalizers, unless a matcher is set. The resources are found by discovery, so that objects of
+// unknown custom resources are swept as well.
+type NamespaceFinalizerSweeper struct {
+	discovery discovery.DiscoveryInterface
+	dynamic   dynamic.Interface
+	logger    *zap.SugaredLogger
+	matcher   *regexp.Regexp
+	excluded  map[schema.GroupResource]bool
+}
+
+type SweeperOption func(*NamespaceFinalizerSweeper)
+
+// WithSweeperLogger sets the logger of the sweeper, which logs nothing by default
+func WithSweeperLogger(logger *zap.SugaredLogger) SweeperOption {
+	return func(s *NamespaceFinalizerSweeper) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// WithSweeperMatcher selects the finalizers which are removed, AllFinalizersPattern by default
+func WithSweeperMatcher(matcher *regexp.Regexp) SweeperOption {
+	return func(s *NamespaceFinalizerSweeper) {
+		if matcher != nil {
+			s.matcher = matcher
+		}
+	}
+}
+
+// WithOryFinalizersOnly removes only the finalizers matching DefaultFinalizerPattern, so that the objects of other
+// components in the namespace keep their finalizers
+func WithOryFinalizersOnly() SweeperOption {
+	return WithSweeperMatcher(DefaultFinalizerPattern)
+}
+
+// WithSweepExclusions excludes further resources from the sweep, in addition to DefaultSweepExclusions
+func WithSweepExclusions(resources ...schema.GroupResource) SweeperOption {
+	return func(s *NamespaceFinalizerSweeper) {
+		for _, resource := range resources {
+			s.excluded[resource] = true
+		}
+	}
+}
+
+func NewNamespaceFinalizerSweeper(discoveryClient discovery.DiscoveryInterface, dynamicClient dynamic.Interface,
+	opts ...SweeperOption) *NamespaceFinalizerSweeper {
+	s := &NamespaceFinalizerSweeper{
+		discovery: discoveryClient,
+		dynamic:   dynamicClient,
+		logger:    zap.NewNop().Sugar(),
+		matcher:   AllFinalizersPattern,
+		excluded:  map[schema.GroupResource]bool{},
+	}
+	for _, resource := range DefaultSweepExclusions {
+		s.excluded[resource] = true
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewNamespaceFinalizerSweeperForProvider creates a sweeper with the discovery and dynamic clients of the provider
+func NewNamespaceFinalizerSweeperForProvider(provider ClientProvider, opts ...SweeperOption) (*NamespaceFinalizerSweeper, error) {
+	discoveryClient, err := provider.Discovery()
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := provider.Dynamic()
+	if err != nil {
+		return nil, err
+	}
+	return NewNamespaceFinalizerSweeper(discoveryClient, dynamicClient, opts...), nil
+}
+
+// NamespaceSweepResult summarizes a sweep of a namespace
+type NamespaceSweepResult struct {
+	Namespace string
+	// Resources contains a summary per swept resource, in the order of discovery
+	Resources []SweptResource
+}
+
+// RemovedFinalizers returns the number of finalizers removed from all objects of the namespace
+func (r *NamespaceSweepResult) RemovedFinalizers() int {
+	if r == nil {
+		return 0
+	}
+	removed := 0
+	for _, resource := range r.Resources {
+		removed += resource.RemovedFinalizers
+	}
+	return removed
+}
+
+// Skipped returns the resources which could not be listed with the given credentials
+func (r *NamespaceSweepResult) Skipped() []SweptResource {
+	var skipped []SweptResource
+	for _, resource := range r.Resources {
+		if resource.Forbidden != nil {
+			skipped = append(skipped, resource)
+		}
+	}
+	return skipped
+}
+
+// SweptResource summarizes the sweep of the objects of a single resource
+type SweptResource struct {
+	GVR schema.GroupVersionResource
+	// Instances is the number of listed objects
+	Instances int
+	// RemovedFinalizers is the number of finalizers removed from all objects
+	RemovedFinalizers int
+	// Failures contains the objects whose finalizers could not be removed
+	Failures []ResourceFailure
+	// Forbidden is set if the objects could not be listed, the resource was skipped then
+	Forbidden *MissingPermissionError
+}
+
+// Sweep removes the matched finalizers from the objects of all namespaced resources which can be listed and updated.
+// Resources the credentials are not allowed to list are recorded in the result and skipped, while other listing
+// errors abort the sweep. Failures of single objects are returned as CleanupErrors after all resources were swept.
+// The finalizers of the namespace itself are left to FinalizeNamespace.
+func (s *NamespaceFinalizerSweeper) Sweep(ctx context.Context, namespace string) (*NamespaceSweepResult, error) {
+	result := &NamespaceSweepResult{Namespace: namespace}
+	if namespace == "" {
+		return result, errors.New("no namespace to sweep given")
+	}
+	resources, err := s.sweptResources()
+	if err != nil {
+		return result, err
+	}
+
+	handler := k8sFinalizers.NewFinalizersHandler(s.dynamic, k8sFinalizers.WithLogger(s.logger),
+		k8sFinalizers.WithMatcher(s.matcher), k8sFinalizers.WithNamespace(namespace))
+	var failures []ResourceFailure
+	for _, gvr := range resources {
+		swept := SweptResource{GVR: gvr}
+		removal, err := handler.RemoveFinalizers(ctx, []schema.GroupVersionResource{gvr})
+		if len(removal.Targets) > 0 {
+			swept.Instances = removal.Targets[0].Instances
+			swept.RemovedFinalizers = removal.Targets[0].RemovedFinalizers
+			for _, failure := range removal.Targets[0].Failures {
+				swept.Failures = append(swept.Failures, ResourceFailure{
+					GVR:       failure.GVR,
+					Namespace: failure.Namespace,
+					Name:      failure.Name,
+					Err:       failure.Err,
+				})
+			}
+		}
+		switch {
+		case err == nil || k8sFinalizers.IsRemovalError(err):
+		case apierr.IsForbidden(err):
+			swept.Forbidden = &MissingPermissionError{Verb: "list", Resource: gvr.GroupResource(), Namespace: namespace, err: err}
+			s.logger.Warnf("Skipping %s in namespace \"%s\": %v", gvr.String(), namespace, swept.Forbidden)
+		default:
+			result.Resources = append(result.Resources, swept)
+			return result, err
+		}
+		result.Resources = append(result.Resources, swept)
+		failures = append(failures, swept.Failures...)
+	}
+	s.logger.Infof("Swept %d resources in namespace \"%s\", removed %d finalizers and skipped %d resources",
+		len(result.Resources), namespace, result.RemovedFinalizers(), len(result.Skipped()))
+	return result, newCleanupErrors(failures)
+}
+
+// sweptResources returns the preferred version of all namespaced resources which support the verbs needed to remove
+// finalizers. Groups which fail discovery, e.g. of an unavailable aggregated API server, are skipped.
+func (s *NamespaceFinalizerSweeper) sweptResources() ([]schema.GroupVersionResource, error) {
+	groups, resourceLists, err := s.discovery.ServerGroupsAndResources()
+	if discovery.IsGroupDiscoveryFailedError(err) {
+		s.logger.Warnf("Skipping the resources of groups which failed discovery: %v", err)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to discover the resources of the cluster")
+	}
+
+	preferred := map[string]bool{}
+	for _, group := range groups {
+		preferred[group.PreferredVersion.GroupVersion] = true
+	}
+
+	var resources []schema.GroupVersionResource
+	seen := map[schema.GroupResource]bool{}
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the discovered group version \"%s\"", list.GroupVersion)
+		}
+		if len(preferred) > 0 && !preferred[list.GroupVersion] {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			gr := gv.WithResource(resource.Name).GroupResource()
+			if !resource.Namespaced || strings.Contains(resource.Name, "/") || seen[gr] || s.excluded[gr] ||
+				!hasVerbs(resource, "list", "get", "update") {
+				continue
+			}
+			seen[gr] = true
+			resources = append(resources, gv.WithResource(resource.Name))
+		}
+	}
+	return resources, nil
+}
+
+func hasVerbs(resource metav1.APIResource, verbs ...string) bool {
+	supported := map[string]bool{}
+	for _, verb := range resource.Verbs {
+		supported[verb] = true
+	}
+	for _, verb := range verbs {
+		if !supported[verb] {
+			return false
+		}
+	}
+	return true
+}
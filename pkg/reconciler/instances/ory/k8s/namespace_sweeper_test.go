@@ -0,0 +1,231 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	sweptConfigMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	sweptEventGVR     = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+	sweptRuleGVR      = schema.GroupVersionResource{Group: "oathkeeper.ory.sh", Version: "v1alpha1", Resource: "rules"}
+)
+
+func Test_NamespaceFinalizerSweeper(t *testing.T) {
+	t.Run("should remove the finalizers of all namespaced resources in the namespace", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixSweptObject(sweptConfigMapGVR, "ConfigMap", "kyma-system", "config", "ory.sh/cleanup", "kept"),
+			fixSweptObject(sweptRuleGVR, "Rule", "kyma-system", "rule", "oathkeeper.ory.sh/finalizer"),
+			fixSweptObject(sweptRuleGVR, "Rule", "default", "other", "oathkeeper.ory.sh/finalizer"),
+			fixSweptObject(oauth2ClientGVR, "OAuth2Client", "kyma-system", "client"),
+		))
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn, WithSweeperLogger(logger.NewTestLogger(t)))
+
+		// when
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []schema.GroupVersionResource{sweptConfigMapGVR, oauth2ClientGVR, sweptRuleGVR}, sweptGVRs(result))
+		require.Equal(t, 3, result.RemovedFinalizers())
+		require.Equal(t, 1, result.Resources[1].Instances)
+		require.Empty(t, result.Skipped())
+		requireSweptFinalizers(t, dyn, sweptConfigMapGVR, "kyma-system", "config")
+		requireSweptFinalizers(t, dyn, sweptRuleGVR, "kyma-system", "rule")
+		requireSweptFinalizers(t, dyn, sweptRuleGVR, "default", "other", "oathkeeper.ory.sh/finalizer")
+	})
+
+	t.Run("should remove finalizers of other components by default", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixSweptObject(sweptConfigMapGVR, "ConfigMap", "kyma-system", "config", "serverless.kyma-project.io/cleanup")))
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn)
+
+		// when
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireSweptFinalizers(t, dyn, sweptConfigMapGVR, "kyma-system", "config")
+	})
+
+	t.Run("should only remove ory finalizers if asked to", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixSweptObject(sweptConfigMapGVR, "ConfigMap", "kyma-system", "config", "ory.sh/cleanup", "serverless.kyma-project.io/cleanup")))
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn, WithOryFinalizersOnly())
+
+		// when
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireSweptFinalizers(t, dyn, sweptConfigMapGVR, "kyma-system", "config", "serverless.kyma-project.io/cleanup")
+	})
+
+	t.Run("should not sweep excluded resources", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixSweptObject(sweptEventGVR, "Event", "kyma-system", "event", "ory.sh/cleanup"),
+			fixSweptObject(sweptConfigMapGVR, "ConfigMap", "kyma-system", "config", "ory.sh/cleanup"),
+		))
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn, WithSweepExclusions(sweptConfigMapGVR.GroupResource()))
+
+		// when
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []schema.GroupVersionResource{oauth2ClientGVR, sweptRuleGVR}, sweptGVRs(result))
+		requireSweptFinalizers(t, dyn, sweptEventGVR, "kyma-system", "event", "ory.sh/cleanup")
+		requireSweptFinalizers(t, dyn, sweptConfigMapGVR, "kyma-system", "config", "ory.sh/cleanup")
+	})
+
+	t.Run("should record and skip resources which cannot be listed", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixSweptObject(sweptRuleGVR, "Rule", "kyma-system", "rule", "oathkeeper.ory.sh/finalizer")))
+		dyn.PrependReactor("list", sweptConfigMapGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(sweptConfigMapGVR.GroupResource(), "", errors.New("rbac"))
+		})
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn)
+
+		// when
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.Skipped(), 1)
+		require.Equal(t, sweptConfigMapGVR, result.Skipped()[0].GVR)
+		require.Equal(t, "list", result.Skipped()[0].Forbidden.Verb)
+		require.Equal(t, 1, result.RemovedFinalizers())
+	})
+
+	t.Run("should abort on other listing errors", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds))
+		dyn.PrependReactor("list", sweptConfigMapGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewBadRequest("invalid")
+		})
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn)
+
+		// when
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		require.True(t, apierr.IsBadRequest(err))
+		require.Len(t, result.Resources, 1)
+	})
+
+	t.Run("should sweep all resources before returning failures of single objects", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds), withObjects(
+			fixSweptObject(sweptConfigMapGVR, "ConfigMap", "kyma-system", "config", "ory.sh/cleanup"),
+			fixSweptObject(sweptRuleGVR, "Rule", "kyma-system", "rule", "oathkeeper.ory.sh/finalizer"),
+		))
+		dyn.PrependReactor("update", sweptConfigMapGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(sweptConfigMapGVR.GroupResource(), "config", errors.New("rbac"))
+		})
+		sweeper := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn)
+
+		// when
+		result, err := sweeper.Sweep(context.Background(), "kyma-system")
+
+		// then
+		var cleanupErrors *CleanupErrors
+		require.True(t, errors.As(err, &cleanupErrors))
+		require.Len(t, cleanupErrors.Forbidden(), 1)
+		require.Equal(t, "config", result.Resources[0].Failures[0].Name)
+		requireSweptFinalizers(t, dyn, sweptRuleGVR, "kyma-system", "rule")
+	})
+
+	t.Run("should reject an empty namespace", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(sweptListKinds))
+
+		// when
+		_, err := NewNamespaceFinalizerSweeper(newSweeperDiscovery(), dyn).Sweep(context.Background(), "")
+
+		// then
+		require.EqualError(t, err, "no namespace to sweep given")
+	})
+}
+
+// newSweeperDiscovery serves events, configmaps, oauth2clients and rules in two versions, of which the first is
+// preferred, besides resources which must not be swept
+func newSweeperDiscovery() *fakediscovery.FakeDiscovery {
+	allVerbs := metav1.Verbs{"get", "list", "watch", "update", "patch", "delete"}
+	return &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "events", Namespaced: true, Verbs: allVerbs},
+				{Name: "configmaps", Namespaced: true, Verbs: allVerbs},
+				{Name: "namespaces", Namespaced: false, Verbs: allVerbs},
+				{Name: "pods/status", Namespaced: true, Verbs: metav1.Verbs{"get", "update"}},
+				{Name: "bindings", Namespaced: true, Verbs: metav1.Verbs{"create"}},
+			},
+		},
+		{
+			GroupVersion: oauth2ClientGVR.GroupVersion().String(),
+			APIResources: []metav1.APIResource{{Name: oauth2ClientGVR.Resource, Namespaced: true, Verbs: allVerbs}},
+		},
+		{
+			GroupVersion: sweptRuleGVR.GroupVersion().String(),
+			APIResources: []metav1.APIResource{{Name: sweptRuleGVR.Resource, Namespaced: true, Verbs: allVerbs}},
+		},
+		{
+			GroupVersion: "oathkeeper.ory.sh/v1beta1",
+			APIResources: []metav1.APIResource{{Name: sweptRuleGVR.Resource, Namespaced: true, Verbs: allVerbs}},
+		},
+	}}}
+}
+
+var sweptListKinds = map[schema.GroupVersionResource]string{
+	sweptConfigMapGVR: "ConfigMapList",
+	sweptEventGVR:     "EventList",
+	sweptRuleGVR:      "RuleList",
+}
+
+func fixSweptObject(gvr schema.GroupVersionResource, kind, namespace, name string, finalizers ...string) *unstructured.Unstructured {
+	res := &unstructured.Unstructured{}
+	res.SetAPIVersion(gvr.GroupVersion().String())
+	res.SetKind(kind)
+	res.SetNamespace(namespace)
+	res.SetName(name)
+	res.SetFinalizers(finalizers)
+	return res
+}
+
+func sweptGVRs(result *NamespaceSweepResult) []schema.GroupVersionResource {
+	var gvrs []schema.GroupVersionResource
+	for _, resource := range result.Resources {
+		gvrs = append(gvrs, resource.GVR)
+	}
+	return gvrs
+}
+
+func requireSweptFinalizers(t *testing.T, dyn *dynamicfake.FakeDynamicClient, gvr schema.GroupVersionResource, namespace, name string, expected ...string) {
+	res, err := dyn.Resource(gvr).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	if len(expected) == 0 {
+		require.Empty(t, res.GetFinalizers())
+		return
+	}
+	require.Equal(t, expected, res.GetFinalizers())
+}
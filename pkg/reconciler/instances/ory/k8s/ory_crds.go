@@ -2,135 +2,1336 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	k8sFinalizers "github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes/finalizers"
 	"github.com/pkg/errors"
-	"go.uber.org/zap"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apixv1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
 	apixv1beta1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/transport"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/flowcontrol"
 	k8sRetry "k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
 )
 
-// go:generate mockery --name=OryFinalizersHandler --outpkg=mock --case=underscore
 // OryFinalizersHandler exposes functionality to find and delete ory custom resource finalizers
+//
+//go:generate mockery --name=OryFinalizersHandler --outpkg=mock --case=underscore
 type OryFinalizersHandler interface {
-	FindAndDeleteOryFinalizers(kubeconfigData string, logger *zap.SugaredLogger) error
+	// FindAndDeleteOryFinalizers removes the ory finalizers of all oauth2client instances. The result is never nil,
+	// also not together with an error, and reports the work done until the run stopped, see
+	// CleanupResult.RemovedFinalizers.
+	FindAndDeleteOryFinalizers(kubeconfigData string) (*CleanupResult, error)
+	// StreamOryFinalizers removes the ory finalizers like FindAndDeleteOryFinalizers, but streams the result of every
+	// custom resource instead of returning them at the end, followed by the error of the run
+	StreamOryFinalizers(ctx context.Context, kubeconfigData string) (<-chan ResourceResult, <-chan error)
+	// DiscoverOryCRDs lists all ory custom resource definitions installed on the cluster without modifying them
+	DiscoverOryCRDs(ctx context.Context, kubeconfigData string) ([]OryCRD, error)
+	// CountStuckOryResources counts the ory custom resources which are being deleted but still carry finalizers
+	CountStuckOryResources(ctx context.Context, kubeconfigData string) (int, error)
+	// WaitForCRDDeleted waits until the named custom resource definition no longer exists
+	WaitForCRDDeleted(ctx context.Context, name string, timeout time.Duration) error
+	// Close releases the clients cached by the handler
+	Close()
+}
+
+const oryGroupSuffix = "ory.sh"
+
+// OryCRD describes an ory custom resource definition found on the cluster
+type OryCRD struct {
+	Name     string
+	Group    string
+	Resource string
+	Versions []string
+	// ClusterScoped reports whether the instances are cluster-scoped instead of namespaced
+	ClusterScoped bool
+}
+
+var crdResource = apixv1beta1.Resource("customresourcedefinitions")
+
+const (
+	oauth2ClientCRD       = "oauth2clients.hydra.ory.sh"
+	defaultListAttempts   = 5
+	defaultRequestTimeout = 30 * time.Second
+)
+
+// Version of the handler reported in its User-Agent, set at build time with
+// -ldflags "-X github.com/kyma-incubator/reconciler/pkg/reconciler/instances/ory/k8s.Version=<version>"
+var Version = "dev"
+
+// RemovedFinalizersAnnotation holds the finalizers removed by the handler, see WithRemovedFinalizersAnnotation
+const RemovedFinalizersAnnotation = "reconciler.kyma-project.io/removed-finalizers"
+
+type removedFinalizersRecord struct {
+	Finalizers []string `json:"finalizers"`
+	Timestamp  string   `json:"timestamp"`
 }
 
+// DefaultFinalizerPattern matches finalizers of any ory.sh domain, including the
+// "finalizer.ory.hydra.sh" finalizer set by hydra-maester
+var DefaultFinalizerPattern = regexp.MustCompile(`^([a-z0-9-]+\.)*ory\.([a-z0-9-]+\.)*sh(/.*)?$`)
+
 type DefaultOryFinalizersHandler struct {
-	apixClient apixv1beta1client.ApiextensionsV1beta1Interface
-	dynamic    dynamic.Interface
-	logger     *zap.SugaredLogger
+	// the clients are guarded by clientsMu and read through currentClients
+	apixClient   apixv1beta1client.ApiextensionsV1beta1Interface
+	apixV1Client apixv1client.ApiextensionsV1Interface
+	discovery    discovery.DiscoveryInterface
+	dynamic      dynamic.Interface
+	// metadata lists the instances without their content, see listInstanceMetadata
+	metadata   metadata.Interface
+	restMapper meta.RESTMapper
+	// clientProvider is set with WithClientProvider, builtProvider is the provider built for the kubeconfig of the
+	// cached clients otherwise
+	clientProvider    ClientProvider
+	builtProvider     *DefaultClientProvider
+	clientsKubeconfig string
+	clusterHost       string
+	clientsMu         sync.Mutex
+	config            *rest.Config
+	// probedKubeconfigs are the kubeconfigs whose exec credential plugin was probed successfully, see probeExecOnce
+	probedKubeconfigs map[string]bool
+	execProbeMu       sync.Mutex
+
+	logger           Logger
+	clock            clock.Clock
+	listAttempts     int
+	finalizerMatcher *regexp.Regexp
+
+	annotateRemovedFinalizers bool
+	progress                  func(done, total int)
+	kubeconfigContext         string
+	kubeconfigCluster         string
+	kubeconfigPaths           []string
+	impersonation             *rest.ImpersonationConfig
+	suppressWarnings          bool
+	warnings                  *warningRouter
+	updateThrottle            *updateThrottle
+	requestTimeout            time.Duration
+	dialTimeout               time.Duration
+	userAgent                 string
+	userAgentSuffix           string
+	establishedTimeout        time.Duration
+	establishedPollInterval   time.Duration
+	unestablishedMode         UnestablishedCRDMode
+	caData                    []byte
+	tlsServerName             string
+	excludedNamespaces        map[string]bool
+	connectRetryTimeout       time.Duration
+	rateLimiter               flowcontrol.RateLimiter
+	sweepLockMode             SweepLockMode
+	gracePeriod               time.Duration
+	minAge                    time.Duration
+	collectModified           bool
+	terminatingOnly           bool
+	deleteCRDWhenEmpty        bool
+	propagationPolicy         metav1.DeletionPropagation
+	requireCRD                bool
+	validateResources         bool
+	crdNames                  []string
+	discoveryAllowlist        map[string]bool
+	discoveryDenylist         map[string]bool
+	scaleDownNamespace        string
+	scaleDownTimeout          time.Duration
+	restoreScale              bool
+	verifyRemoval             bool
+	backupNamespace           string
+	ignoreBackupFailure       bool
+	conflictRetryLimit        int
+	conflictRetriesLimited    bool
+	breaker                   *circuitBreaker
+	patchFinalizers           bool
+	breakerRegisterer         prometheus.Registerer
+	wrapTransport             transport.WrapperFunc
+	contentType               string
+	nestedFinalizerPaths      [][]string
+	removalPredicate          RemovalPredicate
+
+	informerCacheEnabled bool
+	informerResync       time.Duration
+	// informersMu guards informers, which are created on the first cached read
+	informersMu sync.Mutex
+	informers   *informerCache
+
+	apiExtensionsVersion string
+	// crdClientMu guards resolvedCRDs, the client of the apiextensions version resolved on the first request
+	crdClientMu  sync.Mutex
+	resolvedCRDs crdClient
+
+	// selectorsMu guards unsupportedSelectors, which records the resources whose field selector was rejected
+	selectorsMu          sync.Mutex
+	unsupportedSelectors map[schema.GroupVersionResource]bool
+	// scopesMu guards clusterScoped, which records the custom resources whose definition declares the cluster scope
+	scopesMu      sync.Mutex
+	clusterScoped map[schema.GroupVersionResource]bool
+	// metadataMu guards metadataUnsupported, which records that the API server rejected the listing of metadata
+	metadataMu          sync.Mutex
+	metadataUnsupported bool
+
+	checkOwnerReferences          bool
+	removeOrphanedOwnerReferences bool
 }
 
-func NewDefaultOryFinalizersHandler() *DefaultOryFinalizersHandler {
-	return &DefaultOryFinalizersHandler{}
+func NewDefaultOryFinalizersHandler(opts ...Option) *DefaultOryFinalizersHandler {
+	h := &DefaultOryFinalizersHandler{
+		listAttempts:       defaultListAttempts,
+		finalizerMatcher:   DefaultFinalizerPattern,
+		requestTimeout:     defaultRequestTimeout,
+		establishedTimeout: DefaultCRDEstablishedTimeout,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.applyDefaults()
+	return h
 }
 
-func (h *DefaultOryFinalizersHandler) FindAndDeleteOryFinalizers(kubeconfigData string, logger *zap.SugaredLogger) error {
-	h.logger = logger
+// NewOryFinalizersHandlerForConfig creates a handler for the cluster of an existing rest configuration. The
+// configuration is copied, so it is not affected by the options of the handler. Calls with an empty kubeconfig
+// target this cluster instead of the in-cluster configuration.
+func NewOryFinalizersHandlerForConfig(cfg *rest.Config, opts ...Option) (*DefaultOryFinalizersHandler, error) {
+	if cfg == nil {
+		return nil, errors.New("rest config must not be nil")
+	}
+	h := NewDefaultOryFinalizersHandler(opts...)
+	h.config = rest.CopyConfig(cfg)
+	if err := h.initClients(context.Background(), ""); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
 
-	config, err := restConfig(kubeconfigData)
-	if err != nil {
-		return err
+// applyDefaults makes sure a handler which was not created by NewDefaultOryFinalizersHandler is usable
+func (h *DefaultOryFinalizersHandler) applyDefaults() {
+	if isNilLogger(h.logger) {
+		h.logger = NewZapLogger(nil)
+	}
+	if h.finalizerMatcher == nil {
+		h.finalizerMatcher = DefaultFinalizerPattern
+	}
+	if h.warnings == nil {
+		h.warnings = newWarningRouter(h.logger)
+	}
+	if h.clock == nil {
+		h.clock = clock.RealClock{}
+	}
+	if h.propagationPolicy == "" {
+		h.propagationPolicy = metav1.DeletePropagationBackground
+	}
+	if h.contentType == "" {
+		h.contentType = runtime.ContentTypeProtobuf
+	}
+	if h.breaker != nil {
+		h.breaker.setDefaults(h.clock, h.circuitStateGauge)
 	}
+}
 
-	if h.apixClient, err = apixv1beta1client.NewForConfig(config); err != nil {
-		return err
+func (h *DefaultOryFinalizersHandler) FindAndDeleteOryFinalizers(kubeconfigData string) (*CleanupResult, error) {
+	if err := h.initClients(context.Background(), kubeconfigData); err != nil {
+		return &CleanupResult{}, err
 	}
-	h.dynamic, err = dynamic.NewForConfig(config)
+	release, err := h.lockSweep()
 	if err != nil {
-		return err
+		return &CleanupResult{}, err
 	}
+	defer release()
 
-	crd, err := h.apixClient.CustomResourceDefinitions().Get(context.Background(), "oauth2clients.hydra.ory.sh", metav1.GetOptions{})
-	if err != nil && !apierr.IsNotFound(err) {
-		return err
+	return h.findAndDeleteOryFinalizers()
+}
+
+func (h *DefaultOryFinalizersHandler) findAndDeleteOryFinalizers() (*CleanupResult, error) {
+	h.applyDefaults()
+	return h.sweep(h.newRun(context.Background(), nil))
+}
+
+// sweep removes the finalizers of all swept custom resource definitions within the given run. The instances whose
+// finalizers could not be removed are returned as CleanupErrors once all definitions were swept, all other errors
+// stop the run.
+func (h *DefaultOryFinalizersHandler) sweep(run *sweepRun) (result *CleanupResult, err error) {
+	defer h.warnings.register(run.warnings)()
+
+	start := h.clock.Now()
+	result = &CleanupResult{}
+	defer func() {
+		result.Elapsed = h.clock.Since(start)
+		result.Warnings = run.warnings.collected()
+		h.logSummary(result, err)
+	}()
+
+	if h.validateResources {
+		if err := h.validateSweptResources(); err != nil {
+			return result, err
+		}
+	}
+	if h.scaleDownNamespace != "" {
+		restore, err := h.scaleDownHydraMaester(run.ctx)
+		if err != nil {
+			return result, err
+		}
+		if h.restoreScale {
+			// the replicas are restored after every return, a failed restore is only logged when the run failed already
+			defer func() {
+				restoreErr := restore()
+				switch {
+				case restoreErr == nil:
+				case err == nil:
+					err = restoreErr
+				default:
+					h.logger.Warnf("%v", restoreErr)
+				}
+			}()
+		}
 	}
 
-	if crd == nil {
-		h.logger.Debugf("Couldn't find oauth2client crd to remove finalizers from")
+	for _, name := range h.sweptCRDs() {
+		if err := run.streamStopped(); err != nil {
+			return result, err
+		}
+		// the failures of single instances are collected, they do not keep the remaining definitions from being swept
+		if err := h.sweepCRD(run, name, result); err != nil && !isCleanupErrors(err) {
+			return result, err
+		}
+	}
+	if err := run.streamStopped(); err != nil {
+		return result, err
+	}
+	if h.verifyRemoval {
+		if err := h.verify(run.ctx, result); err != nil {
+			return result, err
+		}
+	}
+	return result, newCleanupErrors(result.Failures())
+}
+
+// sweptCRDs returns the custom resource definitions configured with WithCRDs sorted by name, by default only the
+// oauth2clients CRD
+func (h *DefaultOryFinalizersHandler) sweptCRDs() []string {
+	if len(h.crdNames) == 0 {
+		return []string{oauth2ClientCRD}
+	}
+	return sortedCRDNames(h.crdNames)
+}
+
+// sweepCRD removes the finalizers from all instances of the named custom resource definition and appends the
+// outcome to the result. A missing CRD is skipped unless WithRequireCRD was set.
+func (h *DefaultOryFinalizersHandler) sweepCRD(run *sweepRun, name string, result *CleanupResult) error {
+	var crd *apixv1beta1.CustomResourceDefinition
+	// the lookup is the first request of a run, so it also waits for an unreachable API server
+	err := h.retryOnConnectivityError(run.ctx, func() error {
+		return h.retryOnUnauthorized(run.ctx, func() error {
+			return h.retryOnTransientError(run.ctx, func() (err error) {
+				crds, err := h.crds(run.ctx)
+				if err != nil {
+					return err
+				}
+				crd, err = crds.Get(run.ctx, name, metav1.GetOptions{})
+				return h.checkForbidden(err, "get", crdResource, "")
+			})
+		})
+	})
+	if IsAPIExtensionsVersionNotServedError(err) {
+		return err
+	}
+	if apierr.IsNotFound(err) || (err == nil && crd == nil) {
+		if h.requireCRD {
+			return &CRDNotFoundError{Name: name, err: err}
+		}
+		h.logger.Debugf("Couldn't find crd %s to remove finalizers from", name)
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
 	crdef := schema.GroupVersionResource{
 		Group:    crd.Spec.Group,
 		Version:  crd.Spec.Version,
 		Resource: crd.Spec.Names.Plural,
 	}
+	h.recordScope(crdef, crd.Spec.Scope)
+
+	if h.establishedTimeout > 0 {
+		err := h.waitForCRDEstablished(run.ctx, crd, h.establishedTimeout)
+		if IsCRDNotEstablishedError(err) && h.unestablishedMode == UnestablishedCRDSkip {
+			h.logger.Warnf("Skipping the instances of %s: %v", crdef.String(), err)
+			result.CRDs = append(result.CRDs, CRDResult{GVR: crdef, NotEstablished: true})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	crdResult, err := h.removeFinalizersFromAllInstancesOf(run, crdef)
+	if IsBackupFailedError(err) {
+		return err
+	}
+	if err == nil && h.deleteCRDWhenEmpty {
+		if err = run.streamStopped(); err == nil {
+			crdResult.CRDDeleted, err = h.deleteCRDIfEmpty(run.ctx, crd, crdef)
+		}
+	}
+	result.CRDs = append(result.CRDs, crdResult)
+	if err != nil {
+		h.logger.Errorf("Error while dropping finalizers for \"%s\": %s", crdef.String(), err.Error())
+		return err
+	}
+	return nil
+}
+
+// logSummary logs the totals of a run in a single line, for operators who do not enable debug logs
+func (h *DefaultOryFinalizersHandler) logSummary(result *CleanupResult, err error) {
+	var instances, removed, failures int
+	for _, crd := range result.CRDs {
+		instances += crd.Instances
+		removed += crd.RemovedFinalizers
+		failures += len(crd.Failures)
+	}
+	errorCount := failures
+	if err != nil && failures == 0 {
+		errorCount = 1
+	}
+	h.logger.Infow("Finished removing ory finalizers",
+		"crds", len(result.CRDs),
+		"instances", instances,
+		"removedFinalizers", removed,
+		"errors", errorCount,
+		"elapsed", result.Elapsed.String())
+}
+
+// DiscoverOryCRDs returns the name and served versions of all custom resource definitions belonging to an ory.sh group,
+// including those of other groups configured with WithCRDs, sorted by name
+func (h *DefaultOryFinalizersHandler) DiscoverOryCRDs(ctx context.Context, kubeconfigData string) ([]OryCRD, error) {
+	if err := h.initClients(ctx, kubeconfigData); err != nil {
+		return nil, err
+	}
+	return h.discoverOryCRDs(ctx)
+}
 
-	err = h.removeFinalizersFromAllInstancesOf(crdef)
+func (h *DefaultOryFinalizersHandler) discoverOryCRDs(ctx context.Context) ([]OryCRD, error) {
+	h.applyDefaults()
+	if len(h.discoveryAllowlist) > 0 && len(h.discoveryDenylist) > 0 {
+		return nil, errors.New("the discovery allowlist and denylist of ory crds must not both be set")
+	}
+
+	var crdList *apixv1beta1.CustomResourceDefinitionList
+	err := h.retryOnConnectivityError(ctx, func() error {
+		return h.retryOnUnauthorized(ctx, func() (err error) {
+			crds, err := h.crds(ctx)
+			if err != nil {
+				return err
+			}
+			crdList, err = crds.List(ctx, metav1.ListOptions{})
+			return h.checkForbidden(err, "list", crdResource, "")
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list custom resource definitions")
+	}
+
+	var oryCRDs []OryCRD
+	for i := range crdList.Items {
+		crd := crdList.Items[i]
+		if !h.isDiscoveredCRD(crd.Name, crd.Spec.Group) {
+			continue
+		}
+		versions := servedVersions(crd)
+		for _, version := range versions {
+			h.recordScope(schema.GroupVersionResource{Group: crd.Spec.Group, Version: version, Resource: crd.Spec.Names.Plural}, crd.Spec.Scope)
+		}
+		oryCRDs = append(oryCRDs, OryCRD{
+			Name:          crd.Name,
+			Group:         crd.Spec.Group,
+			Resource:      crd.Spec.Names.Plural,
+			Versions:      versions,
+			ClusterScoped: crd.Spec.Scope == apixv1beta1.ClusterScoped,
+		})
+	}
+
+	sortOryCRDs(oryCRDs)
+	return oryCRDs, nil
+}
+
+// initClients builds the clients for the cluster of the given kubeconfig. The clients share one HTTP client and
+// are cached until Close is called. A handler serves a single cluster: a different kubeconfig of the same cluster,
+// e.g. with new credentials, replaces the cached clients, the kubeconfig of another cluster is rejected with a
+// ClusterChangedError until Close is called, as concurrent calls may still use the cached clients. The clients of
+// a provider set with WithClientProvider are cached until Close is called, whatever the kubeconfig.
+func (h *DefaultOryFinalizersHandler) initClients(ctx context.Context, kubeconfigData string) error {
+	h.applyDefaults()
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
+	if h.builtProvider != nil && h.clientsKubeconfig == kubeconfigData {
+		return nil
+	}
+	if h.clientProvider != nil && h.apixClient != nil {
+		return nil
+	}
+	if h.builtProvider != nil {
+		config, err := h.restConfig(ctx, kubeconfigData)
+		if err != nil {
+			return err
+		}
+		if config.Host != h.clusterHost {
+			return &ClusterChangedError{Cached: h.clusterHost, Requested: config.Host}
+		}
+	}
+	h.closeClients()
+	return h.buildClients(ctx, kubeconfigData)
+}
+
+// rebuildClients replaces the cached clients by new ones built from the same kubeconfig, which allows refreshable
+// credentials to be obtained again. Clients which were not built by the handler cannot be rebuilt.
+func (h *DefaultOryFinalizersHandler) rebuildClients(ctx context.Context) (bool, error) {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
+	if h.builtProvider == nil {
+		return false, nil
+	}
+	kubeconfigData := h.clientsKubeconfig
+	h.closeClients()
+	return true, h.buildClients(ctx, kubeconfigData)
+}
+
+// cachedClients are the clients cached on the handler at one point in time, see currentClients
+type cachedClients struct {
+	apixClient   apixv1beta1client.ApiextensionsV1beta1Interface
+	apixV1Client apixv1client.ApiextensionsV1Interface
+	discovery    discovery.DiscoveryInterface
+	dynamic      dynamic.Interface
+	metadata     metadata.Interface
+	restMapper   meta.RESTMapper
+}
+
+// currentClients returns the cached clients. Concurrent calls may rebuild them, see rebuildClients, so they are only
+// read under the lock. A caller keeps the clients it was returned, they stay usable after being replaced.
+func (h *DefaultOryFinalizersHandler) currentClients() cachedClients {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+	return cachedClients{
+		apixClient:   h.apixClient,
+		apixV1Client: h.apixV1Client,
+		discovery:    h.discovery,
+		dynamic:      h.dynamic,
+		metadata:     h.metadata,
+		restMapper:   h.restMapper,
+	}
+}
+
+// buildClients takes the clients from the provider set with WithClientProvider, or from a provider built for the
+// cluster of the kubeconfig
+func (h *DefaultOryFinalizersHandler) buildClients(ctx context.Context, kubeconfigData string) error {
+	provider := h.clientProvider
+	var built *DefaultClientProvider
+	if provider == nil {
+		config, err := h.restConfig(ctx, kubeconfigData)
+		if err != nil {
+			return err
+		}
+		if built, err = NewClientProvider(config); err != nil {
+			return err
+		}
+		provider = built
+	}
+
+	apixClient, err := provider.ApixClient()
+	if err != nil {
+		return err
+	}
+	apixV1Client, err := provider.ApixV1Client()
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := provider.Dynamic()
+	if err != nil {
+		return err
+	}
+	discoveryClient, err := provider.Discovery()
+	if err != nil {
+		return err
+	}
+	metadataClient, err := provider.Metadata()
+	if err != nil {
+		return err
+	}
+	restMapper, err := provider.RESTMapper()
 	if err != nil {
-		h.logger.Errorf("Error while dropping finalizers for oauth2client \"%s\": %s", crdef.String(), err.Error())
 		return err
 	}
 
+	h.apixClient, h.apixV1Client, h.dynamic, h.metadata = apixClient, apixV1Client, dynamicClient, metadataClient
+	h.discovery, h.restMapper = discoveryClient, restMapper
+	h.builtProvider, h.clientsKubeconfig = built, kubeconfigData
+	if hosted, ok := provider.(interface{ Host() string }); ok {
+		h.clusterHost = hosted.Host()
+	}
+	h.breaker.setCluster(h.clusterHost)
 	return nil
 }
 
-func (h *DefaultOryFinalizersHandler) removeFinalizersFromAllInstancesOf(crdef schema.GroupVersionResource) error {
+// Close releases the cached clients and shuts down their idle connections.
+// The handler stays usable, subsequent calls build new clients, which may be those of another cluster.
+func (h *DefaultOryFinalizersHandler) Close() {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+	h.closeClients()
+}
+
+func (h *DefaultOryFinalizersHandler) closeClients() {
+	// the informers watch with the dynamic client, which is replaced or dropped
+	h.stopInformers()
+	if h.builtProvider == nil && h.clientProvider == nil {
+		return
+	}
+	if h.builtProvider != nil {
+		h.builtProvider.Close()
+	}
+	h.apixClient, h.apixV1Client, h.dynamic, h.metadata, h.restMapper, h.discovery = nil, nil, nil, nil, nil, nil
+	h.crdClientMu.Lock()
+	h.resolvedCRDs = nil
+	h.crdClientMu.Unlock()
+	h.builtProvider, h.clientsKubeconfig, h.clusterHost = nil, "", ""
+}
+
+func (h *DefaultOryFinalizersHandler) isSweptCRD(name string) bool {
+	for _, swept := range h.sweptCRDs() {
+		if swept == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isDiscoveredCRD reports whether discovery targets the custom resource definition. The CRDs of WithCRDs are always
+// targeted, those of the ory groups as constrained by WithDiscoveryAllowlist or WithDiscoveryDenylist.
+func (h *DefaultOryFinalizersHandler) isDiscoveredCRD(name, group string) bool {
+	for _, configured := range h.crdNames {
+		if configured == name {
+			return true
+		}
+	}
+	if !isOryGroup(group) {
+		return h.isSweptCRD(name)
+	}
+	if len(h.discoveryAllowlist) > 0 {
+		return h.discoveryAllowlist[name]
+	}
+	return !h.discoveryDenylist[name]
+}
+
+func isOryGroup(group string) bool {
+	return group == oryGroupSuffix || strings.HasSuffix(group, "."+oryGroupSuffix)
+}
+
+// servedVersions returns the served versions of a CRD, falling back to the deprecated single version field
+func servedVersions(crd apixv1beta1.CustomResourceDefinition) []string {
+	var versions []string
+	for _, version := range crd.Spec.Versions {
+		if version.Served {
+			versions = append(versions, version.Name)
+		}
+	}
+	if len(versions) == 0 && crd.Spec.Version != "" {
+		versions = append(versions, crd.Spec.Version)
+	}
+	return versions
+}
+
+// removeFinalizersFromAllInstancesOf removes the finalizers of all instances of the custom resource with the generic
+// finalizers handler, whose hooks list, skip, back up, throttle and write the instances the way of this handler
+func (h *DefaultOryFinalizersHandler) removeFinalizersFromAllInstancesOf(run *sweepRun, crdef schema.GroupVersionResource) (CRDResult, error) {
 	h.logger.Debugf("Dropping finalizers for all ory custom resources of type: %s.%s/%s", crdef.Resource, crdef.Group, crdef.Version)
 	defer h.logger.Debugf("Finished dropping finalizers for ory custom resources of type: %s.%s/%s", crdef.Resource, crdef.Group, crdef.Version)
 
-	customResourceList, err := h.dynamic.Resource(crdef).Namespace(v1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
-	if err != nil && !apierr.IsNotFound(err) {
-		return err
+	result := CRDResult{GVR: crdef}
+	var outcome instanceOutcome
+	var skipped ResourceState
+	var updateStart time.Time
+	opts := append(h.removalOptions(run, &outcome),
+		k8sFinalizers.WithLister(func(ctx context.Context, gvr schema.GroupVersionResource) (*unstructured.UnstructuredList, error) {
+			listStart := h.clock.Now()
+			list, err := h.listForRemoval(ctx, gvr)
+			result.ListDuration = h.clock.Since(listStart)
+			if err == nil && list == nil {
+				h.logger.Debugf("Couldn't find any %s custom resources.", gvr.String())
+				return &unstructured.UnstructuredList{}, nil
+			}
+			if err == nil {
+				run.progress.addTotal(len(list.Items))
+				updateStart = h.clock.Now()
+			}
+			return list, err
+		}),
+		k8sFinalizers.WithPredicate(func(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured) (bool, error) {
+			if err := run.streamStopped(); err != nil {
+				return false, err
+			}
+			outcome = instanceOutcome{}
+			skipped = h.skippedState(instance)
+			return skipped == "", nil
+		}),
+		k8sFinalizers.WithProgress(func(removal k8sFinalizers.InstanceResult) error {
+			return h.recordInstance(run, &result, removal, skipped, outcome)
+		}))
+	if h.backupNamespace != "" {
+		opts = append(opts, k8sFinalizers.WithBackup(func(ctx context.Context, gvr schema.GroupVersionResource) error {
+			var err error
+			result.BackupSecrets, err = h.backupInstances(ctx, gvr)
+			if err != nil && !h.ignoreBackupFailure {
+				return &BackupFailedError{GVR: gvr, err: err}
+			}
+			if err != nil {
+				h.logger.Warnf("Removing finalizers of %s without a complete backup: %v", gvr.String(), err)
+			}
+			return nil
+		}))
 	}
 
-	if customResourceList == nil {
-		h.logger.Debugf("Couldn't find any oauth2client custom resources.")
-		return nil
+	removal, err := k8sFinalizers.NewFinalizersHandler(h.currentClients().dynamic).RemoveFinalizers(run.ctx,
+		[]schema.GroupVersionResource{crdef}, opts...)
+	if len(removal.Targets) > 0 {
+		result.Instances = removal.Targets[0].Instances
+	}
+	if !updateStart.IsZero() {
+		result.UpdateDuration = h.clock.Since(updateStart)
 	}
+	if err != nil && !k8sFinalizers.IsRemovalError(err) {
+		return result, err
+	}
+	return result, result.err()
+}
 
-	for i := range customResourceList.Items {
-		instance := customResourceList.Items[i]
-		retryErr := k8sRetry.RetryOnConflict(k8sRetry.DefaultRetry, func() error { return h.removeCustomResourceFinalizers(crdef, instance) })
-		if retryErr != nil {
-			return errors.Wrapf(retryErr, "deleting ory finalizer for %s.%s/%s \"%s\" failed", crdef.Resource, crdef.Group, crdef.Version, instance.GetName())
+// listForRemoval lists the instances of the custom resource, nil if it is not served
+func (h *DefaultOryFinalizersHandler) listForRemoval(ctx context.Context, crdef schema.GroupVersionResource) (*unstructured.UnstructuredList, error) {
+	var list *unstructured.UnstructuredList
+	err := h.retryOnUnauthorized(ctx, func() error {
+		return h.retryOnTransientError(ctx, func() (err error) {
+			list, err = h.cachedInstances(ctx, crdef)
+			return h.checkForbidden(err, "list", crdef.GroupResource(), v1.NamespaceAll)
+		})
+	})
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	return list, err
+}
+
+// skippedState returns the state of a listed instance whose finalizers are not removed, or an empty state if they are
+func (h *DefaultOryFinalizersHandler) skippedState(instance unstructured.Unstructured) ResourceState {
+	if h.excludedNamespaces[instance.GetNamespace()] {
+		h.logger.Debugf("Skipping ory custom resource \"%s\" in excluded namespace \"%s\"", instance.GetName(), instance.GetNamespace())
+		return ResourceSkipped
+	}
+	deletionTimestamp := instance.GetDeletionTimestamp()
+	if h.terminatingOnly && deletionTimestamp == nil {
+		h.logger.Debugf("Skipping ory custom resource \"%s/%s\" which is not terminating", instance.GetNamespace(), instance.GetName())
+		return ResourceSkipped
+	}
+	if deletionTimestamp != nil && h.clock.Since(deletionTimestamp.Time) < h.gracePeriod {
+		h.logger.Debugf("Skipping ory custom resource \"%s/%s\" terminating since %s until its grace period is over",
+			instance.GetNamespace(), instance.GetName(), deletionTimestamp.UTC().Format(time.RFC3339))
+		return ResourcePendingGrace
+	}
+	if h.isTooYoung(instance) {
+		h.logger.Infof("Skipping ory custom resource \"%s/%s\" created at %s, which is younger than %s",
+			instance.GetNamespace(), instance.GetName(), instance.GetCreationTimestamp().UTC().Format(time.RFC3339), h.minAge)
+		return ResourceTooYoung
+	}
+	return ""
+}
+
+// recordInstance adds the outcome of a listed instance to the result of its custom resource and reports it to the
+// run. The returned error stops the removal of the remaining instances.
+func (h *DefaultOryFinalizersHandler) recordInstance(run *sweepRun, result *CRDResult, removal k8sFinalizers.InstanceResult,
+	skipped ResourceState, outcome instanceOutcome) error {
+	crdef, instance := removal.GVR, removal.Instance
+	if removal.Skipped {
+		switch skipped {
+		case ResourcePendingGrace:
+			result.PendingGrace++
+		case ResourceTooYoung:
+			result.TooYoung++
+		default:
+			result.Skipped++
 		}
+		return run.reportResource(crdef, instance, skipped, 0, nil)
 	}
 
-	return nil
+	err := run.budgetExhausted(removal.Err)
+	if IsCredentialsRejectedError(err) || errors.Is(err, ErrCircuitOpen) {
+		// all remaining instances would fail the same way
+		return err
+	}
+	if err == nil && outcome.rejected {
+		h.logger.Debugf("Skipping ory custom resource \"%s/%s\" rejected by the removal predicate: %s",
+			instance.GetNamespace(), instance.GetName(), outcome.rejectReason)
+		result.Skipped++
+		result.Rejected = append(result.Rejected, RejectedResource{
+			Namespace: instance.GetNamespace(),
+			Name:      instance.GetName(),
+			Reason:    outcome.rejectReason,
+		})
+		return run.reportRejected(crdef, instance, outcome.rejectReason)
+	}
+	state := ResourceProcessed
+	if err != nil {
+		state = ResourceFailed
+		result.Failures = append(result.Failures, h.newResourceFailure(crdef, instance, err))
+	} else {
+		result.RemovedNestedFinalizers += outcome.removedNestedFinalizers
+	}
+	if IsRetryBudgetExhaustedError(err) {
+		result.RetryBudgetExhausted = true
+	}
+	result.RemovedFinalizers += removal.RemovedFinalizers
+	if h.collectModified && outcome.modified != nil {
+		result.Modified = append(result.Modified, *outcome.modified)
+	}
+	for _, ref := range outcome.orphanedOwnerReferences {
+		result.OrphanedOwnerReferences = append(result.OrphanedOwnerReferences, OrphanedOwnerReference{
+			Namespace:      instance.GetNamespace(),
+			Name:           instance.GetName(),
+			OwnerReference: ref,
+			Removed:        outcome.ownerReferencesRemoved,
+		})
+	}
+	return run.reportResource(crdef, instance, state, removal.RemovedFinalizers, err)
+}
+
+// instanceOutcome describes the changes made to a single custom resource
+type instanceOutcome struct {
+	orphanedOwnerReferences []metav1.OwnerReference
+	ownerReferencesRemoved  bool
+	removedFinalizers       int
+	removedNestedFinalizers int
+	// modified is the resource as returned by the update, nil if it was not updated
+	modified *unstructured.Unstructured
+	// rejected reports whether the removal predicate rejected the resource, for the given reason
+	rejected     bool
+	rejectReason string
+}
+
+// removeInstanceFinalizers removes the finalizers of a single instance, e.g. one which was watched or blocks the
+// deletion of a namespace
+func (h *DefaultOryFinalizersHandler) removeInstanceFinalizers(run *sweepRun, crdef schema.GroupVersionResource,
+	instance unstructured.Unstructured) (instanceOutcome, error) {
+	var outcome instanceOutcome
+	removed, err := k8sFinalizers.NewFinalizersHandler(h.currentClients().dynamic).RemoveInstanceFinalizers(run.ctx, crdef, instance,
+		h.removalOptions(run, &outcome)...)
+	outcome.removedFinalizers = removed
+	return outcome, run.budgetExhausted(err)
 }
 
-func (h *DefaultOryFinalizersHandler) removeCustomResourceFinalizers(crdef schema.GroupVersionResource, instance unstructured.Unstructured) error {
-	// Retrieve the latest version of Custom Resource before attempting update
-	// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
-	res, err := h.dynamic.Resource(crdef).Namespace(instance.GetNamespace()).Get(context.Background(), instance.GetName(), metav1.GetOptions{})
-	if err != nil && !apierr.IsNotFound(err) {
+// removalOptions are the hooks of the generic finalizers handler which remove the finalizers of an instance the way
+// of this handler. The changes made to the last instance are recorded in the outcome.
+func (h *DefaultOryFinalizersHandler) removalOptions(run *sweepRun, outcome *instanceOutcome) []k8sFinalizers.Option {
+	// pending is the number of finalizers the write of the current attempt removes
+	pending := 0
+	return []k8sFinalizers.Option{
+		k8sFinalizers.WithMatcher(h.finalizerMatcher),
+		k8sFinalizers.WithValidator(func(gvr schema.GroupVersionResource, instance unstructured.Unstructured) error {
+			return validateInstance(instance, h.isClusterScoped(gvr))
+		}),
+		k8sFinalizers.WithRemover(func(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured,
+			attempt int) (res *unstructured.Unstructured, _ int, err error) {
+			// the first attempt writes the listed instance, a conflict means it is stale and the next attempts read it again
+			listed := attempt == 0
+			err = h.retryOnUnauthorized(ctx, func() (err error) {
+				*outcome, res, pending, err = h.removeCustomResourceFinalizers(ctx, gvr, instance, listed)
+				listed = false
+				return err
+			})
+			return res, pending, err
+		}),
+		k8sFinalizers.WithWriter(func(ctx context.Context, gvr schema.GroupVersionResource, res *unstructured.Unstructured) error {
+			err := h.retryOnUnauthorized(ctx, func() (err error) {
+				outcome.modified, err = h.updateInstance(ctx, gvr, res)
+				return err
+			})
+			if apierr.IsConflict(err) && debugEnabled(h.logger) {
+				h.logFinalizerManagers(ctx, gvr, *res)
+			}
+			if err == nil && pending > 0 {
+				h.logger.Debugf("Deleted ory finalizer for \"%s\" %s", res.GetName(), res.GetKind())
+			}
+			return err
+		}),
+		k8sFinalizers.WithThrottle(func(ctx context.Context) error {
+			return h.updateThrottle.wait(ctx, h.clock)
+		}),
+		k8sFinalizers.WithRetriable(apierr.IsConflict),
+		k8sFinalizers.WithRetryBudget(run.conflictRetries),
+		k8sFinalizers.WithSleep(h.sleep),
+	}
+}
+
+func (h *DefaultOryFinalizersHandler) newResourceFailure(crdef schema.GroupVersionResource, instance unstructured.Unstructured, err error) ResourceFailure {
+	failure := ResourceFailure{
+		GVR:             crdef,
+		Namespace:       instance.GetNamespace(),
+		Name:            instance.GetName(),
+		UID:             instance.GetUID(),
+		ResourceVersion: instance.GetResourceVersion(),
+		Err:             err,
+	}
+	h.logger.Warnf("Skipping ory custom resource: %s", failure.Error())
+	return failure
+}
+
+// validateInstance checks that the fields relied on for removing finalizers are present and well-formed, instances
+// of cluster-scoped custom resources have no namespace
+func validateInstance(instance unstructured.Unstructured, clusterScoped bool) error {
+	if err := k8sFinalizers.ValidateInstance(instance); err != nil {
 		return err
 	}
-	if res == nil {
+	if clusterScoped {
 		return nil
 	}
+	if namespace, _, err := unstructured.NestedString(instance.Object, "metadata", "namespace"); err != nil || namespace == "" {
+		return errors.New("metadata.namespace is missing or not a string")
+	}
+	return nil
+}
 
-	if len(res.GetFinalizers()) > 0 {
-		h.logger.Debugf("Found ory finalizers for \"%s\" %s, deleting", res.GetName(), instance.GetKind())
+// removeCustomResourceFinalizers prepares the removal of the finalizers from the listed instance if it can be written
+// as listed, see isWritableAsListed, or from the latest version of the instance otherwise. It returns the instance to
+// write and the number of finalizers the write removes, or nil if nothing needs to be written. The resourceVersion of
+// the listed instance makes the API server reject the write with a conflict if it is stale, so the retry reads the
+// latest version.
+func (h *DefaultOryFinalizersHandler) removeCustomResourceFinalizers(ctx context.Context, crdef schema.GroupVersionResource,
+	instance unstructured.Unstructured, listed bool) (instanceOutcome, *unstructured.Unstructured, int, error) {
+	var outcome instanceOutcome
+	res, err := h.readInstance(ctx, crdef, instance, listed)
+	if err != nil || res == nil {
+		return outcome, nil, 0, err
+	}
+	if h.removalPredicate != nil {
+		remove, reason := h.removalPredicate(*res.DeepCopy())
+		if !remove {
+			outcome.rejected, outcome.rejectReason = true, reason
+			return outcome, nil, 0, nil
+		}
+	}
 
-		res.SetFinalizers(nil)
-		_, err := h.dynamic.Resource(crdef).Namespace(res.GetNamespace()).Update(context.Background(), res, metav1.UpdateOptions{})
+	if k8sFinalizers.HasMalformedFinalizers(*res) {
+		h.logger.Warnf("Found malformed finalizers field on \"%s\" %s, resetting it to an empty list", res.GetName(), res.GetKind())
+		if err := unstructured.SetNestedStringSlice(res.Object, []string{}, "metadata", "finalizers"); err != nil {
+			return outcome, nil, 0, errors.Wrap(err, "failed to repair malformed finalizers field")
+		}
+		return outcome, res, 0, nil
+	}
+
+	nested := h.removeNestedFinalizers(res)
+	nestedPending := nested.spec
+	if nested.status {
+		h.logger.Debugf("Found ory finalizers in the status of \"%s\" %s, deleting", res.GetName(), res.GetKind())
+		updated, err := h.updateInstanceStatus(ctx, crdef, res)
 		if err != nil {
-			return err
+			return outcome, nil, 0, err
+		}
+		if updated == nil {
+			nestedPending = true
+		} else {
+			// the status update returns the resource without the changes outside of status
+			res, outcome.modified = updated, updated
+			h.removeNestedFinalizers(res)
 		}
+	}
+	outcome.removedNestedFinalizers = nested.removed
 
-		h.logger.Debugf("Deleted ory finalizer for \"%s\" %s", res.GetName(), instance.GetKind())
+	if h.checkOwnerReferences {
+		orphaned, err := h.orphanedOwnerReferences(ctx, res)
+		if err != nil {
+			return outcome, nil, 0, err
+		}
+		outcome.orphanedOwnerReferences = orphaned
+		outcome.ownerReferencesRemoved = h.removeOrphanedOwnerReferences && len(orphaned) > 0
 	}
 
+	finalizers := res.GetFinalizers()
+	remaining := h.remainingFinalizers(finalizers)
+	if len(remaining) == len(finalizers) && !outcome.ownerReferencesRemoved && !nestedPending {
+		return outcome, nil, 0, nil
+	}
+
+	if len(remaining) < len(finalizers) {
+		h.logger.Debugf("Found ory finalizers for \"%s\" %s, deleting", res.GetName(), res.GetKind())
+
+		if h.annotateRemovedFinalizers {
+			if err := annotateRemovedFinalizers(res, removedFinalizers(finalizers, remaining), h.clock.Now()); err != nil {
+				return outcome, nil, 0, err
+			}
+		}
+		res.SetFinalizers(remaining)
+	}
+	if outcome.ownerReferencesRemoved {
+		h.logger.Debugf("Removing %d orphaned owner references from \"%s\" %s", len(outcome.orphanedOwnerReferences), res.GetName(), res.GetKind())
+		res.SetOwnerReferences(withoutOwnerReferences(res.GetOwnerReferences(), outcome.orphanedOwnerReferences))
+	}
+	return outcome, res, len(finalizers) - len(remaining), nil
+}
+
+// readInstance returns a copy of the listed instance if it is written as listed, or gets the latest version of the
+// instance, nil if it was deleted
+func (h *DefaultOryFinalizersHandler) readInstance(ctx context.Context, crdef schema.GroupVersionResource,
+	instance unstructured.Unstructured, listed bool) (*unstructured.Unstructured, error) {
+	if listed && h.isWritableAsListed(instance) {
+		return instance.DeepCopy(), nil
+	}
+	res, err := h.instanceResource(crdef, instance.GetNamespace()).Get(ctx, instance.GetName(), metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, h.checkForbidden(err, "get", crdef.GroupResource(), instance.GetNamespace())
+	}
+	return res, nil
+}
+
+// isWritableAsListed reports whether the listed instance holds all the removal reads. Full instances do, instances
+// listed as metadata only do unless WithNestedFinalizerPaths or WithRemovalPredicate needs their content. Their
+// metadata is written with the merge patch of WithFinalizerPatch, as an update would drop their content.
+func (h *DefaultOryFinalizersHandler) isWritableAsListed(instance unstructured.Unstructured) bool {
+	if !isMetadataOnly(instance) {
+		return true
+	}
+	return len(h.nestedFinalizerPaths) == 0 && h.removalPredicate == nil && instance.GetResourceVersion() != ""
+}
+
+// updateInstance writes the resource as read and modified, the resourceVersion of the read makes the API server
+// reject the update with a conflict if the resource changed since. It returns the updated resource, or nil if the
+// resource was deleted before.
+func (h *DefaultOryFinalizersHandler) updateInstance(ctx context.Context, crdef schema.GroupVersionResource,
+	res *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if h.patchFinalizers || isMetadataOnly(*res) {
+		return h.patchInstance(ctx, crdef, res)
+	}
+	updated, err := h.instanceResource(crdef, res.GetNamespace()).Update(ctx, res, metav1.UpdateOptions{})
+	if apierr.IsNotFound(err) {
+		// the resource was deleted after it was read, which is what removing its finalizers is meant to achieve
+		h.logger.Debugf("Ory custom resource \"%s\" was deleted before its finalizers were removed", res.GetName())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, h.checkForbidden(err, "update", crdef.GroupResource(), res.GetNamespace())
+	}
+	return updated, nil
+}
+
+// annotateRemovedFinalizers records the removed finalizers on the resource so that they can be restored manually.
+// An existing record is replaced to keep the annotation from growing across repeated runs.
+func annotateRemovedFinalizers(res *unstructured.Unstructured, removed []string, now time.Time) error {
+	record, err := json.Marshal(removedFinalizersRecord{
+		Finalizers: removed,
+		Timestamp:  now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode removed finalizers")
+	}
+	annotations := res.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[RemovedFinalizersAnnotation] = string(record)
+	res.SetAnnotations(annotations)
 	return nil
 }
 
+func removedFinalizers(finalizers, remaining []string) []string {
+	kept := make(map[string]bool, len(remaining))
+	for _, finalizer := range remaining {
+		kept[finalizer] = true
+	}
+	var removed []string
+	for _, finalizer := range finalizers {
+		if !kept[finalizer] {
+			removed = append(removed, finalizer)
+		}
+	}
+	return removed
+}
+
+// remainingFinalizers returns all finalizers not matched by the finalizer matcher, or nil if none remain
+func (h *DefaultOryFinalizersHandler) remainingFinalizers(finalizers []string) []string {
+	remaining, _ := k8sFinalizers.Split(finalizers, h.finalizerMatcher)
+	return remaining
+}
+
+// retryOnTransientError retries fn with exponential backoff as long as it fails with a retriable API error, see
+// IsRetriableError, giving up after the configured number of list attempts
+func (h *DefaultOryFinalizersHandler) retryOnTransientError(ctx context.Context, fn func() error) error {
+	backoff := k8sRetry.DefaultBackoff
+	backoff.Steps = h.listAttempts
+	return h.retry(ctx, backoff, IsRetriableError, fn)
+}
+
+// connectBackoff is used between attempts to reach the API server, see WithConnectRetryTimeout
+var connectBackoff = wait.Backoff{Duration: 500 * time.Millisecond, Factor: 2, Jitter: 0.1, Steps: math.MaxInt32, Cap: 10 * time.Second}
+
+// retryOnConnectivityError calls fn again with backoff as long as the API server cannot be reached and the
+// configured connect retry timeout has not passed. The wait ends early when the context is done.
+func (h *DefaultOryFinalizersHandler) retryOnConnectivityError(ctx context.Context, fn func() error) error {
+	deadline := h.clock.Now().Add(h.connectRetryTimeout)
+	backoff := connectBackoff
+	for {
+		err := fn()
+		if !isConnectivityError(err) {
+			return err
+		}
+		delay := backoff.Step()
+		if h.clock.Now().Add(delay).After(deadline) {
+			return err
+		}
+		h.logger.Debugf("API server is not reachable, retrying in %s: %v", delay, err)
+		if sleepErr := h.sleep(ctx, delay); sleepErr != nil {
+			return errors.Wrapf(sleepErr, "retry interrupted after %v", err)
+		}
+	}
+}
+
+// isConnectivityError reports whether the API server could not be reached at all. Errors of the configuration,
+// like an unknown certificate authority, are permanent and not considered connectivity errors.
+func isConnectivityError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var dnsErr *net.DNSError
+	return utilnet.IsConnectionRefused(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsProbableEOF(err) ||
+		utilnet.IsTimeout(err) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.As(err, &dnsErr)
+}
+
+// retryOnUnauthorized calls fn once more with rebuilt clients if it fails with Unauthorized, as short-lived
+// credentials may have expired while the clients were cached. Credentials which are still rejected afterwards
+// result in a CredentialsRejectedError.
+func (h *DefaultOryFinalizersHandler) retryOnUnauthorized(ctx context.Context, fn func() error) error {
+	err := fn()
+	if !apierr.IsUnauthorized(err) {
+		return err
+	}
+
+	h.logger.Warnf("API server rejected the credentials, rebuilding clients: %v", err)
+	rebuilt, rebuildErr := h.rebuildClients(ctx)
+	if rebuildErr != nil {
+		return errors.Wrap(rebuildErr, "failed to rebuild clients after the credentials were rejected")
+	}
+	if rebuilt {
+		err = fn()
+	}
+	if apierr.IsUnauthorized(err) {
+		return &CredentialsRejectedError{err: err}
+	}
+	return err
+}
+
+// retry calls fn until it succeeds, fails with an error which is not retriable or the steps of the backoff are
+// exhausted, returning the last error. Unlike RetryOnTransient it sleeps on the clock of the handler.
+func (h *DefaultOryFinalizersHandler) retry(ctx context.Context, backoff wait.Backoff, retriable func(error) bool, fn func() error) error {
+	return retryOnError(ctx, h.sleep, backoff, retriable, fn)
+}
+
+// sleep waits for a timer of the clock of the handler between the attempts of a retry. It ends early with the
+// error of the context when the context is done.
+func (h *DefaultOryFinalizersHandler) sleep(ctx context.Context, d time.Duration) error {
+	return sleepOnClock(h.clock)(ctx, d)
+}
+
+// inClusterConfig is replaceable to allow testing without a service account being mounted
+var inClusterConfig = rest.InClusterConfig
+
 // restConfig loads the rest configuration needed by k8s clients to interact with clusters based on the kubeconfig.
 // Loading rules are based on standard defined kubernetes config loading.
-func restConfig(kubeconfigData string) (*rest.Config, error) {
-	cfg, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfigData))
+// An empty kubeconfig selects the configuration the handler was created for, the kubeconfig files passed with
+// WithKubeconfigPaths, or the in-cluster configuration of the mounted service account.
+func (h *DefaultOryFinalizersHandler) restConfig(ctx context.Context, kubeconfigData string) (*rest.Config, error) {
+	var cfg *rest.Config
+	var err error
+	if kubeconfigData == "" && h.config != nil {
+		cfg = rest.CopyConfig(h.config)
+	} else if kubeconfigData == "" && len(h.kubeconfigPaths) > 0 {
+		cfg, err = kubeconfigFilesRestConfig(h.kubeconfigPaths, h.kubeconfigContext, h.kubeconfigCluster,
+			h.probeExecOnce(ctx, strings.Join(h.kubeconfigPaths, string(filepath.ListSeparator))))
+		if err != nil {
+			return nil, err
+		}
+	} else if kubeconfigData == "" {
+		cfg, err = inClusterConfig()
+		if err != nil {
+			return nil, &NoClusterConfigError{err: err}
+		}
+	} else {
+		cfg, err = kubeconfigRestConfig(kubeconfigData, h.kubeconfigContext, h.kubeconfigCluster, h.probeExecOnce(ctx, kubeconfigData))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := h.overrideTLS(cfg); err != nil {
+		return nil, err
+	}
+	cfg.UserAgent = h.fullUserAgent()
+	if h.rateLimiter != nil {
+		cfg.RateLimiter = h.rateLimiter
+	}
+	cfg.Timeout = h.requestTimeout
+	if err := setContentType(cfg, h.contentType); err != nil {
+		return nil, err
+	}
+	if h.dialTimeout > 0 {
+		cfg.Dial = (&net.Dialer{Timeout: h.dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if h.suppressWarnings {
+		cfg.WarningHandler = rest.NoWarnings{}
+	} else {
+		cfg.WarningHandler = h.warnings
+	}
+	if h.impersonation != nil {
+		if err := validateImpersonation(*h.impersonation); err != nil {
+			return nil, err
+		}
+		cfg.Impersonate = *h.impersonation
+	}
+	if h.wrapTransport != nil {
+		cfg.Wrap(h.wrapTransport)
+	}
+	if h.breaker != nil {
+		cfg.Wrap(h.breaker.wrap)
+	}
+	return cfg, nil
+}
+
+// overrideTLS replaces the CA bundle and the server name of the configuration if the handler has overrides for them
+func (h *DefaultOryFinalizersHandler) overrideTLS(cfg *rest.Config) error {
+	if len(h.caData) > 0 {
+		if _, err := certutil.ParseCertsPEM(h.caData); err != nil {
+			return errors.Wrap(err, "CA bundle override is not a valid PEM encoded certificate")
+		}
+		cfg.CAData = h.caData
+		cfg.CAFile = ""
+	}
+	if h.tlsServerName != "" {
+		cfg.ServerName = h.tlsServerName
+	}
+	return nil
+}
+
+// DefaultUserAgent returns the User-Agent "kyma-reconciler/ory-cleanup/<version>", which identifies the requests of
+// the cleanup in audit logs and allows to select them in API Priority and Fairness rules
+func DefaultUserAgent() string {
+	return "kyma-reconciler/ory-cleanup/" + Version
+}
+
+// fullUserAgent returns the User-Agent sent with all requests, which identifies the cleanup in audit logs
+func (h *DefaultOryFinalizersHandler) fullUserAgent() string {
+	userAgent := h.userAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent()
+	}
+	if h.userAgentSuffix != "" {
+		userAgent += " " + h.userAgentSuffix
+	}
+	return userAgent
+}
+
+// validateImpersonation makes sure an enabled impersonation describes a complete identity
+func validateImpersonation(impersonation rest.ImpersonationConfig) error {
+	if impersonation.UserName == "" {
+		return errors.New("impersonation is enabled but no user name is set")
+	}
+	for _, group := range impersonation.Groups {
+		if group == "" {
+			return errors.New("impersonation groups must not contain empty group names")
+		}
+	}
+	return nil
+}
+
+// setContentType lets the typed clients send the given content type. Protobuf is accepted along with JSON, which
+// the API server falls back to for resources without a protobuf encoding. The dynamic and metadata clients set
+// their own content types, as custom resources have no protobuf encoding.
+func setContentType(cfg *rest.Config, contentType string) error {
+	switch contentType {
+	case runtime.ContentTypeProtobuf:
+		cfg.ContentType = runtime.ContentTypeProtobuf
+		cfg.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+	case runtime.ContentTypeJSON:
+		cfg.ContentType = runtime.ContentTypeJSON
+		cfg.AcceptContentTypes = runtime.ContentTypeJSON
+	default:
+		return errors.Errorf("unsupported content type \"%s\", expected \"%s\" or \"%s\"",
+			contentType, runtime.ContentTypeProtobuf, runtime.ContentTypeJSON)
+	}
+	return nil
+}
+
+// kubeconfigRestConfig builds the rest configuration of the given context, or of the current context if none is given.
+// A cluster name replaces the cluster of the context while keeping its credentials.
+func kubeconfigRestConfig(kubeconfigData, contextName, clusterName string, probe execProbe) (*rest.Config, error) {
+	rawConfig, err := loadKubeconfig(kubeconfigData, contextName, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return rawRestConfig(rawConfig, contextName, clusterName, probe)
+}
+
+// kubeconfigFilesRestConfig builds the rest configuration like kubeconfigRestConfig from merged kubeconfig files
+func kubeconfigFilesRestConfig(paths []string, contextName, clusterName string, probe execProbe) (*rest.Config, error) {
+	rawConfig, err := loadKubeconfigFiles(paths, contextName, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return rawRestConfig(rawConfig, contextName, clusterName, probe)
+}
+
+// rawRestConfig builds the rest configuration of a validated kubeconfig. Exec credential plugins are passed to the
+// probe, so that a missing plugin is reported up front.
+func rawRestConfig(rawConfig *clientcmdapi.Config, contextName, clusterName string, probe execProbe) (*rest.Config, error) {
+	if contextName == "" {
+		contextName = rawConfig.CurrentContext
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	overrides.Context.Cluster = clusterName
+	cfg, err := clientcmd.NewNonInteractiveClientConfig(*rawConfig, contextName, overrides, nil).ClientConfig()
 	if err != nil {
 		return nil, err
 	}
-	cfg.WarningHandler = rest.NoWarnings{}
+	if cfg.ExecProvider != nil {
+		if kubeContext, ok := rawConfig.Contexts[contextName]; ok && clusterName == "" {
+			clusterName = kubeContext.Cluster
+		}
+		if err := probe(cfg, clusterName, contextName); err != nil {
+			return nil, err
+		}
+	}
 	return cfg, nil
 }
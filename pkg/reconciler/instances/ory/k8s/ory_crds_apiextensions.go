@@ -0,0 +1,201 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apixv1client "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// APIExtensionsV1 reads custom resource definitions with apiextensions.k8s.io/v1, served since Kubernetes 1.16
+	APIExtensionsV1 = "v1"
+	// APIExtensionsV1beta1 reads custom resource definitions with apiextensions.k8s.io/v1beta1, removed in Kubernetes 1.22
+	APIExtensionsV1beta1 = "v1beta1"
+)
+
+// APIExtensionsVersionNotServedError is returned if the apiextensions version set with WithAPIExtensionsVersion
+// is not served by the cluster
+type APIExtensionsVersionNotServedError struct {
+	Version string
+	err     error
+}
+
+func (e *APIExtensionsVersionNotServedError) Error() string {
+	return fmt.Sprintf("apiextensions.k8s.io/%s is not served by the cluster: %v", e.Version, e.err)
+}
+
+func (e *APIExtensionsVersionNotServedError) Unwrap() error {
+	return e.err
+}
+
+func IsAPIExtensionsVersionNotServedError(err error) bool {
+	var target *APIExtensionsVersionNotServedError
+	return errors.As(err, &target)
+}
+
+// crdClient reads and deletes custom resource definitions in their v1beta1 representation, regardless of the
+// apiextensions version used to talk to the cluster
+type crdClient interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*apixv1beta1.CustomResourceDefinition, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*apixv1beta1.CustomResourceDefinitionList, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+// crds returns the client for the apiextensions version set with WithAPIExtensionsVersion, or otherwise the newest
+// version found by discovery. The version is resolved on the first call and kept until the clients are closed.
+// If discovery fails for other reasons than rejected credentials, v1beta1 is used without remembering it, so that
+// the next call tries again.
+func (h *DefaultOryFinalizersHandler) crds(ctx context.Context) (crdClient, error) {
+	// the clients are read before locking, as closing the clients resets the resolved client with their lock held
+	clients := h.currentClients()
+	h.crdClientMu.Lock()
+	defer h.crdClientMu.Unlock()
+	if h.resolvedCRDs != nil {
+		return h.resolvedCRDs, nil
+	}
+
+	switch h.apiExtensionsVersion {
+	case APIExtensionsV1:
+		if clients.apixV1Client == nil {
+			return nil, errors.New("no apiextensions.k8s.io/v1 client available")
+		}
+		client := &v1CRDClient{client: clients.apixV1Client.CustomResourceDefinitions()}
+		if err := verifyServed(ctx, client, APIExtensionsV1); err != nil {
+			return nil, err
+		}
+		h.resolvedCRDs = client
+	case APIExtensionsV1beta1:
+		if clients.apixClient == nil {
+			return nil, errors.New("no apiextensions.k8s.io/v1beta1 client available")
+		}
+		client := clients.apixClient.CustomResourceDefinitions()
+		if err := verifyServed(ctx, client, APIExtensionsV1beta1); err != nil {
+			return nil, err
+		}
+		h.resolvedCRDs = client
+	case "":
+		if clients.apixV1Client == nil || clients.discovery == nil {
+			// only the v1beta1 client was provided
+			return clients.apixClient.CustomResourceDefinitions(), nil
+		}
+		resources, err := clients.discovery.ServerResourcesForGroupVersion(apixv1.SchemeGroupVersion.String())
+		if apierr.IsUnauthorized(err) {
+			// the clients are rebuilt with refreshed credentials, see retryOnUnauthorized
+			return nil, err
+		}
+		if err != nil && !apierr.IsNotFound(err) {
+			h.logger.Debugf("Discovery of apiextensions versions failed, using v1beta1: %v", err)
+			return clients.apixClient.CustomResourceDefinitions(), nil
+		}
+		h.resolvedCRDs = clients.apixClient.CustomResourceDefinitions()
+		if err == nil && servesCRDs(resources) {
+			h.resolvedCRDs = &v1CRDClient{client: clients.apixV1Client.CustomResourceDefinitions()}
+		}
+	default:
+		return nil, errors.Errorf("unsupported apiextensions version \"%s\", expected %s or %s",
+			h.apiExtensionsVersion, APIExtensionsV1, APIExtensionsV1beta1)
+	}
+	return h.resolvedCRDs, nil
+}
+
+// verifyServed lists a single custom resource definition, which is only not found if the version is not served
+func verifyServed(ctx context.Context, client crdClient, version string) error {
+	_, err := client.List(ctx, metav1.ListOptions{Limit: 1})
+	if apierr.IsNotFound(err) {
+		return &APIExtensionsVersionNotServedError{Version: version, err: err}
+	}
+	if err != nil && !apierr.IsForbidden(err) {
+		return err
+	}
+	return nil
+}
+
+func servesCRDs(resources *metav1.APIResourceList) bool {
+	if resources == nil {
+		return false
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == crdResource.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+// v1CRDClient converts the custom resource definitions read with apiextensions.k8s.io/v1 to v1beta1
+type v1CRDClient struct {
+	client apixv1client.CustomResourceDefinitionInterface
+}
+
+func (c *v1CRDClient) Get(ctx context.Context, name string, opts metav1.GetOptions) (*apixv1beta1.CustomResourceDefinition, error) {
+	crd, err := c.client.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	converted := toV1beta1CRD(crd)
+	return &converted, nil
+}
+
+func (c *v1CRDClient) List(ctx context.Context, opts metav1.ListOptions) (*apixv1beta1.CustomResourceDefinitionList, error) {
+	list, err := c.client.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	converted := &apixv1beta1.CustomResourceDefinitionList{ListMeta: list.ListMeta}
+	for i := range list.Items {
+		converted.Items = append(converted.Items, toV1beta1CRD(&list.Items[i]))
+	}
+	return converted, nil
+}
+
+func (c *v1CRDClient) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.client.Delete(ctx, name, opts)
+}
+
+// toV1beta1CRD converts the fields of a custom resource definition read by the handler. The deprecated single
+// version field is set to the first version, like the API server does when converting to v1beta1.
+func toV1beta1CRD(crd *apixv1.CustomResourceDefinition) apixv1beta1.CustomResourceDefinition {
+	converted := apixv1beta1.CustomResourceDefinition{
+		TypeMeta:   metav1.TypeMeta{APIVersion: apixv1beta1.SchemeGroupVersion.String(), Kind: "CustomResourceDefinition"},
+		ObjectMeta: crd.ObjectMeta,
+		Spec: apixv1beta1.CustomResourceDefinitionSpec{
+			Group: crd.Spec.Group,
+			Names: apixv1beta1.CustomResourceDefinitionNames{
+				Plural:     crd.Spec.Names.Plural,
+				Singular:   crd.Spec.Names.Singular,
+				ShortNames: crd.Spec.Names.ShortNames,
+				Kind:       crd.Spec.Names.Kind,
+				ListKind:   crd.Spec.Names.ListKind,
+				Categories: crd.Spec.Names.Categories,
+			},
+			Scope: apixv1beta1.ResourceScope(crd.Spec.Scope),
+		},
+	}
+	for _, version := range crd.Spec.Versions {
+		converted.Spec.Versions = append(converted.Spec.Versions, apixv1beta1.CustomResourceDefinitionVersion{
+			Name:    version.Name,
+			Served:  version.Served,
+			Storage: version.Storage,
+		})
+	}
+	if len(crd.Spec.Versions) > 0 {
+		converted.Spec.Version = crd.Spec.Versions[0].Name
+	}
+	for _, condition := range crd.Status.Conditions {
+		converted.Status.Conditions = append(converted.Status.Conditions, apixv1beta1.CustomResourceDefinitionCondition{
+			Type:               apixv1beta1.CustomResourceDefinitionConditionType(condition.Type),
+			Status:             apixv1beta1.ConditionStatus(condition.Status),
+			LastTransitionTime: condition.LastTransitionTime,
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+		})
+	}
+	converted.Status.StoredVersions = crd.Status.StoredVersions
+	return converted
+}
@@ -0,0 +1,158 @@
+package k8s
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	apixV1Path          = "/apis/apiextensions.k8s.io/v1"
+	apixV1CRDsPath      = apixV1Path + "/customresourcedefinitions"
+	apixV1beta1CRDsPath = "/apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions"
+)
+
+func Test_FindAndDeleteOryFinalizers_APIExtensionsVersion(t *testing.T) {
+	t.Run("should use apiextensions v1 when discovery finds it", func(t *testing.T) {
+		// given
+		server, paths := newAPIExtensionsServer(t, true)
+		handler := NewDefaultOryFinalizersHandler()
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		require.Equal(t, oauth2ClientGVR, result.CRDs[0].GVR)
+		require.Equal(t, []string{apixV1Path, apixV1CRDsPath + "/" + oauth2ClientCRD, "/apis/hydra.ory.sh/v1alpha1/oauth2clients"}, paths())
+	})
+
+	t.Run("should fall back to apiextensions v1beta1 when v1 is not served", func(t *testing.T) {
+		// given
+		server, paths := newAPIExtensionsServer(t, false)
+		handler := NewDefaultOryFinalizersHandler()
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		require.Equal(t, []string{apixV1Path, apixV1beta1CRDsPath + "/" + oauth2ClientCRD, "/apis/hydra.ory.sh/v1alpha1/oauth2clients"}, paths())
+	})
+
+	t.Run("should skip discovery for an explicit version", func(t *testing.T) {
+		// given
+		server, paths := newAPIExtensionsServer(t, true)
+		handler := NewDefaultOryFinalizersHandler(WithAPIExtensionsVersion(APIExtensionsV1))
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		require.Equal(t, []string{apixV1CRDsPath, apixV1CRDsPath + "/" + oauth2ClientCRD, "/apis/hydra.ory.sh/v1alpha1/oauth2clients"}, paths())
+	})
+
+	t.Run("should fail when the explicit version is not served", func(t *testing.T) {
+		// given
+		server, _ := newAPIExtensionsServer(t, false)
+		handler := NewDefaultOryFinalizersHandler(WithAPIExtensionsVersion(APIExtensionsV1))
+		defer handler.Close()
+
+		// when
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.True(t, IsAPIExtensionsVersionNotServedError(err))
+		require.Contains(t, err.Error(), "apiextensions.k8s.io/v1 is not served by the cluster")
+	})
+
+	t.Run("should reject an unsupported version", func(t *testing.T) {
+		// given
+		server, paths := newAPIExtensionsServer(t, true)
+		handler := NewDefaultOryFinalizersHandler(WithAPIExtensionsVersion("v2"))
+		defer handler.Close()
+
+		// when
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `unsupported apiextensions version "v2"`)
+		require.Empty(t, paths())
+	})
+}
+
+func Test_ToV1beta1CRD(t *testing.T) {
+	// given
+	crd := &apixv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: oauth2ClientCRD, UID: "uid"},
+		Spec: apixv1.CustomResourceDefinitionSpec{
+			Group: "hydra.ory.sh",
+			Names: apixv1.CustomResourceDefinitionNames{Plural: "oauth2clients", Kind: "OAuth2Client"},
+			Scope: apixv1.NamespaceScoped,
+			Versions: []apixv1.CustomResourceDefinitionVersion{
+				{Name: "v1alpha1", Served: true, Storage: true},
+				{Name: "v1alpha2", Served: false},
+			},
+		},
+		Status: apixv1.CustomResourceDefinitionStatus{
+			Conditions: []apixv1.CustomResourceDefinitionCondition{{Type: apixv1.Established, Status: apixv1.ConditionTrue}},
+		},
+	}
+
+	// when
+	converted := toV1beta1CRD(crd)
+
+	// then
+	require.Equal(t, oauth2ClientCRD, converted.Name)
+	require.Equal(t, "v1alpha1", converted.Spec.Version)
+	require.Equal(t, apixv1beta1.NamespaceScoped, converted.Spec.Scope)
+	require.Equal(t, []string{"v1alpha1"}, servedVersions(converted))
+	require.True(t, isCRDEstablished(&converted))
+}
+
+// newAPIExtensionsServer serves the oauth2client crd with apiextensions v1beta1 and, if enabled, v1.
+// It returns the requested paths.
+func newAPIExtensionsServer(t *testing.T, v1Served bool) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case v1Served && r.URL.Path == apixV1Path:
+			_, _ = w.Write([]byte(`{"kind":"APIResourceList","apiVersion":"v1","groupVersion":"apiextensions.k8s.io/v1",` +
+				`"resources":[{"name":"customresourcedefinitions","kind":"CustomResourceDefinition","namespaced":false,"verbs":["get","list"]}]}`))
+		case v1Served && r.URL.Path == apixV1CRDsPath:
+			_, _ = w.Write([]byte(`{"apiVersion":"apiextensions.k8s.io/v1","kind":"CustomResourceDefinitionList","metadata":{},"items":[]}`))
+		case v1Served && r.URL.Path == apixV1CRDsPath+"/"+oauth2ClientCRD:
+			_, _ = w.Write([]byte(`{"apiVersion":"apiextensions.k8s.io/v1","kind":"CustomResourceDefinition",` +
+				`"metadata":{"name":"oauth2clients.hydra.ory.sh"},"spec":{"group":"hydra.ory.sh",` +
+				`"versions":[{"name":"v1alpha1","served":true,"storage":true}],"names":{"plural":"oauth2clients"},"scope":"Namespaced"},` +
+				`"status":{"conditions":[{"type":"Established","status":"True"}]}}`))
+		default:
+			fakeAPIServerHandler("")(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
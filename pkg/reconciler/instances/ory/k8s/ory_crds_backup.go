@@ -0,0 +1,199 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// BackupLabel marks the secrets holding a backup, its value is the backed up resource
+	BackupLabel = "reconciler.kyma-project.io/ory-backup"
+	// BackupKey is the key of the secret data holding a JSON list of backed up objects
+	BackupKey = "objects.json"
+	// backupChunkBytes keeps every secret well below the 1MiB object size limit of etcd
+	backupChunkBytes = 512 * 1024
+)
+
+var secretGVR = corev1.SchemeGroupVersion.WithResource("secrets")
+
+// BackupFailedError is returned if the instances could not be backed up, in which case no finalizers were removed
+type BackupFailedError struct {
+	GVR schema.GroupVersionResource
+	err error
+}
+
+func (e *BackupFailedError) Error() string {
+	return fmt.Sprintf("failed to back up %s, no finalizers were removed: %v", e.GVR.String(), e.err)
+}
+
+func (e *BackupFailedError) Unwrap() error {
+	return e.err
+}
+
+func IsBackupFailedError(err error) bool {
+	var target *BackupFailedError
+	return errors.As(err, &target)
+}
+
+// backupInstances writes the spec and identifying metadata of all instances of the custom resource to secrets in
+// the backup namespace, see WithBackup. The objects are split into chunks named "<resource>-backup-<index>" and
+// chunks left over from a larger previous backup are deleted. It returns the names of the written secrets.
+func (h *DefaultOryFinalizersHandler) backupInstances(ctx context.Context, crdef schema.GroupVersionResource) ([]string, error) {
+	list, err := h.listInstances(ctx, crdef)
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, h.checkForbidden(err, "list", crdef.GroupResource(), metav1.NamespaceAll)
+	}
+
+	chunks, err := backupChunks(list.Items)
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for i, chunk := range chunks {
+		name := fmt.Sprintf("%s-backup-%d", crdef.Resource, i)
+		if err := h.writeBackupSecret(ctx, crdef, name, chunk); err != nil {
+			return written, errors.Wrapf(err, "failed to write backup secret \"%s/%s\"", h.backupNamespace, name)
+		}
+		written = append(written, name)
+	}
+	if err := h.deleteStaleBackupSecrets(ctx, crdef, len(chunks)); err != nil {
+		return written, err
+	}
+	h.logger.Infof("Backed up %d instances of %s to secrets %v in namespace \"%s\"",
+		len(list.Items), crdef.String(), written, h.backupNamespace)
+	return written, nil
+}
+
+// backupChunks serializes the backed up objects into JSON lists of at most backupChunkBytes.
+// There is always at least one chunk, so that an empty backup replaces a previous one.
+func backupChunks(instances []unstructured.Unstructured) ([][]byte, error) {
+	var chunks [][]byte
+	var current []json.RawMessage
+	size := 0
+	for i := range instances {
+		object, err := json.Marshal(backupObject(instances[i]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to serialize \"%s/%s\"", instances[i].GetNamespace(), instances[i].GetName())
+		}
+		if len(object) > backupChunkBytes {
+			return nil, errors.Errorf("\"%s/%s\" exceeds the backup size limit of %d bytes",
+				instances[i].GetNamespace(), instances[i].GetName(), backupChunkBytes)
+		}
+		if size+len(object) > backupChunkBytes && len(current) > 0 {
+			chunk, err := json.Marshal(current)
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+			current, size = nil, 0
+		}
+		current = append(current, object)
+		// the separating comma
+		size += len(object) + 1
+	}
+	if current == nil {
+		current = []json.RawMessage{}
+	}
+	chunk, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	return append(chunks, chunk), nil
+}
+
+// backupObject drops everything from the instance but its spec and identifying metadata
+func backupObject(instance unstructured.Unstructured) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"name":      instance.GetName(),
+		"namespace": instance.GetNamespace(),
+		"uid":       string(instance.GetUID()),
+	}
+	if labels := instance.GetLabels(); len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if annotations := instance.GetAnnotations(); len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	object := map[string]interface{}{
+		"apiVersion": instance.GetAPIVersion(),
+		"kind":       instance.GetKind(),
+		"metadata":   metadata,
+	}
+	if spec, found := instance.Object["spec"]; found {
+		object["spec"] = spec
+	}
+	return object
+}
+
+func (h *DefaultOryFinalizersHandler) writeBackupSecret(ctx context.Context, crdef schema.GroupVersionResource, name string, data []byte) error {
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: h.backupNamespace,
+			Labels:    map[string]string{BackupLabel: crdef.GroupResource().String()},
+			Annotations: map[string]string{
+				BackupLabel + "-timestamp": h.clock.Now().UTC().Format(metav1.RFC3339Micro),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{BackupKey: data},
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+	if err != nil {
+		return err
+	}
+	secrets := h.currentClients().dynamic.Resource(secretGVR).Namespace(h.backupNamespace)
+
+	_, err = secrets.Create(ctx, &unstructured.Unstructured{Object: object}, metav1.CreateOptions{})
+	if !apierr.IsAlreadyExists(err) {
+		return h.checkForbidden(err, "create", secretGVR.GroupResource(), h.backupNamespace)
+	}
+	existing, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return h.checkForbidden(err, "get", secretGVR.GroupResource(), h.backupNamespace)
+	}
+	updated := &unstructured.Unstructured{Object: object}
+	updated.SetResourceVersion(existing.GetResourceVersion())
+	_, err = secrets.Update(ctx, updated, metav1.UpdateOptions{})
+	return h.checkForbidden(err, "update", secretGVR.GroupResource(), h.backupNamespace)
+}
+
+// deleteStaleBackupSecrets deletes the chunks of a previous backup with an index beyond the current chunks
+func (h *DefaultOryFinalizersHandler) deleteStaleBackupSecrets(ctx context.Context, crdef schema.GroupVersionResource, chunks int) error {
+	secrets := h.currentClients().dynamic.Resource(secretGVR).Namespace(h.backupNamespace)
+	list, err := secrets.List(ctx, metav1.ListOptions{LabelSelector: BackupLabel + "=" + crdef.GroupResource().String()})
+	if err != nil {
+		return h.checkForbidden(err, "list", secretGVR.GroupResource(), h.backupNamespace)
+	}
+	prefix := crdef.Resource + "-backup-"
+	for _, secret := range list.Items {
+		name := secret.GetName()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+		if err != nil || index < chunks {
+			continue
+		}
+		err = secrets.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierr.IsNotFound(err) {
+			return h.checkForbidden(err, "delete", secretGVR.GroupResource(), h.backupNamespace)
+		}
+	}
+	return nil
+}
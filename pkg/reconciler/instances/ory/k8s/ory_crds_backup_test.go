@@ -0,0 +1,170 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_FindAndDeleteOryFinalizers_Backup(t *testing.T) {
+	t.Run("should back up the instances before removing their finalizers", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.sh")
+		instance.SetLabels(map[string]string{"app": "console"})
+		instance.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "kubectl"}})
+		instance.Object["spec"] = map[string]interface{}{"secretName": "client-credentials"}
+		instance.Object["status"] = map[string]interface{}{"observedGeneration": int64(1)}
+		dyn := newFakeDynamicClient(withListKinds(backupListKinds), withObjects(instance))
+		var verbs []string
+		dyn.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetVerb() != "list" && action.GetVerb() != "get" {
+				verbs = append(verbs, action.GetVerb()+" "+action.GetResource().Resource)
+			}
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithBackup("kyma-system"))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{"oauth2clients-backup-0"}, result.CRDs[0].BackupSecrets)
+		require.Equal(t, []string{"create secrets", "update oauth2clients"}, verbs)
+		requireFinalizers(t, dyn, "default", "client")
+
+		objects := requireBackup(t, dyn, "oauth2clients-backup-0")
+		require.Len(t, objects, 1)
+		require.Equal(t, map[string]interface{}{"secretName": "client-credentials"}, objects[0]["spec"])
+		require.NotContains(t, objects[0], "status")
+		metadata := objects[0]["metadata"].(map[string]interface{})
+		require.Equal(t, "client", metadata["name"])
+		require.Equal(t, "default", metadata["namespace"])
+		require.Equal(t, map[string]interface{}{"app": "console"}, metadata["labels"])
+		require.NotContains(t, metadata, "managedFields")
+		require.NotContains(t, metadata, "finalizers")
+	})
+
+	t.Run("should split large backups and delete chunks of previous backups", func(t *testing.T) {
+		// given
+		var objects []runtime.Object
+		for _, name := range []string{"first", "second", "third"} {
+			instance := fixOAuth2Client("default", name, "finalizer.ory.sh")
+			instance.Object["spec"] = map[string]interface{}{"metadata": strings.Repeat("x", 200*1024)}
+			objects = append(objects, instance)
+		}
+		objects = append(objects,
+			fixBackupSecret("kyma-system", "oauth2clients-backup-0", oauth2ClientGVR),
+			fixBackupSecret("kyma-system", "oauth2clients-backup-2", oauth2ClientGVR),
+			fixBackupSecret("kyma-system", "unrelated", oauth2ClientGVR))
+		dyn := newFakeDynamicClient(withListKinds(backupListKinds), withObjects(objects...))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithBackup("kyma-system"))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{"oauth2clients-backup-0", "oauth2clients-backup-1"}, result.CRDs[0].BackupSecrets)
+		require.Len(t, requireBackup(t, dyn, "oauth2clients-backup-0"), 2)
+		require.Len(t, requireBackup(t, dyn, "oauth2clients-backup-1"), 1)
+		_, err = dyn.Resource(secretGVR).Namespace("kyma-system").Get(context.Background(), "oauth2clients-backup-2", metav1.GetOptions{})
+		require.True(t, apierr.IsNotFound(err))
+		_, err = dyn.Resource(secretGVR).Namespace("kyma-system").Get(context.Background(), "unrelated", metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should keep the finalizers if the backup failed", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(backupListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		dyn.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("etcd unavailable")
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithBackup("kyma-system"))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsBackupFailedError(err))
+		require.Contains(t, err.Error(), "etcd unavailable")
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.sh")
+		require.Zero(t, countActions(dyn, "update"))
+	})
+
+	t.Run("should remove the finalizers after a failed backup if failures are ignored", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(backupListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		dyn.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(secretGVR.GroupResource(), "oauth2clients-backup-0", errors.New("denied"))
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithBackup("kyma-system"), WithBackupFailureIgnored())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, result.CRDs[0].BackupSecrets)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should not back up by default", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(backupListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, result.CRDs[0].BackupSecrets)
+		require.Zero(t, countActions(dyn, "create"))
+	})
+}
+
+var backupListKinds = map[schema.GroupVersionResource]string{secretGVR: "SecretList"}
+
+func fixBackupSecret(namespace, name string, gvr schema.GroupVersionResource) *unstructured.Unstructured {
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace(namespace)
+	secret.SetName(name)
+	secret.SetLabels(map[string]string{BackupLabel: gvr.GroupResource().String()})
+	secret.Object["type"] = string(corev1.SecretTypeOpaque)
+	return secret
+}
+
+// requireBackup returns the objects stored in the backup secret of the given name
+func requireBackup(t *testing.T, dyn *dynamicfake.FakeDynamicClient, name string) []map[string]interface{} {
+	secret, err := dyn.Resource(secretGVR).Namespace("kyma-system").Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	encoded, found, err := unstructured.NestedString(secret.Object, "data", BackupKey)
+	require.NoError(t, err)
+	require.True(t, found)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	var objects []map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &objects))
+	return objects
+}
@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerCache shares the informers of all custom resources read by a handler
+type informerCache struct {
+	factory dynamicinformer.DynamicSharedInformerFactory
+	stop    chan struct{}
+}
+
+// cachedInstances lists the instances of the custom resource from the informer cache, see WithInformerCache.
+// The informer of a resource is started and synced on its first read. If the cache is disabled or cannot be synced
+// within the request timeout, the metadata of the instances is listed from the API server instead, see
+// listInstanceMetadata.
+func (h *DefaultOryFinalizersHandler) cachedInstances(ctx context.Context, gvr schema.GroupVersionResource) (*unstructured.UnstructuredList, error) {
+	if !h.informerCacheEnabled {
+		return h.listInstanceMetadata(ctx, gvr)
+	}
+
+	informer, stop := h.informerFor(gvr)
+	syncCtx, cancel := context.WithTimeout(ctx, h.requestTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(mergeDone(syncCtx.Done(), stop), informer.Informer().HasSynced) {
+		h.logger.Debugf("Informer cache of %s did not sync, listing from the API server", gvr.String())
+		return h.listInstanceMetadata(ctx, gvr)
+	}
+
+	objects, err := informer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %s from the informer cache", gvr.String())
+	}
+	list := &unstructured.UnstructuredList{Items: make([]unstructured.Unstructured, 0, len(objects))}
+	for _, object := range objects {
+		instance, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			return nil, errors.Errorf("unexpected object %T in the informer cache of %s", object, gvr.String())
+		}
+		// cached objects are shared and must not be modified
+		list.Items = append(list.Items, *instance.DeepCopy())
+	}
+	sortInstances(list)
+	return list, nil
+}
+
+func (h *DefaultOryFinalizersHandler) informerFor(gvr schema.GroupVersionResource) (informers.GenericInformer, <-chan struct{}) {
+	// the client is read before locking, as closing the clients stops the informers with their lock held
+	dynamicClient := h.currentClients().dynamic
+	h.informersMu.Lock()
+	defer h.informersMu.Unlock()
+
+	if h.informers == nil {
+		h.informers = &informerCache{
+			factory: dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, h.informerResync, metav1.NamespaceAll, nil),
+			stop:    make(chan struct{}),
+		}
+	}
+	genericInformer := h.informers.factory.ForResource(gvr)
+	// starting only starts informers which are not running yet
+	h.informers.factory.Start(h.informers.stop)
+	return genericInformer, h.informers.stop
+}
+
+// stopInformers stops all informers, the next read starts new ones with the current dynamic client
+func (h *DefaultOryFinalizersHandler) stopInformers() {
+	h.informersMu.Lock()
+	defer h.informersMu.Unlock()
+
+	if h.informers != nil {
+		close(h.informers.stop)
+		h.informers = nil
+	}
+}
+
+// mergeDone returns a channel which is closed as soon as one of the given channels is closed
+func mergeDone(a, b <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-a:
+		case <-b:
+		}
+	}()
+	return done
+}
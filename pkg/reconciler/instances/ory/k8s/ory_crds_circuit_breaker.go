@@ -0,0 +1,228 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+)
+
+// ErrCircuitOpen is returned for requests which were not sent because the API server failed repeatedly,
+// see WithCircuitBreaker
+var ErrCircuitOpen = errors.New("circuit breaker is open after repeated API server failures")
+
+// CircuitState is the state of the circuit breaker of the handler
+type CircuitState int
+
+const (
+	// CircuitClosed sends all requests
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen sends a single request after the cool-down, which decides whether the circuit closes again
+	CircuitHalfOpen
+	// CircuitOpen fails all requests with ErrCircuitOpen until the cool-down has passed
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker counts consecutive failed requests and opens after the threshold is reached. Requests fail if
+// they could not be sent, or the API server answered with a server error or too many requests.
+type circuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+	clock     clock.PassiveClock
+	// gauges reports the state labelled with the cluster, which is set once the clients of the handler are built
+	gauges  *prometheus.GaugeVec
+	cluster string
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// trial is set while the single request of the half-open state is in flight
+	trial bool
+}
+
+func newCircuitBreaker(threshold int, coolDown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, coolDown: coolDown}
+}
+
+// setDefaults sets the clock and the gauges of the handler unless they are already set
+func (b *circuitBreaker) setDefaults(clock clock.PassiveClock, gauges func() *prometheus.GaugeVec) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clock == nil {
+		b.clock = clock
+	}
+	if b.gauges == nil {
+		b.gauges = gauges()
+		b.publish()
+	}
+}
+
+// setCluster labels the reported state with the API server host of the cluster the requests are sent to. The
+// failures of a previous cluster say nothing about the new one, so the circuit closes again and the state reported
+// for the previous cluster is dropped. It is safe to call on a nil breaker.
+func (b *circuitBreaker) setCluster(cluster string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cluster == b.cluster {
+		return
+	}
+	if b.cluster != "" {
+		if b.gauges != nil {
+			b.gauges.DeleteLabelValues(b.cluster)
+		}
+		b.state, b.failures, b.trial = CircuitClosed, 0, false
+	}
+	b.cluster = cluster
+	b.publish()
+}
+
+// allow returns ErrCircuitOpen if the request must not be sent. It is safe to call on a nil breaker.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && b.clock.Since(b.openedAt) >= b.coolDown {
+		b.setState(CircuitHalfOpen)
+	}
+	switch {
+	case b.state == CircuitOpen:
+		return ErrCircuitOpen
+	case b.state == CircuitHalfOpen && b.trial:
+		return ErrCircuitOpen
+	case b.state == CircuitHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// record counts the outcome of a sent request. Requests cancelled by the caller say nothing about the API server.
+func (b *circuitBreaker) record(failed, cancelled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	switch {
+	case cancelled:
+	case !failed:
+		b.failures = 0
+		b.setState(CircuitClosed)
+	case b.state == CircuitHalfOpen:
+		b.open()
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.failures = 0
+	b.openedAt = b.clock.Now()
+	b.setState(CircuitOpen)
+}
+
+func (b *circuitBreaker) setState(state CircuitState) {
+	b.state = state
+	b.publish()
+}
+
+// publish reports the state for the cluster, it must be called with the lock held
+func (b *circuitBreaker) publish() {
+	if b.gauges != nil && b.cluster != "" {
+		b.gauges.WithLabelValues(b.cluster).Set(float64(b.state))
+	}
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && b.clock.Since(b.openedAt) >= b.coolDown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+func (b *circuitBreaker) wrap(next http.RoundTripper) http.RoundTripper {
+	return &circuitBreakerTransport{breaker: b, next: next}
+}
+
+type circuitBreakerTransport struct {
+	breaker *circuitBreaker
+	next    http.RoundTripper
+}
+
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+	cancelled := err != nil && (errors.Is(err, context.Canceled) || req.Context().Err() == context.Canceled)
+	t.breaker.record(failed, cancelled)
+	return resp, err
+}
+
+// newCircuitStateGauge registers the gauges reporting the state of the circuit breaker per cluster. The gauges
+// registered by another handler are shared, each handler reports the cluster of its clients.
+func newCircuitStateGauge(registerer prometheus.Registerer) (*prometheus.GaugeVec, error) {
+	gauges := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "reconciler",
+		Name:      "ory_finalizers_circuit_breaker_state",
+		Help:      "State of the circuit breaker around the API server requests of the ory finalizers handler: 0 closed, 1 half-open, 2 open",
+	}, []string{"cluster"})
+	err := registerer.Register(gauges)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(*prometheus.GaugeVec); ok {
+			return existing, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gauges, nil
+}
+
+// circuitStateGauge registers the gauges of WithCircuitBreakerMetrics, or returns nil if metrics are disabled or the
+// registration failed
+func (h *DefaultOryFinalizersHandler) circuitStateGauge() *prometheus.GaugeVec {
+	if h.breakerRegisterer == nil {
+		return nil
+	}
+	gauge, err := newCircuitStateGauge(h.breakerRegisterer)
+	if err != nil {
+		h.logger.Warnf("Failed to register the circuit breaker metric: %s", err)
+		h.breakerRegisterer = nil
+		return nil
+	}
+	return gauge
+}
+
+// CircuitState returns the state of the circuit breaker, which is always closed without WithCircuitBreaker
+func (h *DefaultOryFinalizersHandler) CircuitState() CircuitState {
+	return h.breaker.currentState()
+}
@@ -0,0 +1,261 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func Test_CircuitBreaker(t *testing.T) {
+	t.Run("should open after the consecutive failures and fail fast", func(t *testing.T) {
+		// given
+		transport, sent := newBreakerTransport(3, http.StatusServiceUnavailable)
+
+		// when
+		for i := 0; i < 3; i++ {
+			_, err := roundTrip(transport)
+			require.NoError(t, err)
+		}
+		_, err := roundTrip(transport)
+
+		// then
+		require.ErrorIs(t, err, ErrCircuitOpen)
+		require.Equal(t, 3, *sent)
+		require.Equal(t, CircuitOpen, transport.breaker.currentState())
+	})
+
+	t.Run("should reset the failures on a success", func(t *testing.T) {
+		// given
+		transport, sent := newBreakerTransport(2, http.StatusTooManyRequests, http.StatusOK, http.StatusInternalServerError)
+
+		// when
+		for i := 0; i < 3; i++ {
+			_, err := roundTrip(transport)
+			require.NoError(t, err)
+		}
+
+		// then
+		require.Equal(t, 3, *sent)
+		require.Equal(t, CircuitClosed, transport.breaker.currentState())
+	})
+
+	t.Run("should not count client errors", func(t *testing.T) {
+		// given
+		transport, _ := newBreakerTransport(1, http.StatusNotFound, http.StatusConflict)
+
+		// when
+		for i := 0; i < 2; i++ {
+			_, err := roundTrip(transport)
+			require.NoError(t, err)
+		}
+
+		// then
+		require.Equal(t, CircuitClosed, transport.breaker.currentState())
+	})
+
+	t.Run("should close after a successful trial request once the cool-down has passed", func(t *testing.T) {
+		// given
+		transport, sent := newBreakerTransport(1, http.StatusServiceUnavailable, http.StatusOK)
+		_, err := roundTrip(transport)
+		require.NoError(t, err)
+		fakeClock := transport.breaker.clock.(*testingclock.FakeClock)
+		fakeClock.Step(59 * time.Second)
+		_, err = roundTrip(transport)
+		require.ErrorIs(t, err, ErrCircuitOpen)
+
+		// when
+		fakeClock.Step(time.Second)
+		require.Equal(t, CircuitHalfOpen, transport.breaker.currentState())
+		_, err = roundTrip(transport)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, *sent)
+		require.Equal(t, CircuitClosed, transport.breaker.currentState())
+	})
+
+	t.Run("should reopen when the trial request fails", func(t *testing.T) {
+		// given
+		transport, sent := newBreakerTransport(1, http.StatusServiceUnavailable)
+		_, err := roundTrip(transport)
+		require.NoError(t, err)
+		transport.breaker.clock.(*testingclock.FakeClock).Step(time.Minute)
+
+		// when
+		_, err = roundTrip(transport)
+		require.NoError(t, err)
+		_, err = roundTrip(transport)
+
+		// then
+		require.ErrorIs(t, err, ErrCircuitOpen)
+		require.Equal(t, 2, *sent)
+		require.Equal(t, CircuitOpen, transport.breaker.currentState())
+	})
+
+	t.Run("should send a single trial request at a time", func(t *testing.T) {
+		// given
+		breaker := newCircuitBreaker(1, time.Minute)
+		fakeClock := newSteppingClock()
+		breaker.setDefaults(fakeClock, func() *prometheus.GaugeVec { return nil })
+		breaker.record(true, false)
+		fakeClock.Step(time.Minute)
+
+		// when
+		trialErr := breaker.allow()
+		concurrentErr := breaker.allow()
+
+		// then
+		require.NoError(t, trialErr)
+		require.ErrorIs(t, concurrentErr, ErrCircuitOpen)
+	})
+
+	t.Run("should not count requests cancelled by the caller", func(t *testing.T) {
+		// given
+		breaker := newCircuitBreaker(1, time.Minute)
+		breaker.setDefaults(newSteppingClock(), func() *prometheus.GaugeVec { return nil })
+		transport := breaker.wrap(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, req.Context().Err()
+		}))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://apiserver", nil)
+		require.NoError(t, err)
+
+		// when
+		_, err = transport.RoundTrip(req)
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, CircuitClosed, breaker.currentState())
+	})
+
+	t.Run("should stop the run when the circuit opens", func(t *testing.T) {
+		// given
+		failing := int32(1)
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&failing) == 1 {
+				atomic.AddInt32(&requests, 1)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"ServiceUnavailable","code":503}`))
+				return
+			}
+			fakeAPIServerHandler("")(w, r)
+		}))
+		t.Cleanup(server.Close)
+		fakeClock := newSteppingClock()
+		handler := NewDefaultOryFinalizersHandler(WithCircuitBreaker(3, time.Hour), WithClock(fakeClock), WithListAttempts(10))
+		t.Cleanup(handler.Close)
+
+		// when
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.ErrorIs(t, err, ErrCircuitOpen)
+		require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+		require.Equal(t, CircuitOpen, handler.CircuitState())
+
+		// when
+		atomic.StoreInt32(&failing, 0)
+		fakeClock.Step(time.Hour)
+		_, err = handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, CircuitClosed, handler.CircuitState())
+	})
+
+	t.Run("should expose the state as a metric per cluster", func(t *testing.T) {
+		// given
+		registry := prometheus.NewRegistry()
+		handler := NewDefaultOryFinalizersHandler(WithCircuitBreaker(1, time.Minute), WithCircuitBreakerMetrics(registry),
+			WithClock(newSteppingClock()))
+		require.NoError(t, handler.initClients(context.Background(), testKubeconfig))
+		other := NewDefaultOryFinalizersHandler(WithCircuitBreaker(1, time.Minute), WithCircuitBreakerMetrics(registry))
+		require.NoError(t, other.initClients(context.Background(), testMultiContextKubeconfig))
+		require.Equal(t, CircuitClosed, other.CircuitState())
+
+		// when
+		handler.breaker.record(true, false)
+
+		// then
+		require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP reconciler_ory_finalizers_circuit_breaker_state State of the circuit breaker around the API server requests of the ory finalizers handler: 0 closed, 1 half-open, 2 open
+# TYPE reconciler_ory_finalizers_circuit_breaker_state gauge
+reconciler_ory_finalizers_circuit_breaker_state{cluster="https://blue.example.com"} 0
+reconciler_ory_finalizers_circuit_breaker_state{cluster="https://test.example.com"} 2
+`)))
+	})
+
+	t.Run("should close the circuit and drop the metric of the previous cluster", func(t *testing.T) {
+		// given
+		registry := prometheus.NewRegistry()
+		handler := NewDefaultOryFinalizersHandler(WithCircuitBreaker(1, time.Minute), WithCircuitBreakerMetrics(registry),
+			WithClock(newSteppingClock()))
+		require.NoError(t, handler.initClients(context.Background(), testKubeconfig))
+		handler.breaker.record(true, false)
+
+		// when
+		handler.Close()
+		require.NoError(t, handler.initClients(context.Background(), testMultiContextKubeconfig))
+
+		// then
+		require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP reconciler_ory_finalizers_circuit_breaker_state State of the circuit breaker around the API server requests of the ory finalizers handler: 0 closed, 1 half-open, 2 open
+# TYPE reconciler_ory_finalizers_circuit_breaker_state gauge
+reconciler_ory_finalizers_circuit_breaker_state{cluster="https://blue.example.com"} 0
+`)))
+		require.Equal(t, CircuitClosed, handler.CircuitState())
+	})
+
+	t.Run("should stay closed without a circuit breaker", func(t *testing.T) {
+		require.Equal(t, CircuitClosed, NewDefaultOryFinalizersHandler().CircuitState())
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newBreakerTransport wraps a transport answering with the given status codes, repeating the last one, in a breaker
+// with a cool-down of a minute on a fake clock. It returns the number of requests which reached the transport.
+func newBreakerTransport(threshold int, statusCodes ...int) (*circuitBreakerTransport, *int) {
+	breaker := newCircuitBreaker(threshold, time.Minute)
+	breaker.setDefaults(testingclock.NewFakeClock(time.Now()), func() *prometheus.GaugeVec { return nil })
+	sent := 0
+	transport := breaker.wrap(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		statusCode := statusCodes[len(statusCodes)-1]
+		if sent < len(statusCodes) {
+			statusCode = statusCodes[sent]
+		}
+		sent++
+		return &http.Response{StatusCode: statusCode, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	}))
+	return transport.(*circuitBreakerTransport), &sent
+}
+
+func roundTrip(transport http.RoundTripper) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://apiserver/apis", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	resp, err := transport.RoundTrip(req)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	return resp, err
+}
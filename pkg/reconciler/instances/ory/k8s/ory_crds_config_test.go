@@ -0,0 +1,1210 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://test.example.com
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: secret-token
+`
+
+func Test_RestConfig(t *testing.T) {
+	t.Run("should build config from kubeconfig", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://test.example.com", cfg.Host)
+		require.IsType(t, &warningRouter{}, cfg.WarningHandler)
+	})
+
+	t.Run("should use in-cluster config when kubeconfig is empty", func(t *testing.T) {
+		// given
+		tokenFile := fixServiceAccount(t, "10.0.0.1", "443")
+
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), "")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://10.0.0.1:443", cfg.Host)
+		require.Equal(t, tokenFile, cfg.BearerTokenFile)
+		require.Equal(t, "in-cluster-token", cfg.BearerToken)
+		require.IsType(t, &warningRouter{}, cfg.WarningHandler)
+	})
+
+	t.Run("should return typed error when not running in a cluster", func(t *testing.T) {
+		// given
+		t.Setenv("KUBERNETES_SERVICE_HOST", "")
+		t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+		// when
+		_, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), "")
+
+		// then
+		require.Error(t, err)
+		require.True(t, IsNoClusterConfigError(err))
+		require.True(t, errors.Is(err, rest.ErrNotInCluster))
+	})
+
+	t.Run("should return typed error when service account token is missing", func(t *testing.T) {
+		// given
+		tokenFile := fixServiceAccount(t, "10.0.0.1", "443")
+		require.NoError(t, os.Remove(tokenFile))
+
+		// when
+		_, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), "")
+
+		// then
+		require.Error(t, err)
+		require.True(t, IsNoClusterConfigError(err))
+		require.True(t, errors.Is(err, os.ErrNotExist))
+	})
+}
+
+const testMultiContextKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: blue
+  cluster:
+    server: https://blue.example.com
+- name: green
+  cluster:
+    server: https://green.example.com
+contexts:
+- name: blue
+  context:
+    cluster: blue
+    user: blue
+- name: green
+  context:
+    cluster: green
+    user: green
+current-context: blue
+users:
+- name: blue
+  user:
+    token: blue-token
+- name: green
+  user:
+    token: green-token
+`
+
+func Test_RestConfig_Warnings(t *testing.T) {
+	t.Run("should forward warnings to the handler by default", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler()
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, handler.warnings, cfg.WarningHandler)
+	})
+
+	t.Run("should discard warnings when suppressed", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler(WithSuppressedWarnings(true)).restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, rest.NoWarnings{}, cfg.WarningHandler)
+	})
+}
+
+func Test_RestConfig_WrapTransport(t *testing.T) {
+	t.Run("should pass every request through the wrapper", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(fakeAPIServerHandler("deprecated"))
+		t.Cleanup(server.Close)
+		var paths []string
+		handler := NewDefaultOryFinalizersHandler(WithSuppressedWarnings(true),
+			WithWrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					paths = append(paths, req.URL.Path)
+					return rt.RoundTrip(req)
+				})
+			}))
+		t.Cleanup(handler.Close)
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		require.Contains(t, paths, "/apis/hydra.ory.sh/v1alpha1/oauth2clients")
+		require.Empty(t, result.Warnings)
+	})
+
+	t.Run("should chain the wrapper behind the wrapper of the config", func(t *testing.T) {
+		// given
+		var calls []string
+		wrapper := func(name string) transport.WrapperFunc {
+			return func(rt http.RoundTripper) http.RoundTripper {
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					calls = append(calls, name)
+					return rt.RoundTrip(req)
+				})
+			}
+		}
+		handler := NewDefaultOryFinalizersHandler(WithWrapTransport(wrapper("option")), WithSuppressedWarnings(true))
+		handler.config = &rest.Config{Host: "https://apiserver", WrapTransport: wrapper("config")}
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), "")
+		require.NoError(t, err)
+		_, err = roundTrip(cfg.WrapTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+		})))
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{"option", "config"}, calls)
+		require.Equal(t, rest.NoWarnings{}, cfg.WarningHandler)
+	})
+}
+
+func Test_RestConfig_ContentType(t *testing.T) {
+	t.Run("should negotiate protobuf by default", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, k8sruntime.ContentTypeProtobuf, cfg.ContentType)
+		require.Equal(t, "application/vnd.kubernetes.protobuf,application/json", cfg.AcceptContentTypes)
+	})
+
+	t.Run("should keep the typed clients on JSON", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler(WithContentType(k8sruntime.ContentTypeJSON)).restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, k8sruntime.ContentTypeJSON, cfg.ContentType)
+		require.Equal(t, k8sruntime.ContentTypeJSON, cfg.AcceptContentTypes)
+	})
+
+	t.Run("should reject an unsupported content type", func(t *testing.T) {
+		// when
+		_, err := NewDefaultOryFinalizersHandler(WithContentType("application/yaml")).restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.EqualError(t, err, `unsupported content type "application/yaml", expected "application/vnd.kubernetes.protobuf" or "application/json"`)
+	})
+
+	t.Run("should keep the dynamic client on JSON", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(fakeAPIServerHandler(""))
+		t.Cleanup(server.Close)
+		accepted := map[string]string{}
+		handler := NewDefaultOryFinalizersHandler(WithWrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				accepted[req.URL.Path] = req.Header.Get("Accept")
+				return rt.RoundTrip(req)
+			})
+		}))
+		t.Cleanup(handler.Close)
+
+		// when
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+		require.NoError(t, err)
+		_, err = handler.dynamic.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+
+		// then
+		require.True(t, apierr.IsNotFound(err))
+		require.Equal(t, "application/vnd.kubernetes.protobuf,application/json",
+			accepted["/apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions/"+oauth2ClientCRD])
+		require.Equal(t, "application/json", accepted["/apis/hydra.ory.sh/v1alpha1/namespaces/default/oauth2clients/client"])
+	})
+}
+
+func Test_RestConfig_Timeouts(t *testing.T) {
+	t.Run("should set default request timeout", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 30*time.Second, cfg.Timeout)
+		require.Nil(t, cfg.Dial)
+	})
+
+	t.Run("should disable request timeout when set to zero", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler(WithRequestTimeout(0)).restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, cfg.Timeout)
+	})
+
+	t.Run("should set dial timeout", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler(WithDialTimeout(time.Second)).restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.NotNil(t, cfg.Dial)
+	})
+
+	t.Run("should fail requests to a hung API server within the request timeout", func(t *testing.T) {
+		// given
+		server := newHungAPIServer(t)
+		handler := NewDefaultOryFinalizersHandler(WithRequestTimeout(200 * time.Millisecond))
+		defer handler.Close()
+
+		// when
+		start := time.Now()
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.Error(t, err)
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+}
+
+// newHungAPIServer accepts requests but never responds until the test ends
+func newHungAPIServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	return server
+}
+
+func Test_RestConfig_Context(t *testing.T) {
+	t.Run("should use current context when no context is selected", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), testMultiContextKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://blue.example.com", cfg.Host)
+		require.Equal(t, "blue-token", cfg.BearerToken)
+	})
+
+	t.Run("should use selected context", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigContext("green"))
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), testMultiContextKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://green.example.com", cfg.Host)
+		require.Equal(t, "green-token", cfg.BearerToken)
+		require.IsType(t, &warningRouter{}, cfg.WarningHandler)
+	})
+
+	t.Run("should list available contexts when selected context does not exist", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigContext("red"))
+
+		// when
+		_, err := handler.restConfig(context.Background(), testMultiContextKubeconfig)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `context "red" not found`)
+		require.Contains(t, err.Error(), "[blue, green]")
+	})
+
+	t.Run("should use selected cluster with the credentials of the context", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigCluster("green"))
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), testMultiContextKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://green.example.com", cfg.Host)
+		require.Equal(t, "blue-token", cfg.BearerToken)
+	})
+
+	t.Run("should list available clusters when selected cluster does not exist", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigContext("green"), WithKubeconfigCluster("red"))
+
+		// when
+		_, err := handler.restConfig(context.Background(), testMultiContextKubeconfig)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `cluster "red" not found`)
+		require.Contains(t, err.Error(), "[blue, green]")
+	})
+}
+
+func Test_RestConfig_ExecCredential(t *testing.T) {
+	t.Run("should return typed error naming the missing plugin, cluster and context", func(t *testing.T) {
+		// given
+		kubeconfig := fixExecKubeconfig("ory-test-missing-plugin")
+
+		// when
+		_, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), kubeconfig)
+
+		// then
+		require.True(t, IsExecCredentialUnavailableError(err))
+		var execErr *ExecCredentialUnavailableError
+		require.True(t, errors.As(err, &execErr))
+		require.Equal(t, "ory-test-missing-plugin", execErr.Command)
+		require.Equal(t, "eks", execErr.Cluster)
+		require.Equal(t, "eks-admin", execErr.Context)
+		require.Contains(t, err.Error(), "install the plugin")
+		require.True(t, errors.Is(err, exec.ErrNotFound))
+	})
+
+	t.Run("should return typed error when the plugin exits non-zero", func(t *testing.T) {
+		// given
+		plugin := writeExecPlugin(t, "echo 'token expired' >&2\nexit 1")
+
+		// when
+		_, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), fixExecKubeconfig(plugin))
+
+		// then
+		require.True(t, IsExecCredentialUnavailableError(err))
+		require.Contains(t, err.Error(), "token expired")
+	})
+
+	t.Run("should pass env and exec info to the plugin", func(t *testing.T) {
+		// given
+		plugin := writeExecPlugin(t, `test "$PLUGIN_ENV" = "value" || exit 1
+case "$KUBERNETES_EXEC_INFO" in *'"kind":"ExecCredential"'*) ;; *) exit 2 ;; esac
+echo '{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"token":"t"}}'`)
+
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), fixExecKubeconfig(plugin))
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://eks.example.com", cfg.Host)
+		require.NotNil(t, cfg.ExecProvider)
+	})
+
+	t.Run("should run the plugin only once for the rebuilt clients of a kubeconfig", func(t *testing.T) {
+		// given
+		runs := filepath.Join(t.TempDir(), "runs")
+		plugin := writeExecPlugin(t, `echo run >> `+runs+`
+echo '{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"token":"t"}}'`)
+		handler := NewDefaultOryFinalizersHandler()
+		defer handler.Close()
+		require.NoError(t, handler.initClients(context.Background(), fixExecKubeconfig(plugin)))
+
+		// when
+		_, err := handler.rebuildClients(context.Background())
+		require.NoError(t, err)
+		_, err = handler.rebuildClients(context.Background())
+
+		// then
+		require.NoError(t, err)
+		data, err := os.ReadFile(runs)
+		require.NoError(t, err)
+		require.Equal(t, "run\n", string(data))
+	})
+
+	t.Run("should stop probing the plugin when the context is cancelled", func(t *testing.T) {
+		// given
+		plugin := writeExecPlugin(t, "sleep 30")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		_, err := NewDefaultOryFinalizersHandler().restConfig(ctx, fixExecKubeconfig(plugin))
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, IsExecCredentialUnavailableError(err))
+	})
+}
+
+func fixExecKubeconfig(command string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: eks
+  cluster:
+    server: https://eks.example.com
+contexts:
+- name: eks-admin
+  context:
+    cluster: eks
+    user: eks-user
+current-context: eks-admin
+users:
+- name: eks-user
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: %s
+      env:
+      - name: PLUGIN_ENV
+        value: value
+      installHint: install the plugin into the image
+      interactiveMode: Never
+`, command)
+}
+
+func writeExecPlugin(t *testing.T, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("exec plugin scripts require a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "plugin.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700))
+	return path
+}
+
+func Test_RestConfig_UserAgent(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{
+			name:     "should send default user agent",
+			expected: "kyma-reconciler/ory-cleanup/" + Version,
+		},
+		{
+			name:     "should append suffix to default user agent",
+			opts:     []Option{WithUserAgentSuffix("reconciliation/1234")},
+			expected: "kyma-reconciler/ory-cleanup/" + Version + " reconciliation/1234",
+		},
+		{
+			name:     "should send overridden user agent with suffix",
+			opts:     []Option{WithUserAgent("custom/1.0"), WithUserAgentSuffix("reconciliation/1234")},
+			expected: "custom/1.0 reconciliation/1234",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			var mu sync.Mutex
+			userAgents := map[string]bool{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				userAgents[r.Header.Get("User-Agent")] = true
+				mu.Unlock()
+				fakeAPIServerHandler("")(w, r)
+			}))
+			defer server.Close()
+			handler := NewDefaultOryFinalizersHandler(tt.opts...)
+			defer handler.Close()
+
+			// when
+			_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+			// then
+			require.NoError(t, err)
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, map[string]bool{tt.expected: true}, userAgents)
+		})
+	}
+}
+
+func Test_RestConfig_CABundle(t *testing.T) {
+	server := httptest.NewUnstartedServer(fakeAPIServerHandler(""))
+	// rejected handshakes are expected and would only clutter the test output
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	staleCA, _, err := certutil.GenerateSelfSignedCertKey("stale.example.com", nil, nil)
+	require.NoError(t, err)
+	kubeconfig := fixKubeconfigWithCA(server.URL, staleCA)
+
+	t.Run("should fail to connect with the stale CA of the kubeconfig", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithListAttempts(1))
+		defer handler.Close()
+
+		// when
+		_, err := handler.FindAndDeleteOryFinalizers(kubeconfig)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "x509")
+	})
+
+	t.Run("should connect with the overridden CA", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithCABundle(serverCA))
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(kubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+	})
+
+	t.Run("should verify the certificate against the overridden server name", func(t *testing.T) {
+		// given
+		valid := NewDefaultOryFinalizersHandler(WithCABundle(serverCA), WithTLSServerName("example.com"))
+		defer valid.Close()
+		invalid := NewDefaultOryFinalizersHandler(WithCABundle(serverCA), WithTLSServerName("other.test"), WithListAttempts(1))
+		defer invalid.Close()
+
+		// when
+		_, validErr := valid.FindAndDeleteOryFinalizers(kubeconfig)
+		_, invalidErr := invalid.FindAndDeleteOryFinalizers(kubeconfig)
+
+		// then
+		require.NoError(t, validErr)
+		require.Error(t, invalidErr)
+		require.Contains(t, invalidErr.Error(), "other.test")
+	})
+
+	t.Run("should reject a CA bundle which is not PEM encoded", func(t *testing.T) {
+		// when
+		_, err := NewDefaultOryFinalizersHandler(WithCABundle([]byte("not a certificate"))).restConfig(context.Background(), kubeconfig)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "CA bundle override")
+	})
+}
+
+func fixKubeconfigWithCA(server string, caPEM []byte) string {
+	return strings.Replace(fixKubeconfig(server), "    server: "+server,
+		"    server: "+server+"\n    certificate-authority-data: "+base64.StdEncoding.EncodeToString(caPEM), 1)
+}
+
+func Test_RestConfig_Impersonation(t *testing.T) {
+	t.Run("should not impersonate by default", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, rest.ImpersonationConfig{}, cfg.Impersonate)
+	})
+
+	t.Run("should impersonate user with uid and multiple groups", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithImpersonation(rest.ImpersonationConfig{
+			UserName: "system:serviceaccount:kyma-system:ory-cleanup",
+			UID:      "3c5e2a4f-1b1d-4e43-8a0e-2c6f6a1d9b7e",
+			Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:kyma-system", "system:authenticated"},
+		}))
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "system:serviceaccount:kyma-system:ory-cleanup", cfg.Impersonate.UserName)
+		require.Equal(t, "3c5e2a4f-1b1d-4e43-8a0e-2c6f6a1d9b7e", cfg.Impersonate.UID)
+		require.Equal(t, []string{"system:serviceaccounts", "system:serviceaccounts:kyma-system", "system:authenticated"}, cfg.Impersonate.Groups)
+	})
+
+	t.Run("should reject impersonation without user name", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithImpersonation(rest.ImpersonationConfig{
+			UID:    "3c5e2a4f-1b1d-4e43-8a0e-2c6f6a1d9b7e",
+			Groups: []string{"system:serviceaccounts"},
+		}))
+
+		// when
+		_, err := handler.restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no user name")
+	})
+
+	t.Run("should reject impersonation with empty group name", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithImpersonation(rest.ImpersonationConfig{
+			UserName: "ory-cleanup",
+			Groups:   []string{"system:serviceaccounts", ""},
+		}))
+
+		// when
+		_, err := handler.restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "empty group names")
+	})
+
+	t.Run("should not share extra fields with the caller", func(t *testing.T) {
+		// given
+		extra := map[string][]string{"reason": {"cleanup"}}
+		handler := NewDefaultOryFinalizersHandler(WithImpersonation(rest.ImpersonationConfig{UserName: "ory-cleanup", Extra: extra}))
+
+		// when
+		extra["reason"][0] = "changed"
+		cfg, err := handler.restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, map[string][]string{"reason": {"cleanup"}}, cfg.Impersonate.Extra)
+	})
+
+	t.Run("should send impersonation headers with all requests", func(t *testing.T) {
+		// given
+		var mu sync.Mutex
+		var headers []http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			headers = append(headers, r.Header.Clone())
+			mu.Unlock()
+			fakeAPIServerHandler("")(w, r)
+		}))
+		defer server.Close()
+		handler := NewDefaultOryFinalizersHandler(WithImpersonation(rest.ImpersonationConfig{
+			UserName: "ory-cleanup",
+			Groups:   []string{"cleanup", "system:authenticated"},
+			Extra:    map[string][]string{"reason": {"post-delete"}},
+		}))
+		defer handler.Close()
+
+		// when
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		// the discovery of the apiextensions version, the lookup of the crd and the listing of its instances
+		require.Len(t, headers, 3)
+		for _, header := range headers {
+			require.Equal(t, "ory-cleanup", header.Get("Impersonate-User"))
+			require.Equal(t, []string{"cleanup", "system:authenticated"}, header.Values("Impersonate-Group"))
+			require.Equal(t, "post-delete", header.Get("Impersonate-Extra-Reason"))
+		}
+	})
+
+	t.Run("should report the impersonated identity when impersonating is forbidden", func(t *testing.T) {
+		// given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"Forbidden","code":403,` +
+				`"message":"users \"ory-cleanup\" is forbidden: User \"admin\" cannot impersonate resource \"users\" in API group \"\" at the cluster scope",` +
+				`"details":{"name":"ory-cleanup","kind":"users"}}`))
+		}))
+		defer server.Close()
+		handler := NewDefaultOryFinalizersHandler(WithImpersonation(rest.ImpersonationConfig{
+			UserName: "ory-cleanup",
+			Groups:   []string{"cleanup"},
+		}))
+		defer handler.Close()
+
+		// when
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.True(t, IsImpersonationForbiddenError(err))
+		require.False(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), `impersonate user "ory-cleanup"`)
+		require.Contains(t, err.Error(), "[cleanup]")
+	})
+}
+
+func Test_InitClients(t *testing.T) {
+	t.Run("should reuse clients for the same kubeconfig", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler()
+		require.NoError(t, handler.initClients(context.Background(), testKubeconfig))
+		provider, apixClient, dynamicClient := handler.builtProvider, handler.apixClient, handler.dynamic
+
+		// when
+		err := handler.initClients(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Same(t, provider, handler.builtProvider)
+		require.Equal(t, apixClient, handler.apixClient)
+		require.Equal(t, dynamicClient, handler.dynamic)
+	})
+
+	t.Run("should rebuild clients for a different kubeconfig of the same cluster", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler()
+		require.NoError(t, handler.initClients(context.Background(), testKubeconfig))
+		provider := handler.builtProvider
+
+		// when
+		err := handler.initClients(context.Background(), strings.Replace(testKubeconfig, "secret-token", "renewed-token", 1))
+
+		// then
+		require.NoError(t, err)
+		require.NotSame(t, provider, handler.builtProvider)
+	})
+
+	t.Run("should keep the clients of the cluster until they are closed", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler()
+		require.NoError(t, handler.initClients(context.Background(), testKubeconfig))
+		provider := handler.builtProvider
+
+		// when
+		err := handler.initClients(context.Background(), testMultiContextKubeconfig)
+
+		// then
+		require.True(t, IsClusterChangedError(err))
+		require.EqualError(t, err, `the handler holds the clients of cluster "https://test.example.com", `+
+			`close it before passing the kubeconfig of cluster "https://blue.example.com"`)
+		require.Same(t, provider, handler.builtProvider)
+		handler.Close()
+		require.NoError(t, handler.initClients(context.Background(), testMultiContextKubeconfig))
+		require.Equal(t, "https://blue.example.com", handler.clusterHost)
+	})
+
+	t.Run("should release clients on close and rebuild them afterwards", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler()
+		require.NoError(t, handler.initClients(context.Background(), testKubeconfig))
+		provider := handler.builtProvider
+
+		// when
+		handler.Close()
+
+		// then
+		require.Nil(t, handler.builtProvider)
+		require.Nil(t, handler.apixClient)
+		require.Nil(t, handler.dynamic)
+		require.NoError(t, handler.initClients(context.Background(), testKubeconfig))
+		require.NotSame(t, provider, handler.builtProvider)
+	})
+
+	t.Run("should tolerate close without clients", func(t *testing.T) {
+		NewDefaultOryFinalizersHandler().Close()
+	})
+}
+
+func Test_NewOryFinalizersHandlerForConfig(t *testing.T) {
+	t.Run("should reject nil config", func(t *testing.T) {
+		// when
+		handler, err := NewOryFinalizersHandlerForConfig(nil)
+
+		// then
+		require.Error(t, err)
+		require.Nil(t, handler)
+	})
+
+	t.Run("should run cleanup against the cluster of the config", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, deprecationWarning)
+		handler, err := NewOryFinalizersHandlerForConfig(&rest.Config{Host: server.URL})
+		require.NoError(t, err)
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers("")
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		require.Equal(t, []string{deprecationWarning}, result.Warnings)
+	})
+
+	t.Run("should not modify the passed config", func(t *testing.T) {
+		// given
+		cfg := &rest.Config{Host: "https://test.example.com", BearerToken: "secret-token"}
+
+		// when
+		handler, err := NewOryFinalizersHandlerForConfig(cfg, WithRequestTimeout(time.Second),
+			WithImpersonation(rest.ImpersonationConfig{UserName: "admin"}))
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, &rest.Config{Host: "https://test.example.com", BearerToken: "secret-token"}, cfg)
+		adjusted, err := handler.restConfig(context.Background(), "")
+		require.NoError(t, err)
+		require.Equal(t, time.Second, adjusted.Timeout)
+		require.Equal(t, "admin", adjusted.Impersonate.UserName)
+		require.Zero(t, handler.config.Timeout)
+	})
+
+	t.Run("should not be affected by later changes of the passed config", func(t *testing.T) {
+		// given
+		cfg := &rest.Config{Host: "https://test.example.com"}
+		handler, err := NewOryFinalizersHandlerForConfig(cfg)
+		require.NoError(t, err)
+
+		// when
+		cfg.Host = "https://other.example.com"
+
+		// then
+		adjusted, err := handler.restConfig(context.Background(), "")
+		require.NoError(t, err)
+		require.Equal(t, "https://test.example.com", adjusted.Host)
+	})
+
+	t.Run("should prefer an explicitly passed kubeconfig", func(t *testing.T) {
+		// given
+		handler, err := NewOryFinalizersHandlerForConfig(&rest.Config{Host: "https://config.example.com"})
+		require.NoError(t, err)
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://test.example.com", cfg.Host)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_Unauthorized(t *testing.T) {
+	unauthorized := `{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"Unauthorized","code":401}`
+
+	t.Run("should rebuild clients and retry once when credentials expired", func(t *testing.T) {
+		// given
+		var mu sync.Mutex
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests++
+			first := requests == 1
+			mu.Unlock()
+			if first {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(unauthorized))
+				return
+			}
+			fakeAPIServerHandler("")(w, r)
+		}))
+		defer server.Close()
+		handler := NewDefaultOryFinalizersHandler()
+		defer handler.Close()
+		kubeconfig := fixKubeconfig(server.URL)
+		require.NoError(t, handler.initClients(context.Background(), kubeconfig))
+		provider := handler.builtProvider
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(kubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		require.NotSame(t, provider, handler.builtProvider)
+	})
+
+	t.Run("should return a single credentials error when credentials are still rejected", func(t *testing.T) {
+		// given
+		var mu sync.Mutex
+		instanceRequests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case r.URL.Path == "/apis/hydra.ory.sh/v1alpha1/oauth2clients":
+				_, _ = w.Write([]byte(`{"apiVersion":"hydra.ory.sh/v1alpha1","kind":"OAuth2ClientList","metadata":{},"items":[` +
+					`{"apiVersion":"hydra.ory.sh/v1alpha1","kind":"OAuth2Client","metadata":{"namespace":"default","name":"client1"}},` +
+					`{"apiVersion":"hydra.ory.sh/v1alpha1","kind":"OAuth2Client","metadata":{"namespace":"default","name":"client2"}},` +
+					`{"apiVersion":"hydra.ory.sh/v1alpha1","kind":"OAuth2Client","metadata":{"namespace":"default","name":"client3"}}]}`))
+			case strings.HasPrefix(r.URL.Path, "/apis/hydra.ory.sh/v1alpha1/namespaces/"):
+				mu.Lock()
+				instanceRequests++
+				mu.Unlock()
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(unauthorized))
+			default:
+				fakeAPIServerHandler("")(w, r)
+			}
+		}))
+		defer server.Close()
+		handler := NewDefaultOryFinalizersHandler()
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.True(t, IsCredentialsRejectedError(err))
+		require.Empty(t, result.Failures())
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, 2, instanceRequests)
+	})
+
+	t.Run("should keep concurrent runs working while the clients are rebuilt", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, "")
+		handler := NewDefaultOryFinalizersHandler()
+		defer handler.Close()
+		kubeconfig := fixKubeconfig(server.URL)
+		require.NoError(t, handler.initClients(context.Background(), kubeconfig))
+
+		// when
+		var wg sync.WaitGroup
+		errs := make([]error, 6)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				switch i % 3 {
+				case 0:
+					_, errs[i] = handler.FindAndDeleteOryFinalizers(kubeconfig)
+				case 1:
+					errs[i] = handler.WaitForCRDEstablished(context.Background(), oauth2ClientCRD, time.Minute)
+				default:
+					_, errs[i] = handler.rebuildClients(context.Background())
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// then
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("should not retry with clients which were not built by the handler", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient())
+		calls := 0
+
+		// when
+		err := handler.retryOnUnauthorized(context.Background(), func() error {
+			calls++
+			return apierr.NewUnauthorized("token expired")
+		})
+
+		// then
+		require.True(t, IsCredentialsRejectedError(err))
+		require.Equal(t, 1, calls)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_RateLimiter(t *testing.T) {
+	t.Run("should share the rate limiter between concurrent handlers", func(t *testing.T) {
+		// given
+		var requests int32
+		newServer := func() *httptest.Server {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				fakeAPIServerHandler("")(w, r)
+			}))
+			t.Cleanup(server.Close)
+			return server
+		}
+		limiter := &countingRateLimiter{RateLimiter: flowcontrol.NewFakeAlwaysRateLimiter()}
+		servers := []*httptest.Server{newServer(), newServer()}
+
+		// when
+		var wg sync.WaitGroup
+		errs := make([]error, len(servers))
+		for i, server := range servers {
+			wg.Add(1)
+			go func(i int, server *httptest.Server) {
+				defer wg.Done()
+				handler := NewDefaultOryFinalizersHandler(WithRateLimiter(limiter))
+				defer handler.Close()
+				_, errs[i] = handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+			}(i, server)
+		}
+		wg.Wait()
+
+		// then
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		// each handler discovers the apiextensions version, looks up the crd and lists its instances
+		require.Equal(t, int32(6), atomic.LoadInt32(&requests))
+		require.Equal(t, atomic.LoadInt32(&requests), atomic.LoadInt32(&limiter.waits))
+	})
+
+	t.Run("should keep the limiter per client by default", func(t *testing.T) {
+		// when
+		cfg, err := NewDefaultOryFinalizersHandler().restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Nil(t, cfg.RateLimiter)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_SweepLock(t *testing.T) {
+	t.Run("should fail while another run against the same cluster is in progress", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, "")
+		release, err := clusterSweepLocks.acquire(server.URL, false)
+		require.NoError(t, err)
+		defer release()
+		handler := NewDefaultOryFinalizersHandler(WithSweepLock(SweepLockFail))
+		defer handler.Close()
+
+		// when
+		_, err = handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.ErrorIs(t, err, ErrSweepInProgress)
+	})
+
+	t.Run("should wait for another run against the same cluster", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, "")
+		release, err := clusterSweepLocks.acquire(server.URL, false)
+		require.NoError(t, err)
+		handler := NewDefaultOryFinalizersHandler(WithSweepLock(SweepLockWait))
+		defer handler.Close()
+
+		// when
+		done := make(chan error, 1)
+		go func() {
+			_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+			done <- err
+		}()
+
+		// then
+		require.Never(t, func() bool { return len(done) > 0 }, 100*time.Millisecond, 10*time.Millisecond)
+		release()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("should not block runs against other clusters", func(t *testing.T) {
+		// given
+		release, err := clusterSweepLocks.acquire(newFakeAPIServer(t, "").URL, false)
+		require.NoError(t, err)
+		defer release()
+		server := newFakeAPIServer(t, "")
+		handler := NewDefaultOryFinalizersHandler(WithSweepLock(SweepLockFail))
+		defer handler.Close()
+
+		// when
+		_, err = handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("should release the lock after a run", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, "")
+		handler := NewDefaultOryFinalizersHandler(WithSweepLock(SweepLockFail))
+		defer handler.Close()
+		_, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+		require.NoError(t, err)
+
+		// when
+		_, err = handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("should not lock runs with a provider which does not identify its cluster", func(t *testing.T) {
+		// given
+		release, err := clusterSweepLocks.acquire("", false)
+		require.NoError(t, err)
+		defer release()
+		provider := newFakeClientProvider(apixfake.NewSimpleClientset(fixOAuth2ClientCRD()),
+			newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"))))
+		handler := NewDefaultOryFinalizersHandler(WithClientProvider(provider), WithSweepLock(SweepLockFail))
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers("")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+	})
+
+	t.Run("should run concurrently without a lock", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, "")
+		release, err := clusterSweepLocks.acquire(server.URL, false)
+		require.NoError(t, err)
+		defer release()
+		handler := NewDefaultOryFinalizersHandler()
+		defer handler.Close()
+
+		// when
+		_, err = handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+	})
+}
+
+// countingRateLimiter counts the requests which waited for the limiter
+type countingRateLimiter struct {
+	flowcontrol.RateLimiter
+	waits int32
+}
+
+func (l *countingRateLimiter) Wait(ctx context.Context) error {
+	atomic.AddInt32(&l.waits, 1)
+	return l.RateLimiter.Wait(ctx)
+}
+
+// fixServiceAccount stubs the in-cluster environment by setting the service env variables and
+// loading the service account token from a temporary directory. It returns the path of the token file.
+func fixServiceAccount(t *testing.T, host, port string) string {
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("in-cluster-token"), 0600))
+
+	original := inClusterConfig
+	inClusterConfig = func() (*rest.Config, error) {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, rest.ErrNotInCluster
+		}
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return &rest.Config{Host: "https://" + host + ":" + port, BearerToken: string(token), BearerTokenFile: tokenFile}, nil
+	}
+	t.Cleanup(func() { inClusterConfig = original })
+	return tokenFile
+}
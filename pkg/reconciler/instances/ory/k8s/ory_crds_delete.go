@@ -0,0 +1,49 @@
+package k8s
+
+import (
+	"context"
+
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// deleteCRDIfEmpty deletes the custom resource definition if it has no instances left. The instances are listed again
+// right before, and the deletion is conditional on the UID, so that neither live data nor a recreated definition is deleted.
+func (h *DefaultOryFinalizersHandler) deleteCRDIfEmpty(ctx context.Context, crd *apixv1beta1.CustomResourceDefinition,
+	crdef schema.GroupVersionResource) (bool, error) {
+	var remaining *unstructured.UnstructuredList
+	err := h.retryOnUnauthorized(ctx, func() (err error) {
+		remaining, err = h.currentClients().dynamic.Resource(crdef).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{Limit: 1})
+		return h.checkForbidden(err, "list", crdef.GroupResource(), metav1.NamespaceAll)
+	})
+	if err != nil && !apierr.IsNotFound(err) {
+		return false, err
+	}
+	if remaining != nil && len(remaining.Items) > 0 {
+		h.logger.Infof("Keeping crd \"%s\" as instances of it are left", crd.Name)
+		return false, nil
+	}
+
+	err = h.retryOnUnauthorized(ctx, func() error {
+		crds, err := h.crds(ctx)
+		if err != nil {
+			return err
+		}
+		err = crds.Delete(ctx, crd.Name, metav1.DeleteOptions{
+			Preconditions:     &metav1.Preconditions{UID: &crd.UID},
+			PropagationPolicy: &h.propagationPolicy,
+		})
+		return h.checkForbidden(err, "delete", crdResource, "")
+	})
+	if apierr.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	h.logger.Infof("Deleted crd \"%s\" without instances", crd.Name)
+	return true, nil
+}
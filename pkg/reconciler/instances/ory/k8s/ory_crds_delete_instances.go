@@ -0,0 +1,121 @@
+package k8s
+
+import (
+	"context"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DeleteInstancesOptions configures DeleteAllInstancesOf
+type DeleteInstancesOptions struct {
+	// PropagationPolicy decides how the dependents of the instances are deleted, the policy of WithPropagationPolicy
+	// if nil
+	PropagationPolicy *metav1.DeletionPropagation
+	// GracePeriodSeconds overrides the grace period of the instances if set
+	GracePeriodSeconds *int64
+	// LabelSelector restricts the deletion to the matching instances
+	LabelSelector string
+	// Collection deletes the instances with a single DeleteCollection request. Resources which do not support it
+	// are deleted object by object.
+	Collection bool
+}
+
+// DeleteInstancesResult is the outcome of DeleteAllInstancesOf
+type DeleteInstancesResult struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	// Deleted is the number of instances whose deletion was accepted. Instances carrying finalizers remain until
+	// these are removed.
+	Deleted int
+	// NotFound is the number of listed instances which were gone, or replaced by a new object of the same name,
+	// before their deletion
+	NotFound int
+	// CollectionDeleted is set if the instances were deleted with a single DeleteCollection request
+	CollectionDeleted bool
+	Failures          []ResourceFailure
+}
+
+// DeleteAllInstancesOf deletes all instances of the resource in the namespace, all namespaces if empty. It uses the
+// cluster of the last call of the handler, or otherwise the configuration the handler was created for, so that
+// FindAndDeleteOryFinalizers followed by DeleteAllInstancesOf strips the finalizers and deletes the instances.
+// Instances are deleted on the condition of their listed UID, a recreated object of the same name is kept. Failures
+// of single instances are collected in the result, only the failure to list or of a DeleteCollection request is
+// returned as error.
+func (h *DefaultOryFinalizersHandler) DeleteAllInstancesOf(ctx context.Context, gvr schema.GroupVersionResource, namespace string,
+	opts DeleteInstancesOptions) (*DeleteInstancesResult, error) {
+	if err := h.ensureClients(ctx); err != nil {
+		return nil, err
+	}
+	resources := h.currentClients().dynamic.Resource(gvr).Namespace(namespace)
+	result := &DeleteInstancesResult{GVR: gvr, Namespace: namespace}
+
+	var list *unstructured.UnstructuredList
+	err := h.retryOnTransientError(ctx, func() (err error) {
+		list, err = resources.List(ctx, metav1.ListOptions{LabelSelector: opts.LabelSelector})
+		return err
+	})
+	if apierr.IsNotFound(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, h.checkForbidden(err, "list", gvr.GroupResource(), namespace)
+	}
+	if len(list.Items) == 0 {
+		return result, nil
+	}
+
+	if opts.Collection {
+		err := h.retryOnTransientError(ctx, func() error {
+			return resources.DeleteCollection(ctx, h.deleteOptions(opts), metav1.ListOptions{LabelSelector: opts.LabelSelector})
+		})
+		if err == nil {
+			result.Deleted, result.CollectionDeleted = len(list.Items), true
+			h.logger.Infof("Deleted the collection of %d %s", result.Deleted, gvr.String())
+			return result, nil
+		}
+		if !apierr.IsMethodNotSupported(err) {
+			return result, h.checkForbidden(err, "deletecollection", gvr.GroupResource(), namespace)
+		}
+		h.logger.Debugf("%s does not support deleting collections, deleting its instances one by one", gvr.String())
+	}
+
+	for i := range list.Items {
+		instance := list.Items[i]
+		deleteOpts := h.deleteOptions(opts)
+		uid := instance.GetUID()
+		deleteOpts.Preconditions = &metav1.Preconditions{UID: &uid}
+		err := h.retryOnTransientError(ctx, func() error {
+			return h.currentClients().dynamic.Resource(gvr).Namespace(instance.GetNamespace()).Delete(ctx, instance.GetName(), deleteOpts)
+		})
+		switch {
+		case err == nil:
+			result.Deleted++
+		case apierr.IsNotFound(err) || apierr.IsConflict(err):
+			result.NotFound++
+		default:
+			failure := ResourceFailure{
+				GVR:             gvr,
+				Namespace:       instance.GetNamespace(),
+				Name:            instance.GetName(),
+				UID:             uid,
+				ResourceVersion: instance.GetResourceVersion(),
+				Err:             h.checkForbidden(err, "delete", gvr.GroupResource(), instance.GetNamespace()),
+			}
+			h.logger.Warnf("Failed to delete custom resource: %s", failure.Error())
+			result.Failures = append(result.Failures, failure)
+		}
+	}
+	h.logger.Infof("Deleted %d %s, %d were already gone and %d failed", result.Deleted, gvr.String(), result.NotFound, len(result.Failures))
+	return result, nil
+}
+
+func (h *DefaultOryFinalizersHandler) deleteOptions(opts DeleteInstancesOptions) metav1.DeleteOptions {
+	policy := h.propagationPolicy
+	if opts.PropagationPolicy != nil {
+		policy = *opts.PropagationPolicy
+	}
+	return metav1.DeleteOptions{PropagationPolicy: &policy, GracePeriodSeconds: opts.GracePeriodSeconds}
+}
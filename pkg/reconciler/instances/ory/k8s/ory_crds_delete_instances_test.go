@@ -0,0 +1,240 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_DeleteAllInstancesOf(t *testing.T) {
+	t.Run("should pass the propagation policy and grace period through", func(t *testing.T) {
+		for _, policy := range []metav1.DeletionPropagation{metav1.DeletePropagationForeground, metav1.DeletePropagationBackground} {
+			t.Run(string(policy), func(t *testing.T) {
+				// given
+				server, deletes := newDeletionServer(t, false, "client1", "client2")
+				handler, err := NewOryFinalizersHandlerForConfig(&rest.Config{Host: server.URL})
+				require.NoError(t, err)
+				gracePeriod := int64(0)
+
+				// when
+				result, err := handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "default",
+					DeleteInstancesOptions{PropagationPolicy: &policy, GracePeriodSeconds: &gracePeriod})
+
+				// then
+				require.NoError(t, err)
+				require.Equal(t, 2, result.Deleted)
+				require.Len(t, deletes(), 2)
+				for _, deletion := range deletes() {
+					require.Equal(t, policy, *deletion.opts.PropagationPolicy)
+					require.Equal(t, int64(0), *deletion.opts.GracePeriodSeconds)
+				}
+			})
+		}
+	})
+
+	t.Run("should delete on the condition of the listed uid", func(t *testing.T) {
+		// given
+		server, deletes := newDeletionServer(t, false, "client")
+		handler, err := NewOryFinalizersHandlerForConfig(&rest.Config{Host: server.URL})
+		require.NoError(t, err)
+
+		// when
+		_, err = handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "default", DeleteInstancesOptions{})
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, deletes(), 1)
+		require.Equal(t, "/apis/hydra.ory.sh/v1alpha1/namespaces/default/oauth2clients/client", deletes()[0].path)
+		require.Equal(t, types.UID("uid-client"), *deletes()[0].opts.Preconditions.UID)
+		require.Equal(t, metav1.DeletePropagationBackground, *deletes()[0].opts.PropagationPolicy)
+	})
+
+	t.Run("should use the propagation policy of the handler without one of the call", func(t *testing.T) {
+		// given
+		server, deletes := newDeletionServer(t, false, "client")
+		handler, err := NewOryFinalizersHandlerForConfig(&rest.Config{Host: server.URL},
+			WithPropagationPolicy(metav1.DeletePropagationOrphan))
+		require.NoError(t, err)
+
+		// when
+		_, err = handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "default", DeleteInstancesOptions{})
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, deletes(), 1)
+		require.Equal(t, metav1.DeletePropagationOrphan, *deletes()[0].opts.PropagationPolicy)
+	})
+
+	t.Run("should continue past instances which are already gone and collect failures", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1"),
+			fixOAuth2Client("default", "client2"),
+			fixOAuth2Client("default", "client3")))
+		dyn.PrependReactor("delete", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			switch action.(k8stesting.DeleteAction).GetName() {
+			case "client1":
+				return true, nil, apierr.NewNotFound(oauth2ClientGVR.GroupResource(), "client1")
+			case "client2":
+				return true, nil, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "client2", errors.New("rbac"))
+			}
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		result, err := handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "default", DeleteInstancesOptions{})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Deleted)
+		require.Equal(t, 1, result.NotFound)
+		require.Len(t, result.Failures, 1)
+		require.Equal(t, "client2", result.Failures[0].Name)
+		require.True(t, IsMissingPermissionError(result.Failures[0]))
+	})
+
+	t.Run("should delete the collection with the options", func(t *testing.T) {
+		// given
+		server, deletes := newDeletionServer(t, true, "client1", "client2")
+		handler, err := NewOryFinalizersHandlerForConfig(&rest.Config{Host: server.URL})
+		require.NoError(t, err)
+		policy := metav1.DeletePropagationForeground
+
+		// when
+		result, err := handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "default",
+			DeleteInstancesOptions{PropagationPolicy: &policy, Collection: true, LabelSelector: "app=console"})
+
+		// then
+		require.NoError(t, err)
+		require.True(t, result.CollectionDeleted)
+		require.Equal(t, 2, result.Deleted)
+		require.Len(t, deletes(), 1)
+		require.Equal(t, "/apis/hydra.ory.sh/v1alpha1/namespaces/default/oauth2clients", deletes()[0].path)
+		require.Equal(t, "app=console", deletes()[0].labelSelector)
+		require.Equal(t, metav1.DeletePropagationForeground, *deletes()[0].opts.PropagationPolicy)
+	})
+
+	t.Run("should delete one by one if the resource does not support collections", func(t *testing.T) {
+		// given
+		server, deletes := newDeletionServer(t, false, "client1", "client2")
+		handler, err := NewOryFinalizersHandlerForConfig(&rest.Config{Host: server.URL})
+		require.NoError(t, err)
+		policy := metav1.DeletePropagationBackground
+
+		// when
+		result, err := handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "default",
+			DeleteInstancesOptions{PropagationPolicy: &policy, Collection: true})
+
+		// then
+		require.NoError(t, err)
+		require.False(t, result.CollectionDeleted)
+		require.Equal(t, 2, result.Deleted)
+		require.Len(t, deletes(), 2)
+		require.Equal(t, metav1.DeletePropagationBackground, *deletes()[1].opts.PropagationPolicy)
+	})
+
+	t.Run("should compose with the removal of the finalizers", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "hydra.ory.sh/finalizer")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+		require.NoError(t, err)
+		result, err := handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "", DeleteInstancesOptions{})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Deleted)
+	})
+
+	t.Run("should name the missing permission to list", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		failFirstCalls(dyn, "list", 1, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "", errors.New("rbac")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		_, err := handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "default", DeleteInstancesOptions{})
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+	})
+
+	t.Run("should succeed when the resource is not served", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		failFirstCalls(dyn, "list", 1, apierr.NewNotFound(oauth2ClientGVR.GroupResource(), ""))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		result, err := handler.DeleteAllInstancesOf(context.Background(), oauth2ClientGVR, "", DeleteInstancesOptions{})
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, result.Deleted)
+	})
+}
+
+type recordedDeletion struct {
+	path          string
+	labelSelector string
+	opts          metav1.DeleteOptions
+}
+
+// newDeletionServer serves oauth2clients of the given names in the namespace default, and records the deletions.
+// Collections can only be deleted if supported.
+func newDeletionServer(t *testing.T, collectionSupported bool, names ...string) (*httptest.Server, func() []recordedDeletion) {
+	var mu sync.Mutex
+	var deletes []recordedDeletion
+	collectionPath := "/apis/hydra.ory.sh/v1alpha1/namespaces/default/oauth2clients"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			var items []string
+			for _, name := range names {
+				items = append(items, fmt.Sprintf(`{"apiVersion":"hydra.ory.sh/v1alpha1","kind":"OAuth2Client",`+
+					`"metadata":{"namespace":"default","name":"%s","uid":"uid-%s"}}`, name, name))
+			}
+			_, _ = fmt.Fprintf(w, `{"apiVersion":"hydra.ory.sh/v1alpha1","kind":"OAuth2ClientList","metadata":{},"items":[%s]}`,
+				strings.Join(items, ","))
+			return
+		}
+		if r.URL.Path == collectionPath && !collectionSupported {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"MethodNotAllowed","code":405}`))
+			return
+		}
+		deletion := recordedDeletion{path: r.URL.Path, labelSelector: r.URL.Query().Get("labelSelector")}
+		if err := json.NewDecoder(r.Body).Decode(&deletion.opts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		deletes = append(deletes, deletion)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Status","status":"Success"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []recordedDeletion {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedDeletion(nil), deletes...)
+	}
+}
@@ -0,0 +1,107 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/kyma-incubator/reconciler/pkg/test"
+	"github.com/stretchr/testify/require"
+	apixv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// Test_FindAndDeleteOryFinalizers_Envtest runs the handler against the API server of envtest, which unlike the fake
+// clients completes the deletion of terminating resources once their last finalizer is removed. It requires the
+// envtest binaries, see KUBEBUILDER_ASSETS, and RECONCILER_INTEGRATION_TESTS to be set.
+func Test_FindAndDeleteOryFinalizers_Envtest(t *testing.T) {
+	test.IntegrationTest(t)
+	cfg := startEnvtest(t)
+	dyn, err := dynamic.NewForConfig(cfg)
+	require.NoError(t, err)
+	clients := dyn.Resource(oauth2ClientGVR).Namespace("default")
+
+	t.Run("should let the API server delete terminating resources", func(t *testing.T) {
+		// given
+		for _, name := range []string{"terminating", "kept"} {
+			_, err := clients.Create(context.Background(), fixOAuth2Client("default", name, "finalizer.ory.hydra.sh"), metav1.CreateOptions{})
+			require.NoError(t, err)
+		}
+		_, err := clients.Create(context.Background(), fixOAuth2Client("default", "foreign", "example.com/keep"), metav1.CreateOptions{})
+		require.NoError(t, err)
+		for _, name := range []string{"terminating", "foreign"} {
+			require.NoError(t, clients.Delete(context.Background(), name, metav1.DeleteOptions{}))
+		}
+		handler, err := NewOryFinalizersHandlerForConfig(cfg, WithLogger(logger.NewTestLogger(t)))
+		require.NoError(t, err)
+		t.Cleanup(handler.Close)
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers("")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, result.RemovedFinalizers())
+		require.NoError(t, wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+			_, err := clients.Get(context.Background(), "terminating", metav1.GetOptions{})
+			return apierr.IsNotFound(err), nil
+		}))
+		kept, err := clients.Get(context.Background(), "kept", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Empty(t, kept.GetFinalizers())
+		require.Nil(t, kept.GetDeletionTimestamp())
+		foreign, err := clients.Get(context.Background(), "foreign", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"example.com/keep"}, foreign.GetFinalizers())
+		require.NotNil(t, foreign.GetDeletionTimestamp())
+	})
+}
+
+// startEnvtest starts an API server with the oauth2clients CRD installed and returns its rest configuration
+func startEnvtest(t *testing.T) *rest.Config {
+	env := &envtest.Environment{
+		CRDs:                  []*apixv1.CustomResourceDefinition{fixEnvtestOAuth2ClientCRD()},
+		ErrorIfCRDPathMissing: false,
+	}
+	cfg, err := env.Start()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, env.Stop())
+	})
+	return cfg
+}
+
+// fixEnvtestOAuth2ClientCRD is a minimal oauth2clients CRD, whose instances keep all fields
+func fixEnvtestOAuth2ClientCRD() *apixv1.CustomResourceDefinition {
+	preserveUnknownFields := true
+	return &apixv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: oauth2ClientCRD},
+		Spec: apixv1.CustomResourceDefinitionSpec{
+			Group: oauth2ClientGVR.Group,
+			Names: apixv1.CustomResourceDefinitionNames{
+				Plural:   oauth2ClientGVR.Resource,
+				Singular: "oauth2client",
+				Kind:     "OAuth2Client",
+				ListKind: "OAuth2ClientList",
+			},
+			Scope: apixv1.NamespaceScoped,
+			Versions: []apixv1.CustomResourceDefinitionVersion{{
+				Name:    oauth2ClientGVR.Version,
+				Served:  true,
+				Storage: true,
+				Schema: &apixv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apixv1.JSONSchemaProps{
+						Type:                   "object",
+						XPreserveUnknownFields: &preserveUnknownFields,
+					},
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,329 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// NoClusterConfigError is returned when no kubeconfig was passed and no in-cluster configuration is available
+type NoClusterConfigError struct {
+	err error
+}
+
+func (e *NoClusterConfigError) Error() string {
+	return fmt.Sprintf("no kubeconfig provided and in-cluster configuration is not available: %v", e.err)
+}
+
+func (e *NoClusterConfigError) Unwrap() error {
+	return e.err
+}
+
+func IsNoClusterConfigError(err error) bool {
+	var target *NoClusterConfigError
+	return errors.As(err, &target)
+}
+
+// ClusterChangedError is returned when a handler caching the clients of one cluster is passed the kubeconfig of
+// another cluster. Concurrent calls may still use the cached clients, so they are only replaced after Close.
+type ClusterChangedError struct {
+	Cached    string
+	Requested string
+}
+
+func (e *ClusterChangedError) Error() string {
+	return fmt.Sprintf("the handler holds the clients of cluster \"%s\", close it before passing the kubeconfig of cluster \"%s\"",
+		e.Cached, e.Requested)
+}
+
+func IsClusterChangedError(err error) bool {
+	var target *ClusterChangedError
+	return errors.As(err, &target)
+}
+
+// ExecCredentialUnavailableError is returned when the kubeconfig authenticates with an exec credential plugin
+// which is not installed or fails to provide a credential
+type ExecCredentialUnavailableError struct {
+	Command     string
+	Cluster     string
+	Context     string
+	InstallHint string
+	err         error
+}
+
+func (e *ExecCredentialUnavailableError) Error() string {
+	msg := fmt.Sprintf("exec credential plugin \"%s\" of context \"%s\" (cluster \"%s\") is unavailable: %v",
+		e.Command, e.Context, e.Cluster, e.err)
+	if e.InstallHint != "" {
+		msg += ": " + e.InstallHint
+	}
+	return msg
+}
+
+func (e *ExecCredentialUnavailableError) Unwrap() error {
+	return e.err
+}
+
+func IsExecCredentialUnavailableError(err error) bool {
+	var target *ExecCredentialUnavailableError
+	return errors.As(err, &target)
+}
+
+// CRDNotFoundError is returned if the custom resource definition does not exist and WithRequireCRD was set
+type CRDNotFoundError struct {
+	Name string
+	err  error
+}
+
+func (e *CRDNotFoundError) Error() string {
+	return fmt.Sprintf("custom resource definition \"%s\" not found, but it is required", e.Name)
+}
+
+func (e *CRDNotFoundError) Unwrap() error {
+	return e.err
+}
+
+func IsCRDNotFoundError(err error) bool {
+	var target *CRDNotFoundError
+	return errors.As(err, &target)
+}
+
+// ResourceNotFoundError is returned if the API server does not serve the resource of a swept custom resource
+// definition and WithResourceValidation was set
+type ResourceNotFoundError struct {
+	Resource schema.GroupResource
+	err      error
+}
+
+func (e *ResourceNotFoundError) Error() string {
+	return fmt.Sprintf("resource \"%s\" not found in cluster", e.Resource.String())
+}
+
+func (e *ResourceNotFoundError) Unwrap() error {
+	return e.err
+}
+
+func IsResourceNotFoundError(err error) bool {
+	var target *ResourceNotFoundError
+	return errors.As(err, &target)
+}
+
+// StreamStoppedError is returned by StreamOryFinalizers when its context is done before the run is over. No further
+// resource is processed once the context is done, so the resources modified by the run are those of the received
+// results and of Unsent.
+type StreamStoppedError struct {
+	// Unsent is the result of the last processed resource, which could not be sent anymore, or nil if the run stopped
+	// before the next resource
+	Unsent *ResourceResult
+	err    error
+}
+
+func (e *StreamStoppedError) Error() string {
+	return fmt.Sprintf("streaming the results of ory custom resources stopped: %v", e.err)
+}
+
+func (e *StreamStoppedError) Unwrap() error {
+	return e.err
+}
+
+func IsStreamStoppedError(err error) bool {
+	var target *StreamStoppedError
+	return errors.As(err, &target)
+}
+
+// MissingPermissionError is returned when a request was forbidden, naming the permission which has to be granted
+type MissingPermissionError struct {
+	Verb     string
+	Resource schema.GroupResource
+	// Namespace is empty for cluster-scoped resources and requests across all namespaces
+	Namespace string
+	err       error
+}
+
+func (e *MissingPermissionError) Error() string {
+	scope := "cluster-wide"
+	if e.Namespace != "" {
+		scope = fmt.Sprintf("in namespace \"%s\"", e.Namespace)
+	}
+	return fmt.Sprintf("missing RBAC permission, grant %s on %s %s: %v", e.Verb, e.Resource, scope, e.err)
+}
+
+func (e *MissingPermissionError) Unwrap() error {
+	return e.err
+}
+
+func IsMissingPermissionError(err error) bool {
+	var target *MissingPermissionError
+	return errors.As(err, &target)
+}
+
+// ImpersonationForbiddenError is returned when the identity of the kubeconfig is not allowed to impersonate
+// the identity configured with WithImpersonation
+type ImpersonationForbiddenError struct {
+	Impersonation rest.ImpersonationConfig
+	err           error
+}
+
+func (e *ImpersonationForbiddenError) Error() string {
+	return fmt.Sprintf("kubeconfig identity is not allowed to impersonate user \"%s\" (uid: \"%s\", groups: %v), "+
+		"grant impersonate on users, groups, uids and userextras as needed: %v",
+		e.Impersonation.UserName, e.Impersonation.UID, e.Impersonation.Groups, e.err)
+}
+
+func (e *ImpersonationForbiddenError) Unwrap() error {
+	return e.err
+}
+
+func IsImpersonationForbiddenError(err error) bool {
+	var target *ImpersonationForbiddenError
+	return errors.As(err, &target)
+}
+
+// checkForbidden turns a Forbidden API error into an ImpersonationForbiddenError if impersonating was denied or
+// a MissingPermissionError otherwise, and returns all other errors unchanged
+func (h *DefaultOryFinalizersHandler) checkForbidden(err error, verb string, resource schema.GroupResource, namespace string) error {
+	if !apierr.IsForbidden(err) {
+		return err
+	}
+	if h.impersonation != nil && isImpersonationForbidden(err) {
+		return &ImpersonationForbiddenError{Impersonation: *h.impersonation, err: err}
+	}
+	return &MissingPermissionError{Verb: verb, Resource: resource, Namespace: namespace, err: err}
+}
+
+// permissionError turns a Forbidden API error of the helpers using a typed client into a MissingPermissionError
+func permissionError(err error, verb string, resource schema.GroupResource, namespace string) error {
+	if apierr.IsForbidden(err) {
+		return &MissingPermissionError{Verb: verb, Resource: resource, Namespace: namespace, err: err}
+	}
+	return err
+}
+
+// isImpersonationForbidden reports whether the API server denied impersonating, which it does before
+// authorizing the actual request
+func isImpersonationForbidden(err error) bool {
+	var status apierr.APIStatus
+	if !errors.As(err, &status) {
+		return false
+	}
+	return strings.Contains(status.Status().Message, "cannot impersonate")
+}
+
+// CredentialsRejectedError is returned when the API server keeps rejecting the credentials of the kubeconfig,
+// even after the clients were rebuilt to refresh them
+type CredentialsRejectedError struct {
+	err error
+}
+
+func (e *CredentialsRejectedError) Error() string {
+	return fmt.Sprintf("credentials were rejected by the API server, the kubeconfig might be expired: %v", e.err)
+}
+
+func (e *CredentialsRejectedError) Unwrap() error {
+	return e.err
+}
+
+func IsCredentialsRejectedError(err error) bool {
+	var target *CredentialsRejectedError
+	return errors.As(err, &target)
+}
+
+// RetryBudgetExhaustedError is the failure of a custom resource whose update conflicted after the conflict retry
+// budget of the run was used up, see WithConflictRetryBudget
+type RetryBudgetExhaustedError struct {
+	Budget int
+	err    error
+}
+
+func (e *RetryBudgetExhaustedError) Error() string {
+	return fmt.Sprintf("conflict retry budget of %d retries is exhausted: %v", e.Budget, e.err)
+}
+
+func (e *RetryBudgetExhaustedError) Unwrap() error {
+	return e.err
+}
+
+func IsRetryBudgetExhaustedError(err error) bool {
+	var target *RetryBudgetExhaustedError
+	return errors.As(err, &target)
+}
+
+// CleanupErrors is returned when the finalizers of some custom resources could not be removed. errors.Is and
+// errors.As match if any of the failures matches.
+type CleanupErrors struct {
+	Failures []ResourceFailure
+}
+
+// newCleanupErrors returns nil if there are no failures
+func newCleanupErrors(failures []ResourceFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return &CleanupErrors{Failures: failures}
+}
+
+func isCleanupErrors(err error) bool {
+	var target *CleanupErrors
+	return errors.As(err, &target)
+}
+
+func (e *CleanupErrors) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		msgs = append(msgs, failure.Error())
+	}
+	return fmt.Sprintf("failed to remove finalizers of %d ory custom resources: [%s]", len(e.Failures), strings.Join(msgs, ", "))
+}
+
+func (e *CleanupErrors) Is(target error) bool {
+	for _, failure := range e.Failures {
+		if errors.Is(failure, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CleanupErrors) As(target interface{}) bool {
+	for _, failure := range e.Failures {
+		if errors.As(failure, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forbidden returns the failures caused by missing permissions, which persist until RBAC is fixed
+func (e *CleanupErrors) Forbidden() []ResourceFailure {
+	return e.filter(apierr.IsForbidden)
+}
+
+// Conflicts returns the failures caused by concurrent modifications, which usually succeed on a retry
+func (e *CleanupErrors) Conflicts() []ResourceFailure {
+	return e.filter(apierr.IsConflict)
+}
+
+// Contended returns the conflicts which were not retried because the retry budget was exhausted, see
+// WithConflictRetryBudget. They are also contained in Conflicts.
+func (e *CleanupErrors) Contended() []ResourceFailure {
+	return e.filter(IsRetryBudgetExhaustedError)
+}
+
+// NotFound returns the failures caused by custom resources which disappeared during the run
+func (e *CleanupErrors) NotFound() []ResourceFailure {
+	return e.filter(apierr.IsNotFound)
+}
+
+func (e *CleanupErrors) filter(matches func(error) bool) []ResourceFailure {
+	var filtered []ResourceFailure
+	for _, failure := range e.Failures {
+		if matches(failure.Err) {
+			filtered = append(filtered, failure)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,113 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultEstablishedPollInterval = time.Second
+
+// DefaultCRDEstablishedTimeout is how long a run waits for a custom resource definition to be established unless
+// configured otherwise with WithCRDEstablishedWait
+const DefaultCRDEstablishedTimeout = 10 * time.Second
+
+// UnestablishedCRDMode defines how a custom resource definition which was not established within the timeout of
+// WithCRDEstablishedWait is handled
+type UnestablishedCRDMode int
+
+const (
+	// UnestablishedCRDSkip skips the instances of the definition with a warning and reports it with
+	// CRDResult.NotEstablished
+	UnestablishedCRDSkip UnestablishedCRDMode = iota
+	// UnestablishedCRDFail fails the run with a CRDNotEstablishedError
+	UnestablishedCRDFail
+)
+
+// CRDNotEstablishedError is returned if a custom resource definition was not established within the timeout,
+// see WithCRDEstablishedWait and WaitForCRDEstablished
+type CRDNotEstablishedError struct {
+	Name    string
+	Timeout time.Duration
+	err     error
+}
+
+func (e *CRDNotEstablishedError) Error() string {
+	msg := fmt.Sprintf("crd \"%s\" was not established within %s", e.Name, e.Timeout)
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+func (e *CRDNotEstablishedError) Unwrap() error {
+	return e.err
+}
+
+func IsCRDNotEstablishedError(err error) bool {
+	var target *CRDNotEstablishedError
+	return errors.As(err, &target)
+}
+
+// WaitForCRDEstablished polls the custom resource definition until its Established condition is true, e.g. after
+// applying it and before listing its instances. It uses the cluster of the last call of the handler, or otherwise
+// the configuration the handler was created for. Transient errors and a CRD which does not exist yet are tolerated
+// until the timeout expires with a CRDNotEstablishedError.
+func (h *DefaultOryFinalizersHandler) WaitForCRDEstablished(ctx context.Context, name string, timeout time.Duration) error {
+	if err := h.ensureClients(ctx); err != nil {
+		return err
+	}
+	return h.waitForCRDEstablished(ctx, &apixv1beta1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: name}}, timeout)
+}
+
+// waitForCRDEstablished polls the custom resource definition until the API server serves its resources.
+// Transient errors are tolerated until the timeout passes.
+func (h *DefaultOryFinalizersHandler) waitForCRDEstablished(ctx context.Context, crd *apixv1beta1.CustomResourceDefinition,
+	timeout time.Duration) error {
+	if isCRDEstablished(crd) {
+		return nil
+	}
+	h.logger.Debugf("Waiting up to %s for crd \"%s\" to be established", timeout, crd.Name)
+
+	interval := h.establishedPollInterval
+	if interval <= 0 {
+		interval = defaultEstablishedPollInterval
+	}
+	deadline := h.clock.Now().Add(timeout)
+	crds, err := h.crds(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		current, err := crds.Get(ctx, crd.Name, metav1.GetOptions{})
+		if err != nil && !IsRetriableError(err) && !apierr.IsNotFound(err) {
+			return h.checkForbidden(err, "get", crdResource, "")
+		}
+		if err == nil && isCRDEstablished(current) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "stopped waiting for crd \"%s\" to be established", crd.Name)
+		}
+		if !h.clock.Now().Before(deadline) {
+			return &CRDNotEstablishedError{Name: crd.Name, Timeout: timeout, err: err}
+		}
+		if err := h.sleep(ctx, interval); err != nil {
+			return errors.Wrapf(err, "stopped waiting for crd \"%s\" to be established", crd.Name)
+		}
+	}
+}
+
+func isCRDEstablished(crd *apixv1beta1.CustomResourceDefinition) bool {
+	for _, condition := range crd.Status.Conditions {
+		if condition.Type == apixv1beta1.Established {
+			return condition.Status == apixv1beta1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	execInfoEnv      = "KUBERNETES_EXEC_INFO"
+	execProbeTimeout = 30 * time.Second
+	maxExecStderr    = 512
+)
+
+// execProbe checks the exec credential plugin of the configuration built for the named cluster and context
+type execProbe func(cfg *rest.Config, clusterName, contextName string) error
+
+// probeExecOnce returns the probe of the exec credential plugin of the kubeconfig identified by the key. The plugin is
+// only run until a probe succeeds, the clients rebuilt for the same kubeconfig obtain their credentials from it
+// anyway. The probe stops early when the context is done.
+func (h *DefaultOryFinalizersHandler) probeExecOnce(ctx context.Context, key string) execProbe {
+	return func(cfg *rest.Config, clusterName, contextName string) error {
+		h.execProbeMu.Lock()
+		defer h.execProbeMu.Unlock()
+		if h.probedKubeconfigs[key] {
+			return nil
+		}
+		if err := probeExecCredential(ctx, cfg, clusterName, contextName); err != nil {
+			return err
+		}
+		if h.probedKubeconfigs == nil {
+			h.probedKubeconfigs = make(map[string]bool)
+		}
+		h.probedKubeconfigs[key] = true
+		return nil
+	}
+}
+
+// probeExecCredential runs the exec credential plugin of the configuration once, so that a missing or failing
+// plugin is reported before any cleanup starts instead of surfacing deep inside the first API call.
+func probeExecCredential(ctx context.Context, cfg *rest.Config, clusterName, contextName string) error {
+	provider := cfg.ExecProvider
+	newError := func(err error) error {
+		return &ExecCredentialUnavailableError{
+			Command:     provider.Command,
+			Cluster:     clusterName,
+			Context:     contextName,
+			InstallHint: provider.InstallHint,
+			err:         err,
+		}
+	}
+
+	path, err := exec.LookPath(provider.Command)
+	if err != nil {
+		return newError(err)
+	}
+
+	execInfo, err := execCredentialInfo(cfg)
+	if err != nil {
+		return newError(err)
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, execProbeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(probeCtx, path, provider.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range provider.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Env = append(cmd.Env, execInfoEnv+"="+execInfo)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			// the caller gave up, which says nothing about the plugin
+			return errors.Wrapf(ctx.Err(), "stopped probing exec credential plugin \"%s\"", provider.Command)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if len(msg) > maxExecStderr {
+				msg = msg[:maxExecStderr] + "..."
+			}
+			return newError(errors.Wrap(err, msg))
+		}
+		return newError(err)
+	}
+	return nil
+}
+
+// execCredentialInfo encodes the ExecCredential passed to plugins, including the cluster if the plugin requests it.
+// Plugins are always run non-interactively.
+func execCredentialInfo(cfg *rest.Config) (string, error) {
+	provider := cfg.ExecProvider
+	credential := clientauthv1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{APIVersion: provider.APIVersion, Kind: "ExecCredential"},
+	}
+	if provider.ProvideClusterInfo {
+		credential.Spec.Cluster = &clientauthv1.Cluster{
+			Server:                   cfg.Host,
+			TLSServerName:            cfg.ServerName,
+			InsecureSkipTLSVerify:    cfg.Insecure,
+			CertificateAuthorityData: cfg.CAData,
+		}
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode exec credential")
+	}
+	return string(data), nil
+}
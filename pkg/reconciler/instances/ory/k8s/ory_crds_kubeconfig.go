@@ -0,0 +1,173 @@
+package k8s
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// InvalidKubeconfigError is returned when a kubeconfig cannot be used to connect to a cluster.
+// The reason never contains secret material of the kubeconfig.
+type InvalidKubeconfigError struct {
+	Reason string
+	// Size is the length of the kubeconfig in bytes, which helps to recognize empty or truncated kubeconfigs
+	Size int
+	err  error
+}
+
+func (e *InvalidKubeconfigError) Error() string {
+	return fmt.Sprintf("invalid kubeconfig (%d bytes): %s", e.Size, e.Reason)
+}
+
+func (e *InvalidKubeconfigError) Unwrap() error {
+	return e.err
+}
+
+func IsInvalidKubeconfigError(err error) bool {
+	var target *InvalidKubeconfigError
+	return errors.As(err, &target)
+}
+
+// KubeconfigFileNotFoundError is returned when a kubeconfig file passed with WithKubeconfigPaths does not exist
+type KubeconfigFileNotFoundError struct {
+	Path string
+	err  error
+}
+
+func (e *KubeconfigFileNotFoundError) Error() string {
+	return fmt.Sprintf("kubeconfig file \"%s\" not found", e.Path)
+}
+
+func (e *KubeconfigFileNotFoundError) Unwrap() error {
+	return e.err
+}
+
+func IsKubeconfigFileNotFoundError(err error) bool {
+	var target *KubeconfigFileNotFoundError
+	return errors.As(err, &target)
+}
+
+// ValidateKubeconfig checks that the kubeconfig can be parsed and that its current context refers to a cluster
+// with a server URL and to a user with credentials. This allows pre-checking kubeconfigs before scheduling work.
+func ValidateKubeconfig(kubeconfigData string) error {
+	_, err := loadKubeconfig(kubeconfigData, "", "")
+	return err
+}
+
+// loadKubeconfig parses and validates the kubeconfig for the given context and cluster, which default to the
+// current context and its cluster
+func loadKubeconfig(kubeconfigData, contextName, clusterName string) (*clientcmdapi.Config, error) {
+	invalid := func(err error, format string, args ...interface{}) error {
+		return &InvalidKubeconfigError{Reason: fmt.Sprintf(format, args...), Size: len(kubeconfigData), err: err}
+	}
+
+	if strings.TrimSpace(kubeconfigData) == "" {
+		return nil, invalid(nil, "kubeconfig is empty")
+	}
+	// the parse error is not part of the reason as it may quote parts of the kubeconfig
+	rawConfig, err := clientcmd.Load([]byte(kubeconfigData))
+	if err != nil {
+		return nil, invalid(err, "kubeconfig cannot be parsed, it might be truncated")
+	}
+	return rawConfig, validateKubeconfig(rawConfig, len(kubeconfigData), contextName, clusterName)
+}
+
+// loadKubeconfigFiles merges the kubeconfig files with the standard loading rules, which also resolve
+// file references relative to the kubeconfig containing them, and validates the result
+func loadKubeconfigFiles(paths []string, contextName, clusterName string) (*clientcmdapi.Config, error) {
+	size := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, &KubeconfigFileNotFoundError{Path: path, err: err}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read kubeconfig file \"%s\"", path)
+		}
+		if _, err := clientcmd.Load(data); err != nil {
+			return nil, &InvalidKubeconfigError{
+				Reason: fmt.Sprintf("kubeconfig file \"%s\" cannot be parsed, it might be truncated", path),
+				Size:   len(data),
+				err:    err,
+			}
+		}
+		size += len(data)
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: paths}
+	rawConfig, err := rules.Load()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to merge kubeconfig files")
+	}
+	return rawConfig, validateKubeconfig(rawConfig, size, contextName, clusterName)
+}
+
+// validateKubeconfig checks that the context and cluster, which default to the current context and its cluster,
+// exist and provide a server URL and credentials
+func validateKubeconfig(rawConfig *clientcmdapi.Config, size int, contextName, clusterName string) error {
+	invalid := func(err error, format string, args ...interface{}) error {
+		return &InvalidKubeconfigError{Reason: fmt.Sprintf(format, args...), Size: size, err: err}
+	}
+
+	if contextName == "" {
+		contextName = rawConfig.CurrentContext
+		if contextName == "" {
+			return invalid(nil, "no current context is set")
+		}
+	}
+	kubeContext, ok := rawConfig.Contexts[contextName]
+	if !ok {
+		return invalid(nil, "context \"%s\" not found in kubeconfig, available contexts: [%s]",
+			contextName, strings.Join(sortedKeys(rawConfig.Contexts), ", "))
+	}
+
+	if clusterName == "" {
+		clusterName = kubeContext.Cluster
+	}
+	cluster, ok := rawConfig.Clusters[clusterName]
+	if !ok {
+		return invalid(nil, "cluster \"%s\" not found in kubeconfig, available clusters: [%s]",
+			clusterName, strings.Join(sortedKeys(rawConfig.Clusters), ", "))
+	}
+	if cluster.Server == "" {
+		return invalid(nil, "cluster \"%s\" has no server URL", clusterName)
+	}
+	if server, err := url.Parse(cluster.Server); err != nil || server.Host == "" {
+		return invalid(err, "cluster \"%s\" has a malformed server URL", clusterName)
+	}
+
+	authInfo, ok := rawConfig.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return invalid(nil, "user \"%s\" of context \"%s\" not found in kubeconfig", kubeContext.AuthInfo, contextName)
+	}
+	if !hasCredentials(authInfo) {
+		return invalid(nil, "user \"%s\" of context \"%s\" has no credentials", kubeContext.AuthInfo, contextName)
+	}
+	return nil
+}
+
+func hasCredentials(authInfo *clientcmdapi.AuthInfo) bool {
+	hasClientCert := (len(authInfo.ClientCertificateData) > 0 || authInfo.ClientCertificate != "") &&
+		(len(authInfo.ClientKeyData) > 0 || authInfo.ClientKey != "")
+	return hasClientCert ||
+		authInfo.Token != "" ||
+		authInfo.TokenFile != "" ||
+		authInfo.Username != "" ||
+		authInfo.Exec != nil ||
+		authInfo.AuthProvider != nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
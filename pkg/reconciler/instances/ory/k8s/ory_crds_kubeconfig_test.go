@@ -0,0 +1,193 @@
+package k8s
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateKubeconfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		kubeconfig string
+		reason     string
+	}{
+		{
+			name:       "valid kubeconfig",
+			kubeconfig: testKubeconfig,
+		},
+		{
+			name:       "empty kubeconfig",
+			kubeconfig: "",
+			reason:     "kubeconfig is empty",
+		},
+		{
+			name:       "blank kubeconfig",
+			kubeconfig: " \n\t",
+			reason:     "kubeconfig is empty",
+		},
+		{
+			name:       "unparsable kubeconfig",
+			kubeconfig: "users: [{user: {token: secret-token",
+			reason:     "kubeconfig cannot be parsed",
+		},
+		{
+			name:       "truncated kubeconfig",
+			kubeconfig: testKubeconfig[:strings.Index(testKubeconfig, "contexts:")],
+			reason:     "no current context is set",
+		},
+		{
+			name:       "missing current context",
+			kubeconfig: strings.Replace(testKubeconfig, "current-context: test", "current-context: other", 1),
+			reason:     `context "other" not found in kubeconfig, available contexts: [test]`,
+		},
+		{
+			name:       "missing cluster",
+			kubeconfig: strings.Replace(testKubeconfig, "    cluster: test", "    cluster: other", 1),
+			reason:     `cluster "other" not found in kubeconfig, available clusters: [test]`,
+		},
+		{
+			name:       "missing server URL",
+			kubeconfig: strings.Replace(testKubeconfig, "server: https://test.example.com", "server: \"\"", 1),
+			reason:     `cluster "test" has no server URL`,
+		},
+		{
+			name:       "malformed server URL",
+			kubeconfig: strings.Replace(testKubeconfig, "server: https://test.example.com", "server: test.example.com", 1),
+			reason:     `cluster "test" has a malformed server URL`,
+		},
+		{
+			name:       "missing user",
+			kubeconfig: strings.Replace(testKubeconfig, "    user: test", "    user: other", 1),
+			reason:     `user "other" of context "test" not found in kubeconfig`,
+		},
+		{
+			name:       "missing credentials",
+			kubeconfig: strings.Replace(testKubeconfig, "token: secret-token", "{}", 1),
+			reason:     `user "test" of context "test" has no credentials`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			err := ValidateKubeconfig(tt.kubeconfig)
+
+			// then
+			if tt.reason == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.True(t, IsInvalidKubeconfigError(err))
+			require.Contains(t, err.Error(), tt.reason)
+			require.Contains(t, err.Error(), "bytes")
+			require.NotContains(t, err.Error(), "secret-token")
+		})
+	}
+}
+
+func Test_RestConfig_InvalidKubeconfig(t *testing.T) {
+	t.Run("should validate the selected context before building the config", func(t *testing.T) {
+		// given
+		kubeconfig := strings.Replace(testMultiContextKubeconfig, "    token: green-token", "    {}", 1)
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigContext("green"))
+
+		// when
+		_, err := handler.restConfig(context.Background(), kubeconfig)
+
+		// then
+		require.True(t, IsInvalidKubeconfigError(err))
+		require.Contains(t, err.Error(), `user "green" of context "green" has no credentials`)
+	})
+}
+
+func Test_RestConfig_KubeconfigPaths(t *testing.T) {
+	t.Run("should resolve file references relative to the kubeconfig file", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		writeFile(t, dir, "ca.crt", "certificate")
+		path := writeFile(t, dir, "config", strings.Replace(testKubeconfig,
+			"server: https://test.example.com", "server: https://test.example.com\n    certificate-authority: ca.crt", 1))
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigPaths(path), WithRequestTimeout(time.Second))
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), "")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://test.example.com", cfg.Host)
+		require.Equal(t, filepath.Join(dir, "ca.crt"), cfg.CAFile)
+		require.Equal(t, time.Second, cfg.Timeout)
+		require.Equal(t, "kyma-reconciler/ory-cleanup/"+Version, cfg.UserAgent)
+		require.IsType(t, &warningRouter{}, cfg.WarningHandler)
+	})
+
+	t.Run("should merge multiple kubeconfig files", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		users := testMultiContextKubeconfig[strings.Index(testMultiContextKubeconfig, "users:"):]
+		clusters := writeFile(t, dir, "clusters", strings.TrimSuffix(testMultiContextKubeconfig, users))
+		credentials := writeFile(t, dir, "users", "apiVersion: v1\nkind: Config\n"+users)
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigPaths(clusters, credentials), WithKubeconfigContext("green"))
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), "")
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://green.example.com", cfg.Host)
+		require.Equal(t, "green-token", cfg.BearerToken)
+	})
+
+	t.Run("should report missing kubeconfig files", func(t *testing.T) {
+		// given
+		path := filepath.Join(t.TempDir(), "missing")
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigPaths(path))
+
+		// when
+		_, err := handler.restConfig(context.Background(), "")
+
+		// then
+		require.True(t, IsKubeconfigFileNotFoundError(err))
+		require.False(t, IsInvalidKubeconfigError(err))
+		require.Contains(t, err.Error(), path)
+	})
+
+	t.Run("should report unparsable kubeconfig files", func(t *testing.T) {
+		// given
+		path := writeFile(t, t.TempDir(), "config", "users: [{user: {token: secret-token")
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigPaths(path))
+
+		// when
+		_, err := handler.restConfig(context.Background(), "")
+
+		// then
+		require.True(t, IsInvalidKubeconfigError(err))
+		require.False(t, IsKubeconfigFileNotFoundError(err))
+		require.Contains(t, err.Error(), path)
+		require.NotContains(t, err.Error(), "secret-token")
+	})
+
+	t.Run("should prefer passed kubeconfig content over files", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithKubeconfigPaths(filepath.Join(t.TempDir(), "missing")))
+
+		// when
+		cfg, err := handler.restConfig(context.Background(), testKubeconfig)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "https://test.example.com", cfg.Host)
+	})
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
@@ -0,0 +1,73 @@
+package k8s
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SweepLockMode defines how concurrent runs of FindAndDeleteOryFinalizers against the same cluster are handled
+type SweepLockMode int
+
+const (
+	// SweepLockDisabled lets concurrent runs proceed in parallel
+	SweepLockDisabled SweepLockMode = iota
+	// SweepLockWait blocks a run until the running one against the same cluster has finished
+	SweepLockWait
+	// SweepLockFail fails a run with ErrSweepInProgress while another one against the same cluster is running
+	SweepLockFail
+)
+
+// ErrSweepInProgress is returned with SweepLockFail if another run against the same cluster is in progress
+var ErrSweepInProgress = errors.New("removing ory finalizers is already in progress for this cluster")
+
+// clusterSweepLocks is shared by all handlers of the process, so the lock also covers handlers created per reconciliation
+var clusterSweepLocks = &sweepLocks{locks: map[string]chan struct{}{}}
+
+// sweepLocks holds one lock per cluster, identified by the host of its API server
+type sweepLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func (l *sweepLocks) acquire(cluster string, wait bool) (release func(), err error) {
+	l.mu.Lock()
+	lock, ok := l.locks[cluster]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		l.locks[cluster] = lock
+	}
+	l.mu.Unlock()
+
+	if wait {
+		lock <- struct{}{}
+	} else {
+		select {
+		case lock <- struct{}{}:
+		default:
+			return nil, ErrSweepInProgress
+		}
+	}
+	return func() { <-lock }, nil
+}
+
+// lockSweep acquires the lock of the cluster the clients were built for, according to the configured mode. Clients of
+// a provider without a Host method cannot be told apart from those of other clusters, so their runs are not locked,
+// as a shared lock would serialize the runs against all such clusters.
+func (h *DefaultOryFinalizersHandler) lockSweep() (release func(), err error) {
+	if h.sweepLockMode == SweepLockDisabled {
+		return func() {}, nil
+	}
+	h.clientsMu.Lock()
+	cluster := h.clusterHost
+	h.clientsMu.Unlock()
+	if cluster == "" {
+		h.logger.Debugf("Not locking the removal of ory finalizers, the client provider does not identify its cluster")
+		return func() {}, nil
+	}
+
+	if h.sweepLockMode == SweepLockWait {
+		h.logger.Debugf("Waiting for other runs removing ory finalizers on %s", cluster)
+	}
+	return clusterSweepLocks.acquire(cluster, h.sweepLockMode == SweepLockWait)
+}
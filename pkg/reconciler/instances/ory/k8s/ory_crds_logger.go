@@ -0,0 +1,51 @@
+package k8s
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logger is the logging interface of the finalizers handler, see WithLogger. It is implemented by
+// *zap.SugaredLogger, other logging libraries like logr or slog can be plugged in with a small adapter.
+type Logger interface {
+	Debugf(template string, args ...interface{})
+	Infof(template string, args ...interface{})
+	// Infow logs a message with structured context given as alternating keys and values
+	Infow(msg string, keysAndValues ...interface{})
+	Warnf(template string, args ...interface{})
+	Errorf(template string, args ...interface{})
+}
+
+// NewZapLogger returns the zap logger as Logger, or a logger discarding all messages if it is nil
+func NewZapLogger(logger *zap.SugaredLogger) Logger {
+	if logger == nil {
+		return zap.NewNop().Sugar()
+	}
+	return logger
+}
+
+// isNilLogger also detects nil pointers wrapped in the interface, like a nil *zap.SugaredLogger
+func isNilLogger(logger Logger) bool {
+	if logger == nil {
+		return true
+	}
+	zapLogger, ok := logger.(*zap.SugaredLogger)
+	return ok && zapLogger == nil
+}
+
+// DebugEnabler can be implemented by a Logger to report whether debug messages are written, which skips diagnostics
+// costing extra requests if they are not
+type DebugEnabler interface {
+	DebugEnabled() bool
+}
+
+// debugEnabled reports whether the logger writes debug messages, assuming it does if it cannot tell
+func debugEnabled(logger Logger) bool {
+	switch l := logger.(type) {
+	case *zap.SugaredLogger:
+		return l.Desugar().Core().Enabled(zapcore.DebugLevel)
+	case DebugEnabler:
+		return l.DebugEnabled()
+	}
+	return true
+}
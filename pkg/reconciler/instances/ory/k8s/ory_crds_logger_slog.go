@@ -0,0 +1,64 @@
+//go:build go1.21
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SlogAdapter is a Logger writing to a *slog.Logger, which removes the need for zap when embedding the handler
+type SlogAdapter struct {
+	logger *slog.Logger
+}
+
+var _ Logger = &SlogAdapter{}
+
+// NewSlogAdapter returns a Logger writing to the given slog logger, or to slog.Default if it is nil
+func NewSlogAdapter(logger *slog.Logger) *SlogAdapter {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogAdapter{logger: logger}
+}
+
+// With returns an adapter attaching the given alternating keys and values, or slog.Attr values, to all records
+func (a *SlogAdapter) With(args ...interface{}) *SlogAdapter {
+	return &SlogAdapter{logger: a.logger.With(args...)}
+}
+
+// DebugEnabled reports whether the slog logger handles debug records
+func (a *SlogAdapter) DebugEnabled() bool {
+	return a.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+func (a *SlogAdapter) Debugf(template string, args ...interface{}) {
+	a.log(slog.LevelDebug, template, args)
+}
+
+func (a *SlogAdapter) Infof(template string, args ...interface{}) {
+	a.log(slog.LevelInfo, template, args)
+}
+
+func (a *SlogAdapter) Warnf(template string, args ...interface{}) {
+	a.log(slog.LevelWarn, template, args)
+}
+
+func (a *SlogAdapter) Errorf(template string, args ...interface{}) {
+	a.log(slog.LevelError, template, args)
+}
+
+// Infow logs the message with the keys and values as attributes
+func (a *SlogAdapter) Infow(msg string, keysAndValues ...interface{}) {
+	a.logger.Log(context.Background(), slog.LevelInfo, msg, keysAndValues...)
+}
+
+func (a *SlogAdapter) log(level slog.Level, template string, args []interface{}) {
+	ctx := context.Background()
+	// formatting is skipped for disabled levels, as most messages of the handler are debug messages
+	if !a.logger.Enabled(ctx, level) {
+		return
+	}
+	a.logger.Log(ctx, level, fmt.Sprintf(template, args...))
+}
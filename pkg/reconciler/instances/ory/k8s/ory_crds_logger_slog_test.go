@@ -0,0 +1,111 @@
+//go:build go1.21
+
+package k8s
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+)
+
+func Test_SlogAdapter(t *testing.T) {
+	t.Run("should map the levels and attach the fields", func(t *testing.T) {
+		// given
+		var out bytes.Buffer
+		adapter := NewSlogAdapter(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))).
+			With("component", "ory")
+
+		// when
+		adapter.Debugf("debug %d", 1)
+		adapter.Infof("info %s", "message")
+		adapter.Warnf("warn")
+		adapter.Errorf("error: %v", "failed")
+		adapter.Infow("summary", "crds", 1, "errors", 0)
+
+		// then
+		records := decodeSlogRecords(t, &out)
+		require.Len(t, records, 5)
+		expected := []struct{ level, msg string }{
+			{"DEBUG", "debug 1"}, {"INFO", "info message"}, {"WARN", "warn"}, {"ERROR", "error: failed"}, {"INFO", "summary"},
+		}
+		for i, record := range records {
+			require.Equal(t, expected[i].level, record["level"])
+			require.Equal(t, expected[i].msg, record["msg"])
+			require.Equal(t, "ory", record["component"])
+		}
+		require.Equal(t, float64(1), records[4]["crds"])
+		require.Equal(t, float64(0), records[4]["errors"])
+	})
+
+	t.Run("should skip disabled levels", func(t *testing.T) {
+		// given
+		var out bytes.Buffer
+		adapter := NewSlogAdapter(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+		// when
+		adapter.Debugf("debug")
+		adapter.Infof("info")
+
+		// then
+		records := decodeSlogRecords(t, &out)
+		require.Len(t, records, 1)
+		require.Equal(t, "info", records[0]["msg"])
+		require.False(t, debugEnabled(adapter))
+	})
+
+	t.Run("should log the run of the handler", func(t *testing.T) {
+		// given
+		var out bytes.Buffer
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := NewDefaultOryFinalizersHandler(WithLogger(NewSlogAdapter(slog.New(slog.NewJSONHandler(&out, nil)))))
+		handler.apixClient = apixfake.NewSimpleClientset(fixOAuth2ClientCRD()).ApiextensionsV1beta1()
+		handler.dynamic = dyn
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		records := decodeSlogRecords(t, &out)
+		require.Len(t, records, 1)
+		require.Equal(t, "Finished removing ory finalizers", records[0]["msg"])
+		require.Equal(t, float64(1), records[0]["removedFinalizers"])
+	})
+}
+
+func decodeSlogRecords(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func ExampleNewSlogAdapter() {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+			// drop the time to keep the output stable
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}))
+	adapter := NewSlogAdapter(logger).With("cluster", "dev")
+	handler := NewDefaultOryFinalizersHandler(WithLogger(adapter))
+	defer handler.Close()
+
+	adapter.Infof("Removing finalizers of %d crds", 1)
+	// Output: level=INFO msg="Removing finalizers of 1 crds" cluster=dev
+}
@@ -0,0 +1,73 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+)
+
+func Test_FindAndDeleteOryFinalizers_Logger(t *testing.T) {
+	t.Run("should log with a custom logger", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		logger := &recordingLogger{}
+		handler := NewDefaultOryFinalizersHandler(WithLogger(logger))
+		handler.apixClient = apixfake.NewSimpleClientset(fixOAuth2ClientCRD()).ApiextensionsV1beta1()
+		handler.dynamic = dyn
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+		require.Contains(t, logger.messages, `debug: Deleted ory finalizer for "client" OAuth2Client`)
+		summary := logger.messages[len(logger.messages)-1]
+		require.True(t, strings.HasPrefix(summary, "info: Finished removing ory finalizers [crds 1 instances 1 removedFinalizers 1 errors 0 elapsed"), summary)
+	})
+
+	t.Run("should discard the output of a nil zap logger", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		var logger *zap.SugaredLogger
+		handler := NewDefaultOryFinalizersHandler(WithLogger(logger))
+		handler.apixClient = apixfake.NewSimpleClientset(fixOAuth2ClientCRD()).ApiextensionsV1beta1()
+		handler.dynamic = dyn
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+}
+
+// recordingLogger is a Logger which is not backed by zap
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(template string, args ...interface{}) {
+	l.messages = append(l.messages, "debug: "+fmt.Sprintf(template, args...))
+}
+
+func (l *recordingLogger) Infof(template string, args ...interface{}) {
+	l.messages = append(l.messages, "info: "+fmt.Sprintf(template, args...))
+}
+
+func (l *recordingLogger) Warnf(template string, args ...interface{}) {
+	l.messages = append(l.messages, "warn: "+fmt.Sprintf(template, args...))
+}
+
+func (l *recordingLogger) Errorf(template string, args ...interface{}) {
+	l.messages = append(l.messages, "error: "+fmt.Sprintf(template, args...))
+}
+
+func (l *recordingLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.messages = append(l.messages, "info: "+msg+" "+fmt.Sprintf("%v", keysAndValues))
+}
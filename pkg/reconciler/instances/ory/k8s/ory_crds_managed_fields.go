@@ -0,0 +1,61 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// logFinalizerManagers logs the field managers of the finalizers of the fresh object after a conflict, which names
+// the controller re-adding them, e.g. ory-hydra-maester. It costs a request and is only called with debug logging.
+func (h *DefaultOryFinalizersHandler) logFinalizerManagers(ctx context.Context, crdef schema.GroupVersionResource, instance unstructured.Unstructured) {
+	res, err := h.instanceResource(crdef, instance.GetNamespace()).Get(ctx, instance.GetName(), metav1.GetOptions{})
+	if err != nil {
+		h.logger.Debugf("Cannot read the field managers of \"%s/%s\" after a conflict: %v", instance.GetNamespace(), instance.GetName(), err)
+		return
+	}
+	managers := finalizerManagers(res.GetManagedFields())
+	if len(managers) == 0 {
+		h.logger.Debugf("Conflict updating \"%s/%s\", no field manager owns its finalizers", res.GetNamespace(), res.GetName())
+		return
+	}
+	h.logger.Debugf("Conflict updating \"%s/%s\", its finalizers are managed by %s",
+		res.GetNamespace(), res.GetName(), strings.Join(managers, ", "))
+}
+
+// finalizerManagers describes the managers whose fields include metadata.finalizers, with their operation and the
+// time of their last change
+func finalizerManagers(entries []metav1.ManagedFieldsEntry) []string {
+	var managers []string
+	for _, entry := range entries {
+		if entry.FieldsV1 == nil || !ownsFinalizers(entry.FieldsV1.Raw) {
+			continue
+		}
+		manager := fmt.Sprintf("%s (%s", entry.Manager, entry.Operation)
+		if entry.Subresource != "" {
+			manager += " of " + entry.Subresource
+		}
+		if entry.Time != nil {
+			manager += " at " + entry.Time.UTC().Format(time.RFC3339)
+		}
+		managers = append(managers, manager+")")
+	}
+	return managers
+}
+
+func ownsFinalizers(fieldsV1 []byte) bool {
+	var fields struct {
+		Metadata map[string]json.RawMessage `json:"f:metadata"`
+	}
+	if err := json.Unmarshal(fieldsV1, &fields); err != nil {
+		return false
+	}
+	_, ok := fields.Metadata["f:finalizers"]
+	return ok
+}
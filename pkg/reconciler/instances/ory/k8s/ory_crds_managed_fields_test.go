@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_FinalizerManagers(t *testing.T) {
+	t.Run("should log the managers of the finalizers on a conflict", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixManagedOAuth2Client()))
+		failFirstCalls(dyn, "update", 1, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", nil))
+		core, logs := observer.New(zapcore.DebugLevel)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithLogger(zap.New(core).Sugar()))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+		messages := logs.FilterMessageSnippet("its finalizers are managed by").All()
+		require.Len(t, messages, 1)
+		require.Equal(t, `Conflict updating "default/client", its finalizers are managed by `+
+			`ory-hydra-maester (Update at 2022-11-03T10:00:00Z)`, messages[0].Message)
+	})
+
+	t.Run("should not read the managers without debug logging", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixManagedOAuth2Client()))
+		failFirstCalls(dyn, "update", 1, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", nil))
+		gets := failFirstCalls(dyn, "get", 0, nil)
+		core, _ := observer.New(zapcore.InfoLevel)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithLogger(zap.New(core).Sugar()))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, *gets)
+	})
+
+	t.Run("should only name the managers of the finalizers", func(t *testing.T) {
+		// given
+		entries := fixManagedOAuth2Client().GetManagedFields()
+		entries = append(entries, metav1.ManagedFieldsEntry{
+			Manager:     "kubectl-patch",
+			Operation:   metav1.ManagedFieldsOperationApply,
+			Subresource: "status",
+			FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:finalizers":{"v:\"hydra.ory.sh/finalizer\"":{}}}}`)},
+		}, metav1.ManagedFieldsEntry{
+			Manager:  "broken",
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`not json`)},
+		})
+
+		// when
+		managers := finalizerManagers(entries)
+
+		// then
+		require.Equal(t, []string{"ory-hydra-maester (Update at 2022-11-03T10:00:00Z)", "kubectl-patch (Apply of status)"}, managers)
+	})
+}
+
+// fixManagedOAuth2Client returns an oauth2client whose finalizers are managed by ory-hydra-maester, while its spec is
+// managed by kubectl
+func fixManagedOAuth2Client() *unstructured.Unstructured {
+	instance := fixOAuth2Client("default", "client", "hydra.ory.sh/finalizer")
+	changed := metav1.NewTime(time.Date(2022, 11, 3, 10, 0, 0, 0, time.UTC))
+	instance.SetManagedFields([]metav1.ManagedFieldsEntry{
+		{
+			Manager:   "kubectl-client-side-apply",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:grantTypes":{}}}`)},
+		},
+		{
+			Manager:   "ory-hydra-maester",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			Time:      &changed,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:finalizers":{".":{},"v:\"hydra.ory.sh/finalizer\"":{}}}}`)},
+		},
+	})
+	return instance
+}
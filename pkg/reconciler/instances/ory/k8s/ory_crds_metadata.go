@@ -0,0 +1,72 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// listInstanceMetadata lists the instances of the custom resource like listInstances, but only their metadata, which
+// is all the sweep reads unless WithNestedFinalizerPaths is set. The instances carry their apiVersion and metadata
+// only, see isMetadataOnly. If the handler has no metadata client or the API server rejects the listing of metadata,
+// the full instances are listed with the dynamic client instead, and the metadata is not requested again.
+func (h *DefaultOryFinalizersHandler) listInstanceMetadata(ctx context.Context, gvr schema.GroupVersionResource) (*unstructured.UnstructuredList, error) {
+	metadataClient := h.currentClients().metadata
+	if metadataClient == nil || h.isMetadataUnsupported() {
+		return h.listInstances(ctx, gvr)
+	}
+	resource := metadataClient.Resource(gvr).Namespace(metav1.NamespaceAll)
+	list, err := h.listWithFieldSelector(gvr, func(opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+		partial, err := resource.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return metadataToUnstructured(gvr, partial)
+	})
+	if !apierr.IsNotAcceptable(err) && !apierr.IsUnsupportedMediaType(err) {
+		return list, err
+	}
+
+	h.logger.Debugf("API server rejected listing the metadata of %s, listing the full instances: %v", gvr.String(), err)
+	h.metadataMu.Lock()
+	h.metadataUnsupported = true
+	h.metadataMu.Unlock()
+	return h.listInstances(ctx, gvr)
+}
+
+// isMetadataOnly reports whether the instance was listed as metadata only. Full instances always carry a kind.
+func isMetadataOnly(instance unstructured.Unstructured) bool {
+	return instance.GetKind() == ""
+}
+
+func (h *DefaultOryFinalizersHandler) isMetadataUnsupported() bool {
+	h.metadataMu.Lock()
+	defer h.metadataMu.Unlock()
+	return h.metadataUnsupported
+}
+
+// metadataToUnstructured converts the listed metadata to instances of the custom resource. The converted items are
+// released from the partial list, so that the metadata is not held twice.
+func metadataToUnstructured(gvr schema.GroupVersionResource, partial *metav1.PartialObjectMetadataList) (*unstructured.UnstructuredList, error) {
+	list := &unstructured.UnstructuredList{Items: make([]unstructured.Unstructured, 0, len(partial.Items))}
+	list.SetResourceVersion(partial.GetResourceVersion())
+	list.SetContinue(partial.GetContinue())
+	apiVersion := gvr.GroupVersion().String()
+	for i := range partial.Items {
+		objectMeta, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&partial.Items[i].ObjectMeta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to convert the metadata of %s", gvr.String())
+		}
+		list.Items = append(list.Items, unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"metadata":   objectMeta,
+		}})
+		partial.Items[i] = metav1.PartialObjectMetadata{}
+	}
+	return list, nil
+}
@@ -0,0 +1,210 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_ListInstanceMetadata(t *testing.T) {
+	t.Run("should remove the finalizers of the instances listed by the metadata client", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh", "example.com/keep")))
+		dynamicLists := failFirstCalls(dyn, "list", 0, nil)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+		handler.metadata = newFakeMetadataClient(fixOAuth2ClientMetadata("default", "client", "finalizer.ory.hydra.sh", "example.com/keep"))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireFinalizers(t, dyn, "default", "client", "example.com/keep")
+		require.Zero(t, *dynamicLists)
+	})
+
+	t.Run("should list the full instances if the API server rejects listing the metadata", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		metadataClient := newFakeMetadataClient(fixOAuth2ClientMetadata("default", "client", "finalizer.ory.hydra.sh"))
+		metadataLists := 0
+		metadataClient.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			metadataLists++
+			return true, nil, apierr.NewGenericServerResponse(http.StatusNotAcceptable, "list", oauth2ClientGVR.GroupResource(), "", "", 0, false)
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+		handler.metadata = metadataClient
+
+		// when
+		first, err := handler.listInstanceMetadata(context.Background(), oauth2ClientGVR)
+		require.NoError(t, err)
+		second, err := handler.listInstanceMetadata(context.Background(), oauth2ClientGVR)
+		require.NoError(t, err)
+
+		// then
+		require.Len(t, first.Items, 1)
+		require.Len(t, second.Items, 1)
+		require.Equal(t, "OAuth2Client", second.Items[0].GetKind())
+		require.Equal(t, 1, metadataLists)
+	})
+
+	t.Run("should return other errors of the metadata client", func(t *testing.T) {
+		// given
+		metadataClient := newFakeMetadataClient()
+		metadataClient.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewServiceUnavailable("overloaded")
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient())
+		handler.metadata = metadataClient
+
+		// when
+		_, err := handler.listInstanceMetadata(context.Background(), oauth2ClientGVR)
+
+		// then
+		require.True(t, apierr.IsServiceUnavailable(err))
+		require.False(t, handler.isMetadataUnsupported())
+	})
+
+	t.Run("should keep the metadata and the resource version of the list", func(t *testing.T) {
+		// given
+		deleted := metav1.Now()
+		partial := &metav1.PartialObjectMetadataList{
+			ListMeta: metav1.ListMeta{ResourceVersion: "42", Continue: "next"},
+			Items: []metav1.PartialObjectMetadata{{ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "default",
+				Name:              "client",
+				UID:               "uid",
+				Finalizers:        []string{"finalizer.ory.hydra.sh"},
+				DeletionTimestamp: &deleted,
+			}}},
+		}
+
+		// when
+		list, err := metadataToUnstructured(oauth2ClientGVR, partial)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "42", list.GetResourceVersion())
+		require.Equal(t, "next", list.GetContinue())
+		require.Len(t, list.Items, 1)
+		instance := list.Items[0]
+		require.Equal(t, oauth2ClientGVR.GroupVersion().String(), instance.GetAPIVersion())
+		require.Equal(t, "default", instance.GetNamespace())
+		require.Equal(t, "client", instance.GetName())
+		require.Equal(t, []string{"finalizer.ory.hydra.sh"}, instance.GetFinalizers())
+		require.Equal(t, deleted.Unix(), instance.GetDeletionTimestamp().Unix())
+		require.NoError(t, validateInstance(instance, false))
+		require.Empty(t, partial.Items[0].GetName())
+	})
+}
+
+// Benchmark_ListInstances compares the memory of listing large instances in full and as metadata, run it with
+// go test -run=^$ -bench=ListInstances -benchmem
+func Benchmark_ListInstances(b *testing.B) {
+	server := httptest.NewServer(fakeListServerHandler(b, 2000, 4096))
+	b.Cleanup(server.Close)
+	provider, err := NewClientProvider(&rest.Config{Host: server.URL})
+	require.NoError(b, err)
+	handler := NewDefaultOryFinalizersHandler(WithClientProvider(provider))
+	require.NoError(b, handler.initClients(context.Background(), ""))
+
+	for name, list := range map[string]func(context.Context, *DefaultOryFinalizersHandler) (int, error){
+		"full": func(ctx context.Context, h *DefaultOryFinalizersHandler) (int, error) {
+			instances, err := h.listInstances(ctx, oauth2ClientGVR)
+			if err != nil {
+				return 0, err
+			}
+			return len(instances.Items), nil
+		},
+		"metadata": func(ctx context.Context, h *DefaultOryFinalizersHandler) (int, error) {
+			instances, err := h.listInstanceMetadata(ctx, oauth2ClientGVR)
+			if err != nil {
+				return 0, err
+			}
+			return len(instances.Items), nil
+		},
+	} {
+		list := list
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				listed, err := list(context.Background(), handler)
+				require.NoError(b, err)
+				require.Equal(b, 2000, listed)
+			}
+		})
+	}
+}
+
+// fakeListServerHandler serves the given number of oauth2clients with a spec of the given size, as metadata if the
+// client asks for it like the API server does
+func fakeListServerHandler(b *testing.B, instances, specSize int) http.HandlerFunc {
+	secret := strings.Repeat("x", specSize)
+	full := map[string]interface{}{"apiVersion": "hydra.ory.sh/v1alpha1", "kind": "OAuth2ClientList", "metadata": map[string]interface{}{}}
+	partial := map[string]interface{}{"apiVersion": "meta.k8s.io/v1", "kind": "PartialObjectMetadataList", "metadata": map[string]interface{}{}}
+	var fullItems, partialItems []interface{}
+	for i := 0; i < instances; i++ {
+		objectMeta := map[string]interface{}{
+			"namespace":  "default",
+			"name":       fmt.Sprintf("client-%d", i),
+			"finalizers": []string{"finalizer.ory.hydra.sh"},
+		}
+		fullItems = append(fullItems, map[string]interface{}{
+			"apiVersion": "hydra.ory.sh/v1alpha1",
+			"kind":       "OAuth2Client",
+			"metadata":   objectMeta,
+			"spec":       map[string]interface{}{"clientName": secret, "redirectUris": []string{secret}},
+		})
+		partialItems = append(partialItems, map[string]interface{}{
+			"apiVersion": "meta.k8s.io/v1",
+			"kind":       "PartialObjectMetadata",
+			"metadata":   objectMeta,
+		})
+	}
+	full["items"], partial["items"] = fullItems, partialItems
+	fullBody, err := json.Marshal(full)
+	require.NoError(b, err)
+	partialBody, err := json.Marshal(partial)
+	require.NoError(b, err)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/hydra.ory.sh/v1alpha1/oauth2clients" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.Header.Get("Accept"), "as=PartialObjectMetadataList") {
+			_, _ = w.Write(partialBody)
+			return
+		}
+		_, _ = w.Write(fullBody)
+	}
+}
+
+func newFakeMetadataClient(objects ...runtime.Object) *metadatafake.FakeMetadataClient {
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	return metadatafake.NewSimpleMetadataClient(scheme, objects...)
+}
+
+func fixOAuth2ClientMetadata(namespace, name string, finalizers ...string) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: oauth2ClientGVR.GroupVersion().String(), Kind: "OAuth2Client"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Finalizers: finalizers},
+	}
+}
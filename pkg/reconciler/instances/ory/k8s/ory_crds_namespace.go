@@ -0,0 +1,233 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const namespacePollInterval = 2 * time.Second
+
+var namespaceGVR = corev1.SchemeGroupVersion.WithResource("namespaces")
+
+// NamespaceUnblockResult lists the objects whose finalizers were removed to unblock the deletion of a namespace
+type NamespaceUnblockResult struct {
+	Namespace string
+	Unblocked []UnblockedObject
+	// Failures contains the objects whose finalizers could not be removed
+	Failures []ResourceFailure
+}
+
+// UnblockedObject is an object whose ory finalizers were removed
+type UnblockedObject struct {
+	GVR               schema.GroupVersionResource
+	Name              string
+	RemovedFinalizers int
+}
+
+func (o UnblockedObject) String() string {
+	return fmt.Sprintf("%s.%s/%s \"%s\"", o.GVR.Resource, o.GVR.Group, o.GVR.Version, o.Name)
+}
+
+// NamespaceStuckError is returned if the namespace still had content remaining when the timeout expired
+type NamespaceStuckError struct {
+	Namespace string
+	Timeout   time.Duration
+	// Remaining is the message of the last remaining content or finalizers condition of the namespace
+	Remaining string
+}
+
+func (e *NamespaceStuckError) Error() string {
+	return fmt.Sprintf("namespace \"%s\" is still terminating after %s: %s", e.Namespace, e.Timeout, e.Remaining)
+}
+
+func IsNamespaceStuckError(err error) bool {
+	var target *NamespaceStuckError
+	return errors.As(err, &target)
+}
+
+// UnblockNamespace removes the ory finalizers from the objects which keep a terminating namespace from being deleted.
+// The objects are taken from the NamespaceContentRemaining condition of the namespace. If only the
+// NamespaceFinalizersRemaining condition names ory finalizers, the instances of the discovered ory CRDs in the
+// namespace are unblocked instead. The conditions are polled until they clear, the namespace is gone, the context is
+// done or the timeout expires with a NamespaceStuckError. Finalizers not matching the finalizer pattern are kept, so
+// objects of other components stay blocked.
+func (h *DefaultOryFinalizersHandler) UnblockNamespace(ctx context.Context, kubeconfigData, namespace string,
+	timeout time.Duration) (*NamespaceUnblockResult, error) {
+	if err := h.initClients(ctx, kubeconfigData); err != nil {
+		return nil, err
+	}
+	return h.unblockNamespace(ctx, namespace, timeout)
+}
+
+func (h *DefaultOryFinalizersHandler) unblockNamespace(ctx context.Context, namespace string, timeout time.Duration) (*NamespaceUnblockResult, error) {
+	h.applyDefaults()
+	run := h.newRun(ctx, nil)
+	defer h.warnings.register(run.warnings)()
+
+	result := &NamespaceUnblockResult{Namespace: namespace}
+	deadline := h.clock.Now().Add(timeout)
+	for {
+		ns, err := h.currentClients().dynamic.Resource(namespaceGVR).Get(ctx, namespace, metav1.GetOptions{})
+		if apierr.IsNotFound(err) {
+			h.logger.Infof("Namespace \"%s\" was deleted, unblocked %d objects", namespace, len(result.Unblocked))
+			return result, nil
+		}
+		if err != nil {
+			return result, h.checkForbidden(err, "get", namespaceGVR.GroupResource(), "")
+		}
+		if ns.GetDeletionTimestamp() == nil {
+			return result, errors.Errorf("namespace \"%s\" is not being deleted", namespace)
+		}
+
+		content, finalizers, err := remainingConditions(ns)
+		if err != nil {
+			return result, err
+		}
+		if content == "" && finalizers == "" {
+			h.logger.Infof("Namespace \"%s\" has no content remaining, unblocked %d objects", namespace, len(result.Unblocked))
+			return result, nil
+		}
+
+		resources, err := h.remainingResources(ctx, content, finalizers)
+		if err != nil {
+			return result, err
+		}
+		if err := h.unblockRemainingContent(run, namespace, resources, result); err != nil {
+			return result, err
+		}
+
+		if !h.clock.Now().Before(deadline) {
+			remaining := content
+			if remaining == "" {
+				remaining = finalizers
+			}
+			return result, &NamespaceStuckError{Namespace: namespace, Timeout: timeout, Remaining: remaining}
+		}
+		if err := sleepOnClock(h.clock)(ctx, namespacePollInterval); err != nil {
+			return result, err
+		}
+	}
+}
+
+// remainingResources returns the resources listed in the message of the NamespaceContentRemaining condition. Without
+// such a message, the namespace may still wait for the finalizers of its objects, and if the message of the
+// NamespaceFinalizersRemaining condition names matched finalizers, the resources of the discovered ory CRDs are
+// returned.
+func (h *DefaultOryFinalizersHandler) remainingResources(ctx context.Context, content, finalizers string) ([]schema.GroupResource, error) {
+	if resources := remainingContent(content); len(resources) > 0 {
+		return resources, nil
+	}
+	if len(h.matchedFinalizers(remainingFinalizerNames(finalizers))) == 0 {
+		return nil, nil
+	}
+	crds, err := h.discoverOryCRDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]schema.GroupResource, 0, len(crds))
+	for _, crd := range crds {
+		resources = append(resources, schema.GroupResource{Group: crd.Group, Resource: crd.Resource})
+	}
+	return resources, nil
+}
+
+// unblockRemainingContent removes the ory finalizers from all instances of the given resources in the namespace
+func (h *DefaultOryFinalizersHandler) unblockRemainingContent(run *sweepRun, namespace string,
+	resources []schema.GroupResource, result *NamespaceUnblockResult) error {
+	for _, gr := range resources {
+		gvr, err := h.currentClients().restMapper.ResourceFor(gr.WithVersion(""))
+		if err != nil {
+			h.logger.Warnf("Cannot resolve remaining resource \"%s\" of namespace \"%s\": %v", gr.String(), namespace, err)
+			continue
+		}
+		list, err := h.currentClients().dynamic.Resource(gvr).Namespace(namespace).List(run.ctx, metav1.ListOptions{})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return h.checkForbidden(err, "list", gvr.GroupResource(), namespace)
+		}
+		for i := range list.Items {
+			instance := list.Items[i]
+			if len(h.remainingFinalizers(instance.GetFinalizers())) == len(instance.GetFinalizers()) {
+				continue
+			}
+			outcome, err := h.removeInstanceFinalizers(run, gvr, instance)
+			if err != nil {
+				result.Failures = append(result.Failures, h.newResourceFailure(gvr, instance, err))
+				continue
+			}
+			if outcome.removedFinalizers > 0 {
+				unblocked := UnblockedObject{GVR: gvr, Name: instance.GetName(), RemovedFinalizers: outcome.removedFinalizers}
+				h.logger.Infof("Removed %d ory finalizers from %s blocking the deletion of namespace \"%s\"",
+					unblocked.RemovedFinalizers, unblocked, namespace)
+				result.Unblocked = append(result.Unblocked, unblocked)
+			}
+		}
+	}
+	return nil
+}
+
+// remainingConditions returns the messages of the content and finalizers remaining conditions which are true
+func remainingConditions(ns *unstructured.Unstructured) (content, finalizers string, err error) {
+	var typed corev1.Namespace
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(ns.Object, &typed); err != nil {
+		return "", "", errors.Wrapf(err, "failed to read status of namespace \"%s\"", ns.GetName())
+	}
+	for _, condition := range typed.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case corev1.NamespaceContentRemaining:
+			content = condition.Message
+		case corev1.NamespaceFinalizersRemaining:
+			finalizers = condition.Message
+		}
+	}
+	return content, finalizers, nil
+}
+
+// remainingContent parses the resources of a NamespaceContentRemaining message like
+// "Some resources are remaining: oauth2clients.hydra.ory.sh has 2 resource instances, pods. has 1 resource instances"
+func remainingContent(message string) []schema.GroupResource {
+	var resources []schema.GroupResource
+	for _, resource := range conditionEntries(message, " has ") {
+		resources = append(resources, schema.ParseGroupResource(resource))
+	}
+	return resources
+}
+
+// remainingFinalizerNames parses the finalizers of a NamespaceFinalizersRemaining message like
+// "Some content in the namespace has finalizers remaining: finalizer.ory.hydra.sh in 2 resource instances"
+func remainingFinalizerNames(message string) []string {
+	return conditionEntries(message, " in ")
+}
+
+// conditionEntries returns the subjects of the comma separated entries after the colon of a namespace condition
+// message, each of which is followed by the separator and its count
+func conditionEntries(message, separator string) []string {
+	_, list, found := strings.Cut(message, ": ")
+	if !found {
+		return nil
+	}
+	var subjects []string
+	for _, entry := range strings.Split(list, ", ") {
+		subject, _, found := strings.Cut(entry, separator)
+		if !found || subject == "" {
+			continue
+		}
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
@@ -0,0 +1,242 @@
+package k8s
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+const (
+	contentRemaining    = "Some resources are remaining: oauth2clients.hydra.ory.sh has 2 resource instances, pods. has 1 resource instances"
+	finalizersRemaining = "Some content in the namespace has finalizers remaining: finalizer.ory.hydra.sh in 2 resource instances, " +
+		"kubernetes.io/pvc-protection in 1 resource instances"
+)
+
+func Test_UnblockNamespace(t *testing.T) {
+	t.Run("should remove ory finalizers of the remaining content until the namespace is gone", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(namespaceListKinds), withObjects(
+			fixOAuth2Client("kyma-system", "blocking", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("kyma-system", "foreign", "example.com/keep"),
+			fixOAuth2Client("other", "unrelated", "finalizer.ory.hydra.sh")))
+		var updates int32
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(&updates, 1)
+			return false, nil, nil
+		})
+		dyn.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if atomic.LoadInt32(&updates) > 0 {
+				return true, nil, apierr.NewNotFound(namespaceGVR.GroupResource(), "kyma-system")
+			}
+			return true, fixTerminatingNamespace("kyma-system", contentRemaining), nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithClock(newSteppingClock()))
+		handler.restMapper = newNamespaceRESTMapper()
+
+		// when
+		result, err := handler.unblockNamespace(context.Background(), "kyma-system", time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []UnblockedObject{{GVR: oauth2ClientGVR, Name: "blocking", RemovedFinalizers: 1}}, result.Unblocked)
+		require.Empty(t, result.Failures)
+		requireFinalizers(t, dyn, "kyma-system", "blocking")
+		requireFinalizers(t, dyn, "kyma-system", "foreign", "example.com/keep")
+		requireFinalizers(t, dyn, "other", "unrelated", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should give up when the content remains after the timeout", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(namespaceListKinds), withObjects(
+			fixOAuth2Client("kyma-system", "foreign", "example.com/keep")))
+		dyn.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, fixTerminatingNamespace("kyma-system", contentRemaining), nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithClock(newSteppingClock()))
+		handler.restMapper = newNamespaceRESTMapper()
+
+		// when
+		result, err := handler.unblockNamespace(context.Background(), "kyma-system", 10*time.Second)
+
+		// then
+		require.True(t, IsNamespaceStuckError(err))
+		require.Contains(t, err.Error(), `namespace "kyma-system" is still terminating after 10s`)
+		require.Empty(t, result.Unblocked)
+	})
+
+	t.Run("should unblock the ory crds if only the finalizers remaining condition names ory finalizers", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(namespaceListKinds), withObjects(
+			fixOAuth2Client("kyma-system", "blocking", "finalizer.ory.hydra.sh")))
+		var updates int32
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			atomic.AddInt32(&updates, 1)
+			return false, nil, nil
+		})
+		dyn.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if atomic.LoadInt32(&updates) > 0 {
+				return true, fixTerminatingNamespace("kyma-system", ""), nil
+			}
+			ns := fixTerminatingNamespace("kyma-system", "")
+			setNamespaceCondition(t, ns, corev1.NamespaceFinalizersRemaining, finalizersRemaining)
+			return true, ns, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithClock(newSteppingClock()))
+		handler.restMapper = newNamespaceRESTMapper()
+
+		// when
+		result, err := handler.unblockNamespace(context.Background(), "kyma-system", time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []UnblockedObject{{GVR: oauth2ClientGVR, Name: "blocking", RemovedFinalizers: 1}}, result.Unblocked)
+		requireFinalizers(t, dyn, "kyma-system", "blocking")
+	})
+
+	t.Run("should stop waiting when the context is done", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(namespaceListKinds))
+		dyn.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, fixTerminatingNamespace("kyma-system", contentRemaining), nil
+		})
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithClock(fakeClock))
+		handler.restMapper = newNamespaceRESTMapper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error, 1)
+
+		// when
+		go func() {
+			_, err := handler.unblockNamespace(ctx, "kyma-system", time.Hour)
+			done <- err
+		}()
+		require.Eventually(t, fakeClock.HasWaiters, time.Second, time.Millisecond)
+		cancel()
+
+		// then
+		select {
+		case err := <-done:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("unblocking did not stop after the context was cancelled")
+		}
+	})
+
+	t.Run("should return once the remaining content condition cleared", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(namespaceListKinds))
+		dyn.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, fixTerminatingNamespace("kyma-system", ""), nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		result, err := handler.unblockNamespace(context.Background(), "kyma-system", time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, result.Unblocked)
+	})
+
+	t.Run("should reject a namespace which is not being deleted", func(t *testing.T) {
+		// given
+		ns := &unstructured.Unstructured{}
+		ns.SetAPIVersion("v1")
+		ns.SetKind("Namespace")
+		ns.SetName("kyma-system")
+		dyn := newFakeDynamicClient(withListKinds(namespaceListKinds), withObjects(ns))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		_, err := handler.unblockNamespace(context.Background(), "kyma-system", time.Minute)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "is not being deleted")
+	})
+}
+
+func Test_RemainingContent(t *testing.T) {
+	require.Equal(t, []schema.GroupResource{
+		{Group: "hydra.ory.sh", Resource: "oauth2clients"},
+		{Resource: "pods"},
+	}, remainingContent(contentRemaining))
+	require.Empty(t, remainingContent("All content successfully removed"))
+}
+
+func Test_RemainingFinalizerNames(t *testing.T) {
+	require.Equal(t, []string{"finalizer.ory.hydra.sh", "kubernetes.io/pvc-protection"}, remainingFinalizerNames(finalizersRemaining))
+	require.Empty(t, remainingFinalizerNames("All content-preserving finalizers finished"))
+}
+
+var namespaceListKinds = map[schema.GroupVersionResource]string{
+	namespaceGVR: "NamespaceList",
+	corev1.SchemeGroupVersion.WithResource("pods"): "PodList",
+}
+
+func newNamespaceRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(oauth2ClientGVR.GroupVersion().WithKind("OAuth2Client"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	return mapper
+}
+
+// newSteppingClock returns a fake clock whose timers fire at once, by advancing the time to their end
+func newSteppingClock() *steppingClock {
+	return &steppingClock{FakeClock: testingclock.NewFakeClock(time.Now())}
+}
+
+type steppingClock struct {
+	*testingclock.FakeClock
+}
+
+func (c *steppingClock) NewTimer(d time.Duration) clock.Timer {
+	timer := c.FakeClock.NewTimer(d)
+	c.Step(d)
+	return timer
+}
+
+// setNamespaceCondition replaces the conditions of the namespace with the given true condition
+func setNamespaceCondition(t *testing.T, ns *unstructured.Unstructured, conditionType corev1.NamespaceConditionType, message string) {
+	require.NoError(t, unstructured.SetNestedSlice(ns.Object, []interface{}{map[string]interface{}{
+		"type":    string(conditionType),
+		"status":  string(corev1.ConditionTrue),
+		"message": message,
+	}}, "status", "conditions"))
+}
+
+func fixTerminatingNamespace(name, contentMessage string) *unstructured.Unstructured {
+	deleted := metav1.Now()
+	ns := &corev1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, DeletionTimestamp: &deleted},
+		Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+	}
+	if contentMessage != "" {
+		ns.Status.Conditions = []corev1.NamespaceCondition{
+			{Type: corev1.NamespaceContentRemaining, Status: corev1.ConditionTrue, Message: contentMessage},
+			{Type: corev1.NamespaceFinalizersRemaining, Status: corev1.ConditionTrue,
+				Message: "Some content in the namespace has finalizers remaining: finalizer.ory.hydra.sh in 1 resource instances"},
+		}
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ns)
+	if err != nil {
+		panic(err)
+	}
+	return &unstructured.Unstructured{Object: object}
+}
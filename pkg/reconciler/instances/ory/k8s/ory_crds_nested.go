@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"context"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultNestedFinalizerPaths contains the path a faulty ory release stored its finalizers under instead of
+// metadata.finalizers, see WithNestedFinalizerPaths
+var DefaultNestedFinalizerPaths = [][]string{{"status", "finalizers"}}
+
+// nestedRemoval describes the finalizers removed from the nested paths of a resource
+type nestedRemoval struct {
+	removed int
+	// status reports whether a path under status changed, which is written with an update of the status subresource
+	status bool
+	// spec reports whether a path outside of status changed, which is written with the update of the resource
+	spec bool
+}
+
+// removeNestedFinalizers removes the matched finalizers from the nested paths of the resource. A path left without
+// finalizers is removed. Paths which do not hold a list of strings are not touched.
+func (h *DefaultOryFinalizersHandler) removeNestedFinalizers(res *unstructured.Unstructured) nestedRemoval {
+	var removal nestedRemoval
+	for _, path := range h.nestedFinalizerPaths {
+		finalizers, found, err := unstructured.NestedStringSlice(res.Object, path...)
+		if err != nil || !found {
+			continue
+		}
+		remaining := h.remainingFinalizers(finalizers)
+		if len(remaining) == len(finalizers) {
+			continue
+		}
+		if len(remaining) == 0 {
+			unstructured.RemoveNestedField(res.Object, path...)
+		} else if err := unstructured.SetNestedStringSlice(res.Object, remaining, path...); err != nil {
+			continue
+		}
+		removal.removed += len(finalizers) - len(remaining)
+		if path[0] == "status" {
+			removal.status = true
+		} else {
+			removal.spec = true
+		}
+	}
+	return removal
+}
+
+// updateInstanceStatus writes the status of the resource with an update of the status subresource. It returns nil
+// without an error if the subresource does not exist, either because the custom resource definition has none and
+// the status is written with the resource, or because the resource was deleted.
+func (h *DefaultOryFinalizersHandler) updateInstanceStatus(ctx context.Context, crdef schema.GroupVersionResource,
+	res *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if err := h.updateThrottle.wait(ctx, h.clock); err != nil {
+		return nil, err
+	}
+	updated, err := h.instanceResource(crdef, res.GetNamespace()).UpdateStatus(ctx, res, metav1.UpdateOptions{})
+	if apierr.IsNotFound(err) {
+		h.logger.Debugf("Status of ory custom resource \"%s\" is written with the resource", res.GetName())
+		return nil, nil
+	}
+	if err != nil {
+		gr := schema.GroupResource{Group: crdef.Group, Resource: crdef.Resource + "/status"}
+		return nil, h.checkForbidden(err, "update", gr, res.GetNamespace())
+	}
+	return updated, nil
+}
@@ -0,0 +1,121 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_FindAndDeleteOryFinalizers_NestedFinalizers(t *testing.T) {
+	t.Run("should remove ory finalizers from the status with an update of the status", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2ClientWithNestedFinalizers("status", []string{"finalizer.ory.hydra.sh", "example.com/keep"},
+				"finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithNestedFinalizerPaths())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, 1, result.CRDs[0].RemovedNestedFinalizers)
+		requireFinalizers(t, dyn, "default", "client")
+		require.Equal(t, []string{"example.com/keep"}, nestedFinalizers(t, dyn, "status"))
+		require.Equal(t, 1, countUpdates(dyn, "status"))
+	})
+
+	t.Run("should write the status with the resource without a status subresource", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2ClientWithNestedFinalizers("status", []string{"finalizer.ory.hydra.sh"})))
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() == "status" {
+				return true, nil, apierr.NewNotFound(oauth2ClientGVR.GroupResource(), "client")
+			}
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithNestedFinalizerPaths())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.CRDs[0].RemovedNestedFinalizers)
+		require.Nil(t, nestedFinalizers(t, dyn, "status"))
+		require.Equal(t, 1, countUpdates(dyn, ""))
+	})
+
+	t.Run("should write other paths with the resource", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2ClientWithNestedFinalizers("spec", []string{"finalizer.ory.hydra.sh"})))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithNestedFinalizerPaths([]string{"spec", "finalizers"}))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.CRDs[0].RemovedNestedFinalizers)
+		require.Nil(t, nestedFinalizers(t, dyn, "spec"))
+		require.Equal(t, 0, countUpdates(dyn, "status"))
+		require.Equal(t, 1, countUpdates(dyn, ""))
+	})
+
+	t.Run("should not touch nested paths by default", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2ClientWithNestedFinalizers("status", []string{"finalizer.ory.hydra.sh"})))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, result.CRDs[0].RemovedNestedFinalizers)
+		require.Equal(t, []string{"finalizer.ory.hydra.sh"}, nestedFinalizers(t, dyn, "status"))
+		require.Zero(t, countUpdates(dyn, "status")+countUpdates(dyn, ""))
+	})
+}
+
+// fixOAuth2ClientWithNestedFinalizers returns the oauth2client "default/client" with the nested finalizers under
+// the field finalizers of the given top-level field
+func fixOAuth2ClientWithNestedFinalizers(field string, nested []string, finalizers ...string) *unstructured.Unstructured {
+	res := fixOAuth2Client("default", "client", finalizers...)
+	res.SetResourceVersion("1")
+	if err := unstructured.SetNestedStringSlice(res.Object, nested, field, "finalizers"); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func nestedFinalizers(t *testing.T, dyn *dynamicfake.FakeDynamicClient, field string) []string {
+	res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+	require.NoError(t, err)
+	finalizers, _, err := unstructured.NestedStringSlice(res.Object, field, "finalizers")
+	require.NoError(t, err)
+	return finalizers
+}
+
+// countUpdates returns the number of updates of the subresource, or of the resource with an empty subresource
+func countUpdates(dyn *dynamicfake.FakeDynamicClient, subresource string) int {
+	count := 0
+	for _, action := range dyn.Actions() {
+		if action.GetVerb() == "update" && action.GetSubresource() == subresource {
+			count++
+		}
+	}
+	return count
+}
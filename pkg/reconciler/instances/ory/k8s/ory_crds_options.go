@@ -0,0 +1,497 @@
+package k8s
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/utils/clock"
+)
+
+// Option configures a DefaultOryFinalizersHandler
+type Option func(*DefaultOryFinalizersHandler)
+
+// WithListAttempts sets how often the lookup of the ory CRD and the listing of its instances is attempted
+// when the API server responds with a transient error
+func WithListAttempts(attempts int) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.listAttempts = attempts
+	}
+}
+
+// WithFinalizerMatcher sets the expression deciding which finalizers are removed from ory custom resources.
+// Finalizers not matching the expression are preserved. Defaults to DefaultFinalizerPattern.
+func WithFinalizerMatcher(matcher *regexp.Regexp) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		if matcher != nil {
+			h.finalizerMatcher = matcher
+		}
+	}
+}
+
+// WithLogger sets the logger used by the handler. Without a logger, log output is discarded.
+func WithLogger(logger Logger) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.logger = logger
+	}
+}
+
+// WithRemovedFinalizersAnnotation enables recording the removed finalizers and the time of removal as JSON in the
+// RemovedFinalizersAnnotation of the resource, written by the same update which removes the finalizers
+func WithRemovedFinalizersAnnotation(enabled bool) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.annotateRemovedFinalizers = enabled
+	}
+}
+
+// WithProgress registers a callback which is invoked after each processed custom resource with the number of
+// processed resources and the number of resources found so far. The total grows while further resources are listed.
+func WithProgress(progress func(done, total int)) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.progress = progress
+	}
+}
+
+// WithKubeconfigContext selects the context of the kubeconfig used to connect to the cluster.
+// Without a context, the current context of the kubeconfig is used.
+func WithKubeconfigContext(name string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.kubeconfigContext = name
+	}
+}
+
+// WithKubeconfigCluster connects to the given cluster of the kubeconfig, using the credentials of the selected context.
+// This allows targeting any cluster of a multi-cluster kubeconfig without editing it first.
+func WithKubeconfigCluster(name string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.kubeconfigCluster = name
+	}
+}
+
+// WithKubeconfigPaths reads the kubeconfig from files whenever no kubeconfig content is passed to the handler.
+// Multiple files are merged like the KUBECONFIG environment variable and relative file references, like
+// certificates, are resolved relative to the file containing them.
+func WithKubeconfigPaths(paths ...string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.kubeconfigPaths = append([]string(nil), paths...)
+	}
+}
+
+// WithImpersonation lets all requests of the handler impersonate the given identity, including its UID, groups and
+// extra fields. The user name is mandatory as the API server rejects impersonating without a user.
+func WithImpersonation(impersonation rest.ImpersonationConfig) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		impersonation.Groups = append([]string(nil), impersonation.Groups...)
+		if impersonation.Extra != nil {
+			extra := make(map[string][]string, len(impersonation.Extra))
+			for key, values := range impersonation.Extra {
+				extra[key] = append([]string(nil), values...)
+			}
+			impersonation.Extra = extra
+		}
+		h.impersonation = &impersonation
+	}
+}
+
+// WithSuppressedWarnings discards warnings sent by the API server instead of logging them and
+// reporting them in the CleanupResult
+func WithSuppressedWarnings(suppress bool) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.suppressWarnings = suppress
+	}
+}
+
+// WithWrapTransport wraps the transport of every client built by the handler, e.g. to log, measure or proxy the
+// requests. The wrapper is chained behind a wrapper of the kubeconfig and runs inside the circuit breaker, so requests
+// rejected by an open circuit do not reach it. It is not applied to the clients of WithClientProvider.
+func WithWrapTransport(wrapper transport.WrapperFunc) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.wrapTransport = wrapper
+	}
+}
+
+// WithContentType sets the content type the typed clients built by the handler negotiate with the API server,
+// runtime.ContentTypeProtobuf by default. runtime.ContentTypeJSON keeps them on JSON, e.g. for proxies which inspect
+// the requests. Custom resources are always read and written as JSON. It is not applied to the clients of
+// WithClientProvider.
+func WithContentType(contentType string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.contentType = contentType
+	}
+}
+
+// WithUpdateInterval enforces a minimum interval between successive updates of custom resources, extended by a
+// random jitter of up to jitter*interval. This reduces the load on fragile control planes. Disabled by default.
+func WithUpdateInterval(interval time.Duration, jitter float64) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.updateThrottle = &updateThrottle{interval: interval, jitter: jitter}
+	}
+}
+
+// WithRequestTimeout sets the maximum duration of a single request to the API server.
+// Defaults to 30 seconds, zero disables the timeout.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.requestTimeout = timeout
+	}
+}
+
+// WithDialTimeout sets the maximum duration for establishing a connection to the API server.
+// Without it, the client-go default applies.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.dialTimeout = timeout
+	}
+}
+
+// WithOrphanedOwnerReferences makes the handler detect owner references of custom resources whose referent no longer
+// exists and report them in the CleanupResult. If remove is set, they are also removed from the custom resource
+// together with its finalizers.
+func WithOrphanedOwnerReferences(remove bool) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.checkOwnerReferences = true
+		h.removeOrphanedOwnerReferences = remove
+	}
+}
+
+// WithUserAgent replaces the default User-Agent "kyma-reconciler/ory-cleanup/<version>" of all requests
+func WithUserAgent(userAgent string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.userAgent = userAgent
+	}
+}
+
+// WithUserAgentSuffix appends a suffix like the reconciliation ID to the User-Agent of all requests,
+// which allows attributing the requests in the audit logs of the API server
+func WithUserAgentSuffix(suffix string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.userAgentSuffix = suffix
+	}
+}
+
+// WithCRDEstablishedWait waits up to the given timeout for the custom resource definition to be established before
+// listing its instances, which avoids failing right after the custom resource definition was installed. The mode
+// decides what happens to a definition which is not established in time. Defaults to DefaultCRDEstablishedTimeout
+// and UnestablishedCRDSkip, a timeout of zero disables the wait.
+func WithCRDEstablishedWait(timeout time.Duration, mode UnestablishedCRDMode) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.establishedTimeout = timeout
+		h.unestablishedMode = mode
+	}
+}
+
+// WithCABundle replaces the CA bundle of the cluster with the given PEM encoded certificates,
+// for example when the CA in a stored kubeconfig is stale after a cluster migration
+func WithCABundle(caPEM []byte) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.caData = append([]byte(nil), caPEM...)
+	}
+}
+
+// WithTLSServerName sets the server name used to verify the certificate of the API server
+func WithTLSServerName(serverName string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.tlsServerName = serverName
+	}
+}
+
+// WithClock replaces the clock used for backoffs, intervals, timeouts and timings, which allows tests to
+// advance time deterministically. Defaults to the real clock.
+func WithClock(clock clock.Clock) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.clock = clock
+	}
+}
+
+// WithExcludedNamespaces skips all instances in the given namespaces, for example to protect a namespace in which
+// ory intentionally keeps running. The namespaces are excluded server-side by a field selector, so CRDResult.Skipped
+// only counts excluded instances if the API server rejected the selector.
+func WithExcludedNamespaces(namespaces ...string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.excludedNamespaces = make(map[string]bool, len(namespaces))
+		for _, namespace := range namespaces {
+			h.excludedNamespaces[namespace] = true
+		}
+	}
+}
+
+// WithConnectRetryTimeout keeps retrying the first request of a run with backoff for up to the given duration while
+// the API server cannot be reached, for example during a control plane upgrade. Invalid kubeconfigs and other
+// permanent errors are never retried. Disabled by default.
+func WithConnectRetryTimeout(timeout time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.connectRetryTimeout = timeout
+	}
+}
+
+// WithRateLimiter throttles all requests of the handler with the given rate limiter instead of a limiter per client.
+// Passing the same limiter to multiple handlers limits their aggregate request rate.
+func WithRateLimiter(limiter flowcontrol.RateLimiter) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.rateLimiter = limiter
+	}
+}
+
+// WithSweepLock serializes runs against the same cluster within the process, which prevents concurrent
+// reconciliations from conflicting on the same resources. The mode defines whether a second run waits or fails.
+// Clusters are identified by the host of their API server, runs with a client provider which has no Host method
+// are not serialized.
+func WithSweepLock(mode SweepLockMode) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.sweepLockMode = mode
+	}
+}
+
+// WithGracePeriod sets how long a resource must have been terminating before its finalizers are removed,
+// which gives the owning controller the chance to finish its own cleanup. Younger terminating resources are
+// reported as CRDResult.PendingGrace. Resources which are not terminating are not affected. Defaults to no grace period.
+func WithGracePeriod(gracePeriod time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.gracePeriod = gracePeriod
+	}
+}
+
+// WithMinimumAge skips resources created less than the given age ago, which keeps the sweep from racing with an
+// installation which is still in progress. Skipped resources are reported as CRDResult.TooYoung. Unlike
+// WithGracePeriod it applies to all resources, terminating or not. Defaults to no minimum age.
+func WithMinimumAge(age time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.minAge = age
+	}
+}
+
+// WithTerminatingOnly restricts the removal of finalizers to resources which are being deleted,
+// other resources are reported as CRDResult.Skipped
+func WithTerminatingOnly() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.terminatingOnly = true
+	}
+}
+
+// WithDeleteCRDWhenEmpty deletes the custom resource definition after its finalizers were removed,
+// provided that no instances of it are left
+func WithDeleteCRDWhenEmpty() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.deleteCRDWhenEmpty = true
+	}
+}
+
+// WithPropagationPolicy sets how the dependents of the objects deleted by the handler are deleted, i.e. of the
+// custom resource definitions of WithDeleteCRDWhenEmpty and of the instances of DeleteAllInstancesOf without a policy
+// of their own. Foreground deletes the dependents before their owner, Orphan keeps them. Defaults to Background,
+// like kubectl.
+func WithPropagationPolicy(policy metav1.DeletionPropagation) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.propagationPolicy = policy
+	}
+}
+
+// WithInformerCache reads the instances of repeated sweeps and counts from shared informers, which are started on
+// the first read and keep a cache of all ory custom resources until Close is called. Finalizers are still removed
+// directly on the API server, and RunUntil keeps its own watch. A resync period of 0 disables resyncs.
+func WithInformerCache(resync time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.informerCacheEnabled = true
+		h.informerResync = resync
+	}
+}
+
+// WithRequireCRD returns a CRDNotFoundError if a swept CRD does not exist, instead of skipping its cleanup,
+// for flows in which a missing CRD indicates a misconfiguration
+func WithRequireCRD() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.requireCRD = true
+	}
+}
+
+// WithResourceValidation checks that the API server serves the resources of all swept CRDs before anything is
+// modified, and returns a ResourceNotFoundError for the first one which is not served, so that a misspelled CRD of
+// WithCRDs fails the run right away instead of being skipped
+func WithResourceValidation() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.validateResources = true
+	}
+}
+
+// WithCRDs sweeps the instances of the given custom resource definitions instead of only the oauth2clients CRD,
+// e.g. "virtualservices.networking.istio.io" for the virtual services created for ory. The CRDs are swept sorted by
+// name with the same logic, and only finalizers accepted by the finalizer matcher are removed, so objects
+// of other groups usually also need WithFinalizerMatcher. The CRDs also count to the discovered ory CRDs, which
+// are watched by RunUntil and counted by CountStuckOryResources.
+func WithCRDs(names ...string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.crdNames = append([]string(nil), names...)
+	}
+}
+
+// WithDiscoveryAllowlist restricts the discovered ory CRDs to the named custom resource definitions, e.g.
+// "oauth2clients.hydra.ory.sh", which scopes DiscoverOryCRDs, RunUntil and CountStuckOryResources. The CRDs of
+// WithCRDs are discovered anyway. It must not be combined with WithDiscoveryDenylist.
+func WithDiscoveryAllowlist(names ...string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.discoveryAllowlist = crdNameSet(names)
+	}
+}
+
+// WithDiscoveryDenylist discovers all ory CRDs except the named custom resource definitions, see
+// WithDiscoveryAllowlist. The CRDs of WithCRDs are discovered anyway.
+func WithDiscoveryDenylist(names ...string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.discoveryDenylist = crdNameSet(names)
+	}
+}
+
+func crdNameSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// WithHydraMaesterScaleDown scales the hydra-maester deployment in the given namespace to zero replicas and waits
+// up to the given timeout for its pods to terminate before any finalizer is removed, because a running hydra-maester
+// adds its finalizer again. A missing deployment is skipped. The deployment stays scaled down, unless
+// WithHydraMaesterScaleRestored is set.
+func WithHydraMaesterScaleDown(namespace string, timeout time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.scaleDownNamespace = namespace
+		h.scaleDownTimeout = timeout
+	}
+}
+
+// WithHydraMaesterScaleRestored restores the original replicas of the deployment scaled down by
+// WithHydraMaesterScaleDown after the run, also if the run failed
+func WithHydraMaesterScaleRestored() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.restoreScale = true
+	}
+}
+
+// WithVerification lists the instances of all processed custom resource definitions again after a successful sweep
+// and sets CleanupResult.Verified if none of them has matched finalizers left, e.g. because a controller added them
+// again. The instances still having finalizers are reported in CleanupResult.Unverified. This doubles the list
+// requests of a run.
+func WithVerification() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.verifyRemoval = true
+	}
+}
+
+// WithBackup writes the spec and identifying metadata of all instances to secrets in the given namespace before any
+// finalizer is removed. If the backup fails, the run returns a BackupFailedError without modifying the instances.
+func WithBackup(namespace string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.backupNamespace = namespace
+	}
+}
+
+// WithBackupFailureIgnored removes the finalizers even if the backup configured by WithBackup failed
+func WithBackupFailureIgnored() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.ignoreBackupFailure = true
+	}
+}
+
+// WithConflictRetryBudget caps the conflict retries of all custom resources of a run to the given number, to keep a
+// large number of contended resources from multiplying the requests to the API server. Once the budget is used up,
+// resources are updated only once and fail with a RetryBudgetExhaustedError on a conflict. Unlimited by default.
+func WithConflictRetryBudget(retries int) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.conflictRetryLimit = retries
+		h.conflictRetriesLimited = true
+	}
+}
+
+// WithAPIExtensionsVersion reads the custom resource definitions with the given version of apiextensions.k8s.io,
+// APIExtensionsV1 or APIExtensionsV1beta1, instead of the newest version found by discovery. This bypasses discovery
+// when it misbehaves, like during an upgrade of the control plane. If the version is not served, the run fails with
+// an APIExtensionsVersionNotServedError.
+func WithAPIExtensionsVersion(version string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.apiExtensionsVersion = version
+	}
+}
+
+// WithCircuitBreaker stops sending requests to the API server after the given number of consecutive failed requests,
+// those which could not be sent or were answered with a server error or too many requests. Until the cool-down has
+// passed, requests fail with ErrCircuitOpen and the run stops at the first of them. Afterwards a single request is
+// sent, which closes the circuit again if it succeeds. Disabled by default.
+func WithCircuitBreaker(failures int, coolDown time.Duration) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.breaker = newCircuitBreaker(failures, coolDown)
+	}
+}
+
+// WithCircuitBreakerMetrics exposes the state of the circuit breaker of WithCircuitBreaker as the gauge
+// reconciler_ory_finalizers_circuit_breaker_state, 0 closed, 1 half-open and 2 open, in the given registerer. The
+// gauge is labelled with the API server host of the cluster, and reported once the clients of the handler are built.
+func WithCircuitBreakerMetrics(registerer prometheus.Registerer) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.breakerRegisterer = registerer
+	}
+}
+
+// WithFinalizerPatch removes the finalizers with a merge patch of the metadata instead of an update of the whole
+// resource. The patch carries the resourceVersion of the preceding read as precondition, so a resource changed in
+// between is read again instead of being overwritten. Resources without a resourceVersion fail.
+func WithFinalizerPatch() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.patchFinalizers = true
+	}
+}
+
+// WithModifiedObjects collects the instances whose finalizers or owner references were changed, as returned by the
+// API server, in CRDResult.Modified. This saves callers fetching them again, e.g. to audit the changes, but keeps a
+// full copy of every changed instance in memory until the result is dropped, which adds up on large sweeps.
+func WithModifiedObjects() Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.collectModified = true
+	}
+}
+
+// WithClientProvider takes the clients from the provider instead of building them from the kubeconfig passed to the
+// calls, which is ignored then. Clients whose credentials are rejected are not rebuilt.
+func WithClientProvider(provider ClientProvider) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.clientProvider = provider
+	}
+}
+
+// RemovalPredicate decides whether the finalizers of a custom resource are removed, see WithRemovalPredicate.
+// The reason explains a rejection.
+type RemovalPredicate func(obj unstructured.Unstructured) (remove bool, reason string)
+
+// WithRemovalPredicate removes the finalizers only from the custom resources accepted by the predicate, e.g. to
+// keep those with an annotation. The predicate is called after the other checks with the latest version of the
+// resource including its spec, and the finalizer matcher still decides which of its finalizers are removed.
+// Rejected resources are skipped and recorded with the reason in CRDResult.Rejected, and not verified.
+func WithRemovalPredicate(predicate RemovalPredicate) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		h.removalPredicate = predicate
+	}
+}
+
+// WithNestedFinalizerPaths removes matched finalizers also from lists of strings under the nested paths of the
+// instances, DefaultNestedFinalizerPaths without paths. This works around an ory release which stored its finalizers
+// under status, where they are not seen by the API server, yet keep the resource from being cleaned up by ory.
+// Paths under status are written with an update of the status subresource, others with the resource, which
+// WithFinalizerPatch does not support.
+func WithNestedFinalizerPaths(paths ...[]string) Option {
+	return func(h *DefaultOryFinalizersHandler) {
+		if len(paths) == 0 {
+			paths = DefaultNestedFinalizerPaths
+		}
+		h.nestedFinalizerPaths = make([][]string, 0, len(paths))
+		for _, path := range paths {
+			if len(path) > 0 {
+				h.nestedFinalizerPaths = append(h.nestedFinalizerPaths, append([]string(nil), path...))
+			}
+		}
+	}
+}
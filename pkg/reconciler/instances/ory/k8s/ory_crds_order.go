@@ -0,0 +1,34 @@
+package k8s
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// sortInstances sorts the listed instances by namespace, then name, so that runs against the same cluster process,
+// log and report them in the same order whatever order the API server or the informer cache returns
+func sortInstances(list *unstructured.UnstructuredList) {
+	if list == nil {
+		return
+	}
+	sort.SliceStable(list.Items, func(i, j int) bool {
+		a, b := list.Items[i], list.Items[j]
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+}
+
+// sortedCRDNames returns a sorted copy of the names of custom resource definitions
+func sortedCRDNames(names []string) []string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// sortOryCRDs sorts the discovered CRDs by name
+func sortOryCRDs(crds []OryCRD) {
+	sort.Slice(crds, func(i, j int) bool { return crds[i].Name < crds[j].Name })
+}
@@ -0,0 +1,140 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_FindAndDeleteOryFinalizers_Order(t *testing.T) {
+	shuffled := []*unstructured.Unstructured{
+		fixOAuth2Client("kyma-system", "b", "finalizer.ory.hydra.sh"),
+		fixOAuth2Client("default", "c", "finalizer.ory.hydra.sh"),
+		fixOAuth2Client("kyma-system", "a", "finalizer.ory.hydra.sh"),
+		fixOAuth2Client("default", "a", "finalizer.ory.hydra.sh"),
+	}
+	sorted := []string{"default/a", "default/c", "kyma-system/a", "kyma-system/b"}
+
+	t.Run("should process a shuffled list sorted by namespace and name", func(t *testing.T) {
+		// given
+		core, logs := observer.New(zapcore.DebugLevel)
+		dyn := newFakeDynamicClient(withListOrder(shuffled...))
+		handler := newStreamTestHandler(t, dyn, WithLogger(zap.New(core).Sugar()))
+
+		// when
+		results, errs := handler.StreamOryFinalizers(context.Background(), "")
+		var streamed []string
+		for result := range results {
+			streamed = append(streamed, result.Namespace+"/"+result.Name)
+		}
+
+		// then
+		require.NoError(t, <-errs)
+		require.Equal(t, sorted, streamed)
+		var logged []string
+		for _, entry := range logs.FilterMessageSnippet("Deleted ory finalizer").All() {
+			logged = append(logged, entry.Message)
+		}
+		require.Equal(t, []string{
+			`Deleted ory finalizer for "a" OAuth2Client`,
+			`Deleted ory finalizer for "c" OAuth2Client`,
+			`Deleted ory finalizer for "a" OAuth2Client`,
+			`Deleted ory finalizer for "b" OAuth2Client`,
+		}, logged)
+	})
+
+	t.Run("should report the failures sorted by namespace and name", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListOrder(shuffled...))
+		failFirstCalls(dyn, "update", 100, apierr.NewBadRequest("rejected by webhook"))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		var failed []string
+		for _, failure := range result.Failures() {
+			failed = append(failed, failure.Namespace+"/"+failure.Name)
+		}
+		require.Equal(t, sorted, failed)
+	})
+
+	t.Run("should sort the instances of the informer cache", func(t *testing.T) {
+		// given
+		objects := make([]runtime.Object, 0, len(shuffled))
+		for _, instance := range shuffled {
+			objects = append(objects, instance)
+		}
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient(withObjects(
+			objects...)), WithInformerCache(0))
+		defer handler.Close()
+
+		// when
+		list, err := handler.cachedInstances(context.Background(), oauth2ClientGVR)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, sorted, instanceKeys(list))
+	})
+
+	t.Run("should sort the instances listed as metadata", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient())
+		handler.metadata = newFakeMetadataClient(
+			fixOAuth2ClientMetadata("kyma-system", "b"),
+			fixOAuth2ClientMetadata("default", "c"),
+			fixOAuth2ClientMetadata("kyma-system", "a"),
+			fixOAuth2ClientMetadata("default", "a"))
+
+		// when
+		list, err := handler.listInstanceMetadata(context.Background(), oauth2ClientGVR)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, sorted, instanceKeys(list))
+	})
+}
+
+func Test_DiscoverOryCRDs_Order(t *testing.T) {
+	t.Run("should return the discovered crds sorted by name", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset()
+		apix.PrependReactor("list", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, &apixv1beta1.CustomResourceDefinitionList{Items: []apixv1beta1.CustomResourceDefinition{
+				*fixCRD("rules", "oathkeeper.ory.sh", "v1alpha1"), *fixOAuth2ClientCRD(), *fixCRD("jwks", "hydra.ory.sh", "v1alpha1"),
+			}}, nil
+		})
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		var names []string
+		for _, crd := range crds {
+			names = append(names, crd.Name)
+		}
+		require.Equal(t, []string{"jwks.hydra.ory.sh", oauth2ClientCRD, "rules.oathkeeper.ory.sh"}, names)
+	})
+}
+
+func instanceKeys(list *unstructured.UnstructuredList) []string {
+	keys := make([]string, 0, len(list.Items))
+	for _, instance := range list.Items {
+		keys = append(keys, instance.GetNamespace()+"/"+instance.GetName())
+	}
+	return keys
+}
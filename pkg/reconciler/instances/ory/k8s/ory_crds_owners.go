@@ -0,0 +1,73 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// orphanedOwnerReferences returns the owner references of the resource whose referent no longer exists.
+// A referent counts as gone if its kind is no longer served, it cannot be found or it was recreated with another UID.
+func (h *DefaultOryFinalizersHandler) orphanedOwnerReferences(ctx context.Context, res *unstructured.Unstructured) ([]metav1.OwnerReference, error) {
+	var orphaned []metav1.OwnerReference
+	for _, ref := range res.GetOwnerReferences() {
+		gone, err := h.isOwnerGone(ctx, res.GetNamespace(), ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check owner %s \"%s\"", ref.Kind, ref.Name)
+		}
+		if gone {
+			orphaned = append(orphaned, ref)
+		}
+	}
+	return orphaned, nil
+}
+
+func (h *DefaultOryFinalizersHandler) isOwnerGone(ctx context.Context, namespace string, ref metav1.OwnerReference) (bool, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false, err
+	}
+	mapping, err := h.currentClients().restMapper.RESTMapping(gv.WithKind(ref.Kind).GroupKind(), gv.Version)
+	if meta.IsNoMatchError(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// owners are either in the namespace of their child or cluster-scoped
+	resource := h.currentClients().dynamic.Resource(mapping.Resource)
+	var owner *unstructured.Unstructured
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		namespace = ""
+	}
+	owner, err = resource.Namespace(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, h.checkForbidden(err, "get", mapping.Resource.GroupResource(), namespace)
+	}
+	return owner.GetUID() != ref.UID, nil
+}
+
+// withoutOwnerReferences returns the owner references which are not contained in removed
+func withoutOwnerReferences(refs, removed []metav1.OwnerReference) []metav1.OwnerReference {
+	drop := make(map[types.UID]bool, len(removed))
+	for _, ref := range removed {
+		drop[ref.UID] = true
+	}
+	var kept []metav1.OwnerReference
+	for _, ref := range refs {
+		if !drop[ref.UID] {
+			kept = append(kept, ref)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,59 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// patchInstance writes the metadata changed by the removal with a merge patch instead of an update, see
+// WithFinalizerPatch. The resourceVersion of the read is part of the patch, so that the API server rejects it
+// with a conflict if the resource changed since, and the conflict retry reads it again.
+func (h *DefaultOryFinalizersHandler) patchInstance(ctx context.Context, crdef schema.GroupVersionResource,
+	res *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	patch, err := finalizersPatch(res)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := h.instanceResource(crdef, res.GetNamespace()).
+		Patch(ctx, res.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierr.IsNotFound(err) {
+		h.logger.Debugf("Ory custom resource \"%s\" was deleted before its finalizers were removed", res.GetName())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, h.checkForbidden(err, "patch", crdef.GroupResource(), res.GetNamespace())
+	}
+	return patched, nil
+}
+
+// finalizersPatch replaces the finalizers and owner references with those of the resource, and sets the
+// RemovedFinalizersAnnotation if present. Custom resources do not support strategic merge patches, so lists are
+// replaced as a whole, which the resourceVersion precondition makes safe.
+func finalizersPatch(res *unstructured.Unstructured) ([]byte, error) {
+	if res.GetResourceVersion() == "" {
+		return nil, errors.Errorf("ory custom resource \"%s\" has no resourceVersion to patch it conditionally", res.GetName())
+	}
+	finalizers := res.GetFinalizers()
+	if finalizers == nil {
+		finalizers = []string{}
+	}
+	// owner references removed down to none are dropped from the resource, null removes them with the patch
+	ownerReferences, _, _ := unstructured.NestedFieldNoCopy(res.Object, "metadata", "ownerReferences")
+	metadata := map[string]interface{}{
+		"resourceVersion": res.GetResourceVersion(),
+		"finalizers":      finalizers,
+		"ownerReferences": ownerReferences,
+	}
+	if record, ok := res.GetAnnotations()[RemovedFinalizersAnnotation]; ok {
+		metadata["annotations"] = map[string]string{RemovedFinalizersAnnotation: record}
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	return patch, errors.Wrap(err, "failed to encode finalizers patch")
+}
@@ -0,0 +1,154 @@
+package k8s
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_ResourceVersionPrecondition(t *testing.T) {
+	t.Run("should retry a patch rejected after a concurrent modification", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "hydra.ory.sh/finalizer", "other.io/finalizer")
+		instance.SetResourceVersion("1")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		writes := enforceResourceVersion(t, dyn)
+		modifyAfterList(t, dyn)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithFinalizerPatch())
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{"1", "2"}, *writes)
+		requireFinalizers(t, dyn, "default", "client", "other.io/finalizer")
+		requireAnnotation(t, dyn, "edited-by", "someone")
+	})
+
+	t.Run("should retry an update rejected after a concurrent modification", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "hydra.ory.sh/finalizer")
+		instance.SetResourceVersion("1")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		writes := enforceResourceVersion(t, dyn)
+		modifyAfterList(t, dyn)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{"1", "2"}, *writes)
+		requireFinalizers(t, dyn, "default", "client")
+		requireAnnotation(t, dyn, "edited-by", "someone")
+	})
+
+	t.Run("should patch the finalizers, owner references and removal record conditionally", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "other.io/finalizer")
+		instance.SetResourceVersion("42")
+		instance.SetAnnotations(map[string]string{"kept": "yes", RemovedFinalizersAnnotation: `{"finalizers":["hydra.ory.sh/finalizer"]}`})
+
+		// when
+		patch, err := finalizersPatch(instance)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, `{"metadata":{"annotations":{"reconciler.kyma-project.io/removed-finalizers":`+
+			`"{\"finalizers\":[\"hydra.ory.sh/finalizer\"]}"},"finalizers":["other.io/finalizer"],`+
+			`"ownerReferences":null,"resourceVersion":"42"}}`, string(patch))
+	})
+
+	t.Run("should patch the finalizers to an empty list", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client")
+		instance.SetResourceVersion("42")
+		instance.SetOwnerReferences(nil)
+
+		// when
+		patch, err := finalizersPatch(instance)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, `{"metadata":{"finalizers":[],"ownerReferences":null,"resourceVersion":"42"}}`, string(patch))
+	})
+
+	t.Run("should refuse to patch without a resourceVersion", func(t *testing.T) {
+		// when
+		_, err := finalizersPatch(fixOAuth2Client("default", "client"))
+
+		// then
+		require.EqualError(t, err, `ory custom resource "client" has no resourceVersion to patch it conditionally`)
+	})
+}
+
+// enforceResourceVersion rejects updates and patches of oauth2clients with a conflict unless they carry the stored
+// resourceVersion, like the API server. It returns the resourceVersions of all writes.
+func enforceResourceVersion(t *testing.T, dyn *dynamicfake.FakeDynamicClient) *[]string {
+	var writes []string
+	check := func(namespace, name, resourceVersion string) error {
+		writes = append(writes, resourceVersion)
+		stored, err := dyn.Tracker().Get(oauth2ClientGVR, namespace, name)
+		require.NoError(t, err)
+		if stored.(*unstructured.Unstructured).GetResourceVersion() != resourceVersion {
+			return apierr.NewConflict(oauth2ClientGVR.GroupResource(), name, nil)
+		}
+		return nil
+	}
+	dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured)
+		if err := check(action.GetNamespace(), obj.GetName(), obj.GetResourceVersion()); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	dyn.PrependReactor("patch", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		var patch struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		require.NoError(t, json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch))
+		if err := check(action.GetNamespace(), action.(k8stesting.PatchAction).GetName(), patch.Metadata.ResourceVersion); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	return &writes
+}
+
+// modifyAfterList lets another client annotate the oauth2client right after the handler listed it, which bumps its
+// resourceVersion to 2
+func modifyAfterList(t *testing.T, dyn *dynamicfake.FakeDynamicClient) {
+	lists := 0
+	dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		if lists > 1 {
+			return false, nil, nil
+		}
+		listed, err := dyn.Tracker().List(oauth2ClientGVR, oauth2ClientGVR.GroupVersion().WithKind("OAuth2Client"), action.GetNamespace())
+		require.NoError(t, err)
+		stored, err := dyn.Tracker().Get(oauth2ClientGVR, "default", "client")
+		require.NoError(t, err)
+		modified := stored.(*unstructured.Unstructured)
+		modified.SetAnnotations(map[string]string{"edited-by": "someone"})
+		modified.SetResourceVersion("2")
+		require.NoError(t, dyn.Tracker().Update(oauth2ClientGVR, modified, "default"))
+		return true, listed, nil
+	})
+}
+
+func requireAnnotation(t *testing.T, dyn *dynamicfake.FakeDynamicClient, key, expected string) {
+	stored, err := dyn.Tracker().Get(oauth2ClientGVR, "default", "client")
+	require.NoError(t, err)
+	require.Equal(t, expected, stored.(*unstructured.Unstructured).GetAnnotations()[key])
+}
@@ -0,0 +1,25 @@
+package k8s
+
+// progressReporter counts processed resources of a run and forwards the progress to a callback.
+// A nil reporter ignores all calls.
+type progressReporter struct {
+	report      func(done, total int)
+	done, total int
+}
+
+func (p *progressReporter) addTotal(n int) {
+	if p == nil {
+		return
+	}
+	p.total += n
+}
+
+func (p *progressReporter) increment() {
+	if p == nil {
+		return
+	}
+	p.done++
+	if p.report != nil {
+		p.report(p.done, p.total)
+	}
+}
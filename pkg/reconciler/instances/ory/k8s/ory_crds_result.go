@@ -0,0 +1,132 @@
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// CleanupResult summarizes a run of FindAndDeleteOryFinalizers
+type CleanupResult struct {
+	// Elapsed is the total duration of the run
+	Elapsed time.Duration
+	// CRDs contains the results of each custom resource definition which was processed
+	CRDs []CRDResult
+	// Warnings contains the distinct warnings sent by the API server during the run
+	Warnings []string
+	// Verified reports whether no targeted instance had matched finalizers when listed again after the sweep.
+	// It is only set with WithVerification.
+	Verified bool
+	// Unverified contains the instances which still had matched finalizers, see WithVerification
+	Unverified []UnverifiedResource
+}
+
+// CRDResult summarizes the finalizer removal of all instances of a single custom resource definition
+type CRDResult struct {
+	GVR schema.GroupVersionResource
+	// ListDuration is the time spent on listing the instances
+	ListDuration time.Duration
+	// UpdateDuration is the time spent on removing finalizers from the listed instances
+	UpdateDuration time.Duration
+	// Instances is the number of listed instances
+	Instances int
+	// Skipped is the number of instances in excluded namespaces, not terminating or rejected by the removal
+	// predicate, see WithExcludedNamespaces, WithTerminatingOnly and WithRemovalPredicate
+	Skipped int
+	// PendingGrace is the number of terminating instances whose grace period is not over yet, see WithGracePeriod
+	PendingGrace int
+	// TooYoung is the number of instances created less than the minimum age ago, see WithMinimumAge
+	TooYoung int
+	// BackupSecrets contains the names of the secrets the instances were backed up to, see WithBackup
+	BackupSecrets []string
+	// NotEstablished reports whether the instances were skipped as the custom resource definition was not
+	// established in time, see WithCRDEstablishedWait
+	NotEstablished bool
+	// CRDDeleted reports whether the custom resource definition was deleted, see WithDeleteCRDWhenEmpty
+	CRDDeleted bool
+	// RemovedFinalizers is the number of finalizers removed from all instances
+	RemovedFinalizers int
+	// RemovedNestedFinalizers is the number of finalizers removed from the nested paths of all instances,
+	// see WithNestedFinalizerPaths
+	RemovedNestedFinalizers int
+	// Failures contains the instances whose finalizers could not be removed
+	Failures []ResourceFailure
+	// RetryBudgetExhausted reports whether instances failed on a conflict which was not retried because the
+	// budget of the run was used up, see WithConflictRetryBudget and CleanupErrors.Contended
+	RetryBudgetExhausted bool
+	// OrphanedOwnerReferences contains the owner references pointing at deleted objects, see WithOrphanedOwnerReferences
+	OrphanedOwnerReferences []OrphanedOwnerReference
+	// Modified contains the instances as updated by the API server, see WithModifiedObjects
+	Modified []unstructured.Unstructured
+	// Rejected contains the instances whose finalizers were kept as the removal predicate rejected them,
+	// see WithRemovalPredicate
+	Rejected []RejectedResource
+}
+
+// RejectedResource is a custom resource rejected by the removal predicate, see WithRemovalPredicate
+type RejectedResource struct {
+	Namespace string
+	Name      string
+	// Reason is the reason given by the removal predicate
+	Reason string
+}
+
+// OrphanedOwnerReference is an owner reference of a custom resource whose referent no longer exists
+type OrphanedOwnerReference struct {
+	Namespace      string
+	Name           string
+	OwnerReference metav1.OwnerReference
+	// Removed reports whether the owner reference was removed from the custom resource
+	Removed bool
+}
+
+// err returns the failures of the custom resource definition as CleanupErrors, nil if there are none
+func (r CRDResult) err() error {
+	return newCleanupErrors(r.Failures)
+}
+
+// RemovedFinalizers returns the exact number of finalizers removed from all custom resources during the run.
+// Only updates accepted by the API server are counted, so the count is also exact when the run returned an error.
+// Zero together with a nil error means that there was nothing to clean up. It is safe to call on a nil result.
+func (r *CleanupResult) RemovedFinalizers() int {
+	if r == nil {
+		return 0
+	}
+	removed := 0
+	for _, crd := range r.CRDs {
+		removed += crd.RemovedFinalizers
+	}
+	return removed
+}
+
+// Failures returns the failures of all processed custom resource definitions
+func (r *CleanupResult) Failures() []ResourceFailure {
+	var failures []ResourceFailure
+	for _, crd := range r.CRDs {
+		failures = append(failures, crd.Failures...)
+	}
+	return failures
+}
+
+// ResourceFailure identifies a custom resource whose finalizers could not be removed
+type ResourceFailure struct {
+	GVR             schema.GroupVersionResource
+	Namespace       string
+	Name            string
+	UID             types.UID
+	ResourceVersion string
+	Err             error
+}
+
+func (f ResourceFailure) Error() string {
+	return fmt.Sprintf("%s.%s/%s \"%s/%s\" (uid: %s, resourceVersion: %s): %v",
+		f.GVR.Resource, f.GVR.Group, f.GVR.Version, f.Namespace, f.Name, f.UID, f.ResourceVersion, f.Err)
+}
+
+func (f ResourceFailure) Unwrap() error {
+	return f.Err
+}
@@ -0,0 +1,24 @@
+package k8s
+
+// retryBudget caps the number of conflict retries of all custom resources of a run, see WithConflictRetryBudget.
+// A nil budget allows unlimited retries.
+type retryBudget struct {
+	limit int
+	used  int
+}
+
+// Take reports whether another retry is allowed and consumes it, see finalizers.RetryBudget
+func (b *retryBudget) Take() bool {
+	if b == nil {
+		return true
+	}
+	if b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+func (b *retryBudget) exhausted() bool {
+	return b != nil && b.used >= b.limit
+}
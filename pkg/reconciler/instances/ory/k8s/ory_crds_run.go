@@ -0,0 +1,46 @@
+package k8s
+
+import (
+	"context"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// sweepRun holds the state of a single run. The handler and its clients are shared by concurrent runs, so the
+// progress, the warnings, the conflict retry budget and the result stream of a run are kept here and passed down
+// to the steps of the run instead of being stored on the handler.
+type sweepRun struct {
+	// ctx is passed to all requests and retries of the run, so that none of them outlasts a cancelled run
+	ctx context.Context
+	// stream receives the results of the resources if the run is streamed, see StreamOryFinalizers
+	stream          *resultStream
+	progress        *progressReporter
+	warnings        *warningCollector
+	conflictRetries *retryBudget
+}
+
+// newRun creates the state of a run with the progress callback and the conflict retry budget configured on the
+// handler. The stream is nil unless the run is streamed.
+func (h *DefaultOryFinalizersHandler) newRun(ctx context.Context, stream *resultStream) *sweepRun {
+	run := &sweepRun{
+		ctx:      ctx,
+		stream:   stream,
+		warnings: newWarningCollector(h.logger),
+	}
+	if h.progress != nil {
+		run.progress = &progressReporter{report: h.progress}
+	}
+	if h.conflictRetriesLimited {
+		run.conflictRetries = &retryBudget{limit: h.conflictRetryLimit}
+	}
+	return run
+}
+
+// budgetExhausted returns a RetryBudgetExhaustedError for a conflict which was not retried because the conflict retry
+// budget of the run was used up
+func (r *sweepRun) budgetExhausted(err error) error {
+	if apierr.IsConflict(err) && r.conflictRetries.exhausted() {
+		return &RetryBudgetExhaustedError{Budget: r.conflictRetries.limit, err: err}
+	}
+	return err
+}
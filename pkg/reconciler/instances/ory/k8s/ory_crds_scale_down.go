@@ -0,0 +1,136 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// HydraMaesterDeployment is the deployment of hydra-maester, which adds its finalizer to all oauth2clients
+	HydraMaesterDeployment = "ory-hydra-maester"
+	scaleDownPollInterval  = time.Second
+	// scaleRestoreTimeout bounds the restore of the replicas, which does not end with the run
+	scaleRestoreTimeout = 30 * time.Second
+)
+
+var (
+	deploymentGVR = appsv1.SchemeGroupVersion.WithResource("deployments")
+	podGVR        = corev1.SchemeGroupVersion.WithResource("pods")
+)
+
+// scaleDownHydraMaester scales the hydra-maester deployment to zero replicas and waits until its pods are gone, so
+// that it does not add its finalizer again while the finalizers are removed, see WithHydraMaesterScaleDown.
+// It returns a function restoring the original number of replicas. A missing deployment is not scaled.
+// The given context interrupts the scale down and the wait, but not the restore: hydra-maester would stay scaled
+// down after a cancelled run otherwise, so the restore gets its own context.
+func (h *DefaultOryFinalizersHandler) scaleDownHydraMaester(ctx context.Context) (func() error, error) {
+	namespace := h.scaleDownNamespace
+	deployments := h.currentClients().dynamic.Resource(deploymentGVR).Namespace(namespace)
+	deployment, err := deployments.Get(ctx, HydraMaesterDeployment, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		h.logger.Debugf("Couldn't find deployment \"%s/%s\" to scale down", namespace, HydraMaesterDeployment)
+		return func() error { return nil }, nil
+	}
+	if err != nil {
+		return nil, h.checkForbidden(err, "get", deploymentResource, namespace)
+	}
+
+	replicas, found, err := unstructured.NestedInt64(deployment.Object, "spec", "replicas")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the replicas of deployment \"%s/%s\"", namespace, HydraMaesterDeployment)
+	}
+	if !found {
+		// the API server defaults the replicas to 1
+		replicas = 1
+	}
+	restore := func() error { return nil }
+	if replicas > 0 {
+		if err := h.scaleHydraMaester(ctx, 0); err != nil {
+			return nil, err
+		}
+		h.logger.Infof("Scaled down deployment \"%s/%s\" from %d replicas", namespace, HydraMaesterDeployment, replicas)
+		restore = func() error {
+			restoreCtx, cancel := context.WithTimeout(context.Background(), scaleRestoreTimeout)
+			defer cancel()
+			err := h.scaleHydraMaester(restoreCtx, replicas)
+			if apierr.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to restore %d replicas of deployment \"%s/%s\"", replicas, namespace, HydraMaesterDeployment)
+			}
+			h.logger.Infof("Restored %d replicas of deployment \"%s/%s\"", replicas, namespace, HydraMaesterDeployment)
+			return nil
+		}
+	}
+
+	if err := h.waitForHydraMaesterPodsGone(ctx, deployment); err != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			h.logger.Warnf("%v", restoreErr)
+		}
+		return nil, err
+	}
+	return restore, nil
+}
+
+func (h *DefaultOryFinalizersHandler) scaleHydraMaester(ctx context.Context, replicas int64) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	_, err := h.currentClients().dynamic.Resource(deploymentGVR).Namespace(h.scaleDownNamespace).
+		Patch(ctx, HydraMaesterDeployment, types.MergePatchType, patch, metav1.PatchOptions{})
+	return h.checkForbidden(err, "patch", deploymentResource, h.scaleDownNamespace)
+}
+
+// waitForHydraMaesterPodsGone polls the pods selected by the deployment until none are left or the scale down
+// timeout passes or the context is done
+func (h *DefaultOryFinalizersHandler) waitForHydraMaesterPodsGone(ctx context.Context, deployment *unstructured.Unstructured) error {
+	selectorObject, _, err := unstructured.NestedMap(deployment.Object, "spec", "selector")
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the selector of deployment \"%s/%s\"", h.scaleDownNamespace, HydraMaesterDeployment)
+	}
+	var selector metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selectorObject, &selector); err != nil {
+		return errors.Wrapf(err, "failed to read the selector of deployment \"%s/%s\"", h.scaleDownNamespace, HydraMaesterDeployment)
+	}
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		// an empty selector would match all pods of the namespace
+		return errors.Errorf("deployment \"%s/%s\" has no pod selector", h.scaleDownNamespace, HydraMaesterDeployment)
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(&selector)
+	if err != nil {
+		return errors.Wrapf(err, "invalid selector of deployment \"%s/%s\"", h.scaleDownNamespace, HydraMaesterDeployment)
+	}
+
+	deadline := h.clock.Now().Add(h.scaleDownTimeout)
+	pods := h.currentClients().dynamic.Resource(podGVR).Namespace(h.scaleDownNamespace)
+	for {
+		list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: labelSelector.String()})
+		if err != nil && !IsRetriableError(err) {
+			return h.checkForbidden(err, "list", podResource, h.scaleDownNamespace)
+		}
+		if err == nil && len(list.Items) == 0 {
+			return nil
+		}
+		if !h.clock.Now().Before(deadline) {
+			if err != nil {
+				return errors.Wrapf(err, "pods of deployment \"%s/%s\" did not terminate within %s",
+					h.scaleDownNamespace, HydraMaesterDeployment, h.scaleDownTimeout)
+			}
+			return errors.Errorf("%d pods of deployment \"%s/%s\" did not terminate within %s",
+				len(list.Items), h.scaleDownNamespace, HydraMaesterDeployment, h.scaleDownTimeout)
+		}
+		if err := h.sleep(ctx, scaleDownPollInterval); err != nil {
+			return errors.Wrapf(err, "stopped waiting for the pods of deployment \"%s/%s\" to terminate",
+				h.scaleDownNamespace, HydraMaesterDeployment)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func Test_FindAndDeleteOryFinalizers_HydraMaesterScaleDown(t *testing.T) {
+	t.Run("should fail while hydra-maester adds its finalizer again", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(scaleDownListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixHydraMaesterDeployment(2), fixHydraMaesterPod("ory-hydra-maester-abc")))
+		simulateHydraMaester(t, dyn)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		var cleanupErrors *CleanupErrors
+		require.True(t, errors.As(err, &cleanupErrors))
+		require.Len(t, cleanupErrors.Conflicts(), 1)
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should remove the finalizers after scaling down hydra-maester", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(scaleDownListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixHydraMaesterDeployment(2), fixHydraMaesterPod("ory-hydra-maester-abc")))
+		simulateHydraMaester(t, dyn)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithHydraMaesterScaleDown("kyma-system", time.Minute), WithClock(newSteppingClock()))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+		require.Equal(t, int64(0), hydraMaesterReplicas(t, dyn))
+	})
+
+	t.Run("should restore the replicas when asked for", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(scaleDownListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixHydraMaesterDeployment(2), fixHydraMaesterPod("ory-hydra-maester-abc")))
+		simulateHydraMaester(t, dyn)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithHydraMaesterScaleDown("kyma-system", time.Minute), WithHydraMaesterScaleRestored(),
+			WithClock(newSteppingClock()))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+		require.Equal(t, int64(2), hydraMaesterReplicas(t, dyn))
+	})
+
+	t.Run("should not remove finalizers when the pods do not terminate in time", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(scaleDownListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixHydraMaesterDeployment(1), fixHydraMaesterPod("ory-hydra-maester-abc")))
+		fakeClock := newSteppingClock()
+		start := fakeClock.Now()
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithHydraMaesterScaleDown("kyma-system", time.Minute), WithHydraMaesterScaleRestored(), WithClock(fakeClock))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `1 pods of deployment "kyma-system/ory-hydra-maester" did not terminate within 1m0s`)
+		require.Equal(t, time.Minute, fakeClock.Since(start))
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+		require.Equal(t, int64(1), hydraMaesterReplicas(t, dyn))
+	})
+
+	t.Run("should stop waiting for the pods and restore the replicas when the run is cancelled", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(scaleDownListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixHydraMaesterDeployment(1), fixHydraMaesterPod("ory-hydra-maester-abc")))
+		ctx, cancel := context.WithCancel(context.Background())
+		dyn.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			cancel()
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithHydraMaesterScaleDown("kyma-system", time.Hour), WithHydraMaesterScaleRestored(),
+			WithClock(testingclock.NewFakeClock(time.Now())))
+
+		// when
+		_, err := handler.sweep(handler.newRun(ctx, nil))
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		require.Contains(t, err.Error(), `stopped waiting for the pods of deployment "kyma-system/ory-hydra-maester"`)
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+		require.Equal(t, int64(1), hydraMaesterReplicas(t, dyn))
+	})
+
+	t.Run("should remove the finalizers when hydra-maester is not deployed", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(scaleDownListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithHydraMaesterScaleDown("kyma-system", time.Minute), WithHydraMaesterScaleRestored())
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should name the missing permission to scale hydra-maester", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withListKinds(scaleDownListKinds), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"), fixHydraMaesterDeployment(1)))
+		dyn.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(deploymentResource, HydraMaesterDeployment, errors.New("rbac"))
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithHydraMaesterScaleDown("kyma-system", time.Minute))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+}
+
+var scaleDownListKinds = map[schema.GroupVersionResource]string{podGVR: "PodList"}
+
+// simulateHydraMaester lets the updates of oauth2clients conflict while hydra-maester has replicas, as it adds its
+// finalizer again in the meantime. Scaling it down terminates its pods.
+func simulateHydraMaester(t *testing.T, dyn *dynamicfake.FakeDynamicClient) {
+	dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if hydraMaesterReplicas(t, dyn) > 0 {
+			return true, nil, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", errors.New("finalizer added again"))
+		}
+		return false, nil, nil
+	})
+	dyn.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if string(action.(k8stesting.PatchAction).GetPatch()) == `{"spec":{"replicas":0}}` {
+			require.NoError(t, dyn.Tracker().Delete(podGVR, "kyma-system", "ory-hydra-maester-abc"))
+		}
+		return false, nil, nil
+	})
+}
+
+func hydraMaesterReplicas(t *testing.T, dyn *dynamicfake.FakeDynamicClient) int64 {
+	deployment, err := dyn.Tracker().Get(deploymentGVR, "kyma-system", HydraMaesterDeployment)
+	if apierr.IsNotFound(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	replicas, _, err := unstructured.NestedInt64(deployment.(*unstructured.Unstructured).Object, "spec", "replicas")
+	require.NoError(t, err)
+	return replicas
+}
+
+func fixHydraMaesterDeployment(replicas int64) *unstructured.Unstructured {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{"app.kubernetes.io/name": "hydra-maester"},
+			},
+		},
+	}}
+	deployment.SetAPIVersion("apps/v1")
+	deployment.SetKind("Deployment")
+	deployment.SetNamespace("kyma-system")
+	deployment.SetName(HydraMaesterDeployment)
+	return deployment
+}
+
+func fixHydraMaesterPod(name string) *unstructured.Unstructured {
+	pod := &unstructured.Unstructured{}
+	pod.SetAPIVersion("v1")
+	pod.SetKind("Pod")
+	pod.SetNamespace("kyma-system")
+	pod.SetName(name)
+	pod.SetLabels(map[string]string{"app.kubernetes.io/name": "hydra-maester"})
+	return pod
+}
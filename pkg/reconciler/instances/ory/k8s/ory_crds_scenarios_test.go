@@ -0,0 +1,190 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Test_FindAndDeleteOryFinalizers_Scenarios runs the whole cleanup against fake clients injected with
+// WithClientProvider, the way a caller sharing the clients of a reconciler uses the handler.
+func Test_FindAndDeleteOryFinalizers_Scenarios(t *testing.T) {
+	gr := oauth2ClientGVR.GroupResource()
+	tests := []struct {
+		name      string
+		withCRD   bool
+		objects   []runtime.Object
+		reactors  func(dyn *dynamicfake.FakeDynamicClient)
+		assertErr func(t *testing.T, err error)
+		removed   int
+		instances int
+		remaining map[string][]string
+	}{
+		{
+			name:    "should do nothing when the crd is not installed",
+			objects: []runtime.Object{fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")},
+			remaining: map[string][]string{
+				"client": {"finalizer.ory.hydra.sh"},
+			},
+		},
+		{
+			name:    "should succeed when the crd has no instances",
+			withCRD: true,
+		},
+		{
+			name:    "should remove ory finalizers only from instances which have them",
+			withCRD: true,
+			objects: []runtime.Object{
+				fixOAuth2Client("default", "with-finalizers", "finalizer.ory.hydra.sh", "example.com/keep"),
+				fixOAuth2Client("default", "without-finalizers"),
+				fixOAuth2Client("kyma-system", "other-finalizers", "example.com/keep"),
+			},
+			removed:   1,
+			instances: 3,
+			remaining: map[string][]string{
+				"with-finalizers":    {"example.com/keep"},
+				"without-finalizers": nil,
+				"other-finalizers":   {"example.com/keep"},
+			},
+		},
+		{
+			name:    "should retry an update which failed on a conflict",
+			withCRD: true,
+			objects: []runtime.Object{fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")},
+			reactors: func(dyn *dynamicfake.FakeDynamicClient) {
+				failFirstCalls(dyn, "update", 2, apierr.NewConflict(gr, "client", errors.New("modified")))
+			},
+			removed:   1,
+			instances: 1,
+			remaining: map[string][]string{
+				"client": nil,
+			},
+		},
+		{
+			name:    "should succeed when an instance is deleted between get and update",
+			withCRD: true,
+			objects: []runtime.Object{fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")},
+			reactors: func(dyn *dynamicfake.FakeDynamicClient) {
+				dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+					if err := dyn.Tracker().Delete(oauth2ClientGVR, "default", "client"); err != nil {
+						return true, nil, err
+					}
+					return true, nil, apierr.NewNotFound(gr, "client")
+				})
+			},
+			// the deletion is what removing the finalizers is meant to achieve
+			removed:   1,
+			instances: 1,
+		},
+		{
+			name:    "should report the missing permission to list the instances",
+			withCRD: true,
+			objects: []runtime.Object{fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")},
+			reactors: func(dyn *dynamicfake.FakeDynamicClient) {
+				failFirstCalls(dyn, "list", 10, apierr.NewForbidden(gr, "", errors.New("rbac")))
+			},
+			assertErr: func(t *testing.T, err error) {
+				require.True(t, IsMissingPermissionError(err))
+				require.True(t, apierr.IsForbidden(err))
+			},
+			remaining: map[string][]string{
+				"client": {"finalizer.ory.hydra.sh"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			apix := apixfake.NewSimpleClientset()
+			if tt.withCRD {
+				apix = apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+			}
+			dyn := newFakeDynamicClient(withObjects(tt.objects...))
+			if tt.reactors != nil {
+				tt.reactors(dyn)
+			}
+			handler := NewDefaultOryFinalizersHandler(WithClientProvider(newFakeClientProvider(apix, dyn)))
+
+			// when
+			result, err := handler.FindAndDeleteOryFinalizers("")
+
+			// then
+			if tt.assertErr != nil {
+				tt.assertErr(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.removed, result.RemovedFinalizers())
+			instances := 0
+			for _, crd := range result.CRDs {
+				instances += crd.Instances
+			}
+			require.Equal(t, tt.instances, instances)
+			list, err := dyn.Tracker().List(oauth2ClientGVR, oauth2ClientGVR.GroupVersion().WithKind("OAuth2Client"), "")
+			require.NoError(t, err)
+			items, err := metaItems(list)
+			require.NoError(t, err)
+			require.Len(t, items, len(tt.remaining))
+			for _, item := range items {
+				expected, ok := tt.remaining[item.GetName()]
+				require.True(t, ok, "unexpected instance %q", item.GetName())
+				require.ElementsMatch(t, expected, item.GetFinalizers())
+			}
+		})
+	}
+}
+
+func Test_NewFakeDynamicClient(t *testing.T) {
+	t.Run("should list the instances of all namespaces with the registered list kind", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "a"), fixOAuth2Client("kyma-system", "b")))
+
+		// when
+		list, err := dyn.Resource(oauth2ClientGVR).List(context.Background(), metav1.ListOptions{})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "OAuth2ClientList", list.GetKind())
+		require.Equal(t, oauth2ClientGVR.GroupVersion().String(), list.GetAPIVersion())
+		require.Len(t, list.Items, 2)
+	})
+
+	t.Run("should list the instances of a single namespace", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "a"), fixOAuth2Client("kyma-system", "b")))
+
+		// when
+		list, err := dyn.Resource(oauth2ClientGVR).Namespace("kyma-system").List(context.Background(), metav1.ListOptions{})
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, list.Items, 1)
+		require.Equal(t, "b", list.Items[0].GetName())
+	})
+}
+
+// metaItems returns the objects of a list returned by the tracker of a fake client
+func metaItems(list runtime.Object) ([]metav1.Object, error) {
+	objects, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]metav1.Object, 0, len(objects))
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, accessor)
+	}
+	return items, nil
+}
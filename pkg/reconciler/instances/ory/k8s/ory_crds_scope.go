@@ -0,0 +1,35 @@
+package k8s
+
+import (
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// recordScope remembers whether the instances of the custom resource are cluster-scoped, as declared by the scope
+// of its definition
+func (h *DefaultOryFinalizersHandler) recordScope(gvr schema.GroupVersionResource, scope apixv1beta1.ResourceScope) {
+	h.scopesMu.Lock()
+	defer h.scopesMu.Unlock()
+	if h.clusterScoped == nil {
+		h.clusterScoped = map[schema.GroupVersionResource]bool{}
+	}
+	h.clusterScoped[gvr] = scope == apixv1beta1.ClusterScoped
+}
+
+// isClusterScoped reports whether the custom resource was recorded as cluster-scoped, resources whose definition
+// was not read are namespaced
+func (h *DefaultOryFinalizersHandler) isClusterScoped(gvr schema.GroupVersionResource) bool {
+	h.scopesMu.Lock()
+	defer h.scopesMu.Unlock()
+	return h.clusterScoped[gvr]
+}
+
+// instanceResource returns the dynamic resource to read and write an instance with, which is not namespaced for
+// cluster-scoped custom resources
+func (h *DefaultOryFinalizersHandler) instanceResource(gvr schema.GroupVersionResource, namespace string) dynamic.ResourceInterface {
+	if h.isClusterScoped(gvr) {
+		return h.currentClients().dynamic.Resource(gvr)
+	}
+	return h.currentClients().dynamic.Resource(gvr).Namespace(namespace)
+}
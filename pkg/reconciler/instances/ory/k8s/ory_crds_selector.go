@@ -0,0 +1,78 @@
+package k8s
+
+import (
+	"context"
+	"sort"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// instanceFieldSelector narrows the instances listed server-side. The API server cannot select custom resources by
+// their deletion timestamp, so terminating instances are always filtered client-side, but every API server supports
+// selecting custom resources by metadata.namespace, which drops the excluded namespaces from the response.
+// The selector is empty for cluster-scoped resources and if it was rejected before for the given resource.
+func (h *DefaultOryFinalizersHandler) instanceFieldSelector(gvr schema.GroupVersionResource) string {
+	if len(h.excludedNamespaces) == 0 || h.isClusterScoped(gvr) {
+		return ""
+	}
+	h.selectorsMu.Lock()
+	unsupported := h.unsupportedSelectors[gvr]
+	h.selectorsMu.Unlock()
+	if unsupported {
+		return ""
+	}
+
+	namespaces := make([]string, 0, len(h.excludedNamespaces))
+	for namespace := range h.excludedNamespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	selectors := make([]fields.Selector, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+	}
+	return fields.AndSelectors(selectors...).String()
+}
+
+// listInstances lists the instances of the custom resource in all namespaces, narrowed by instanceFieldSelector.
+// If the API server rejects the selector, the list is repeated without it and the selector is not sent again
+// for the resource, so callers have to filter the returned instances themselves.
+func (h *DefaultOryFinalizersHandler) listInstances(ctx context.Context, gvr schema.GroupVersionResource) (*unstructured.UnstructuredList, error) {
+	resource := h.currentClients().dynamic.Resource(gvr).Namespace(metav1.NamespaceAll)
+	return h.listWithFieldSelector(gvr, func(opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+		return resource.List(ctx, opts)
+	})
+}
+
+// listWithFieldSelector lists with the selector of instanceFieldSelector, and without it if the API server rejects it.
+// The instances are sorted, see sortInstances.
+func (h *DefaultOryFinalizersHandler) listWithFieldSelector(gvr schema.GroupVersionResource,
+	list func(opts metav1.ListOptions) (*unstructured.UnstructuredList, error)) (*unstructured.UnstructuredList, error) {
+	selector := h.instanceFieldSelector(gvr)
+	instances, err := list(metav1.ListOptions{FieldSelector: selector})
+	if selector == "" || !apierr.IsBadRequest(err) {
+		if err != nil {
+			return instances, err
+		}
+		sortInstances(instances)
+		return instances, nil
+	}
+
+	h.logger.Debugf("API server rejected field selector \"%s\" for %s, filtering client-side: %v", selector, gvr.String(), err)
+	h.selectorsMu.Lock()
+	if h.unsupportedSelectors == nil {
+		h.unsupportedSelectors = map[schema.GroupVersionResource]bool{}
+	}
+	h.unsupportedSelectors[gvr] = true
+	h.selectorsMu.Unlock()
+	instances, err = list(metav1.ListOptions{})
+	if err != nil {
+		return instances, err
+	}
+	sortInstances(instances)
+	return instances, nil
+}
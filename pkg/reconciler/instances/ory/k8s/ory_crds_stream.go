@@ -0,0 +1,142 @@
+package k8s
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResourceState is the outcome of a single custom resource of a run
+type ResourceState string
+
+const (
+	// ResourceProcessed is a custom resource whose matched finalizers were removed, also if it had none
+	ResourceProcessed ResourceState = "Processed"
+	// ResourceSkipped is a custom resource in an excluded namespace, not terminating or rejected by the removal
+	// predicate, see WithExcludedNamespaces, WithTerminatingOnly and WithRemovalPredicate
+	ResourceSkipped ResourceState = "Skipped"
+	// ResourcePendingGrace is a terminating custom resource whose grace period is not over yet, see WithGracePeriod
+	ResourcePendingGrace ResourceState = "PendingGrace"
+	// ResourceTooYoung is a custom resource created less than the minimum age ago, see WithMinimumAge
+	ResourceTooYoung ResourceState = "TooYoung"
+	// ResourceFailed is a custom resource whose finalizers could not be removed
+	ResourceFailed ResourceState = "Failed"
+)
+
+// ResourceResult is the outcome of a single custom resource, as streamed by StreamOryFinalizers
+type ResourceResult struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	Name      string
+	State     ResourceState
+	// RemovedFinalizers is the number of finalizers removed from the resource
+	RemovedFinalizers int
+	// Err is the reason a ResourceFailed resource failed
+	Err error
+	// Reason is the reason the removal predicate gave for a ResourceSkipped resource, see WithRemovalPredicate
+	Reason string
+}
+
+// StreamOryFinalizers removes the ory finalizers like FindAndDeleteOryFinalizers, but sends the result of every
+// custom resource over the result channel as soon as it is processed, instead of returning them at the end. The
+// result channel is closed when the run is over, then the error channel receives the error of the run, including
+// the failures of all resources as FindAndDeleteOryFinalizers returns them, or nil and is closed. Cancelling the
+// context stops the run before the next resource and interrupts the requests and retries in flight, so consumers
+// which stop reading the results must cancel it. The
+// run then fails with a StreamStoppedError, which holds the result of a processed resource that could not be sent.
+// Once the error is received, no request of the run is in flight and the run modifies nothing anymore.
+func (h *DefaultOryFinalizersHandler) StreamOryFinalizers(ctx context.Context, kubeconfigData string) (<-chan ResourceResult, <-chan error) {
+	results := make(chan ResourceResult)
+	errs := make(chan error, 1)
+	go func() {
+		err := h.streamOryFinalizers(ctx, kubeconfigData, results)
+		close(results)
+		errs <- err
+		close(errs)
+	}()
+	return results, errs
+}
+
+func (h *DefaultOryFinalizersHandler) streamOryFinalizers(ctx context.Context, kubeconfigData string, results chan<- ResourceResult) error {
+	if err := h.initClients(ctx, kubeconfigData); err != nil {
+		return err
+	}
+	release, err := h.lockSweep()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	h.applyDefaults()
+	_, err = h.sweep(h.newRun(ctx, &resultStream{ctx: ctx, results: results}))
+	return err
+}
+
+// resultStream sends the results of the resources of a run to the consumer of StreamOryFinalizers
+type resultStream struct {
+	ctx     context.Context
+	results chan<- ResourceResult
+}
+
+// send blocks until the consumer received the result, it fails when the context of the run is done
+func (s *resultStream) send(result ResourceResult) error {
+	if err := s.ctx.Err(); err != nil {
+		return &StreamStoppedError{Unsent: &result, err: err}
+	}
+	select {
+	case <-s.ctx.Done():
+		return &StreamStoppedError{Unsent: &result, err: s.ctx.Err()}
+	case s.results <- result:
+		return nil
+	}
+}
+
+// stopped fails when the context of the run is done, so that no further resource is processed
+func (s *resultStream) stopped() error {
+	if err := s.ctx.Err(); err != nil {
+		return &StreamStoppedError{err: err}
+	}
+	return nil
+}
+
+// streamStopped fails if the run is streamed and its context is done
+func (r *sweepRun) streamStopped() error {
+	if r.stream == nil {
+		return nil
+	}
+	return r.stream.stopped()
+}
+
+// reportResource counts the resource towards the progress and streams its result, if the run is streamed.
+// The returned error stops the run.
+func (r *sweepRun) reportResource(crdef schema.GroupVersionResource, instance unstructured.Unstructured,
+	state ResourceState, removed int, err error) error {
+	return r.report(ResourceResult{
+		GVR:               crdef,
+		Namespace:         instance.GetNamespace(),
+		Name:              instance.GetName(),
+		State:             state,
+		RemovedFinalizers: removed,
+		Err:               err,
+	})
+}
+
+// reportRejected reports a resource rejected by the removal predicate like reportResource, with the reason
+func (r *sweepRun) reportRejected(crdef schema.GroupVersionResource, instance unstructured.Unstructured, reason string) error {
+	return r.report(ResourceResult{
+		GVR:       crdef,
+		Namespace: instance.GetNamespace(),
+		Name:      instance.GetName(),
+		State:     ResourceSkipped,
+		Reason:    reason,
+	})
+}
+
+func (r *sweepRun) report(result ResourceResult) error {
+	r.progress.increment()
+	if r.stream == nil {
+		return nil
+	}
+	return r.stream.send(result)
+}
@@ -0,0 +1,203 @@
+package k8s
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func Test_StreamOryFinalizers(t *testing.T) {
+	t.Run("should stream the result of every instance and close the channels", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh", "example.com/keep"),
+			fixOAuth2Client("kyma-system", "excluded", "finalizer.ory.hydra.sh")))
+		handler := newStreamTestHandler(t, dyn, WithExcludedNamespaces("kyma-system"))
+
+		// when
+		results, errs := handler.StreamOryFinalizers(context.Background(), "")
+		streamed := map[string]ResourceResult{}
+		for result := range results {
+			streamed[result.Name] = result
+		}
+
+		// then
+		require.NoError(t, <-errs)
+		_, open := <-errs
+		require.False(t, open)
+		require.Equal(t, map[string]ResourceResult{
+			"client":   {GVR: oauth2ClientGVR, Namespace: "default", Name: "client", State: ResourceProcessed, RemovedFinalizers: 1},
+			"excluded": {GVR: oauth2ClientGVR, Namespace: "kyma-system", Name: "excluded", State: ResourceSkipped},
+		}, streamed)
+		requireFinalizers(t, dyn, "default", "client", "example.com/keep")
+	})
+
+	t.Run("should stream failed instances and send the failures as final error", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		failFirstCalls(dyn, "update", 10, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "client", errors.New("rbac")))
+		handler := newStreamTestHandler(t, dyn)
+
+		// when
+		results, errs := handler.StreamOryFinalizers(context.Background(), "")
+		var streamed []ResourceResult
+		for result := range results {
+			streamed = append(streamed, result)
+		}
+
+		// then
+		require.Len(t, streamed, 1)
+		require.Equal(t, ResourceFailed, streamed[0].State)
+		require.True(t, IsMissingPermissionError(streamed[0].Err))
+		err := <-errs
+		var cleanupErrs *CleanupErrors
+		require.True(t, errors.As(err, &cleanupErrs))
+	})
+
+	t.Run("should stop the run when the context is cancelled and report the processed resources", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "a", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "b", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "c", "finalizer.ory.hydra.sh")))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		updates := cancelOnUpdate(dyn, 2, cancel)
+		handler := newStreamTestHandler(t, dyn)
+
+		// when
+		results, errs := handler.StreamOryFinalizers(ctx, "")
+		var streamed []ResourceResult
+		for result := range results {
+			streamed = append(streamed, result)
+		}
+		err := <-errs
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		var stopped *StreamStoppedError
+		require.True(t, errors.As(err, &stopped))
+		require.Len(t, streamed, 1)
+		require.NotNil(t, stopped.Unsent)
+		require.Equal(t, ResourceProcessed, stopped.Unsent.State)
+		require.Equal(t, 2, *updates)
+		processed := map[string]bool{streamed[0].Name: true, stopped.Unsent.Name: true}
+		list, err := dyn.Tracker().List(oauth2ClientGVR, oauth2ClientGVR.GroupVersion().WithKind("OAuth2Client"), "default")
+		require.NoError(t, err)
+		items, err := metaItems(list)
+		require.NoError(t, err)
+		for _, item := range items {
+			require.Equal(t, processed[item.GetName()], len(item.GetFinalizers()) == 0, item.GetName())
+		}
+	})
+
+	t.Run("should leave no goroutine running once the error of a cancelled run is received", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "a", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "b", "finalizer.ory.hydra.sh")))
+		handler := newStreamTestHandler(t, dyn)
+		baseline := runtime.NumGoroutine()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// when
+		results, errs := handler.StreamOryFinalizers(ctx, "")
+		<-results
+		cancel()
+		err := <-errs
+		updates := len(dyn.Actions())
+
+		// then
+		require.True(t, IsStreamStoppedError(err))
+		requireGoroutinesDone(t, baseline)
+		require.Len(t, dyn.Actions(), updates)
+	})
+
+	t.Run("should interrupt the retries of an update when the context is cancelled", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		updates := 0
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+			updates++
+			cancel()
+			return true, nil, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", errors.New("modified"))
+		})
+		// the timers of the fake clock never fire, only the cancellation ends the backoff
+		handler := newStreamTestHandler(t, dyn, WithClock(testingclock.NewFakeClock(time.Now())))
+
+		// when
+		results, errs := handler.StreamOryFinalizers(ctx, "")
+		for range results {
+		}
+		err := <-errs
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		var stopped *StreamStoppedError
+		require.True(t, errors.As(err, &stopped))
+		require.NotNil(t, stopped.Unsent)
+		require.Equal(t, ResourceFailed, stopped.Unsent.State)
+		require.Equal(t, 1, updates)
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should send the error of a run which could not start", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithLogger(logger.NewTestLogger(t)))
+
+		// when
+		results, errs := handler.StreamOryFinalizers(context.Background(), "invalid kubeconfig")
+
+		// then
+		_, open := <-results
+		require.False(t, open)
+		require.Error(t, <-errs)
+	})
+}
+
+func newStreamTestHandler(t *testing.T, dyn *dynamicfake.FakeDynamicClient, opts ...Option) *DefaultOryFinalizersHandler {
+	provider := newFakeClientProvider(apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+	return NewDefaultOryFinalizersHandler(append([]Option{WithLogger(logger.NewTestLogger(t)), WithClientProvider(provider)}, opts...)...)
+}
+
+// cancelOnUpdate lets the nth update of an oauth2client cancel the run, after the update was made, and returns a
+// pointer to the update counter
+func cancelOnUpdate(dyn *dynamicfake.FakeDynamicClient, n int, cancel context.CancelFunc) *int {
+	updates := 0
+	dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, k8sruntime.Object, error) {
+		updates++
+		if updates != n {
+			return false, nil, nil
+		}
+		object := action.(k8stesting.UpdateAction).GetObject()
+		if err := dyn.Tracker().Update(oauth2ClientGVR, object, action.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		cancel()
+		return true, object, nil
+	})
+	return &updates
+}
+
+// requireGoroutinesDone waits until no more goroutines than the baseline are running. It polls itself, as
+// require.Eventually runs the condition in goroutines of its own.
+func requireGoroutinesDone(t *testing.T, baseline int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), baseline)
+}
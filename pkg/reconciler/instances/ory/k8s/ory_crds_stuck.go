@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// CountStuckOryResources returns the number of ory custom resources which are being deleted but still carry
+// finalizers. It does not modify the cluster and is meant to back health checks.
+func (h *DefaultOryFinalizersHandler) CountStuckOryResources(ctx context.Context, kubeconfigData string) (int, error) {
+	if err := h.initClients(ctx, kubeconfigData); err != nil {
+		return 0, err
+	}
+	return h.countStuckOryResources(ctx)
+}
+
+func (h *DefaultOryFinalizersHandler) countStuckOryResources(ctx context.Context) (int, error) {
+	crds, err := h.discoverOryCRDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	stuck := 0
+	for _, crd := range crds {
+		if len(crd.Versions) == 0 {
+			continue
+		}
+		gvr := schema.GroupVersionResource{Group: crd.Group, Version: crd.Versions[0], Resource: crd.Resource}
+		count, err := h.countStuckInstancesOf(ctx, gvr)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to count stuck instances of \"%s\"", crd.Name)
+		}
+		stuck += count
+	}
+	return stuck, nil
+}
+
+// countStuckInstancesOf filters client-side, as custom resources do not support field selectors on the deletion
+// timestamp. Instances in excluded namespaces are not counted.
+func (h *DefaultOryFinalizersHandler) countStuckInstancesOf(ctx context.Context, gvr schema.GroupVersionResource) (int, error) {
+	list, err := h.cachedInstances(ctx, gvr)
+	if apierr.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, h.checkForbidden(err, "list", gvr.GroupResource(), metav1.NamespaceAll)
+	}
+
+	stuck := 0
+	for i := range list.Items {
+		if h.excludedNamespaces[list.Items[i].GetNamespace()] {
+			continue
+		}
+		if list.Items[i].GetDeletionTimestamp() != nil && len(list.Items[i].GetFinalizers()) > 0 {
+			stuck++
+		}
+	}
+	return stuck, nil
+}
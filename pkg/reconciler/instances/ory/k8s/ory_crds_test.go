@@ -0,0 +1,2604 @@
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	k8sRetry "k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+var oauth2ClientGVR = schema.GroupVersionResource{Group: "hydra.ory.sh", Version: "v1alpha1", Resource: "oauth2clients"}
+
+func Test_DiscoverOryCRDs(t *testing.T) {
+	t.Run("should return only crds of ory groups with their served versions", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(
+			fixCRD("oauth2clients", "hydra.ory.sh", "v1alpha1"),
+			fixCRD("rules", "oathkeeper.ory.sh", "v1alpha1", "v1beta1"),
+			fixCRD("virtualservices", "networking.istio.io", "v1beta1"),
+			fixCRD("notory", "factory.sh", "v1"),
+		)
+		handler := &DefaultOryFinalizersHandler{apixClient: apix.ApiextensionsV1beta1()}
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.ElementsMatch(t, []OryCRD{
+			{Name: "oauth2clients.hydra.ory.sh", Group: "hydra.ory.sh", Resource: "oauth2clients", Versions: []string{"v1alpha1"}},
+			{Name: "rules.oathkeeper.ory.sh", Group: "oathkeeper.ory.sh", Resource: "rules", Versions: []string{"v1alpha1", "v1beta1"}},
+		}, crds)
+	})
+
+	t.Run("should skip versions which are not served", func(t *testing.T) {
+		// given
+		crd := fixCRD("rules", "oathkeeper.ory.sh", "v1alpha1", "v1beta1")
+		crd.Spec.Versions[0].Served = false
+		apix := apixfake.NewSimpleClientset(crd)
+		handler := &DefaultOryFinalizersHandler{apixClient: apix.ApiextensionsV1beta1()}
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, crds, 1)
+		require.Equal(t, []string{"v1beta1"}, crds[0].Versions)
+	})
+
+	t.Run("should only return the crds of the allowlist", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(
+			fixCRD("oauth2clients", "hydra.ory.sh", "v1alpha1"),
+			fixCRD("rules", "oathkeeper.ory.sh", "v1alpha1"),
+			fixCRD("virtualservices", "networking.istio.io", "v1beta1"),
+		)
+		handler := NewDefaultOryFinalizersHandler(WithDiscoveryAllowlist("rules.oathkeeper.ory.sh", "virtualservices.networking.istio.io"))
+		handler.apixClient = apix.ApiextensionsV1beta1()
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, crds, 1)
+		require.Equal(t, "rules.oathkeeper.ory.sh", crds[0].Name)
+	})
+
+	t.Run("should return the ory crds except those of the denylist", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(
+			fixCRD("oauth2clients", "hydra.ory.sh", "v1alpha1"),
+			fixCRD("rules", "oathkeeper.ory.sh", "v1alpha1"),
+			fixCRD("jwks", "hydra.ory.sh", "v1alpha1"),
+		)
+		handler := NewDefaultOryFinalizersHandler(WithDiscoveryDenylist("oauth2clients.hydra.ory.sh", "jwks.hydra.ory.sh"))
+		handler.apixClient = apix.ApiextensionsV1beta1()
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, crds, 1)
+		require.Equal(t, "rules.oathkeeper.ory.sh", crds[0].Name)
+	})
+
+	t.Run("should always return the configured crds", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(
+			fixCRD("oauth2clients", "hydra.ory.sh", "v1alpha1"),
+			fixCRD("virtualservices", "networking.istio.io", "v1beta1"),
+		)
+		handler := NewDefaultOryFinalizersHandler(WithCRDs("virtualservices.networking.istio.io"),
+			WithDiscoveryAllowlist("rules.oathkeeper.ory.sh"))
+		handler.apixClient = apix.ApiextensionsV1beta1()
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, crds, 1)
+		require.Equal(t, "virtualservices.networking.istio.io", crds[0].Name)
+	})
+
+	t.Run("should reject an allowlist together with a denylist", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixCRD("oauth2clients", "hydra.ory.sh", "v1alpha1"))
+		handler := NewDefaultOryFinalizersHandler(WithDiscoveryAllowlist("oauth2clients.hydra.ory.sh"),
+			WithDiscoveryDenylist("rules.oathkeeper.ory.sh"))
+		handler.apixClient = apix.ApiextensionsV1beta1()
+
+		// when
+		_, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.EqualError(t, err, "the discovery allowlist and denylist of ory crds must not both be set")
+		require.Empty(t, apix.Actions())
+	})
+
+	t.Run("should return nothing when no ory crds are installed", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixCRD("virtualservices", "networking.istio.io", "v1beta1"))
+		handler := &DefaultOryFinalizersHandler{apixClient: apix.ApiextensionsV1beta1()}
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, crds)
+	})
+}
+
+func Test_CountStuckOryResources(t *testing.T) {
+	t.Run("should count only deleted resources with finalizers", func(t *testing.T) {
+		// given
+		deleted := metav1.Now()
+		stuck := fixOAuth2Client("default", "stuck", "finalizer.ory.sh")
+		stuck.SetDeletionTimestamp(&deleted)
+		other := fixOAuth2Client("kyma-system", "other", "finalizer.hydra.ory.sh")
+		other.SetDeletionTimestamp(&deleted)
+		released := fixOAuth2Client("default", "released")
+		released.SetDeletionTimestamp(&deleted)
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD(), fixCRD("virtualservices", "networking.istio.io", "v1beta1"))
+		dyn := newFakeDynamicClient(withObjects(stuck, other, released, fixOAuth2Client("default", "alive", "finalizer.ory.sh")))
+		handler := newTestHandler(t, apix, dyn)
+
+		// when
+		count, err := handler.countStuckOryResources(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+		for _, action := range dyn.Actions() {
+			require.Equal(t, "list", action.GetVerb())
+		}
+	})
+
+	t.Run("should return zero when no ory crds are installed", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient())
+
+		// when
+		count, err := handler.countStuckOryResources(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+
+	t.Run("should report missing permissions", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		failFirstCalls(dyn, "list", 1, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "", errors.New("forbidden")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		_, err := handler.countStuckOryResources(context.Background())
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+	})
+}
+
+func fixCRD(plural, group string, versions ...string) *apixv1beta1.CustomResourceDefinition {
+	crd := &apixv1beta1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: plural + "." + group},
+		Spec: apixv1beta1.CustomResourceDefinitionSpec{
+			Group:   group,
+			Version: versions[0],
+			Names:   apixv1beta1.CustomResourceDefinitionNames{Plural: plural},
+			Scope:   apixv1beta1.NamespaceScoped,
+		},
+	}
+	for _, version := range versions {
+		crd.Spec.Versions = append(crd.Spec.Versions, apixv1beta1.CustomResourceDefinitionVersion{Name: version, Served: true})
+	}
+	crd.Status.Conditions = []apixv1beta1.CustomResourceDefinitionCondition{
+		{Type: apixv1beta1.Established, Status: apixv1beta1.ConditionTrue},
+	}
+	return crd
+}
+
+func Test_FindAndDeleteOryFinalizers_Retry(t *testing.T) {
+	t.Run("should retry listing custom resources on transient errors", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		listCalls := failFirstCalls(dyn, "list", 2, apierr.NewServiceUnavailable("etcd leader changed"))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithListAttempts(3))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 3, *listCalls)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should back off on the clock of the handler between attempts", func(t *testing.T) {
+		// given
+		fakeClock := newSteppingClock()
+		start := fakeClock.Now()
+		dyn := newFakeDynamicClient()
+		failFirstCalls(dyn, "list", 2, apierr.NewServiceUnavailable("etcd leader changed"))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithListAttempts(3), WithClock(fakeClock))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		// the default backoff waits 10ms and 50ms with a jitter of up to 10%
+		require.GreaterOrEqual(t, fakeClock.Since(start), 60*time.Millisecond)
+		require.LessOrEqual(t, fakeClock.Since(start), 66*time.Millisecond)
+	})
+
+	t.Run("should back off conflicts on the clock of the handler", func(t *testing.T) {
+		// given
+		fakeClock := newSteppingClock()
+		start := fakeClock.Now()
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		updateCalls := failFirstCalls(dyn, "update", 3, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", errors.New("modified")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithClock(fakeClock))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 4, *updateCalls)
+		// the default conflict backoff waits 10ms before every retry with a jitter of up to 10%
+		require.GreaterOrEqual(t, fakeClock.Since(start), 30*time.Millisecond)
+		require.LessOrEqual(t, fakeClock.Since(start), 33*time.Millisecond)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should give up on conflicts after the steps of the backoff", func(t *testing.T) {
+		// given
+		fakeClock := newSteppingClock()
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		updateCalls := failFirstCalls(dyn, "update", 10, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", errors.New("modified")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithClock(fakeClock))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Equal(t, 5, *updateCalls)
+		require.Len(t, result.Failures(), 1)
+		require.True(t, apierr.IsConflict(result.Failures()[0].Err))
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should give up listing custom resources after configured attempts", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		listCalls := failFirstCalls(dyn, "list", 10, apierr.NewTooManyRequests("slow down", 0))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithListAttempts(2))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.True(t, apierr.IsTooManyRequests(err))
+		require.Equal(t, 2, *listCalls)
+	})
+
+	t.Run("should not retry listing custom resources on forbidden", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		listCalls := failFirstCalls(dyn, "list", 10,
+			apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "", errors.New("rbac")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithListAttempts(5))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.True(t, apierr.IsForbidden(err))
+		require.Equal(t, 1, *listCalls)
+	})
+
+	t.Run("should retry getting the crd on transient errors", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		getCalls := 0
+		apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			getCalls++
+			if getCalls == 1 {
+				return true, nil, apierr.NewInternalError(errors.New("boom"))
+			}
+			return false, nil, nil
+		})
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apix, dyn, WithListAttempts(3))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, getCalls)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should skip cleanup when crd does not exist", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, dyn.Actions())
+	})
+
+	t.Run("should fail when a required crd does not exist", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithRequireCRD())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsCRDNotFoundError(err))
+		require.True(t, apierr.IsNotFound(err))
+		require.Contains(t, err.Error(), `custom resource definition "oauth2clients.hydra.ory.sh" not found`)
+		require.Empty(t, result.CRDs)
+		require.Empty(t, dyn.Actions())
+	})
+
+	t.Run("should clean up when a required crd exists", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithRequireCRD())
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_CRDs(t *testing.T) {
+	virtualServiceGVR := schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "virtualservices"}
+	virtualServiceCRD := virtualServiceGVR.Resource + "." + virtualServiceGVR.Group
+	newDynamicClient := func(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+		return newFakeDynamicClient(withListKinds(map[schema.GroupVersionResource]string{virtualServiceGVR: "VirtualServiceList"}),
+			withObjects(objects...))
+	}
+	fixVirtualService := func(namespace, name string, finalizers ...string) *unstructured.Unstructured {
+		res := &unstructured.Unstructured{}
+		res.SetAPIVersion(virtualServiceGVR.GroupVersion().String())
+		res.SetKind("VirtualService")
+		res.SetNamespace(namespace)
+		res.SetName(name)
+		res.SetFinalizers(finalizers)
+		return res
+	}
+
+	t.Run("should sweep the instances of a crd outside of the ory groups", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD(), fixCRD(virtualServiceGVR.Resource, virtualServiceGVR.Group, virtualServiceGVR.Version))
+		dyn := newDynamicClient(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixVirtualService("kyma-system", "ory-oathkeeper", "oathkeeper.ory.sh/cleanup", "istio.io/keep"),
+		)
+		handler := newTestHandler(t, apix, dyn, WithCRDs(oauth2ClientCRD, virtualServiceCRD))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 2)
+		require.Equal(t, oauth2ClientGVR, result.CRDs[0].GVR)
+		require.Equal(t, virtualServiceGVR, result.CRDs[1].GVR)
+		require.Equal(t, 1, result.CRDs[1].RemovedFinalizers)
+		requireFinalizers(t, dyn, "default", "client")
+		res, err := dyn.Resource(virtualServiceGVR).Namespace("kyma-system").Get(context.Background(), "ory-oathkeeper", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"istio.io/keep"}, res.GetFinalizers())
+	})
+
+	t.Run("should sweep a crd outside of the ory groups after instances of an ory crd failed", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD(), fixCRD(virtualServiceGVR.Resource, virtualServiceGVR.Group, virtualServiceGVR.Version))
+		dyn := newDynamicClient(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixVirtualService("kyma-system", "ory-oathkeeper", "oathkeeper.ory.sh/cleanup"),
+		)
+		failFirstCalls(dyn, "update", 10, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "client", errors.New("rbac")))
+		handler := newTestHandler(t, apix, dyn, WithCRDs(oauth2ClientCRD, virtualServiceCRD))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Len(t, result.CRDs, 2)
+		require.Len(t, result.CRDs[0].Failures, 1)
+		require.Equal(t, virtualServiceGVR, result.CRDs[1].GVR)
+		require.Equal(t, 1, result.CRDs[1].RemovedFinalizers)
+		res, err := dyn.Resource(virtualServiceGVR).Namespace("kyma-system").Get(context.Background(), "ory-oathkeeper", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Empty(t, res.GetFinalizers())
+	})
+
+	t.Run("should remove finalizers of other groups with a custom matcher", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixCRD(virtualServiceGVR.Resource, virtualServiceGVR.Group, virtualServiceGVR.Version))
+		dyn := newDynamicClient(fixVirtualService("kyma-system", "ory-oathkeeper", "networking.istio.io/cleanup"))
+		handler := newTestHandler(t, apix, dyn, WithCRDs(virtualServiceCRD),
+			WithFinalizerMatcher(regexp.MustCompile(`^networking\.istio\.io/`)))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		require.Equal(t, 1, result.RemovedFinalizers())
+	})
+
+	t.Run("should skip a configured crd which does not exist", func(t *testing.T) {
+		// given
+		dyn := newDynamicClient(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithCRDs(virtualServiceCRD, oauth2ClientCRD))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should name the missing crd when it is required", func(t *testing.T) {
+		// given
+		dyn := newDynamicClient()
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithCRDs(oauth2ClientCRD, virtualServiceCRD), WithRequireCRD())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsCRDNotFoundError(err))
+		require.Contains(t, err.Error(), `custom resource definition "virtualservices.networking.istio.io" not found`)
+		require.Len(t, result.CRDs, 1)
+	})
+
+	t.Run("should discover the configured crds next to the ory crds", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD(), fixCRD(virtualServiceGVR.Resource, virtualServiceGVR.Group, virtualServiceGVR.Version),
+			fixCRD("gateways", "networking.istio.io", "v1beta1"))
+		handler := newTestHandler(t, apix, newDynamicClient(), WithCRDs(oauth2ClientCRD, virtualServiceCRD))
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.ElementsMatch(t, []OryCRD{
+			{Name: oauth2ClientCRD, Group: "hydra.ory.sh", Resource: "oauth2clients", Versions: []string{"v1alpha1"}},
+			{Name: virtualServiceCRD, Group: "networking.istio.io", Resource: "virtualservices", Versions: []string{"v1beta1"}},
+		}, crds)
+	})
+
+	t.Run("should sweep the crds sorted by name", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD(), fixCRD(virtualServiceGVR.Resource, virtualServiceGVR.Group, virtualServiceGVR.Version))
+		handler := newTestHandler(t, apix, newDynamicClient(), WithCRDs(virtualServiceCRD, oauth2ClientCRD))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 2)
+		require.Equal(t, oauth2ClientGVR, result.CRDs[0].GVR)
+		require.Equal(t, virtualServiceGVR, result.CRDs[1].GVR)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_RetryBudget(t *testing.T) {
+	conflict := apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", errors.New("modified concurrently"))
+
+	t.Run("should stop retrying conflicts once the budget of the run is used up", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client3", "finalizer.ory.hydra.sh")))
+		updateCalls := failFirstCalls(dyn, "update", 100, conflict)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithConflictRetryBudget(2), WithClock(newSteppingClock()))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsRetryBudgetExhaustedError(err))
+		require.True(t, apierr.IsConflict(err))
+		// every instance is updated once, the first one is retried twice
+		require.Equal(t, 5, *updateCalls)
+		require.True(t, result.CRDs[0].RetryBudgetExhausted)
+		var cleanupErrors *CleanupErrors
+		require.True(t, errors.As(err, &cleanupErrors))
+		require.Len(t, cleanupErrors.Contended(), 3)
+		require.Len(t, cleanupErrors.Conflicts(), 3)
+		require.Contains(t, err.Error(), "conflict retry budget of 2 retries is exhausted")
+	})
+
+	t.Run("should retry conflicts within the budget", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2", "finalizer.ory.hydra.sh")))
+		failFirstCalls(dyn, "update", 1, conflict)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithConflictRetryBudget(1), WithClock(newSteppingClock()))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.False(t, result.CRDs[0].RetryBudgetExhausted)
+		requireFinalizers(t, dyn, "default", "client1")
+		requireFinalizers(t, dyn, "default", "client2")
+	})
+
+	t.Run("should renew the budget for every run", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		failFirstCalls(dyn, "update", 1, conflict)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithConflictRetryBudget(1), WithClock(newSteppingClock()))
+		_, err := handler.findAndDeleteOryFinalizers()
+		require.NoError(t, err)
+
+		// when
+		require.NoError(t, dyn.Tracker().Update(oauth2ClientGVR,
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"), "default"))
+		failFirstCalls(dyn, "update", 1, conflict)
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.False(t, result.CRDs[0].RetryBudgetExhausted)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should retry conflicts without limit by default", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		updateCalls := failFirstCalls(dyn, "update", 100, conflict)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(newSteppingClock()))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, apierr.IsConflict(err))
+		require.False(t, IsRetryBudgetExhaustedError(err))
+		require.Equal(t, k8sRetry.DefaultRetry.Steps, *updateCalls)
+		require.False(t, result.CRDs[0].RetryBudgetExhausted)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_ConnectRetry(t *testing.T) {
+	connectionRefused := &url.Error{Op: "Get", URL: "https://api.example.com",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+
+	t.Run("should wait for an unreachable API server", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		calls := failFirstCRDCalls(apix, 3, connectionRefused)
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apix, dyn, WithConnectRetryTimeout(time.Minute),
+			WithClock(newSteppingClock()))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 4, *calls)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should give up when the API server stays unreachable", func(t *testing.T) {
+		// given
+		fakeClock := newSteppingClock()
+		start := fakeClock.Now()
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		failFirstCRDCalls(apix, math.MaxInt32, connectionRefused)
+		handler := newTestHandler(t, apix, newFakeDynamicClient(), WithConnectRetryTimeout(time.Minute), WithClock(fakeClock))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, errors.Is(err, syscall.ECONNREFUSED))
+		require.LessOrEqual(t, fakeClock.Since(start), time.Minute)
+		require.Greater(t, fakeClock.Since(start), 30*time.Second)
+	})
+
+	t.Run("should stop waiting for an unreachable API server when the run is cancelled", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+			cancel()
+			return true, nil, connectionRefused
+		})
+		// the timers of the fake clock never fire, only the cancellation ends the wait
+		handler := newTestHandler(t, apix, newFakeDynamicClient(), WithConnectRetryTimeout(time.Minute),
+			WithClock(testingclock.NewFakeClock(time.Now())))
+
+		// when
+		_, err := handler.sweep(handler.newRun(ctx, nil))
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("should not retry permanent errors", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		calls := failFirstCRDCalls(apix, math.MaxInt32, &url.Error{Op: "Get", URL: "https://api.example.com",
+			Err: x509.UnknownAuthorityError{}})
+		handler := newTestHandler(t, apix, newFakeDynamicClient(), WithConnectRetryTimeout(time.Minute),
+			WithClock(newSteppingClock()))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Equal(t, 1, *calls)
+	})
+
+	t.Run("should not retry by default", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		calls := failFirstCRDCalls(apix, math.MaxInt32, connectionRefused)
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Equal(t, 1, *calls)
+	})
+}
+
+// failFirstCRDCalls lets the first n lookups of custom resource definitions fail with err and returns a pointer
+// to the call counter
+func failFirstCRDCalls(apix *apixfake.Clientset, n int, err error) *int {
+	calls := 0
+	apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls <= n {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	return &calls
+}
+
+func Test_FindAndDeleteOryFinalizers_CRDEstablished(t *testing.T) {
+	t.Run("should wait for the crd to be established before listing instances", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		apix := apixfake.NewSimpleClientset()
+		getCalls := 0
+		apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			getCalls++
+			if getCalls == 2 {
+				return true, nil, apierr.NewServiceUnavailable("etcd leader changed")
+			}
+			return true, fixEstablishedCRD(getCalls >= 4), nil
+		})
+		handler := newTestHandler(t, apix, dyn, WithCRDEstablishedWait(time.Minute, UnestablishedCRDFail),
+			WithClock(newSteppingClock()))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 4, getCalls)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should fail without listing instances when the crd is not established in time", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		listCalls := failFirstCalls(dyn, "list", 0, nil)
+		fakeClock := newSteppingClock()
+		start := fakeClock.Now()
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixEstablishedCRD(false)), dyn,
+			WithCRDEstablishedWait(time.Minute, UnestablishedCRDFail), WithClock(fakeClock))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `crd "oauth2clients.hydra.ory.sh" was not established within 1m0s`)
+		require.Equal(t, time.Minute, fakeClock.Since(start))
+		require.Zero(t, *listCalls)
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should not poll an established crd", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixEstablishedCRD(true))
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, apix.Actions(), 1)
+	})
+
+	t.Run("should list the instances of a crd which is not established if the wait is disabled", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		apix := apixfake.NewSimpleClientset(fixEstablishedCRD(false))
+		handler := newTestHandler(t, apix, dyn, WithCRDEstablishedWait(0, UnestablishedCRDFail))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, apix.Actions(), 1)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should skip the instances of a crd which is not established in time by default", func(t *testing.T) {
+		// given
+		virtualService := fixCRD("virtualservices", "networking.istio.io", "v1beta1")
+		virtualServiceGVR := schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "virtualservices"}
+		dyn := newFakeDynamicClient(withListKinds(map[schema.GroupVersionResource]string{virtualServiceGVR: "VirtualServiceList"}),
+			withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		listCalls := failFirstCalls(dyn, "list", 0, nil)
+		fakeClock := newSteppingClock()
+		start := fakeClock.Now()
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixEstablishedCRD(false), virtualService), dyn,
+			WithCRDs(oauth2ClientCRD, virtualService.Name), WithClock(fakeClock))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, DefaultCRDEstablishedTimeout, fakeClock.Since(start))
+		require.Zero(t, *listCalls)
+		require.Len(t, result.CRDs, 2)
+		require.True(t, result.CRDs[0].NotEstablished)
+		require.Equal(t, oauth2ClientGVR, result.CRDs[0].GVR)
+		require.False(t, result.CRDs[1].NotEstablished)
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+}
+
+func Test_WaitForCRDEstablished(t *testing.T) {
+	t.Run("should wait until the crd is established after a few polls", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset()
+		gets := 0
+		apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			gets++
+			if gets == 1 {
+				return true, nil, apierr.NewNotFound(crdResource, oauth2ClientCRD)
+			}
+			return true, fixEstablishedCRD(gets >= 3), nil
+		})
+		handler := newTestHandler(t, apix, newFakeDynamicClient(), WithClock(newSteppingClock()))
+
+		// when
+		err := handler.WaitForCRDEstablished(context.Background(), oauth2ClientCRD, time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 3, gets)
+	})
+
+	t.Run("should return a typed error when the timeout expires", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixEstablishedCRD(false)), newFakeDynamicClient(),
+			WithClock(newSteppingClock()))
+
+		// when
+		err := handler.WaitForCRDEstablished(context.Background(), oauth2ClientCRD, 5*time.Second)
+
+		// then
+		require.True(t, IsCRDNotEstablishedError(err))
+		require.EqualError(t, err, `crd "oauth2clients.hydra.ory.sh" was not established within 5s`)
+	})
+
+	t.Run("should stop when the context is cancelled", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixEstablishedCRD(false)), newFakeDynamicClient(),
+			WithClock(newSteppingClock()))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		err := handler.WaitForCRDEstablished(ctx, oauth2ClientCRD, time.Minute)
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func fixEstablishedCRD(established bool) *apixv1beta1.CustomResourceDefinition {
+	crd := fixOAuth2ClientCRD()
+	status := apixv1beta1.ConditionFalse
+	if established {
+		status = apixv1beta1.ConditionTrue
+	}
+	crd.Status.Conditions = []apixv1beta1.CustomResourceDefinitionCondition{
+		{Type: apixv1beta1.NamesAccepted, Status: apixv1beta1.ConditionTrue},
+		{Type: apixv1beta1.Established, Status: status},
+	}
+	return crd
+}
+
+func Test_FindAndDeleteOryFinalizers_Result(t *testing.T) {
+	t.Run("should report elapsed time and timings per crd", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2", "finalizer.ory.hydra.sh")))
+		dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			fakeClock.Step(10 * time.Millisecond)
+			return false, nil, nil
+		})
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			fakeClock.Step(20 * time.Millisecond)
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithClock(fakeClock))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		crdResult := result.CRDs[0]
+		require.Equal(t, oauth2ClientGVR, crdResult.GVR)
+		require.Equal(t, 10*time.Millisecond, crdResult.ListDuration)
+		require.Equal(t, 40*time.Millisecond, crdResult.UpdateDuration)
+		require.Equal(t, 50*time.Millisecond, result.Elapsed)
+	})
+
+	t.Run("should count instances and removed finalizers", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh", "other.ory.sh", "example.com/keep"),
+			fixOAuth2Client("default", "client2", "example.com/keep"),
+			fixOAuth2Client("default", "client3", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 3, result.CRDs[0].Instances)
+		require.Equal(t, 3, result.CRDs[0].RemovedFinalizers)
+	})
+
+	t.Run("should count the removed finalizers of a failed run", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "broken", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh", "other.ory.sh")))
+		failFirstCalls(dyn, "update", 1, apierr.NewBadRequest("rejected by webhook"))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Equal(t, 2, result.RemovedFinalizers())
+	})
+
+	t.Run("should sum the removed finalizers of all crds", func(t *testing.T) {
+		result := &CleanupResult{CRDs: []CRDResult{{RemovedFinalizers: 2}, {RemovedFinalizers: 3}}}
+
+		require.Equal(t, 5, result.RemovedFinalizers())
+		require.Zero(t, (&CleanupResult{}).RemovedFinalizers())
+		require.Zero(t, (*CleanupResult)(nil).RemovedFinalizers())
+	})
+
+	t.Run("should return a result when the clients cannot be created", func(t *testing.T) {
+		// given
+		handler := NewDefaultOryFinalizersHandler(WithLogger(logger.NewTestLogger(t)))
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers("invalid kubeconfig")
+
+		// then
+		require.Error(t, err)
+		require.NotNil(t, result)
+		require.Zero(t, result.RemovedFinalizers())
+	})
+
+	t.Run("should log a summary at info level", func(t *testing.T) {
+		// given
+		core, logs := observer.New(zapcore.InfoLevel)
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "broken", "finalizer.ory.hydra.sh")))
+		failFirstCalls(dyn, "update", 1, apierr.NewBadRequest("rejected by webhook"))
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			fakeClock.Step(time.Second)
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithLogger(zap.New(core).Sugar()), WithClock(fakeClock))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		summaries := logs.FilterMessage("Finished removing ory finalizers").All()
+		require.Len(t, summaries, 1)
+		require.Equal(t, zapcore.InfoLevel, summaries[0].Level)
+		require.Equal(t, map[string]interface{}{
+			"crds":              int64(1),
+			"instances":         int64(2),
+			"removedFinalizers": int64(1),
+			"errors":            int64(1),
+			"elapsed":           "1s",
+		}, summaries[0].ContextMap())
+	})
+
+	t.Run("should report elapsed time when crd does not exist", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, result.CRDs)
+		require.Positive(t, result.Elapsed)
+	})
+}
+
+func Test_ValidateInstance(t *testing.T) {
+	tests := []struct {
+		name          string
+		object        map[string]interface{}
+		clusterScoped bool
+		wantErr       string
+	}{
+		{
+			name:   "well-formed instance",
+			object: map[string]interface{}{"metadata": map[string]interface{}{"name": "client", "namespace": "default", "finalizers": []interface{}{"finalizer.ory.hydra.sh"}}},
+		},
+		{
+			name:   "instance without finalizers",
+			object: map[string]interface{}{"metadata": map[string]interface{}{"name": "client", "namespace": "default"}},
+		},
+		{
+			name:    "missing metadata",
+			object:  map[string]interface{}{"spec": map[string]interface{}{}},
+			wantErr: "metadata is missing",
+		},
+		{
+			name:    "metadata is a string",
+			object:  map[string]interface{}{"metadata": "client"},
+			wantErr: "metadata is of unexpected type string",
+		},
+		{
+			name:    "missing name",
+			object:  map[string]interface{}{"metadata": map[string]interface{}{"namespace": "default"}},
+			wantErr: "metadata.name",
+		},
+		{
+			name:    "name is a number",
+			object:  map[string]interface{}{"metadata": map[string]interface{}{"name": int64(42), "namespace": "default"}},
+			wantErr: "metadata.name",
+		},
+		{
+			name:    "empty namespace",
+			object:  map[string]interface{}{"metadata": map[string]interface{}{"name": "client", "namespace": ""}},
+			wantErr: "metadata.namespace",
+		},
+		{
+			name:          "cluster-scoped instance without namespace",
+			object:        map[string]interface{}{"metadata": map[string]interface{}{"name": "client"}},
+			clusterScoped: true,
+		},
+		{
+			name:          "cluster-scoped instance without name",
+			object:        map[string]interface{}{"metadata": map[string]interface{}{}},
+			clusterScoped: true,
+			wantErr:       "metadata.name",
+		},
+		{
+			name:   "finalizers is a string",
+			object: map[string]interface{}{"metadata": map[string]interface{}{"name": "client", "namespace": "default", "finalizers": "finalizer.ory.hydra.sh"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInstance(unstructured.Unstructured{Object: tt.object}, tt.clusterScoped)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func Test_FindAndDeleteOryFinalizers_MalformedInstances(t *testing.T) {
+	t.Run("should record malformed instances as failures and process the rest", func(t *testing.T) {
+		// given
+		valid := fixOAuth2Client("default", "valid", "finalizer.ory.hydra.sh")
+		dyn := newFakeDynamicClient(withObjects(valid))
+		dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, &unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+				{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "no-namespace", "uid": "uid-1"}}},
+				{Object: map[string]interface{}{"metadata": map[string]interface{}{
+					"namespace": "default", "resourceVersion": "7", "finalizers": []interface{}{"finalizer.ory.hydra.sh"}}}},
+				*valid,
+			}}, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		requireFinalizers(t, dyn, "default", "valid")
+		failures := result.Failures()
+		require.Len(t, failures, 2)
+		require.Equal(t, "no-namespace", failures[0].Name)
+		require.Equal(t, "uid-1", string(failures[0].UID))
+		require.Empty(t, failures[1].Name)
+		require.Equal(t, "default", failures[1].Namespace)
+		require.Equal(t, "7", failures[1].ResourceVersion)
+		require.Contains(t, err.Error(), "no-namespace")
+		require.Contains(t, err.Error(), "metadata.name is missing")
+	})
+
+	t.Run("should record instances which cannot be updated as failures and process the rest", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "broken", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "valid", "finalizer.ory.hydra.sh")))
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			obj := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured)
+			if obj.GetName() == "broken" {
+				return true, nil, apierr.NewBadRequest("rejected by webhook")
+			}
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "rejected by webhook")
+		requireFinalizers(t, dyn, "default", "valid")
+		requireFinalizers(t, dyn, "default", "broken", "finalizer.ory.hydra.sh")
+		failures := result.Failures()
+		require.Len(t, failures, 1)
+		require.Equal(t, "broken", failures[0].Name)
+		require.True(t, apierr.IsBadRequest(failures[0].Err))
+	})
+}
+
+func Test_CleanupErrors(t *testing.T) {
+	gr := oauth2ClientGVR.GroupResource()
+	forbidden := ResourceFailure{GVR: oauth2ClientGVR, Namespace: "default", Name: "forbidden",
+		Err: &MissingPermissionError{Verb: "update", Resource: gr, err: apierr.NewForbidden(gr, "forbidden", errors.New("rbac"))}}
+	conflict := ResourceFailure{GVR: oauth2ClientGVR, Namespace: "default", Name: "conflict",
+		Err: apierr.NewConflict(gr, "conflict", errors.New("modified"))}
+	notFound := ResourceFailure{GVR: oauth2ClientGVR, Namespace: "default", Name: "gone", Err: apierr.NewNotFound(gr, "gone")}
+	sentinel := errors.New("sentinel")
+	other := ResourceFailure{GVR: oauth2ClientGVR, Namespace: "default", Name: "other", Err: errors.Wrap(sentinel, "failed")}
+
+	t.Run("should filter the failures by kind", func(t *testing.T) {
+		// given
+		err := &CleanupErrors{Failures: []ResourceFailure{forbidden, conflict, notFound, other}}
+
+		// then
+		require.Equal(t, []ResourceFailure{forbidden}, err.Forbidden())
+		require.Equal(t, []ResourceFailure{conflict}, err.Conflicts())
+		require.Equal(t, []ResourceFailure{notFound}, err.NotFound())
+		require.Contains(t, err.Error(), "failed to remove finalizers of 4 ory custom resources")
+		require.Contains(t, err.Error(), `"default/conflict"`)
+	})
+
+	t.Run("should match the failures with errors.Is and errors.As", func(t *testing.T) {
+		// given
+		var err error = &CleanupErrors{Failures: []ResourceFailure{conflict, other}}
+
+		// then
+		require.True(t, errors.Is(err, sentinel))
+		var failure ResourceFailure
+		require.True(t, errors.As(err, &failure))
+		require.Equal(t, "conflict", failure.Name)
+		require.True(t, apierr.IsConflict(err))
+		require.False(t, IsMissingPermissionError(err))
+	})
+
+	t.Run("should be nil without failures", func(t *testing.T) {
+		require.NoError(t, newCleanupErrors(nil))
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_Forbidden(t *testing.T) {
+	forbidden := apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "", errors.New("rbac"))
+
+	t.Run("should name the missing update permission in the aggregate error", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("kyma-system", "client", "finalizer.ory.hydra.sh")))
+		failFirstCalls(dyn, "update", 10, forbidden)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `grant update on oauth2clients.hydra.ory.sh in namespace "kyma-system"`)
+		failures := result.Failures()
+		require.Len(t, failures, 1)
+		require.True(t, IsMissingPermissionError(failures[0].Err))
+		require.True(t, apierr.IsForbidden(failures[0].Err))
+		var cleanupErrs *CleanupErrors
+		require.True(t, errors.As(err, &cleanupErrs))
+		require.Equal(t, failures, cleanupErrs.Forbidden())
+		require.True(t, IsMissingPermissionError(err))
+	})
+
+	t.Run("should name the missing get permission of an instance", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("kyma-system", "client", "finalizer.ory.hydra.sh")))
+		// the instance is read again after the update of the listed instance conflicted
+		failFirstCalls(dyn, "update", 1, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "client", nil))
+		failFirstCalls(dyn, "get", 10, forbidden)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `grant get on oauth2clients.hydra.ory.sh in namespace "kyma-system"`)
+	})
+
+	t.Run("should name the missing cluster-wide list permission", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		failFirstCalls(dyn, "list", 10, forbidden)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), "grant list on oauth2clients.hydra.ory.sh cluster-wide")
+	})
+
+	t.Run("should name the missing permission on custom resource definitions", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(crdResource, oauth2ClientCRD, errors.New("rbac"))
+		})
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), "grant get on customresourcedefinitions.apiextensions.k8s.io cluster-wide")
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_FailedInstances(t *testing.T) {
+	t.Run("should sweep the remaining crds and verify them after instances failed", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD(), fixCRD(sweptRuleGVR.Resource, sweptRuleGVR.Group, sweptRuleGVR.Version))
+		dyn := newFakeDynamicClient(withListKinds(map[schema.GroupVersionResource]string{sweptRuleGVR: "RuleList"}), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixSweptObject(sweptRuleGVR, "Rule", "kyma-system", "rule", "oathkeeper.ory.sh/finalizer")))
+		failFirstCalls(dyn, "update", 10, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "client", errors.New("rbac")))
+		handler := newTestHandler(t, apix, dyn, WithCRDs(oauth2ClientCRD, "rules.oathkeeper.ory.sh"), WithVerification())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		var cleanupErrs *CleanupErrors
+		require.True(t, errors.As(err, &cleanupErrs))
+		require.Len(t, cleanupErrs.Failures, 1)
+		require.Equal(t, "client", cleanupErrs.Failures[0].Name)
+		require.Len(t, result.CRDs, 2)
+		require.Equal(t, 1, result.CRDs[1].RemovedFinalizers)
+		requireSweptFinalizers(t, dyn, sweptRuleGVR, "kyma-system", "rule")
+		require.False(t, result.Verified)
+		require.Equal(t, []UnverifiedResource{{
+			GVR:        oauth2ClientGVR,
+			Namespace:  "default",
+			Name:       "client",
+			Finalizers: []string{"finalizer.ory.hydra.sh"},
+		}}, result.Unverified)
+	})
+
+	t.Run("should combine the failures of all crds", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD(), fixCRD(sweptRuleGVR.Resource, sweptRuleGVR.Group, sweptRuleGVR.Version))
+		dyn := newFakeDynamicClient(withListKinds(map[schema.GroupVersionResource]string{sweptRuleGVR: "RuleList"}), withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixSweptObject(sweptRuleGVR, "Rule", "kyma-system", "rule", "oathkeeper.ory.sh/finalizer")))
+		dyn.PrependReactor("update", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(action.GetResource().GroupResource(), "", errors.New("rbac"))
+		})
+		handler := newTestHandler(t, apix, dyn, WithCRDs(oauth2ClientCRD, "rules.oathkeeper.ory.sh"))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		var cleanupErrs *CleanupErrors
+		require.True(t, errors.As(err, &cleanupErrs))
+		require.Equal(t, result.Failures(), cleanupErrs.Failures)
+		require.Len(t, cleanupErrs.Forbidden(), 2)
+	})
+
+	t.Run("should stop at a required crd which does not exist", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithCRDs("jwks.hydra.ory.sh", oauth2ClientCRD), WithRequireCRD())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsCRDNotFoundError(err))
+		require.Empty(t, result.CRDs)
+	})
+}
+
+func Test_RemoveCustomResourceFinalizers_MalformedFinalizers(t *testing.T) {
+	for name, finalizers := range map[string]interface{}{
+		"string":     "finalizer.ory.hydra.sh",
+		"map":        map[string]interface{}{"finalizer.ory.hydra.sh": true},
+		"mixed list": []interface{}{"finalizer.ory.hydra.sh", int64(1)},
+		"number":     int64(7),
+	} {
+		t.Run("should reset malformed finalizers field of type "+name, func(t *testing.T) {
+			// given
+			instance := fixOAuth2Client("default", "client")
+			instance.Object["metadata"].(map[string]interface{})["finalizers"] = finalizers
+			dyn := newFakeDynamicClient(withObjects(instance))
+			handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+			// when
+			_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+			// then
+			require.NoError(t, err)
+			res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+			require.NoError(t, err)
+			repaired, found, err := unstructured.NestedStringSlice(res.Object, "metadata", "finalizers")
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Empty(t, repaired)
+		})
+	}
+}
+
+func Test_RemoveCustomResourceFinalizers_Annotation(t *testing.T) {
+	t.Run("should record removed finalizers in an annotation", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh", "example.com/keep")
+		instance.SetAnnotations(map[string]string{"example.com/other": "value"})
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithRemovedFinalizersAnnotation(true))
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client", "example.com/keep")
+		record := requireRemovedFinalizersRecord(t, dyn, "default", "client")
+		require.Equal(t, []string{"finalizer.ory.hydra.sh"}, record.Finalizers)
+		timestamp, err := time.Parse(time.RFC3339, record.Timestamp)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now(), timestamp, time.Minute)
+		res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "value", res.GetAnnotations()["example.com/other"])
+	})
+
+	t.Run("should replace an existing record instead of appending to it", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		instance.SetAnnotations(map[string]string{
+			RemovedFinalizersAnnotation: `{"finalizers":["old.ory.sh"],"timestamp":"2020-01-01T00:00:00Z"}`,
+		})
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithRemovedFinalizersAnnotation(true))
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		record := requireRemovedFinalizersRecord(t, dyn, "default", "client")
+		require.Equal(t, []string{"finalizer.ory.hydra.sh"}, record.Finalizers)
+		require.NotEqual(t, "2020-01-01T00:00:00Z", record.Timestamp)
+	})
+
+	t.Run("should not annotate resources without removed finalizers", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "example.com/keep")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithRemovedFinalizersAnnotation(true))
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, res.GetAnnotations(), RemovedFinalizersAnnotation)
+	})
+
+	t.Run("should not annotate resources when disabled", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.NotContains(t, res.GetAnnotations(), RemovedFinalizersAnnotation)
+	})
+}
+
+func requireRemovedFinalizersRecord(t *testing.T, dyn *dynamicfake.FakeDynamicClient, namespace, name string) removedFinalizersRecord {
+	res, err := dyn.Resource(oauth2ClientGVR).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	annotation, ok := res.GetAnnotations()[RemovedFinalizersAnnotation]
+	require.True(t, ok)
+	var record removedFinalizersRecord
+	require.NoError(t, json.Unmarshal([]byte(annotation), &record))
+	return record
+}
+
+func Test_FindAndDeleteOryFinalizers_RemovalPredicate(t *testing.T) {
+	keepAnnotated := func(obj unstructured.Unstructured) (bool, string) {
+		if obj.GetAnnotations()["example.com/keep-finalizers"] == "true" {
+			return false, "annotated with example.com/keep-finalizers"
+		}
+		return true, ""
+	}
+	fixAnnotatedClient := func(namespace, name string, finalizers ...string) *unstructured.Unstructured {
+		instance := fixOAuth2Client(namespace, name, finalizers...)
+		instance.SetAnnotations(map[string]string{"example.com/keep-finalizers": "true"})
+		return instance
+	}
+
+	t.Run("should skip the instances rejected by the predicate and record the reason", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixAnnotatedClient("default", "kept", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithRemovalPredicate(keepAnnotated))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, 1, result.CRDs[0].Skipped)
+		require.Equal(t, []RejectedResource{
+			{Namespace: "default", Name: "kept", Reason: "annotated with example.com/keep-finalizers"},
+		}, result.CRDs[0].Rejected)
+		requireFinalizers(t, dyn, "default", "client")
+		requireFinalizers(t, dyn, "default", "kept", "finalizer.ory.hydra.sh")
+		require.Equal(t, 1, countActions(dyn, "update"))
+	})
+
+	t.Run("should only remove matched finalizers of the accepted instances", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh", "example.com/cleanup"),
+			fixAnnotatedClient("default", "kept", "example.com/cleanup")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithRemovalPredicate(keepAnnotated), WithFinalizerMatcher(regexp.MustCompile(`^example\.com/`)))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+		requireFinalizers(t, dyn, "default", "kept", "example.com/cleanup")
+	})
+
+	t.Run("should pass the content of instances listed as metadata to the predicate", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		require.NoError(t, unstructured.SetNestedField(instance.Object, "legacy", "spec", "clientName"))
+		dyn := newFakeDynamicClient(withObjects(instance))
+		listed := fixOAuth2ClientMetadata("default", "client", "finalizer.ory.hydra.sh")
+		listed.SetResourceVersion("1")
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithRemovalPredicate(func(obj unstructured.Unstructured) (bool, string) {
+				clientName, _, _ := unstructured.NestedString(obj.Object, "spec", "clientName")
+				return clientName != "legacy", "legacy client"
+			}))
+		handler.metadata = newFakeMetadataClient(listed)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, result.RemovedFinalizers())
+		require.Equal(t, "legacy client", result.CRDs[0].Rejected[0].Reason)
+		require.Equal(t, 1, countActions(dyn, "get"))
+		require.Zero(t, countActions(dyn, "patch"))
+	})
+
+	t.Run("should not verify the rejected instances", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixAnnotatedClient("default", "kept", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithRemovalPredicate(keepAnnotated), WithVerification())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.True(t, result.Verified)
+		require.Empty(t, result.Unverified)
+	})
+
+	t.Run("should stream the rejected instances as skipped with the reason", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixAnnotatedClient("default", "kept", "finalizer.ory.hydra.sh")))
+		handler := newStreamTestHandler(t, dyn, WithRemovalPredicate(keepAnnotated))
+
+		// when
+		results, errs := handler.StreamOryFinalizers(context.Background(), "")
+		var streamed []ResourceResult
+		for result := range results {
+			streamed = append(streamed, result)
+		}
+
+		// then
+		require.NoError(t, <-errs)
+		require.Equal(t, []ResourceResult{{
+			GVR:       oauth2ClientGVR,
+			Namespace: "default",
+			Name:      "kept",
+			State:     ResourceSkipped,
+			Reason:    "annotated with example.com/keep-finalizers",
+		}}, streamed)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_ListedInstances(t *testing.T) {
+	t.Run("should update the listed instances without reading them again", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "a", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "b", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "c", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 3, result.RemovedFinalizers())
+		// a get per instance took 7 requests before
+		require.Equal(t, 1, countActions(dyn, "list"))
+		require.Equal(t, 3, countActions(dyn, "update"))
+		require.Zero(t, countActions(dyn, "get"))
+		require.Len(t, dyn.Actions(), 4)
+	})
+
+	t.Run("should patch the metadata of instances listed as metadata without reading them", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh", "example.com/keep")
+		instance.SetResourceVersion("1")
+		require.NoError(t, unstructured.SetNestedField(instance.Object, "secret", "spec", "clientName"))
+		dyn := newFakeDynamicClient(withObjects(instance))
+		listed := fixOAuth2ClientMetadata("default", "client", "finalizer.ory.hydra.sh", "example.com/keep")
+		listed.SetResourceVersion("1")
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+		handler.metadata = newFakeMetadataClient(listed)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, 1, countActions(dyn, "patch"))
+		require.Zero(t, countActions(dyn, "get"))
+		require.Len(t, dyn.Actions(), 1)
+		requireFinalizers(t, dyn, "default", "client", "example.com/keep")
+		stored, err := dyn.Tracker().Get(oauth2ClientGVR, "default", "client")
+		require.NoError(t, err)
+		clientName, _, err := unstructured.NestedString(stored.(*unstructured.Unstructured).Object, "spec", "clientName")
+		require.NoError(t, err)
+		require.Equal(t, "secret", clientName)
+	})
+
+	t.Run("should read a stale listed instance again after the conflict", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		instance.SetResourceVersion("2")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		writes := enforceResourceVersion(t, dyn)
+		listed := fixOAuth2ClientMetadata("default", "client", "finalizer.ory.hydra.sh")
+		listed.SetResourceVersion("1")
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+		handler.metadata = newFakeMetadataClient(listed)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, []string{"1", "2"}, *writes)
+		// one get explains the conflict, one reads the current instance
+		require.Equal(t, 1, countActions(dyn, "patch"))
+		require.Equal(t, 2, countActions(dyn, "get"))
+		require.Equal(t, 1, countActions(dyn, "update"))
+		require.Len(t, dyn.Actions(), 4)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should succeed if the listed instance is gone", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		listed := fixOAuth2ClientMetadata("default", "client", "finalizer.ory.hydra.sh")
+		listed.SetResourceVersion("1")
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+		handler.metadata = newFakeMetadataClient(listed)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, result.Failures())
+		require.Equal(t, 1, countActions(dyn, "patch"))
+		require.Zero(t, countActions(dyn, "get"))
+		require.Len(t, dyn.Actions(), 1)
+	})
+
+	t.Run("should read instances listed as metadata if nested finalizers are removed", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		listed := fixOAuth2ClientMetadata("default", "client", "finalizer.ory.hydra.sh")
+		listed.SetResourceVersion("1")
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithNestedFinalizerPaths())
+		handler.metadata = newFakeMetadataClient(listed)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, 1, countActions(dyn, "get"))
+		require.Equal(t, 1, countActions(dyn, "update"))
+		require.Len(t, dyn.Actions(), 2)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_ExcludedNamespaces(t *testing.T) {
+	t.Run("should skip instances in excluded namespaces", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("ory-keep", "client", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("other-keep", "client", "finalizer.ory.hydra.sh")))
+		var reported []int
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithExcludedNamespaces("ory-keep", "other-keep"),
+			WithProgress(func(done, total int) { reported = append(reported, done) }))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+		requireFinalizers(t, dyn, "ory-keep", "client", "finalizer.ory.hydra.sh")
+		requireFinalizers(t, dyn, "other-keep", "client", "finalizer.ory.hydra.sh")
+		require.Equal(t, 3, result.CRDs[0].Instances)
+		require.Equal(t, 2, result.CRDs[0].Skipped)
+		require.Equal(t, []int{1, 2, 3}, reported)
+	})
+
+	t.Run("should exclude the namespaces server-side", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithExcludedNamespaces("other-keep", "ory-keep"))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{"metadata.namespace!=ory-keep,metadata.namespace!=other-keep"}, listFieldSelectors(dyn))
+	})
+
+	t.Run("should filter client-side if the field selector is rejected", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("ory-keep", "client", "finalizer.ory.hydra.sh")))
+		dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.(k8stesting.ListAction).GetListRestrictions().Fields.Empty() {
+				return false, nil, nil
+			}
+			return true, nil, apierr.NewBadRequest("field label not supported: metadata.namespace")
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithExcludedNamespaces("ory-keep"))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+		require.NoError(t, err)
+		_, err = handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.CRDs[0].Skipped)
+		requireFinalizers(t, dyn, "default", "client")
+		requireFinalizers(t, dyn, "ory-keep", "client", "finalizer.ory.hydra.sh")
+		require.Equal(t, []string{"metadata.namespace!=ory-keep", "", ""}, listFieldSelectors(dyn))
+	})
+}
+
+// listFieldSelectors returns the field selectors of all list requests
+func listFieldSelectors(dyn *dynamicfake.FakeDynamicClient) []string {
+	var selectors []string
+	for _, action := range dyn.Actions() {
+		if list, ok := action.(k8stesting.ListAction); ok {
+			selectors = append(selectors, list.GetListRestrictions().Fields.String())
+		}
+	}
+	return selectors
+}
+
+func Test_FindAndDeleteOryFinalizers_InformerCache(t *testing.T) {
+	t.Run("should read repeated sweeps from the informer cache", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithInformerCache(0))
+		defer handler.Close()
+
+		// when
+		first, err := handler.findAndDeleteOryFinalizers()
+		require.NoError(t, err)
+		second, err := handler.findAndDeleteOryFinalizers()
+		require.NoError(t, err)
+
+		// then
+		requireFinalizers(t, dyn, "default", "client")
+		require.Equal(t, 1, first.CRDs[0].RemovedFinalizers)
+		require.Equal(t, 1, second.CRDs[0].Instances)
+		require.Equal(t, 1, countActions(dyn, "list"))
+		require.Equal(t, 1, countActions(dyn, "watch"))
+	})
+
+	t.Run("should start new informers after the handler was closed", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithInformerCache(0))
+		_, err := handler.findAndDeleteOryFinalizers()
+		require.NoError(t, err)
+
+		// when
+		handler.Close()
+		_, err = handler.findAndDeleteOryFinalizers()
+		handler.Close()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, countActions(dyn, "list"))
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_GracePeriod(t *testing.T) {
+	t.Run("should only remove finalizers of resources terminating longer than the grace period", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		dyn := newFakeDynamicClient(withObjects(
+			fixTerminatingOAuth2Client("default", "stuck", fakeClock.Now().Add(-2*time.Minute), "finalizer.ory.sh"),
+			fixTerminatingOAuth2Client("default", "young", fakeClock.Now().Add(-30*time.Second), "finalizer.ory.sh"),
+			fixOAuth2Client("default", "alive", "finalizer.ory.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(fakeClock), WithGracePeriod(time.Minute))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "stuck")
+		requireFinalizers(t, dyn, "default", "young", "finalizer.ory.sh")
+		requireFinalizers(t, dyn, "default", "alive")
+		require.Equal(t, 1, result.CRDs[0].PendingGrace)
+		require.Equal(t, 2, result.CRDs[0].RemovedFinalizers)
+	})
+
+	t.Run("should only remove finalizers of terminating resources in terminating-only mode", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		dyn := newFakeDynamicClient(withObjects(
+			fixTerminatingOAuth2Client("default", "stuck", fakeClock.Now(), "finalizer.ory.sh"),
+			fixOAuth2Client("default", "alive", "finalizer.ory.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(fakeClock), WithTerminatingOnly())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "stuck")
+		requireFinalizers(t, dyn, "default", "alive", "finalizer.ory.sh")
+		require.Equal(t, 1, result.CRDs[0].Skipped)
+		require.Zero(t, result.CRDs[0].PendingGrace)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_ClusterScoped(t *testing.T) {
+	t.Run("should remove finalizers of cluster-scoped custom resources", func(t *testing.T) {
+		// given
+		gvr := schema.GroupVersionResource{Group: "oathkeeper.ory.sh", Version: "v1alpha1", Resource: "globalrules"}
+		crd := fixCRD(gvr.Resource, gvr.Group, gvr.Version)
+		crd.Spec.Scope = apixv1beta1.ClusterScoped
+		rule := &unstructured.Unstructured{}
+		rule.SetAPIVersion(gvr.GroupVersion().String())
+		rule.SetKind("GlobalRule")
+		rule.SetName("rule")
+		rule.SetFinalizers([]string{"finalizer.oathkeeper.ory.sh", "kept"})
+		dyn := newFakeDynamicClient(withListKinds(map[schema.GroupVersionResource]string{gvr: "GlobalRuleList"}), withObjects(rule))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(crd), dyn,
+			WithCRDs(crd.Name), WithExcludedNamespaces("kube-system"))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.CRDs[0].RemovedFinalizers)
+		res, err := dyn.Resource(gvr).Get(context.Background(), "rule", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"kept"}, res.GetFinalizers())
+		for _, action := range dyn.Actions() {
+			require.Empty(t, action.GetNamespace())
+			if list, ok := action.(k8stesting.ListAction); ok {
+				require.Empty(t, list.GetListRestrictions().Fields.String())
+			}
+		}
+	})
+
+	t.Run("should report the scope of discovered custom resources", func(t *testing.T) {
+		// given
+		crd := fixCRD("globalrules", "oathkeeper.ory.sh", "v1alpha1")
+		crd.Spec.Scope = apixv1beta1.ClusterScoped
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(crd, fixOAuth2ClientCRD()), newFakeDynamicClient())
+
+		// when
+		crds, err := handler.discoverOryCRDs(context.Background())
+
+		// then
+		require.NoError(t, err)
+		require.ElementsMatch(t, []OryCRD{
+			{Name: crd.Name, Group: "oathkeeper.ory.sh", Resource: "globalrules", Versions: []string{"v1alpha1"}, ClusterScoped: true},
+			{Name: oauth2ClientCRD, Group: oauth2ClientGVR.Group, Resource: oauth2ClientGVR.Resource, Versions: []string{oauth2ClientGVR.Version}},
+		}, crds)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_MinimumAge(t *testing.T) {
+	t.Run("should skip resources younger than the minimum age", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2ClientCreatedAt("default", "old", fakeClock.Now().Add(-time.Hour), "finalizer.ory.sh"),
+			fixOAuth2ClientCreatedAt("default", "new", fakeClock.Now().Add(-time.Minute), "finalizer.ory.sh"),
+			fixOAuth2ClientCreatedAt("istio-system", "installing", fakeClock.Now(), "finalizer.ory.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(fakeClock), WithMinimumAge(10*time.Minute), WithVerification())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "old")
+		requireFinalizers(t, dyn, "default", "new", "finalizer.ory.sh")
+		requireFinalizers(t, dyn, "istio-system", "installing", "finalizer.ory.sh")
+		require.Equal(t, 2, result.CRDs[0].TooYoung)
+		require.Equal(t, 1, result.CRDs[0].RemovedFinalizers)
+		require.True(t, result.Verified)
+	})
+
+	t.Run("should apply the minimum age and the grace period independently", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		oldAndStuck := fixOAuth2ClientCreatedAt("default", "old-and-stuck", fakeClock.Now().Add(-time.Hour), "finalizer.ory.sh")
+		oldAndStuck.SetDeletionTimestamp(&metav1.Time{Time: fakeClock.Now().Add(-5 * time.Minute)})
+		newAndStuck := fixOAuth2ClientCreatedAt("default", "new-and-stuck", fakeClock.Now().Add(-5*time.Minute), "finalizer.ory.sh")
+		newAndStuck.SetDeletionTimestamp(&metav1.Time{Time: fakeClock.Now().Add(-5 * time.Minute)})
+		oldAndTerminating := fixOAuth2ClientCreatedAt("default", "old-and-terminating", fakeClock.Now().Add(-time.Hour), "finalizer.ory.sh")
+		oldAndTerminating.SetDeletionTimestamp(&metav1.Time{Time: fakeClock.Now()})
+		dyn := newFakeDynamicClient(withObjects(oldAndStuck, newAndStuck, oldAndTerminating))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(fakeClock), WithMinimumAge(10*time.Minute), WithGracePeriod(time.Minute))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "old-and-stuck")
+		requireFinalizers(t, dyn, "default", "new-and-stuck", "finalizer.ory.sh")
+		requireFinalizers(t, dyn, "default", "old-and-terminating", "finalizer.ory.sh")
+		require.Equal(t, 1, result.CRDs[0].TooYoung)
+		require.Equal(t, 1, result.CRDs[0].PendingGrace)
+	})
+
+	t.Run("should not skip any resource without minimum age", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2ClientCreatedAt("default", "new", fakeClock.Now(), "finalizer.ory.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithClock(fakeClock))
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "new")
+		require.Zero(t, result.CRDs[0].TooYoung)
+	})
+}
+
+func fixOAuth2ClientCreatedAt(namespace, name string, createdAt time.Time, finalizers ...string) *unstructured.Unstructured {
+	res := fixOAuth2Client(namespace, name, finalizers...)
+	res.SetCreationTimestamp(metav1.Time{Time: createdAt})
+	return res
+}
+
+func Test_FindAndDeleteOryFinalizers_ModifiedObjects(t *testing.T) {
+	t.Run("should collect the updated instances", func(t *testing.T) {
+		for name, opts := range map[string][]Option{
+			"update": {WithModifiedObjects()},
+			"patch":  {WithModifiedObjects(), WithFinalizerPatch()},
+		} {
+			t.Run(name, func(t *testing.T) {
+				// given
+				first := fixOAuth2Client("default", "first", "finalizer.ory.sh", "kept")
+				first.SetResourceVersion("1")
+				second := fixOAuth2Client("istio-system", "second", "finalizer.ory.sh")
+				second.SetResourceVersion("1")
+				dyn := newFakeDynamicClient(withObjects(first, second, fixOAuth2Client("default", "untouched", "kept")))
+				handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, opts...)
+
+				// when
+				result, err := handler.findAndDeleteOryFinalizers()
+
+				// then
+				require.NoError(t, err)
+				modified := map[string]string{}
+				for _, res := range result.CRDs[0].Modified {
+					modified[res.GetNamespace()+"/"+res.GetName()] = strings.Join(res.GetFinalizers(), ",")
+				}
+				require.Equal(t, map[string]string{"default/first": "kept", "istio-system/second": ""}, modified)
+			})
+		}
+	})
+
+	t.Run("should not collect the updated instances by default", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.CRDs[0].RemovedFinalizers)
+		require.Nil(t, result.CRDs[0].Modified)
+	})
+
+	t.Run("should not collect instances deleted before the update", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		failFirstCalls(dyn, "update", 1, apierr.NewNotFound(oauth2ClientGVR.GroupResource(), "client"))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithModifiedObjects())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, result.CRDs[0].Modified)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_DeleteCRDWhenEmpty(t *testing.T) {
+	t.Run("should delete the crd without instances", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		handler := newTestHandler(t, apix, newFakeDynamicClient(), WithDeleteCRDWhenEmpty())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.True(t, result.CRDs[0].CRDDeleted)
+		_, err = apix.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.Background(), oauth2ClientCRD, metav1.GetOptions{})
+		require.True(t, apierr.IsNotFound(err))
+	})
+
+	t.Run("should delete the crd with the propagation policy", func(t *testing.T) {
+		for policy, expected := range map[metav1.DeletionPropagation]metav1.DeletionPropagation{
+			"":                                 metav1.DeletePropagationBackground,
+			metav1.DeletePropagationForeground: metav1.DeletePropagationForeground,
+		} {
+			// given
+			apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+			handler := newTestHandler(t, apix, newFakeDynamicClient(), WithDeleteCRDWhenEmpty(), WithPropagationPolicy(policy))
+
+			// when
+			_, err := handler.findAndDeleteOryFinalizers()
+
+			// then
+			require.NoError(t, err)
+			var deletions []metav1.DeleteOptions
+			for _, action := range apix.Actions() {
+				if deletion, ok := action.(k8stesting.DeleteActionImpl); ok {
+					deletions = append(deletions, deletion.DeleteOptions)
+				}
+			}
+			require.Len(t, deletions, 1)
+			require.Equal(t, expected, *deletions[0].PropagationPolicy)
+		}
+	})
+
+	t.Run("should keep the crd when instances are left", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		handler := newTestHandler(t, apix, dyn, WithDeleteCRDWhenEmpty())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+		require.False(t, result.CRDs[0].CRDDeleted)
+		_, err = apix.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.Background(), oauth2ClientCRD, metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should keep the crd when finalizers could not be removed", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.sh")))
+		failFirstCalls(dyn, "update", 100, errors.New("update failed"))
+		handler := newTestHandler(t, apix, dyn, WithDeleteCRDWhenEmpty())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.Error(t, err)
+		require.False(t, result.CRDs[0].CRDDeleted)
+		for _, action := range apix.Actions() {
+			require.NotEqual(t, "delete", action.GetVerb())
+		}
+	})
+
+	t.Run("should keep the crd by default", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixOAuth2ClientCRD())
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.False(t, result.CRDs[0].CRDDeleted)
+		_, err = apix.ApiextensionsV1beta1().CustomResourceDefinitions().Get(context.Background(), oauth2ClientCRD, metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_Progress(t *testing.T) {
+	t.Run("should report progress for each processed instance", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2"),
+			fixOAuth2Client("other", "client3", "finalizer.ory.hydra.sh")))
+		var reported [][2]int
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithProgress(func(done, total int) { reported = append(reported, [2]int{done, total}) }))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+		require.NoError(t, err)
+		_, err = handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, [][2]int{{1, 3}, {2, 3}, {3, 3}, {1, 3}, {2, 3}, {3, 3}}, reported)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_ConcurrentRuns(t *testing.T) {
+	t.Run("should keep the progress and result of concurrent runs apart", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("other", "client3", "finalizer.ory.hydra.sh")))
+		var mu sync.Mutex
+		var reported [][2]int
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithProgress(func(done, total int) {
+				mu.Lock()
+				defer mu.Unlock()
+				reported = append(reported, [2]int{done, total})
+			}))
+
+		// when
+		results := make([]*CleanupResult, 2)
+		errs := make([]error, 2)
+		var runs sync.WaitGroup
+		for i := range results {
+			runs.Add(1)
+			go func(i int) {
+				defer runs.Done()
+				results[i], errs[i] = handler.findAndDeleteOryFinalizers()
+			}(i)
+		}
+		runs.Wait()
+
+		// then
+		for i := range results {
+			require.NoError(t, errs[i])
+			require.Len(t, results[i].CRDs, 1)
+			require.Equal(t, 3, results[i].CRDs[0].Instances)
+		}
+		require.ElementsMatch(t, [][2]int{{1, 3}, {2, 3}, {3, 3}, {1, 3}, {2, 3}, {3, 3}}, reported)
+		requireFinalizers(t, dyn, "default", "client1")
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_UpdateInterval(t *testing.T) {
+	t.Run("should wait at least the configured interval between updates", func(t *testing.T) {
+		// given
+		interval := 30 * time.Second
+		fakeClock := newSteppingClock()
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client3", "finalizer.ory.hydra.sh")))
+		updates := recordUpdateTimes(dyn, fakeClock)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithUpdateInterval(interval, 0.5), WithClock(fakeClock))
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, *updates, 3)
+		for i := 1; i < len(*updates); i++ {
+			gap := (*updates)[i].Sub((*updates)[i-1])
+			require.GreaterOrEqual(t, gap, interval)
+			require.LessOrEqual(t, gap, interval+interval/2)
+		}
+	})
+
+	t.Run("should serialize concurrent updates", func(t *testing.T) {
+		// given
+		interval := 20 * time.Millisecond
+		throttle := &updateThrottle{interval: interval}
+		var mu sync.Mutex
+		var calls []time.Time
+		var wg sync.WaitGroup
+
+		// when
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				require.NoError(t, throttle.wait(context.Background(), clock.RealClock{}))
+				mu.Lock()
+				calls = append(calls, time.Now())
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		// then
+		sort.Slice(calls, func(i, j int) bool { return calls[i].Before(calls[j]) })
+		require.GreaterOrEqual(t, calls[3].Sub(calls[0]), 3*interval-5*time.Millisecond)
+	})
+}
+
+// recordUpdateTimes returns a pointer to the times of all update calls issued to the fake client
+func recordUpdateTimes(dyn *dynamicfake.FakeDynamicClient, clock clock.PassiveClock) *[]time.Time {
+	var updates []time.Time
+	dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates = append(updates, clock.Now())
+		return false, nil, nil
+	})
+	return &updates
+}
+
+func Test_FindAndDeleteOryFinalizers_WithoutLogger(t *testing.T) {
+	t.Run("should run cleanup when constructed without logger", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := NewDefaultOryFinalizersHandler()
+		handler.apixClient = apixfake.NewSimpleClientset(fixOAuth2ClientCRD()).ApiextensionsV1beta1()
+		handler.dynamic = dyn
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+
+	t.Run("should run cleanup when not constructed by the constructor", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := &DefaultOryFinalizersHandler{
+			apixClient: apixfake.NewSimpleClientset(fixOAuth2ClientCRD()).ApiextensionsV1beta1(),
+			dynamic:    dyn,
+		}
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client")
+	})
+}
+
+func Test_RemoveCustomResourceFinalizers_Matching(t *testing.T) {
+	t.Run("should only remove ory finalizers and preserve others", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client",
+			"finalizer.ory.hydra.sh", "kubernetes.io/pvc-protection", "oathkeeper.ory.sh/finalizer", "example.com/keep")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client", "kubernetes.io/pvc-protection", "example.com/keep")
+	})
+
+	t.Run("should not update resource without matching finalizers", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "example.com/keep")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client", "example.com/keep")
+		for _, action := range dyn.Actions() {
+			require.NotEqual(t, "update", action.GetVerb())
+		}
+	})
+
+	t.Run("should succeed when resource is deleted between list and update", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if err := dyn.Tracker().Delete(oauth2ClientGVR, "default", "client"); err != nil {
+				return true, nil, err
+			}
+			return true, nil, apierr.NewNotFound(oauth2ClientGVR.GroupResource(), "client")
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		_, err = dyn.Tracker().Get(oauth2ClientGVR, "default", "client")
+		require.True(t, apierr.IsNotFound(err))
+	})
+
+	t.Run("should remove finalizers matching a custom expression", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh", "example.com/drop", "example.com/keep")
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn,
+			WithFinalizerMatcher(regexp.MustCompile(`^example\.com/drop$`)))
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh", "example.com/keep")
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_OrphanedOwnerReferences(t *testing.T) {
+	t.Run("should report owner references whose referent is gone without removing them", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		instance.SetOwnerReferences([]metav1.OwnerReference{
+			fixOwnerReference("v1", "Secret", "existing", "uid-existing"),
+			fixOwnerReference("v1", "Secret", "deleted", "uid-deleted"),
+			fixOwnerReference("v1", "Secret", "recreated", "uid-old"),
+			fixOwnerReference("v1", "Namespace", "owner-ns", "uid-ns"),
+			fixOwnerReference("example.com/v1", "Unknown", "unknown", "uid-unknown"),
+		})
+		dyn := newFakeDynamicClient(withObjects(instance,
+			fixOwner("v1", "Secret", "default", "existing", "uid-existing"),
+			fixOwner("v1", "Secret", "default", "recreated", "uid-new"),
+			fixOwner("v1", "Namespace", "", "owner-ns", "uid-ns")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithOrphanedOwnerReferences(false))
+		handler.restMapper = newTestRESTMapper()
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.CRDs, 1)
+		var orphaned []string
+		for _, ref := range result.CRDs[0].OrphanedOwnerReferences {
+			require.Equal(t, "default", ref.Namespace)
+			require.Equal(t, "client", ref.Name)
+			require.False(t, ref.Removed)
+			orphaned = append(orphaned, ref.OwnerReference.Name)
+		}
+		require.Equal(t, []string{"deleted", "recreated", "unknown"}, orphaned)
+		requireFinalizers(t, dyn, "default", "client")
+		res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Len(t, res.GetOwnerReferences(), 5)
+	})
+
+	t.Run("should remove orphaned owner references together with the finalizers", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		instance.SetOwnerReferences([]metav1.OwnerReference{
+			fixOwnerReference("v1", "Secret", "existing", "uid-existing"),
+			fixOwnerReference("v1", "Secret", "deleted", "uid-deleted"),
+		})
+		dyn := newFakeDynamicClient(withObjects(instance, fixOwner("v1", "Secret", "default", "existing", "uid-existing")))
+		updates := 0
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			updates++
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithOrphanedOwnerReferences(true))
+		handler.restMapper = newTestRESTMapper()
+
+		// when
+		outcome, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, outcome.ownerReferencesRemoved)
+		require.Equal(t, 1, updates)
+		requireFinalizers(t, dyn, "default", "client")
+		res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []metav1.OwnerReference{fixOwnerReference("v1", "Secret", "existing", "uid-existing")}, res.GetOwnerReferences())
+	})
+
+	t.Run("should remove orphaned owner references from resources without ory finalizers", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "example.com/keep")
+		instance.SetOwnerReferences([]metav1.OwnerReference{fixOwnerReference("v1", "Secret", "deleted", "uid-deleted")})
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithOrphanedOwnerReferences(true))
+		handler.restMapper = newTestRESTMapper()
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, dyn, "default", "client", "example.com/keep")
+		res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Empty(t, res.GetOwnerReferences())
+	})
+
+	t.Run("should fail the instance when the existence of an owner cannot be checked", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		instance.SetOwnerReferences([]metav1.OwnerReference{fixOwnerReference("v1", "Secret", "owner", "uid-owner")})
+		dyn := newFakeDynamicClient(withObjects(instance))
+		dyn.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(schema.GroupResource{Resource: "secrets"}, "owner", errors.New("denied"))
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn, WithOrphanedOwnerReferences(true))
+		handler.restMapper = newTestRESTMapper()
+
+		// when
+		_, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.True(t, apierr.IsForbidden(err))
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should not check owner references when disabled", func(t *testing.T) {
+		// given
+		instance := fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")
+		instance.SetOwnerReferences([]metav1.OwnerReference{fixOwnerReference("v1", "Secret", "deleted", "uid-deleted")})
+		dyn := newFakeDynamicClient(withObjects(instance))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		outcome, err := handler.removeInstanceFinalizers(handler.newRun(context.Background(), nil), oauth2ClientGVR, *instance)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, outcome.orphanedOwnerReferences)
+		res, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Get(context.Background(), "client", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Len(t, res.GetOwnerReferences(), 1)
+	})
+}
+
+func Test_DefaultFinalizerPattern(t *testing.T) {
+	for finalizer, expected := range map[string]bool{
+		"finalizer.ory.hydra.sh":       true,
+		"finalizer.oathkeeper.ory.sh":  true,
+		"ory.sh":                       true,
+		"hydra.ory.sh/finalizer":       true,
+		"kubernetes.io/pvc-protection": false,
+		"foregroundDeletion":           false,
+		"factory.sh":                   false,
+		"ory.sh.example.com":           false,
+	} {
+		require.Equal(t, expected, DefaultFinalizerPattern.MatchString(finalizer), finalizer)
+	}
+}
+
+func newTestHandler(t *testing.T, apix *apixfake.Clientset, dyn *dynamicfake.FakeDynamicClient, opts ...Option) *DefaultOryFinalizersHandler {
+	handler := NewDefaultOryFinalizersHandler(append([]Option{WithLogger(logger.NewTestLogger(t))}, opts...)...)
+	handler.apixClient = apix.ApiextensionsV1beta1()
+	handler.dynamic = dyn
+	return handler
+}
+
+type fakeDynamicConfig struct {
+	objects   []runtime.Object
+	listKinds map[schema.GroupVersionResource]string
+	listOrder *unstructured.UnstructuredList
+}
+
+type fakeDynamicOption func(*fakeDynamicConfig)
+
+// withObjects adds the objects to the tracker of the fake client
+func withObjects(objects ...runtime.Object) fakeDynamicOption {
+	return func(c *fakeDynamicConfig) {
+		c.objects = append(c.objects, objects...)
+	}
+}
+
+// withListKinds lets the fake client list further resources, oauth2clients can always be listed
+func withListKinds(kinds map[schema.GroupVersionResource]string) fakeDynamicOption {
+	return func(c *fakeDynamicConfig) {
+		for gvr, kind := range kinds {
+			c.listKinds[gvr] = kind
+		}
+	}
+}
+
+// withListOrder adds the oauth2client instances to the tracker and lists them in the given order
+func withListOrder(instances ...*unstructured.Unstructured) fakeDynamicOption {
+	return func(c *fakeDynamicConfig) {
+		if c.listOrder == nil {
+			c.listOrder = &unstructured.UnstructuredList{}
+			c.listOrder.SetAPIVersion(oauth2ClientGVR.GroupVersion().String())
+			c.listOrder.SetKind("OAuth2ClientList")
+		}
+		for _, instance := range instances {
+			c.objects = append(c.objects, instance)
+			c.listOrder.Items = append(c.listOrder.Items, *instance.DeepCopy())
+		}
+	}
+}
+
+// newFakeDynamicClient returns the fake dynamic client of all tests, configured by the options
+func newFakeDynamicClient(opts ...fakeDynamicOption) *dynamicfake.FakeDynamicClient {
+	config := &fakeDynamicConfig{listKinds: map[schema.GroupVersionResource]string{oauth2ClientGVR: "OAuth2ClientList"}}
+	for _, opt := range opts {
+		opt(config)
+	}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), config.listKinds, config.objects...)
+	if config.listOrder != nil {
+		dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, config.listOrder.DeepCopy(), nil
+		})
+	}
+	return dyn
+}
+
+// failFirstCalls lets the first n calls of the given verb fail with err and returns a pointer to the call counter
+func failFirstCalls(dyn *dynamicfake.FakeDynamicClient, verb string, n int, err error) *int {
+	calls := 0
+	dyn.PrependReactor(verb, oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls <= n {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	return &calls
+}
+
+func requireFinalizers(t *testing.T, dyn *dynamicfake.FakeDynamicClient, namespace, name string, expected ...string) {
+	res, err := dyn.Resource(oauth2ClientGVR).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	if len(expected) == 0 {
+		require.Empty(t, res.GetFinalizers())
+		return
+	}
+	require.Equal(t, expected, res.GetFinalizers())
+}
+
+func fixOAuth2ClientCRD() *apixv1beta1.CustomResourceDefinition {
+	return fixCRD(oauth2ClientGVR.Resource, oauth2ClientGVR.Group, oauth2ClientGVR.Version)
+}
+
+func fixOAuth2Client(namespace, name string, finalizers ...string) *unstructured.Unstructured {
+	res := &unstructured.Unstructured{}
+	res.SetAPIVersion(oauth2ClientGVR.GroupVersion().String())
+	res.SetKind("OAuth2Client")
+	res.SetNamespace(namespace)
+	res.SetName(name)
+	res.SetFinalizers(finalizers)
+	return res
+}
+
+func newTestRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return mapper
+}
+
+func fixOwnerReference(apiVersion, kind, name string, uid types.UID) metav1.OwnerReference {
+	return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: uid}
+}
+
+func fixOwner(apiVersion, kind, namespace, name string, uid types.UID) *unstructured.Unstructured {
+	res := &unstructured.Unstructured{}
+	res.SetAPIVersion(apiVersion)
+	res.SetKind(kind)
+	res.SetNamespace(namespace)
+	res.SetName(name)
+	res.SetUID(uid)
+	return res
+}
@@ -0,0 +1,39 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// updateThrottle enforces a minimum, jittered interval between successive updates.
+// Waiting callers are serialized, so the interval also applies to the aggregate of concurrent callers.
+// A nil throttle does not delay at all. The wait ends early with the error of the context when the context is done.
+type updateThrottle struct {
+	interval time.Duration
+	jitter   float64
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (t *updateThrottle) wait(ctx context.Context, clock clock.Clock) error {
+	if t == nil || t.interval <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.last.IsZero() {
+		if remaining := wait.Jitter(t.interval, t.jitter) - clock.Since(t.last); remaining > 0 {
+			if err := sleepOnClock(clock)(ctx, remaining); err != nil {
+				return err
+			}
+		}
+	}
+	t.last = clock.Now()
+	return nil
+}
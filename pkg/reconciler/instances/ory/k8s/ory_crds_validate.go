@@ -0,0 +1,34 @@
+package k8s
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// validateSweptResources looks up the resources of all swept CRDs in the REST mapper, which is built from the
+// discovery of the cluster. The name of a CRD is the resource and group of its instances, e.g.
+// "oauth2clients.hydra.ory.sh", so they are validated without reading the CRDs. The mapper also resolves singular
+// names, which are no CRD names, so only a resource of the exact name counts.
+func (h *DefaultOryFinalizersHandler) validateSweptResources() error {
+	for _, name := range h.sweptCRDs() {
+		resource := schema.ParseGroupResource(name)
+		resources, err := h.currentClients().restMapper.ResourcesFor(resource.WithVersion(""))
+		if err != nil && !meta.IsNoMatchError(err) {
+			return errors.Wrapf(err, "failed to validate resource \"%s\"", resource.String())
+		}
+		if !containsGroupResource(resources, resource) {
+			return &ResourceNotFoundError{Resource: resource, err: err}
+		}
+	}
+	return nil
+}
+
+func containsGroupResource(resources []schema.GroupVersionResource, resource schema.GroupResource) bool {
+	for _, gvr := range resources {
+		if gvr.GroupResource() == resource {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_WithResourceValidation(t *testing.T) {
+	t.Run("should sweep when all resources are served", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithResourceValidation())
+		handler.restMapper = newValidationRESTMapper()
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+	})
+
+	t.Run("should fail before any change if a resource is not served", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithCRDs(oauth2ClientCRD, "virtualservice.networking.istio.io"), WithResourceValidation())
+		handler.restMapper = newValidationRESTMapper()
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.True(t, IsResourceNotFoundError(err))
+		require.EqualError(t, err, `resource "virtualservice.networking.istio.io" not found in cluster`)
+		require.Empty(t, result.CRDs)
+		require.Empty(t, dyn.Actions())
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should return other errors of the REST mapper", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), newFakeDynamicClient(), WithResourceValidation())
+		handler.restMapper = &failingRESTMapper{RESTMapper: newValidationRESTMapper(), err: errors.New("discovery failed")}
+
+		// when
+		_, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.EqualError(t, err, `failed to validate resource "oauth2clients.hydra.ory.sh": discovery failed`)
+		require.False(t, IsResourceNotFoundError(err))
+	})
+}
+
+func newValidationRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(oauth2ClientGVR.GroupVersion().WithKind("OAuth2Client"), meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1beta1", Kind: "VirtualService"}, meta.RESTScopeNamespace)
+	return mapper
+}
+
+type failingRESTMapper struct {
+	meta.RESTMapper
+	err error
+}
+
+func (m *failingRESTMapper) ResourcesFor(schema.GroupVersionResource) ([]schema.GroupVersionResource, error) {
+	return nil, m.err
+}
@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// UnverifiedResource is a custom resource which still had finalizers matched by the finalizer matcher when the run
+// was verified, see WithVerification
+type UnverifiedResource struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	Name      string
+	// Finalizers are the matched finalizers, which were either not removed or added again by a controller
+	Finalizers []string
+}
+
+// verify lists the instances of all processed custom resource definitions again from the API server and records
+// the targeted instances which still have matched finalizers. Skipped instances, including those rejected by the removal
+// predicate, and those pending their grace period are not targeted by the sweep, so they are ignored.
+func (h *DefaultOryFinalizersHandler) verify(ctx context.Context, result *CleanupResult) error {
+	var unverified []UnverifiedResource
+	for _, crd := range result.CRDs {
+		if crd.CRDDeleted {
+			continue
+		}
+		var list *unstructured.UnstructuredList
+		err := h.retryOnUnauthorized(ctx, func() error {
+			return h.retryOnTransientError(ctx, func() (err error) {
+				// the informer cache may still hold the objects before the removal
+				list, err = h.listInstanceMetadata(ctx, crd.GVR)
+				return h.checkForbidden(err, "list", crd.GVR.GroupResource(), metav1.NamespaceAll)
+			})
+		})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to verify the finalizers of %s", crd.GVR.String())
+		}
+		rejected := rejectedNames(crd.Rejected)
+		for i := range list.Items {
+			instance := list.Items[i]
+			if !h.isTargeted(instance) || rejected[types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}] {
+				continue
+			}
+			matched := h.matchedFinalizers(instance.GetFinalizers())
+			if len(matched) == 0 {
+				continue
+			}
+			unverified = append(unverified, UnverifiedResource{
+				GVR:        crd.GVR,
+				Namespace:  instance.GetNamespace(),
+				Name:       instance.GetName(),
+				Finalizers: matched,
+			})
+		}
+	}
+
+	result.Verified = len(unverified) == 0
+	result.Unverified = unverified
+	if !result.Verified {
+		h.logger.Warnf("%d ory custom resources still have finalizers after the cleanup", len(unverified))
+	}
+	return nil
+}
+
+// isTargeted reports whether the sweep removes the finalizers of the instance, following the checks of
+// removeFinalizersFromAllInstancesOf
+func (h *DefaultOryFinalizersHandler) isTargeted(instance unstructured.Unstructured) bool {
+	if h.excludedNamespaces[instance.GetNamespace()] {
+		return false
+	}
+	deletionTimestamp := instance.GetDeletionTimestamp()
+	if h.terminatingOnly && deletionTimestamp == nil || h.isTooYoung(instance) {
+		return false
+	}
+	return deletionTimestamp == nil || h.clock.Since(deletionTimestamp.Time) >= h.gracePeriod
+}
+
+// rejectedNames returns the names of the instances rejected by the removal predicate
+func rejectedNames(rejected []RejectedResource) map[types.NamespacedName]bool {
+	names := make(map[types.NamespacedName]bool, len(rejected))
+	for _, resource := range rejected {
+		names[types.NamespacedName{Namespace: resource.Namespace, Name: resource.Name}] = true
+	}
+	return names
+}
+
+// isTooYoung reports whether the instance was created less than the minimum age ago, see WithMinimumAge
+func (h *DefaultOryFinalizersHandler) isTooYoung(instance unstructured.Unstructured) bool {
+	return h.minAge > 0 && h.clock.Since(instance.GetCreationTimestamp().Time) < h.minAge
+}
+
+// matchedFinalizers returns the finalizers matched by the finalizer matcher
+func (h *DefaultOryFinalizersHandler) matchedFinalizers(finalizers []string) []string {
+	var matched []string
+	for _, finalizer := range finalizers {
+		if h.finalizerMatcher.MatchString(finalizer) {
+			matched = append(matched, finalizer)
+		}
+	}
+	return matched
+}
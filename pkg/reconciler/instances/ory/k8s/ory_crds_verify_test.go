@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_FindAndDeleteOryFinalizers_Verification(t *testing.T) {
+	t.Run("should verify that the finalizers stayed removed", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2", "finalizer.ory.hydra.sh")))
+		listCalls := failFirstCalls(dyn, "list", 0, nil)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithVerification())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.True(t, result.Verified)
+		require.Empty(t, result.Unverified)
+		require.Equal(t, 2, *listCalls)
+	})
+
+	t.Run("should report the finalizers added again by a controller", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("default", "client2", "finalizer.ory.hydra.sh", "kept")))
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			updated := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured)
+			if updated.GetName() != "client2" {
+				return false, nil, nil
+			}
+			readded := updated.DeepCopy()
+			readded.SetFinalizers(append(readded.GetFinalizers(), "finalizer.ory.hydra.sh"))
+			return true, updated, dyn.Tracker().Update(oauth2ClientGVR, readded, "default")
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithVerification())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, result.RemovedFinalizers())
+		require.False(t, result.Verified)
+		require.Equal(t, []UnverifiedResource{{
+			GVR:        oauth2ClientGVR,
+			Namespace:  "default",
+			Name:       "client2",
+			Finalizers: []string{"finalizer.ory.hydra.sh"},
+		}}, result.Unverified)
+	})
+
+	t.Run("should ignore the instances which are not targeted", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh"),
+			fixOAuth2Client("kyma-system", "client", "finalizer.ory.hydra.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithExcludedNamespaces("kyma-system"), WithVerification())
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.True(t, result.Verified)
+		requireFinalizers(t, dyn, "kyma-system", "client", "finalizer.ory.hydra.sh")
+	})
+
+	t.Run("should not verify by default", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.hydra.sh")))
+		listCalls := failFirstCalls(dyn, "list", 0, nil)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+
+		// when
+		result, err := handler.findAndDeleteOryFinalizers()
+
+		// then
+		require.NoError(t, err)
+		require.False(t, result.Verified)
+		require.Equal(t, 1, *listCalls)
+	})
+}
@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// deletionBackoff is used between the reads of objects being deleted, see WaitForCRDDeleted and WaitForResourcesGone
+var deletionBackoff = wait.Backoff{Duration: 250 * time.Millisecond, Factor: 1.5, Jitter: 0.2, Steps: math.MaxInt32, Cap: 5 * time.Second}
+
+// CRDDeletionTimeoutError is returned if a custom resource definition still existed when the timeout of
+// WaitForCRDDeleted expired. It describes the deletion state of its last read.
+type CRDDeletionTimeoutError struct {
+	Name    string
+	Timeout time.Duration
+	// DeletionTimestamp is nil if the deletion of the CRD was never requested
+	DeletionTimestamp *metav1.Time
+	// Finalizers are the finalizers of the CRD, e.g. the one of the API server removing all instances
+	Finalizers []string
+	// Terminating is the message of the Terminating condition, which names what blocks the deletion
+	Terminating string
+	err         error
+}
+
+func (e *CRDDeletionTimeoutError) Error() string {
+	var state string
+	if e.DeletionTimestamp == nil {
+		state = "its deletion was not requested"
+	} else {
+		state = fmt.Sprintf("deletion requested at %s, finalizers [%s]",
+			e.DeletionTimestamp.UTC().Format(time.RFC3339), strings.Join(e.Finalizers, ", "))
+		if e.Terminating != "" {
+			state += ", terminating: " + e.Terminating
+		}
+	}
+	return fmt.Sprintf("crd \"%s\" was not deleted within %s, %s", e.Name, e.Timeout, state)
+}
+
+func (e *CRDDeletionTimeoutError) Unwrap() error {
+	return e.err
+}
+
+func IsCRDDeletionTimeoutError(err error) bool {
+	var target *CRDDeletionTimeoutError
+	return errors.As(err, &target)
+}
+
+// WaitForCRDDeleted polls the custom resource definition with jittered backoff until the API server no longer finds
+// it, e.g. before reinstalling it after a deletion. It uses the cluster of the last call of the handler, or otherwise
+// the configuration the handler was created for. Transient errors are tolerated until the timeout expires.
+func (h *DefaultOryFinalizersHandler) WaitForCRDDeleted(ctx context.Context, name string, timeout time.Duration) error {
+	if err := h.ensureClients(ctx); err != nil {
+		return err
+	}
+	crds, err := h.crds(ctx)
+	if err != nil {
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var last *apixv1beta1.CustomResourceDefinition
+	var lastErr error
+	err = wait.ExponentialBackoffWithContext(waitCtx, deletionBackoff, func() (bool, error) {
+		crd, err := crds.Get(waitCtx, name, metav1.GetOptions{})
+		if apierr.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil && (IsRetriableError(err) || waitCtx.Err() != nil) {
+			lastErr = err
+			return false, nil
+		}
+		if err != nil {
+			return false, h.checkForbidden(err, "get", crdResource, "")
+		}
+		last, lastErr = crd, nil
+		return false, nil
+	})
+	if err == nil {
+		h.logger.Debugf("Crd \"%s\" is deleted", name)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return errors.Wrapf(ctx.Err(), "stopped waiting for crd \"%s\" to be deleted", name)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, wait.ErrWaitTimeout) {
+		return err
+	}
+
+	timeoutErr := &CRDDeletionTimeoutError{Name: name, Timeout: timeout, err: lastErr}
+	if last != nil {
+		timeoutErr.DeletionTimestamp = last.DeletionTimestamp
+		timeoutErr.Finalizers = last.Finalizers
+		for _, condition := range last.Status.Conditions {
+			if condition.Type == apixv1beta1.Terminating && condition.Status == apixv1beta1.ConditionTrue {
+				timeoutErr.Terminating = condition.Message
+			}
+		}
+	}
+	return timeoutErr
+}
+
+// ensureClients keeps the clients of the previous call, or builds them for the configuration of the handler
+func (h *DefaultOryFinalizersHandler) ensureClients(ctx context.Context) error {
+	h.clientsMu.Lock()
+	built := h.apixClient != nil
+	h.clientsMu.Unlock()
+	if built {
+		h.applyDefaults()
+		return nil
+	}
+	return h.initClients(ctx, "")
+}
@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_WaitForCRDDeleted(t *testing.T) {
+	backoff := deletionBackoff
+	deletionBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1.5, Jitter: 0.2, Steps: backoff.Steps, Cap: 5 * time.Millisecond}
+	defer func() { deletionBackoff = backoff }()
+
+	t.Run("should wait until the crd is gone", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset(fixDeletingCRD())
+		gets := 0
+		apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			gets++
+			switch gets {
+			case 2:
+				return true, nil, apierr.NewServiceUnavailable("etcd leader changed")
+			case 4:
+				return true, nil, apierr.NewNotFound(crdResource, oauth2ClientCRD)
+			}
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		err := handler.WaitForCRDDeleted(context.Background(), oauth2ClientCRD, time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 4, gets)
+	})
+
+	t.Run("should return immediately when the crd does not exist", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset()
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		err := handler.WaitForCRDDeleted(context.Background(), oauth2ClientCRD, time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, apix.Actions(), 1)
+	})
+
+	t.Run("should describe the deletion state when the timeout expires", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixDeletingCRD()), newFakeDynamicClient())
+
+		// when
+		err := handler.WaitForCRDDeleted(context.Background(), oauth2ClientCRD, 20*time.Millisecond)
+
+		// then
+		require.True(t, IsCRDDeletionTimeoutError(err))
+		var timeoutErr *CRDDeletionTimeoutError
+		require.True(t, errors.As(err, &timeoutErr))
+		require.Equal(t, []string{"customresourcecleanup.apiextensions.k8s.io"}, timeoutErr.Finalizers)
+		require.Equal(t, `crd "oauth2clients.hydra.ory.sh" was not deleted within 20ms, deletion requested at 2022-11-03T10:00:00Z, `+
+			`finalizers [customresourcecleanup.apiextensions.k8s.io], terminating: 3 instances are left`, err.Error())
+	})
+
+	t.Run("should report a crd whose deletion was not requested", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), newFakeDynamicClient())
+
+		// when
+		err := handler.WaitForCRDDeleted(context.Background(), oauth2ClientCRD, 10*time.Millisecond)
+
+		// then
+		require.True(t, IsCRDDeletionTimeoutError(err))
+		require.Contains(t, err.Error(), "its deletion was not requested")
+	})
+
+	t.Run("should stop when the context is cancelled", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixDeletingCRD()), newFakeDynamicClient())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		err := handler.WaitForCRDDeleted(ctx, oauth2ClientCRD, time.Minute)
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, IsCRDDeletionTimeoutError(err))
+	})
+
+	t.Run("should name the missing permission", func(t *testing.T) {
+		// given
+		apix := apixfake.NewSimpleClientset()
+		apix.PrependReactor("get", "customresourcedefinitions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(crdResource, oauth2ClientCRD, errors.New("rbac"))
+		})
+		handler := newTestHandler(t, apix, newFakeDynamicClient())
+
+		// when
+		err := handler.WaitForCRDDeleted(context.Background(), oauth2ClientCRD, time.Minute)
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+	})
+}
+
+func fixDeletingCRD() *apixv1beta1.CustomResourceDefinition {
+	crd := fixOAuth2ClientCRD()
+	deletion := metav1.NewTime(time.Date(2022, 11, 3, 10, 0, 0, 0, time.UTC))
+	crd.DeletionTimestamp = &deletion
+	crd.Finalizers = []string{"customresourcecleanup.apiextensions.k8s.io"}
+	crd.Status.Conditions = []apixv1beta1.CustomResourceDefinitionCondition{
+		{Type: apixv1beta1.Terminating, Status: apixv1beta1.ConditionTrue, Message: "3 instances are left"},
+	}
+	return crd
+}
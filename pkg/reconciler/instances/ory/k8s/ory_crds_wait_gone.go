@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// remainingObjectsListed bounds the page size of the polls, which is also the number of objects named by a
+// ResourcesRemainError
+const remainingObjectsListed = 10
+
+// ResourcesRemainError is returned if objects still existed when the timeout of WaitForResourcesGone expired
+type ResourcesRemainError struct {
+	GVR           schema.GroupVersionResource
+	Namespace     string
+	LabelSelector string
+	Timeout       time.Duration
+	// Remaining names up to ten of the remaining objects as "namespace/name", or only by name if cluster-scoped
+	Remaining []string
+	// More is the number of remaining objects beyond those named, if the API server reported it
+	More int64
+	err  error
+}
+
+func (e *ResourcesRemainError) Error() string {
+	scope := ""
+	if e.Namespace != "" {
+		scope += fmt.Sprintf(" in namespace \"%s\"", e.Namespace)
+	}
+	if e.LabelSelector != "" {
+		scope += fmt.Sprintf(" matching \"%s\"", e.LabelSelector)
+	}
+	remaining := strings.Join(e.Remaining, ", ")
+	if e.More > 0 {
+		remaining += fmt.Sprintf(" and %d more", e.More)
+	}
+	if remaining == "" && e.err != nil {
+		remaining = e.err.Error()
+	}
+	return fmt.Sprintf("%s%s were not gone within %s: %s", e.GVR.String(), scope, e.Timeout, remaining)
+}
+
+func (e *ResourcesRemainError) Unwrap() error {
+	return e.err
+}
+
+func IsResourcesRemainError(err error) bool {
+	var target *ResourcesRemainError
+	return errors.As(err, &target)
+}
+
+// WaitForResourcesGone polls the objects of the resource in the namespace, all namespaces if empty, which match the
+// label selector until none are left. A resource which is not served, e.g. because its CRD was deleted, has no
+// objects left. It uses the cluster of the last call of the handler, or otherwise the configuration the handler was
+// created for. Transient errors are tolerated until the timeout expires.
+func (h *DefaultOryFinalizersHandler) WaitForResourcesGone(ctx context.Context, gvr schema.GroupVersionResource, namespace,
+	labelSelector string, timeout time.Duration) error {
+	if err := h.ensureClients(ctx); err != nil {
+		return err
+	}
+	resources := h.currentClients().dynamic.Resource(gvr).Namespace(namespace)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	remainErr := &ResourcesRemainError{GVR: gvr, Namespace: namespace, LabelSelector: labelSelector, Timeout: timeout}
+	err := wait.ExponentialBackoffWithContext(waitCtx, deletionBackoff, func() (bool, error) {
+		list, err := resources.List(waitCtx, metav1.ListOptions{LabelSelector: labelSelector, Limit: remainingObjectsListed})
+		if apierr.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil && (IsRetriableError(err) || waitCtx.Err() != nil) {
+			remainErr.err = err
+			return false, nil
+		}
+		if err != nil {
+			return false, h.checkForbidden(err, "list", gvr.GroupResource(), namespace)
+		}
+		if len(list.Items) == 0 {
+			return true, nil
+		}
+
+		remainErr.Remaining, remainErr.More, remainErr.err = nil, 0, nil
+		for i := range list.Items {
+			if len(remainErr.Remaining) == remainingObjectsListed {
+				remainErr.More++
+				continue
+			}
+			name := list.Items[i].GetName()
+			if list.Items[i].GetNamespace() != "" {
+				name = list.Items[i].GetNamespace() + "/" + name
+			}
+			remainErr.Remaining = append(remainErr.Remaining, name)
+		}
+		if count := list.GetRemainingItemCount(); count != nil {
+			remainErr.More += *count
+		}
+		return false, nil
+	})
+	if err == nil {
+		h.logger.Debugf("All %s are gone", gvr.String())
+		return nil
+	}
+	if ctx.Err() != nil {
+		return errors.Wrapf(ctx.Err(), "stopped waiting for %s to be gone", gvr.String())
+	}
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, wait.ErrWaitTimeout) {
+		return err
+	}
+	return remainErr
+}
@@ -0,0 +1,154 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_WaitForResourcesGone(t *testing.T) {
+	backoff := deletionBackoff
+	deletionBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1.5, Jitter: 0.2, Steps: backoff.Steps, Cap: 5 * time.Millisecond}
+	defer func() { deletionBackoff = backoff }()
+
+	t.Run("should wait until the objects are gone", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client1"),
+			fixOAuth2Client("default", "client2"),
+			fixOAuth2Client("default", "client3")))
+		lists := deleteOnList(t, dyn, "default", "client1", "client2", "client3")
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		err := handler.WaitForResourcesGone(context.Background(), oauth2ClientGVR, "default", "", time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 4, *lists)
+	})
+
+	t.Run("should only wait for the objects in the namespace matching the selector", func(t *testing.T) {
+		// given
+		matching := fixOAuth2Client("default", "client1")
+		matching.SetLabels(map[string]string{"app": "console"})
+		other := fixOAuth2Client("default", "client2")
+		otherNamespace := fixOAuth2Client("kyma-system", "client1")
+		otherNamespace.SetLabels(map[string]string{"app": "console"})
+		dyn := newFakeDynamicClient(withObjects(matching, other, otherNamespace))
+		lists := deleteOnList(t, dyn, "default", "client1")
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		err := handler.WaitForResourcesGone(context.Background(), oauth2ClientGVR, "default", "app=console", time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, *lists)
+	})
+
+	t.Run("should name the remaining objects when the timeout expires", func(t *testing.T) {
+		// given
+		var objects []runtime.Object
+		for i := 0; i < 12; i++ {
+			objects = append(objects, fixOAuth2Client("default", fmt.Sprintf("client%02d", i)))
+		}
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient(withObjects(objects...)))
+
+		// when
+		err := handler.WaitForResourcesGone(context.Background(), oauth2ClientGVR, "", "", 20*time.Millisecond)
+
+		// then
+		require.True(t, IsResourcesRemainError(err))
+		var remainErr *ResourcesRemainError
+		require.True(t, errors.As(err, &remainErr))
+		require.Len(t, remainErr.Remaining, 10)
+		require.Equal(t, int64(2), remainErr.More)
+		require.Equal(t, "hydra.ory.sh/v1alpha1, Resource=oauth2clients were not gone within 20ms: default/client00, default/client01, "+
+			"default/client02, default/client03, default/client04, default/client05, default/client06, default/client07, "+
+			"default/client08, default/client09 and 2 more", err.Error())
+	})
+
+	t.Run("should succeed when the resource is not served", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewNotFound(oauth2ClientGVR.GroupResource(), "")
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		err := handler.WaitForResourcesGone(context.Background(), oauth2ClientGVR, "", "", time.Minute)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("should tolerate transient errors", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client")))
+		lists := deleteOnList(t, dyn, "default", "client")
+		failFirstCalls(dyn, "list", 1, apierr.NewServiceUnavailable("etcd leader changed"))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		err := handler.WaitForResourcesGone(context.Background(), oauth2ClientGVR, "", "", time.Minute)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, *lists)
+	})
+
+	t.Run("should name the missing permission", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		failFirstCalls(dyn, "list", 1, apierr.NewForbidden(oauth2ClientGVR.GroupResource(), "", errors.New("rbac")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), dyn)
+
+		// when
+		err := handler.WaitForResourcesGone(context.Background(), oauth2ClientGVR, "default", "", time.Minute)
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), `grant list on oauth2clients.hydra.ory.sh in namespace "default"`)
+	})
+
+	t.Run("should stop when the context is cancelled", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient(withObjects(
+			fixOAuth2Client("default", "client"))))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		err := handler.WaitForResourcesGone(ctx, oauth2ClientGVR, "", "", time.Minute)
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// deleteOnList deletes the next of the given objects before every list but the first, so that they disappear one by one.
+// It returns the number of lists which reached the tracker.
+func deleteOnList(t *testing.T, dyn *dynamicfake.FakeDynamicClient, namespace string, names ...string) *int {
+	lists := 0
+	dyn.PrependReactor("list", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		if len(names) > 0 && lists > 1 {
+			require.NoError(t, dyn.Tracker().Delete(oauth2ClientGVR, namespace, names[0]))
+			names = names[1:]
+		}
+		return false, nil, nil
+	})
+	return &lists
+}
@@ -0,0 +1,90 @@
+package k8s
+
+import (
+	"sync"
+)
+
+// warningCollector collects the warnings sent by the API server during a run, e.g. about deprecated APIs.
+// Identical warnings are logged and collected only once per run.
+type warningCollector struct {
+	logger Logger
+
+	mu       sync.Mutex
+	seen     map[string]bool
+	warnings []string
+}
+
+func newWarningCollector(logger Logger) *warningCollector {
+	return &warningCollector{logger: logger, seen: map[string]bool{}}
+}
+
+func (c *warningCollector) HandleWarningHeader(code int, agent string, text string) {
+	if code != 299 || text == "" {
+		return
+	}
+	if c.add(text) {
+		c.logger.Warnf("API server warning: %s", text)
+	}
+}
+
+// add collects the warning and reports whether it was not collected before
+func (c *warningCollector) add(text string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[text] {
+		return false
+	}
+	c.seen[text] = true
+	c.warnings = append(c.warnings, text)
+	return true
+}
+
+func (c *warningCollector) collected() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.warnings...)
+}
+
+// warningRouter is the rest.WarningHandler of the clients of a handler. The clients are shared by concurrent runs,
+// so a warning is collected by all runs in progress, as the request it was sent for cannot be told. Warnings are
+// logged once, also those received while no run is in progress, e.g. by a watch.
+type warningRouter struct {
+	logger Logger
+
+	mu         sync.Mutex
+	collectors map[*warningCollector]bool
+}
+
+func newWarningRouter(logger Logger) *warningRouter {
+	return &warningRouter{logger: logger, collectors: map[*warningCollector]bool{}}
+}
+
+func (r *warningRouter) HandleWarningHeader(code int, agent string, text string) {
+	if code != 299 || text == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	added := len(r.collectors) == 0
+	for collector := range r.collectors {
+		if collector.add(text) {
+			added = true
+		}
+	}
+	if added {
+		r.logger.Warnf("API server warning: %s", text)
+	}
+}
+
+// register forwards the warnings to the collector of a run until the returned function is called
+func (r *warningRouter) register(collector *warningCollector) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[collector] = true
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.collectors, collector)
+	}
+}
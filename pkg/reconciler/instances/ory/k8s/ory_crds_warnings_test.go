@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const deprecationWarning = "apiextensions.k8s.io/v1beta1 CustomResourceDefinition is deprecated in v1.16+, unavailable in v1.22+"
+
+func Test_WarningCollector(t *testing.T) {
+	t.Run("should log and collect distinct warnings once", func(t *testing.T) {
+		// given
+		core, logs := observer.New(zapcore.WarnLevel)
+		collector := newWarningCollector(zap.New(core).Sugar())
+
+		// when
+		collector.HandleWarningHeader(299, "-", deprecationWarning)
+		collector.HandleWarningHeader(299, "-", deprecationWarning)
+		collector.HandleWarningHeader(299, "-", "another warning")
+
+		// then
+		require.Equal(t, []string{deprecationWarning, "another warning"}, collector.collected())
+		require.Equal(t, 2, logs.Len())
+		require.Contains(t, logs.All()[0].Message, deprecationWarning)
+	})
+
+	t.Run("should ignore warnings with unexpected code or empty text", func(t *testing.T) {
+		// given
+		collector := newWarningCollector(zap.NewNop().Sugar())
+
+		// when
+		collector.HandleWarningHeader(199, "-", "misc")
+		collector.HandleWarningHeader(299, "-", "")
+
+		// then
+		require.Empty(t, collector.collected())
+	})
+}
+
+func Test_WarningRouter(t *testing.T) {
+	t.Run("should forward warnings to all registered collectors and log them once", func(t *testing.T) {
+		// given
+		core, logs := observer.New(zapcore.WarnLevel)
+		logger := zap.New(core).Sugar()
+		router := newWarningRouter(logger)
+		first, second := newWarningCollector(logger), newWarningCollector(logger)
+		unregisterFirst := router.register(first)
+		defer router.register(second)()
+
+		// when
+		router.HandleWarningHeader(299, "-", deprecationWarning)
+		unregisterFirst()
+		router.HandleWarningHeader(299, "-", "another warning")
+
+		// then
+		require.Equal(t, []string{deprecationWarning}, first.collected())
+		require.Equal(t, []string{deprecationWarning, "another warning"}, second.collected())
+		require.Equal(t, 2, logs.Len())
+	})
+
+	t.Run("should log warnings while no collector is registered", func(t *testing.T) {
+		// given
+		core, logs := observer.New(zapcore.WarnLevel)
+		router := newWarningRouter(zap.New(core).Sugar())
+
+		// when
+		router.HandleWarningHeader(299, "-", deprecationWarning)
+		router.HandleWarningHeader(199, "-", "misc")
+
+		// then
+		require.Equal(t, 1, logs.Len())
+		require.Contains(t, logs.All()[0].Message, deprecationWarning)
+	})
+}
+
+func Test_FindAndDeleteOryFinalizers_Warnings(t *testing.T) {
+	t.Run("should report warnings of the API server in the result", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, deprecationWarning)
+		core, logs := observer.New(zapcore.WarnLevel)
+		handler := NewDefaultOryFinalizersHandler(WithLogger(zap.New(core).Sugar()))
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{deprecationWarning}, result.Warnings)
+		require.Equal(t, 1, logs.FilterMessageSnippet(deprecationWarning).Len())
+	})
+
+	t.Run("should not report warnings when suppressed", func(t *testing.T) {
+		// given
+		server := newFakeAPIServer(t, deprecationWarning)
+		core, logs := observer.New(zapcore.WarnLevel)
+		handler := NewDefaultOryFinalizersHandler(WithLogger(zap.New(core).Sugar()), WithSuppressedWarnings(true))
+		defer handler.Close()
+
+		// when
+		result, err := handler.FindAndDeleteOryFinalizers(fixKubeconfig(server.URL))
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, result.Warnings)
+		require.Zero(t, logs.Len())
+	})
+}
+
+// newFakeAPIServer serves the oauth2client CRD and an empty list of its instances,
+// adding the given warning to every response
+func newFakeAPIServer(t *testing.T, warning string) *httptest.Server {
+	server := httptest.NewServer(fakeAPIServerHandler(warning))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// fakeAPIServerHandler serves the oauth2client crd without any instances, sending the warning with each response
+func fakeAPIServerHandler(warning string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if warning != "" {
+			w.Header().Set("Warning", fmt.Sprintf("299 - %q", warning))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/apis/apiextensions.k8s.io/v1beta1/customresourcedefinitions/" + oauth2ClientCRD:
+			_, _ = w.Write([]byte(`{"apiVersion":"apiextensions.k8s.io/v1beta1","kind":"CustomResourceDefinition",` +
+				`"metadata":{"name":"oauth2clients.hydra.ory.sh"},"spec":{"group":"hydra.ory.sh","version":"v1alpha1",` +
+				`"names":{"plural":"oauth2clients"},"scope":"Namespaced"},` +
+				`"status":{"conditions":[{"type":"Established","status":"True"}]}}`))
+		case "/apis/hydra.ory.sh/v1alpha1/oauth2clients":
+			_, _ = w.Write([]byte(`{"apiVersion":"hydra.ory.sh/v1alpha1","kind":"OAuth2ClientList","metadata":{},"items":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"NotFound","code":404}`))
+		}
+	}
+}
+
+func fixKubeconfig(server string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: secret-token
+`, server)
+}
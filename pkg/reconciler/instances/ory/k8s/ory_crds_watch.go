@@ -0,0 +1,237 @@
+package k8s
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// watchRestartBackoff is used between restarts of the list or watch of a resource. It is reset whenever a watch
+// delivered events, so that a watch which keeps failing or closing right away does not restart in a hot loop.
+var watchRestartBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.5, Steps: math.MaxInt32, Cap: time.Minute}
+
+// watchState is the progress of the watch of a resource, which is kept across restarts of the watch
+type watchState struct {
+	// resourceVersion is the version of the last list or event, the watch restarts from it so that no event is
+	// missed or handled twice. It is empty if the resource has to be listed again.
+	resourceVersion string
+	// events counts the handled events, including bookmarks
+	events int
+	stuck  map[types.NamespacedName]unstructured.Unstructured
+}
+
+// RunUntil watches all ory custom resources and removes the ory finalizers of resources which have been terminating
+// for longer than the grace period and reached the minimum age, see WithGracePeriod and WithMinimumAge. It blocks
+// until the context is done.
+// The ory custom resource definitions are discovered once when it is started.
+func (h *DefaultOryFinalizersHandler) RunUntil(ctx context.Context, kubeconfigData string) error {
+	if err := h.initClients(ctx, kubeconfigData); err != nil {
+		return err
+	}
+	return h.runUntil(ctx)
+}
+
+func (h *DefaultOryFinalizersHandler) runUntil(ctx context.Context) error {
+	crds, err := h.discoverOryCRDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, crd := range crds {
+		if len(crd.Versions) == 0 {
+			continue
+		}
+		gvr := schema.GroupVersionResource{Group: crd.Group, Version: crd.Versions[0], Resource: crd.Resource}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.watchAndClean(ctx, gvr)
+		}()
+	}
+	wg.Wait()
+	<-ctx.Done()
+	return nil
+}
+
+// watchAndClean lists and watches the resources until the context is done. A watch which ends is restarted from
+// the resource version of its last event, the resources are only listed again if that version expired. Restarts are
+// delayed with jittered exponential backoff until a watch delivers events again.
+func (h *DefaultOryFinalizersHandler) watchAndClean(ctx context.Context, gvr schema.GroupVersionResource) {
+	backoff := watchRestartBackoff
+	state := &watchState{}
+	for ctx.Err() == nil {
+		events := state.events
+		err := h.listAndWatch(ctx, gvr, state)
+		if ctx.Err() != nil {
+			return
+		}
+		if state.events > events {
+			backoff = watchRestartBackoff
+		}
+		delay := backoff.Step()
+		switch {
+		case apierr.IsResourceExpired(err) || apierr.IsGone(err):
+			state.resourceVersion = ""
+			h.logger.Debugf("Resource version of the watch of %s expired, listing again in %s", gvr, delay)
+		case err == nil:
+			h.logger.Debugf("Watch of %s ended, watching again from resource version \"%s\" in %s", gvr, state.resourceVersion, delay)
+		default:
+			h.logger.Warnf("Failed to watch %s, retrying in %s: %s", gvr, delay, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-h.clock.After(delay):
+		}
+	}
+}
+
+// listAndWatch lists the resources unless the state has a resource version to watch from, and handles the events
+// of a watch until it ends
+func (h *DefaultOryFinalizersHandler) listAndWatch(ctx context.Context, gvr schema.GroupVersionResource, state *watchState) error {
+	if state.resourceVersion == "" {
+		list, err := h.listInstanceMetadata(ctx, gvr)
+		if err != nil {
+			return h.checkForbidden(err, "list", gvr.GroupResource(), metav1.NamespaceAll)
+		}
+		state.stuck = map[types.NamespacedName]unstructured.Unstructured{}
+		for i := range list.Items {
+			h.observe(state.stuck, list.Items[i])
+		}
+		state.resourceVersion = list.GetResourceVersion()
+		h.cleanDue(ctx, gvr, state.stuck)
+	}
+
+	watcher, err := h.currentClients().dynamic.Resource(gvr).Namespace(metav1.NamespaceAll).Watch(ctx, metav1.ListOptions{
+		ResourceVersion:     state.resourceVersion,
+		AllowWatchBookmarks: true,
+		FieldSelector:       h.instanceFieldSelector(gvr),
+	})
+	if err != nil {
+		return h.checkForbidden(err, "watch", gvr.GroupResource(), metav1.NamespaceAll)
+	}
+	defer watcher.Stop()
+
+	for {
+		done, err := h.awaitEvent(ctx, watcher, state)
+		if done {
+			return err
+		}
+		h.cleanDue(ctx, gvr, state.stuck)
+	}
+}
+
+// awaitEvent handles the next watch event or waits until the grace period of the next stuck resource is over.
+// It reports whether the watch is done, which is the case if it was closed or failed.
+func (h *DefaultOryFinalizersHandler) awaitEvent(ctx context.Context, watcher watch.Interface, state *watchState) (bool, error) {
+	var timeout <-chan time.Time
+	if deadline, ok := h.nextDeadline(state.stuck); ok {
+		timer := h.clock.NewTimer(deadline.Sub(h.clock.Now()))
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case <-ctx.Done():
+		return true, nil
+	case event, ok := <-watcher.ResultChan():
+		if !ok {
+			return true, nil
+		}
+		err := h.handleEvent(state, event)
+		return err != nil, err
+	case <-timeout:
+		return false, nil
+	}
+}
+
+func (h *DefaultOryFinalizersHandler) handleEvent(state *watchState, event watch.Event) error {
+	if event.Type == watch.Error {
+		return apierr.FromObject(event.Object)
+	}
+	res, ok := event.Object.(*unstructured.Unstructured)
+	if !ok {
+		return errors.Errorf("unexpected object %T in watch event", event.Object)
+	}
+	state.events++
+	if resourceVersion := res.GetResourceVersion(); resourceVersion != "" {
+		state.resourceVersion = resourceVersion
+	}
+	switch event.Type {
+	case watch.Bookmark:
+	case watch.Deleted:
+		delete(state.stuck, types.NamespacedName{Namespace: res.GetNamespace(), Name: res.GetName()})
+	default:
+		h.observe(state.stuck, *res)
+	}
+	return nil
+}
+
+// observe tracks the resource if it is terminating and blocked by finalizers which are removed by the handler
+func (h *DefaultOryFinalizersHandler) observe(stuck map[types.NamespacedName]unstructured.Unstructured, res unstructured.Unstructured) {
+	key := types.NamespacedName{Namespace: res.GetNamespace(), Name: res.GetName()}
+	finalizers := res.GetFinalizers()
+	if res.GetDeletionTimestamp() == nil || h.excludedNamespaces[res.GetNamespace()] ||
+		len(h.remainingFinalizers(finalizers)) == len(finalizers) {
+		delete(stuck, key)
+		return
+	}
+	stuck[key] = res
+}
+
+func (h *DefaultOryFinalizersHandler) nextDeadline(stuck map[types.NamespacedName]unstructured.Unstructured) (time.Time, bool) {
+	var next time.Time
+	for _, res := range stuck {
+		if deadline := h.dueAt(res); next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// dueAt returns when the grace period of the terminating resource is over, or when it reaches the minimum age if that
+// is later
+func (h *DefaultOryFinalizersHandler) dueAt(res unstructured.Unstructured) time.Time {
+	due := res.GetDeletionTimestamp().Add(h.gracePeriod)
+	if h.minAge > 0 {
+		if oldEnough := res.GetCreationTimestamp().Add(h.minAge); oldEnough.After(due) {
+			return oldEnough
+		}
+	}
+	return due
+}
+
+// cleanDue removes the finalizers of all resources whose grace period is over and which reached the minimum age.
+// Resources which fail are not retried before they change again or are listed again. Each pass is a run of its own,
+// so that the conflict retry budget is not used up by the passes before.
+func (h *DefaultOryFinalizersHandler) cleanDue(ctx context.Context, gvr schema.GroupVersionResource,
+	stuck map[types.NamespacedName]unstructured.Unstructured) {
+	run := h.newRun(ctx, nil)
+	now := h.clock.Now()
+	for key, res := range stuck {
+		if ctx.Err() != nil {
+			return
+		}
+		if now.Before(h.dueAt(res)) {
+			continue
+		}
+		delete(stuck, key)
+		outcome, err := h.removeInstanceFinalizers(run, gvr, res)
+		if err != nil {
+			h.logger.Warnf("Failed to remove finalizers from stuck ory custom resource %s \"%s\": %s", gvr.Resource, key, err)
+			continue
+		}
+		h.logger.Infof("Removed %d finalizers from ory custom resource %s \"%s\" stuck in deletion",
+			outcome.removedFinalizers, gvr.Resource, key)
+	}
+}
@@ -0,0 +1,296 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apixfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func Test_RunUntil(t *testing.T) {
+	backoff := watchRestartBackoff
+	watchRestartBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Jitter: 0.5, Steps: backoff.Steps, Cap: 5 * time.Millisecond}
+	defer func() { watchRestartBackoff = backoff }()
+
+	t.Run("should remove finalizers of resources stuck longer than the grace period", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		stuck := fixTerminatingOAuth2Client("default", "stuck", fakeClock.Now().Add(-2*time.Minute), "finalizer.ory.sh")
+		dyn := newFakeDynamicClient(withObjects(stuck, fixOAuth2Client("default", "alive", "finalizer.ory.sh")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(fakeClock), WithGracePeriod(time.Minute))
+
+		// when
+		stop := runInBackground(t, handler)
+		defer stop()
+
+		// then
+		require.Eventually(t, func() bool { return len(getOAuth2Client(t, dyn, "default", "stuck").GetFinalizers()) == 0 },
+			5*time.Second, 10*time.Millisecond)
+		requireFinalizers(t, dyn, "default", "alive", "finalizer.ory.sh")
+	})
+
+	t.Run("should wait for the grace period of resources which start terminating", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client", "finalizer.ory.sh", "other.example.com")))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(fakeClock), WithGracePeriod(time.Minute))
+		stop := runInBackground(t, handler)
+		defer stop()
+		waitForWatch(t, dyn)
+
+		// when
+		terminating := fixTerminatingOAuth2Client("default", "client", fakeClock.Now(), "finalizer.ory.sh", "other.example.com")
+		_, err := dyn.Resource(oauth2ClientGVR).Namespace("default").Update(context.Background(), terminating, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		// then
+		require.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+		requireFinalizers(t, dyn, "default", "client", "finalizer.ory.sh", "other.example.com")
+		fakeClock.Step(time.Minute)
+		require.Eventually(t, func() bool { return len(getOAuth2Client(t, dyn, "default", "client").GetFinalizers()) == 1 },
+			5*time.Second, 10*time.Millisecond)
+		requireFinalizers(t, dyn, "default", "client", "other.example.com")
+	})
+
+	t.Run("should wait for stuck resources to reach the minimum age", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		stuck := fixTerminatingOAuth2Client("default", "stuck", fakeClock.Now().Add(-2*time.Minute), "finalizer.ory.sh")
+		stuck.SetCreationTimestamp(metav1.Time{Time: fakeClock.Now().Add(-5 * time.Minute)})
+		dyn := newFakeDynamicClient(withObjects(stuck))
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(fakeClock), WithGracePeriod(time.Minute), WithMinimumAge(10*time.Minute))
+
+		// when
+		stop := runInBackground(t, handler)
+		defer stop()
+
+		// then
+		require.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+		requireFinalizers(t, dyn, "default", "stuck", "finalizer.ory.sh")
+		fakeClock.Step(5 * time.Minute)
+		require.Eventually(t, func() bool { return len(getOAuth2Client(t, dyn, "default", "stuck").GetFinalizers()) == 0 },
+			5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should list again when the watch expired", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient()
+		expiring := watch.NewFake()
+		watches := 0
+		dyn.PrependWatchReactor(oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, watch.Interface, error) {
+			watches++
+			if watches == 1 {
+				return true, expiring, nil
+			}
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+		stop := runInBackground(t, handler)
+		defer stop()
+		waitForWatch(t, dyn)
+
+		// when
+		status := apierr.NewResourceExpired("too old resource version").Status()
+		expiring.Error(&status)
+
+		// then
+		require.Eventually(t, func() bool { return countActions(dyn, "list") == 2 && countActions(dyn, "watch") == 2 },
+			5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should watch again from the last resource version when the watch closes", func(t *testing.T) {
+		// given
+		dyn := newFakeDynamicClient(withObjects(fixOAuth2Client("default", "client")))
+		closing := watch.NewFake()
+		resourceVersions := recordWatches(dyn, closing)
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn)
+		stop := runInBackground(t, handler)
+		defer stop()
+		waitForWatch(t, dyn)
+
+		// when
+		modified := fixOAuth2Client("default", "client")
+		modified.SetResourceVersion("5")
+		closing.Modify(modified)
+		bookmark := &unstructured.Unstructured{}
+		bookmark.SetResourceVersion("7")
+		closing.Action(watch.Bookmark, bookmark)
+		closing.Stop()
+
+		// then
+		require.Eventually(t, func() bool { return len(resourceVersions()) == 2 }, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, []string{"", "7"}, resourceVersions())
+		require.Equal(t, 1, countActions(dyn, "list"))
+	})
+
+	t.Run("should back off between restarts of a failing watch", func(t *testing.T) {
+		// given
+		watchRestartBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Steps: backoff.Steps, Cap: time.Minute}
+		defer func() {
+			watchRestartBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Jitter: 0.5, Steps: backoff.Steps, Cap: 5 * time.Millisecond}
+		}()
+		dyn := newFakeDynamicClient()
+		dyn.PrependWatchReactor(oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, watch.Interface, error) {
+			return true, nil, apierr.NewInternalError(errors.New("etcd unavailable"))
+		})
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn, WithClock(fakeClock))
+		stop := runInBackground(t, handler)
+		defer stop()
+		waitForWatch(t, dyn)
+
+		// when
+		require.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+		fakeClock.Step(time.Second)
+		require.Eventually(t, func() bool { return countActions(dyn, "watch") == 2 && fakeClock.HasWaiters() },
+			5*time.Second, 10*time.Millisecond)
+		fakeClock.Step(time.Second)
+
+		// then
+		require.Never(t, func() bool { return countActions(dyn, "watch") > 2 }, 100*time.Millisecond, 10*time.Millisecond)
+		fakeClock.Step(time.Second)
+		require.Eventually(t, func() bool { return countActions(dyn, "watch") == 3 }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should return when the context is done without ory crds", func(t *testing.T) {
+		// given
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		err := handler.runUntil(ctx)
+
+		// then
+		require.NoError(t, err)
+	})
+}
+
+func Test_Observe(t *testing.T) {
+	// given
+	handler := newTestHandler(t, apixfake.NewSimpleClientset(), newFakeDynamicClient(), WithExcludedNamespaces("excluded"))
+	deleted := time.Now()
+	stuck := map[types.NamespacedName]unstructured.Unstructured{}
+
+	// when
+	for _, res := range []*unstructured.Unstructured{
+		fixTerminatingOAuth2Client("default", "stuck", deleted, "finalizer.ory.sh"),
+		fixTerminatingOAuth2Client("default", "foreign", deleted, "other.example.com"),
+		fixTerminatingOAuth2Client("excluded", "stuck", deleted, "finalizer.ory.sh"),
+		fixOAuth2Client("default", "alive", "finalizer.ory.sh"),
+	} {
+		handler.observe(stuck, *res)
+	}
+
+	// then
+	require.Len(t, stuck, 1)
+	require.Contains(t, stuck, types.NamespacedName{Namespace: "default", Name: "stuck"})
+}
+
+// runInBackground runs the handler until the returned function is called, which waits for it to return
+func runInBackground(t *testing.T, handler *DefaultOryFinalizersHandler) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.runUntil(ctx)
+	}()
+	return func() {
+		cancel()
+		require.NoError(t, <-done)
+	}
+}
+
+// recordWatches serves the first watch of oauth2clients with the given watcher and records the resource versions
+// all watches start from
+func recordWatches(dyn *dynamicfake.FakeDynamicClient, first watch.Interface) func() []string {
+	var mu sync.Mutex
+	var resourceVersions []string
+	dyn.PrependWatchReactor(oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, watch.Interface, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		resourceVersions = append(resourceVersions, action.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion)
+		if len(resourceVersions) == 1 {
+			return true, first, nil
+		}
+		return false, nil, nil
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), resourceVersions...)
+	}
+}
+
+func waitForWatch(t *testing.T, dyn *dynamicfake.FakeDynamicClient) {
+	require.Eventually(t, func() bool { return countActions(dyn, "watch") > 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_CleanDue(t *testing.T) {
+	t.Run("should give every pass a conflict retry budget of its own", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		first := fixTerminatingOAuth2Client("default", "first", fakeClock.Now().Add(-time.Minute), "finalizer.ory.sh")
+		second := fixTerminatingOAuth2Client("default", "second", fakeClock.Now().Add(-time.Minute), "finalizer.ory.sh")
+		dyn := newFakeDynamicClient(withObjects(first, second))
+		updates := 0
+		dyn.PrependReactor("update", oauth2ClientGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			// the first update of every resource conflicts
+			updates++
+			if updates%2 == 1 {
+				return true, nil, apierr.NewConflict(oauth2ClientGVR.GroupResource(), "", errors.New("stale"))
+			}
+			return false, nil, nil
+		})
+		handler := newTestHandler(t, apixfake.NewSimpleClientset(fixOAuth2ClientCRD()), dyn,
+			WithClock(newSteppingClock()), WithConflictRetryBudget(1))
+
+		// when
+		handler.cleanDue(context.Background(), oauth2ClientGVR, map[types.NamespacedName]unstructured.Unstructured{{Namespace: "default", Name: "first"}: *first})
+		handler.cleanDue(context.Background(), oauth2ClientGVR, map[types.NamespacedName]unstructured.Unstructured{{Namespace: "default", Name: "second"}: *second})
+
+		// then
+		require.Equal(t, 4, updates)
+		requireFinalizers(t, dyn, "default", "first")
+		requireFinalizers(t, dyn, "default", "second")
+	})
+}
+
+func countActions(dyn *dynamicfake.FakeDynamicClient, verb string) int {
+	count := 0
+	for _, action := range dyn.Actions() {
+		if action.GetVerb() == verb {
+			count++
+		}
+	}
+	return count
+}
+
+func getOAuth2Client(t *testing.T, dyn *dynamicfake.FakeDynamicClient, namespace, name string) *unstructured.Unstructured {
+	res, err := dyn.Resource(oauth2ClientGVR).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return res
+}
+
+func fixTerminatingOAuth2Client(namespace, name string, deleted time.Time, finalizers ...string) *unstructured.Unstructured {
+	res := fixOAuth2Client(namespace, name, finalizers...)
+	deletionTimestamp := metav1.NewTime(deleted)
+	res.SetDeletionTimestamp(&deletionTimestamp)
+	return res
+}
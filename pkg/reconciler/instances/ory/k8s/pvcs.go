@@ -0,0 +1,167 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrDataLossNotAllowed is returned by DeletePersistentVolumeClaims unless data loss was explicitly allowed
+var ErrDataLossNotAllowed = errors.New("deleting the persistent volume claims of ory destroys the OAuth2 client database, data loss has to be allowed explicitly")
+
+// pvcDeletionInterval is the interval in which deleted claims are checked for having disappeared
+const pvcDeletionInterval = time.Second
+
+var (
+	pvcResource         = corev1.Resource("persistentvolumeclaims")
+	statefulSetResource = appsv1.Resource("statefulsets")
+)
+
+// DeletedClaim is a persistent volume claim deleted by DeletePersistentVolumeClaims
+type DeletedClaim struct {
+	Name     string
+	Capacity resource.Quantity
+}
+
+func (c DeletedClaim) String() string {
+	return fmt.Sprintf("%s (%s)", c.Name, c.Capacity.String())
+}
+
+// PVCsNotDeletedError is returned when deleted claims did not disappear within the timeout, for example because
+// pods still mounting them keep them protected
+type PVCsNotDeletedError struct {
+	Namespace string
+	Timeout   time.Duration
+	Remaining []string
+}
+
+func (e *PVCsNotDeletedError) Error() string {
+	return fmt.Sprintf("persistent volume claims %v in namespace \"%s\" still exist %s after their deletion",
+		e.Remaining, e.Namespace, e.Timeout)
+}
+
+func IsPVCsNotDeletedError(err error) bool {
+	var target *PVCsNotDeletedError
+	return errors.As(err, &target)
+}
+
+// DeletePersistentVolumeClaims deletes the persistent volume claims of the ory release in the given namespace and
+// waits up to the timeout for them to disappear. As this destroys the OAuth2 client database, it returns
+// ErrDataLossNotAllowed unless allowDataLoss is set. Nothing is deleted while a StatefulSet or Deployment of the
+// release still exists and is not being deleted, as its pods would still be using the claims.
+func DeletePersistentVolumeClaims(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger,
+	namespace string, allowDataLoss bool, timeout time.Duration) ([]DeletedClaim, error) {
+	if !allowDataLoss {
+		return nil, ErrDataLossNotAllowed
+	}
+
+	selector := metav1.ListOptions{LabelSelector: OryInstanceLabel + "=" + oryReleaseName}
+	owner, err := runningWorkload(ctx, client, namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	if owner != "" {
+		logger.Infof("Keeping persistent volume claims of ory, as %s still exists", owner)
+		return nil, nil
+	}
+
+	claims, err := client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, selector)
+	if apierr.IsForbidden(err) {
+		return nil, &MissingPermissionError{Verb: "list", Resource: pvcResource, Namespace: namespace, err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []DeletedClaim
+	for _, claim := range claims.Items {
+		err := client.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, claim.Name, metav1.DeleteOptions{})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if apierr.IsForbidden(err) {
+			return deleted, &MissingPermissionError{Verb: "delete", Resource: pvcResource, Namespace: namespace, err: err}
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deletedClaim := DeletedClaim{Name: claim.Name, Capacity: claimCapacity(claim)}
+		logger.Infof("Deleted persistent volume claim %s of ory in namespace '%s'", deletedClaim, namespace)
+		deleted = append(deleted, deletedClaim)
+	}
+
+	return deleted, waitForClaimsGone(ctx, client, namespace, deleted, timeout)
+}
+
+// runningWorkload returns the first StatefulSet or Deployment matching the selector which is not being deleted
+func runningWorkload(ctx context.Context, client kubernetes.Interface, namespace string, selector metav1.ListOptions) (string, error) {
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(ctx, selector)
+	if apierr.IsForbidden(err) {
+		return "", &MissingPermissionError{Verb: "list", Resource: statefulSetResource, Namespace: namespace, err: err}
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if statefulSet.DeletionTimestamp == nil {
+			return Workload{Kind: WorkloadKindStatefulSet, Name: statefulSet.Name}.String(), nil
+		}
+	}
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(ctx, selector)
+	if apierr.IsForbidden(err) {
+		return "", &MissingPermissionError{Verb: "list", Resource: deploymentResource, Namespace: namespace, err: err}
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, deployment := range deployments.Items {
+		if deployment.DeletionTimestamp == nil {
+			return Workload{Kind: WorkloadKindDeployment, Name: deployment.Name}.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// claimCapacity returns the provisioned capacity of the claim, or the requested one if it was never bound
+func claimCapacity(claim corev1.PersistentVolumeClaim) resource.Quantity {
+	if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+		return capacity
+	}
+	return claim.Spec.Resources.Requests[corev1.ResourceStorage]
+}
+
+func waitForClaimsGone(ctx context.Context, client kubernetes.Interface, namespace string, claims []DeletedClaim, timeout time.Duration) error {
+	if len(claims) == 0 {
+		return nil
+	}
+	var remaining []string
+	err := wait.PollImmediateWithContext(ctx, pvcDeletionInterval, timeout, func(ctx context.Context) (bool, error) {
+		remaining = nil
+		for _, claim := range claims {
+			_, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+			if apierr.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			remaining = append(remaining, claim.Name)
+		}
+		return len(remaining) == 0, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return &PVCsNotDeletedError{Namespace: namespace, Timeout: timeout, Remaining: remaining}
+	}
+	return err
+}
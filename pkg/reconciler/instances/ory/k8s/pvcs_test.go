@@ -0,0 +1,105 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var oryLabels = map[string]string{OryInstanceLabel: "ory"}
+
+func Test_DeletePersistentVolumeClaims(t *testing.T) {
+	t.Run("should require data loss to be allowed", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixPersistentVolumeClaim("data-ory-postgresql-0", oryLabels, "8Gi", ""))
+
+		// when
+		deleted, err := DeletePersistentVolumeClaims(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", false, time.Second)
+
+		// then
+		require.True(t, errors.Is(err, ErrDataLossNotAllowed))
+		require.Empty(t, deleted)
+		require.Empty(t, clientset.Actions())
+	})
+
+	t.Run("should delete the claims of ory and report their capacity", func(t *testing.T) {
+		// given
+		terminating := fixOryStatefulSet()
+		now := metav1.Now()
+		terminating.DeletionTimestamp = &now
+		clientset := fake.NewSimpleClientset(terminating,
+			fixPersistentVolumeClaim("data-ory-postgresql-0", oryLabels, "8Gi", "10Gi"),
+			fixPersistentVolumeClaim("pending", oryLabels, "", "1Gi"),
+			fixPersistentVolumeClaim("other", map[string]string{OryInstanceLabel: "eventing"}, "5Gi", ""))
+
+		// when
+		deleted, err := DeletePersistentVolumeClaims(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", true, time.Second)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []DeletedClaim{
+			{Name: "data-ory-postgresql-0", Capacity: resource.MustParse("10Gi")},
+			{Name: "pending", Capacity: resource.MustParse("1Gi")},
+		}, deleted)
+		_, err = clientset.CoreV1().PersistentVolumeClaims("kyma-system").Get(context.Background(), "other", metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should keep the claims while the workload of ory exists", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixOryStatefulSet(),
+			fixPersistentVolumeClaim("data-ory-postgresql-0", oryLabels, "8Gi", ""))
+
+		// when
+		deleted, err := DeletePersistentVolumeClaims(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", true, time.Second)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+		_, err = clientset.CoreV1().PersistentVolumeClaims("kyma-system").Get(context.Background(), "data-ory-postgresql-0", metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should report claims which do not disappear in time", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixPersistentVolumeClaim("data-ory-postgresql-0", oryLabels, "8Gi", ""))
+		// the pvc-protection finalizer keeps claims mounted by a pod
+		clientset.PrependReactor("delete", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, nil
+		})
+
+		// when
+		deleted, err := DeletePersistentVolumeClaims(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", true, 10*time.Millisecond)
+
+		// then
+		require.True(t, IsPVCsNotDeletedError(err))
+		require.Contains(t, err.Error(), "[data-ory-postgresql-0]")
+		require.Len(t, deleted, 1)
+	})
+}
+
+func fixPersistentVolumeClaim(name string, labels map[string]string, requested, provisioned string) *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: name, Labels: labels}}
+	if requested != "" {
+		claim.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(requested)}
+	}
+	if provisioned != "" {
+		claim.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(provisioned)}
+	}
+	return claim
+}
+
+func fixOryStatefulSet() *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: "ory-postgresql", Labels: oryLabels}}
+}
@@ -0,0 +1,273 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	WorkloadKindDeployment  = "Deployment"
+	WorkloadKindStatefulSet = "StatefulSet"
+
+	readinessInterval = 5 * time.Second
+	// eventsPerWorkload is the number of most recent events reported for each unready workload
+	eventsPerWorkload = 3
+)
+
+// Workload identifies a Deployment or StatefulSet whose readiness is checked
+type Workload struct {
+	Kind string
+	Name string
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s \"%s\"", w.Kind, w.Name)
+}
+
+// WorkloadStatus is the readiness of a workload at the time of the check
+type WorkloadStatus struct {
+	Workload
+	Ready bool
+	// Reason explains why the workload is not ready, empty if it is ready
+	Reason string
+}
+
+// ReadinessChecker verifies that workloads have rolled out all their replicas
+//
+//go:generate mockery --name=ReadinessChecker --outpkg=mock --case=underscore
+type ReadinessChecker interface {
+	// CheckReadiness returns the current status of the workloads without waiting, which allows using it as a health probe
+	CheckReadiness(ctx context.Context, client kubernetes.Interface, namespace string, workloads []Workload) ([]WorkloadStatus, error)
+	// WaitForReadiness waits until all workloads are ready and returns a WorkloadsNotReadyError if the timeout expired before
+	WaitForReadiness(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger, namespace string,
+		workloads []Workload, timeout time.Duration) error
+}
+
+type DefaultReadinessChecker struct {
+	interval time.Duration
+}
+
+func NewDefaultReadinessChecker() *DefaultReadinessChecker {
+	return &DefaultReadinessChecker{interval: readinessInterval}
+}
+
+// WorkloadsNotReadyError is returned when workloads did not become ready in time
+type WorkloadsNotReadyError struct {
+	Namespace string
+	Timeout   time.Duration
+	// Unready contains the status of all workloads which were not ready at the last check
+	Unready []WorkloadStatus
+	// Events contains the most recent events of each unready workload and its pods, keyed by workload name
+	Events map[string][]string
+}
+
+func (e *WorkloadsNotReadyError) Error() string {
+	var details []string
+	for _, status := range e.Unready {
+		detail := fmt.Sprintf("%s: %s", status.Workload, status.Reason)
+		if events := e.Events[status.Name]; len(events) > 0 {
+			detail += fmt.Sprintf(" (recent events: %s)", strings.Join(events, "; "))
+		}
+		details = append(details, detail)
+	}
+	return fmt.Sprintf("%d workloads in namespace \"%s\" not ready within %s: %s",
+		len(e.Unready), e.Namespace, e.Timeout, strings.Join(details, ", "))
+}
+
+func IsWorkloadsNotReadyError(err error) bool {
+	var target *WorkloadsNotReadyError
+	return errors.As(err, &target)
+}
+
+// WaitForReadiness polls the workloads until all are ready. Checks failing with an error which IsRetriableError
+// accepts are logged and polled again, all other errors end the wait.
+func (c *DefaultReadinessChecker) WaitForReadiness(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger,
+	namespace string, workloads []Workload, timeout time.Duration) error {
+	var unready []WorkloadStatus
+	var checkErr error
+	err := wait.PollImmediateWithContext(ctx, c.interval, timeout, func(ctx context.Context) (bool, error) {
+		var statuses []WorkloadStatus
+		statuses, checkErr = c.CheckReadiness(ctx, client, namespace, workloads)
+		if IsRetriableError(checkErr) {
+			logger.Warnf("Checking the readiness of workloads in namespace '%s' failed, checking again: %v", namespace, checkErr)
+			return false, nil
+		}
+		if checkErr != nil {
+			return false, checkErr
+		}
+		unready = unreadyWorkloads(statuses)
+		if len(unready) > 0 {
+			logger.Infof("Waiting for %d of %d workloads in namespace '%s' to become ready: %s",
+				len(unready), len(workloads), namespace, workloadNames(unready))
+		}
+		return len(unready) == 0, nil
+	})
+	if !errors.Is(err, wait.ErrWaitTimeout) {
+		return err
+	}
+	// the poll also ends with a timeout when the context is done
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return errors.Wrapf(ctxErr, "stopped waiting for the workloads in namespace \"%s\" to become ready", namespace)
+	}
+	if checkErr != nil {
+		return errors.Wrapf(checkErr, "readiness of the workloads in namespace \"%s\" could not be checked within %s",
+			namespace, timeout)
+	}
+
+	notReady := &WorkloadsNotReadyError{Namespace: namespace, Timeout: timeout, Unready: unready}
+	notReady.Events, err = recentEvents(ctx, client, namespace, workloads, unready)
+	if err != nil {
+		logger.Warnf("Failed to collect events of unready workloads in namespace '%s': %v", namespace, err)
+	}
+	return notReady
+}
+
+func (c *DefaultReadinessChecker) CheckReadiness(ctx context.Context, client kubernetes.Interface, namespace string,
+	workloads []Workload) ([]WorkloadStatus, error) {
+	statuses := make([]WorkloadStatus, 0, len(workloads))
+	for _, workload := range workloads {
+		status, err := checkWorkload(ctx, client, namespace, workload)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check readiness of %s", workload)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func checkWorkload(ctx context.Context, client kubernetes.Interface, namespace string, workload Workload) (WorkloadStatus, error) {
+	var err error
+	var generation, observedGeneration int64
+	var replicas, updated, ready int32
+	switch workload.Kind {
+	case WorkloadKindDeployment:
+		var deployment *appsv1.Deployment
+		deployment, err = client.AppsV1().Deployments(namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err == nil {
+			generation, observedGeneration = deployment.Generation, deployment.Status.ObservedGeneration
+			replicas, updated, ready = desiredReplicas(deployment.Spec.Replicas), deployment.Status.UpdatedReplicas, deployment.Status.ReadyReplicas
+		}
+	case WorkloadKindStatefulSet:
+		var statefulSet *appsv1.StatefulSet
+		statefulSet, err = client.AppsV1().StatefulSets(namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err == nil {
+			generation, observedGeneration = statefulSet.Generation, statefulSet.Status.ObservedGeneration
+			replicas, updated, ready = desiredReplicas(statefulSet.Spec.Replicas), statefulSet.Status.UpdatedReplicas, statefulSet.Status.ReadyReplicas
+		}
+	default:
+		return WorkloadStatus{}, errors.Errorf("unsupported workload kind \"%s\"", workload.Kind)
+	}
+	if apierr.IsNotFound(err) {
+		return WorkloadStatus{Workload: workload, Reason: "not found"}, nil
+	}
+	if err != nil {
+		return WorkloadStatus{}, err
+	}
+
+	status := WorkloadStatus{Workload: workload}
+	switch {
+	case observedGeneration < generation:
+		status.Reason = fmt.Sprintf("generation %d not observed yet", generation)
+	case updated < replicas:
+		status.Reason = fmt.Sprintf("%d/%d replicas updated", updated, replicas)
+	case ready < replicas:
+		status.Reason = fmt.Sprintf("%d/%d replicas ready", ready, replicas)
+	default:
+		status.Ready = true
+	}
+	return status, nil
+}
+
+// desiredReplicas applies the API server default of a single replica
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// recentEvents returns the most recent events of each unready workload, including the events of its replica sets and
+// pods, whose names are prefixed with the name of the workload. An event belongs to the workload with the longest
+// matching name, so that the pods of "ory-hydra-maester" are not attributed to "ory-hydra".
+func recentEvents(ctx context.Context, client kubernetes.Interface, namespace string, workloads []Workload,
+	unready []WorkloadStatus) (map[string][]string, error) {
+	if len(unready) == 0 {
+		return nil, nil
+	}
+	events, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events.Items, func(i, j int) bool {
+		return eventTime(events.Items[i]).After(eventTime(events.Items[j]))
+	})
+
+	recent := make(map[string][]string, len(unready))
+	for _, status := range unready {
+		recent[status.Name] = nil
+	}
+	for _, event := range events.Items {
+		owner := eventOwner(event.InvolvedObject.Name, workloads)
+		if current, ok := recent[owner]; !ok || len(current) == eventsPerWorkload {
+			continue
+		}
+		recent[owner] = append(recent[owner],
+			fmt.Sprintf("%s %s/%s: %s", event.Reason, event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Message))
+	}
+	return recent, nil
+}
+
+// eventOwner returns the name of the workload the involved object belongs to, empty if there is none
+func eventOwner(involved string, workloads []Workload) string {
+	var owner string
+	for _, workload := range workloads {
+		if (involved == workload.Name || strings.HasPrefix(involved, workload.Name+"-")) && len(workload.Name) > len(owner) {
+			owner = workload.Name
+		}
+	}
+	return owner
+}
+
+// eventTime returns the time the event was last seen, falling back to the fields set by older and newer reporters
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case event.Series != nil:
+		return event.Series.LastObservedTime.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+func unreadyWorkloads(statuses []WorkloadStatus) []WorkloadStatus {
+	var unready []WorkloadStatus
+	for _, status := range statuses {
+		if !status.Ready {
+			unready = append(unready, status)
+		}
+	}
+	return unready
+}
+
+func workloadNames(statuses []WorkloadStatus) string {
+	names := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		names = append(names, fmt.Sprintf("%s (%s)", status.Workload, status.Reason))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,219 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var oryWorkloads = []Workload{
+	{Kind: WorkloadKindDeployment, Name: "ory-hydra"},
+	{Kind: WorkloadKindDeployment, Name: "ory-hydra-maester"},
+	{Kind: WorkloadKindStatefulSet, Name: "ory-postgresql"},
+}
+
+func Test_CheckReadiness(t *testing.T) {
+	t.Run("should report the readiness of each workload", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(
+			fixReadinessDeployment("ory-hydra", 2, 2, 2),
+			fixReadinessDeployment("ory-hydra-maester", 1, 1, 0),
+			fixReadinessStatefulSet("ory-postgresql", 1, 1))
+		checker := NewDefaultReadinessChecker()
+
+		// when
+		statuses, err := checker.CheckReadiness(context.Background(), clientset, "kyma-system", oryWorkloads)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []WorkloadStatus{
+			{Workload: oryWorkloads[0], Ready: true},
+			{Workload: oryWorkloads[1], Reason: "0/1 replicas ready"},
+			{Workload: oryWorkloads[2], Ready: true},
+		}, statuses)
+	})
+
+	t.Run("should report missing and outdated workloads as not ready", func(t *testing.T) {
+		// given
+		outdated := fixReadinessDeployment("ory-hydra", 1, 1, 1)
+		outdated.Generation = 3
+		clientset := fake.NewSimpleClientset(outdated, fixReadinessDeployment("ory-hydra-maester", 2, 1, 2))
+		checker := NewDefaultReadinessChecker()
+
+		// when
+		statuses, err := checker.CheckReadiness(context.Background(), clientset, "kyma-system", oryWorkloads)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, "generation 3 not observed yet", statuses[0].Reason)
+		require.Equal(t, "1/2 replicas updated", statuses[1].Reason)
+		require.Equal(t, "not found", statuses[2].Reason)
+	})
+
+	t.Run("should reject unsupported kinds", func(t *testing.T) {
+		// when
+		_, err := NewDefaultReadinessChecker().CheckReadiness(context.Background(), fake.NewSimpleClientset(), "kyma-system",
+			[]Workload{{Kind: "DaemonSet", Name: "ory-hydra"}})
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `unsupported workload kind "DaemonSet"`)
+	})
+}
+
+func Test_WaitForReadiness(t *testing.T) {
+	t.Run("should return once all workloads are ready", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(
+			fixReadinessDeployment("ory-hydra", 1, 1, 1),
+			fixReadinessDeployment("ory-hydra-maester", 1, 1, 1),
+			fixReadinessStatefulSet("ory-postgresql", 1, 1))
+		checker := &DefaultReadinessChecker{interval: time.Millisecond}
+
+		// when
+		err := checker.WaitForReadiness(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", oryWorkloads, time.Second)
+
+		// then
+		require.NoError(t, err)
+	})
+
+	t.Run("should keep polling after a transient error", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(
+			fixReadinessDeployment("ory-hydra", 1, 1, 1),
+			fixReadinessDeployment("ory-hydra-maester", 1, 1, 1),
+			fixReadinessStatefulSet("ory-postgresql", 1, 1))
+		failed := false
+		clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if failed {
+				return false, nil, nil
+			}
+			failed = true
+			return true, nil, apierr.NewServiceUnavailable("apiserver restarting")
+		})
+		checker := &DefaultReadinessChecker{interval: time.Millisecond}
+
+		// when
+		err := checker.WaitForReadiness(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", oryWorkloads, time.Second)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, failed)
+	})
+
+	t.Run("should stop polling at a permanent error", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		gets := 0
+		clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			gets++
+			return true, nil, apierr.NewForbidden(appsv1.Resource("deployments"), "ory-hydra", errors.New("rbac"))
+		})
+		checker := &DefaultReadinessChecker{interval: time.Millisecond}
+
+		// when
+		err := checker.WaitForReadiness(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", oryWorkloads, time.Second)
+
+		// then
+		require.True(t, apierr.IsForbidden(err))
+		require.False(t, IsWorkloadsNotReadyError(err))
+		require.Equal(t, 1, gets)
+	})
+
+	t.Run("should report a transient error which lasts until the timeout", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewServiceUnavailable("apiserver restarting")
+		})
+		checker := &DefaultReadinessChecker{interval: time.Millisecond}
+
+		// when
+		err := checker.WaitForReadiness(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", oryWorkloads, 10*time.Millisecond)
+
+		// then
+		require.True(t, apierr.IsServiceUnavailable(err))
+		require.Contains(t, err.Error(), `readiness of the workloads in namespace "kyma-system" could not be checked within 10ms`)
+	})
+
+	t.Run("should stop waiting when the context is cancelled", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		checker := &DefaultReadinessChecker{interval: time.Millisecond}
+
+		// when
+		err := checker.WaitForReadiness(ctx, clientset, logger.NewTestLogger(t), "kyma-system", oryWorkloads, time.Second)
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, IsWorkloadsNotReadyError(err))
+	})
+
+	t.Run("should summarize the unready workloads with their recent events", func(t *testing.T) {
+		// given
+		now := time.Now()
+		clientset := fake.NewSimpleClientset(
+			fixReadinessDeployment("ory-hydra", 1, 1, 1),
+			fixReadinessDeployment("ory-hydra-maester", 1, 1, 0),
+			fixReadinessStatefulSet("ory-postgresql", 1, 1),
+			fixReadinessEvent("maester-old", "Pod", "ory-hydra-maester-5d8f-abcde", "Scheduled", now.Add(-time.Hour)),
+			fixReadinessEvent("maester-new", "Pod", "ory-hydra-maester-5d8f-abcde", "BackOff", now),
+			fixReadinessEvent("hydra", "Pod", "ory-hydra-84b9-fghij", "Pulled", now))
+		checker := &DefaultReadinessChecker{interval: time.Millisecond}
+
+		// when
+		err := checker.WaitForReadiness(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system", oryWorkloads, 10*time.Millisecond)
+
+		// then
+		require.True(t, IsWorkloadsNotReadyError(err))
+		var notReady *WorkloadsNotReadyError
+		require.True(t, errors.As(err, &notReady))
+		require.Len(t, notReady.Unready, 1)
+		require.Equal(t, "ory-hydra-maester", notReady.Unready[0].Name)
+		require.Equal(t, []string{
+			"BackOff Pod/ory-hydra-maester-5d8f-abcde: maester-new",
+			"Scheduled Pod/ory-hydra-maester-5d8f-abcde: maester-old",
+		}, notReady.Events["ory-hydra-maester"])
+		require.Contains(t, err.Error(), `Deployment "ory-hydra-maester": 0/1 replicas ready (recent events: BackOff`)
+		require.NotContains(t, err.Error(), "Pulled")
+	})
+}
+
+func fixReadinessDeployment(name string, replicas, updated, ready int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: name, Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: updated, ReadyReplicas: ready},
+	}
+}
+
+func fixReadinessStatefulSet(name string, replicas, ready int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: name, Generation: 1},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, UpdatedReplicas: replicas, ReadyReplicas: ready},
+	}
+}
+
+func fixReadinessEvent(message, kind, involved, reason string, lastSeen time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: "kyma-system", Name: involved + "." + message},
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Name: involved},
+		Reason:         reason,
+		Message:        message,
+		LastTimestamp:  metav1.NewTime(lastSeen),
+	}
+}
@@ -0,0 +1,33 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes/retry"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// The retry loop and the classification of transient errors are shared with the generic finalizers handler
+var (
+	retryOnError = retry.OnError
+	sleepOnClock = retry.SleepOnClock
+)
+
+// RetryOnTransient calls fn until it succeeds, fails with an error which IsRetriableError rejects, or the steps of
+// the backoff are exhausted, see retry.OnTransient
+func RetryOnTransient(ctx context.Context, backoff wait.Backoff, fn func() error) error {
+	return retry.OnTransient(ctx, backoff, fn)
+}
+
+// RetryOnTransientWithClock is RetryOnTransient waiting for the timers of the clock between attempts, which allows
+// tests to step a fake clock instead of sleeping
+func RetryOnTransientWithClock(ctx context.Context, clock clock.Clock, backoff wait.Backoff, fn func() error) error {
+	return retry.OnTransientWithClock(ctx, clock, backoff, fn)
+}
+
+// IsRetriableError reports whether a failed API call is likely to succeed on a subsequent attempt, see
+// retry.IsRetriableError for the retried errors
+func IsRetriableError(err error) bool {
+	return retry.IsRetriableError(err)
+}
@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	k8sRetry "k8s.io/client-go/util/retry"
+)
+
+// SecretDataGenerator returns freshly generated values for all keys a secret is required to contain
+type SecretDataGenerator func() (map[string][]byte, error)
+
+// EnsureSecret makes sure the secret exists and contains all keys returned by the generator, without regenerating
+// credentials which are already in use. An absent secret is created with the generated data. Of an existing secret
+// only missing or empty keys are filled in, the values of all other keys are kept unless forceRotate is set, which
+// replaces every generated key. Keys not returned by the generator are never touched. It reports whether the secret
+// was created or changed, in which case the workloads using it have to be restarted.
+func EnsureSecret(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger,
+	name types.NamespacedName, generate SecretDataGenerator, forceRotate bool) (bool, error) {
+	generated, err := generate()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to generate data of secret %s", name)
+	}
+
+	secrets := client.CoreV1().Secrets(name.Namespace)
+	_, err = secrets.Get(ctx, name.Name, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name.Name, Namespace: name.Namespace},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       generated,
+		}
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		if err == nil {
+			logger.Infof("Created secret %s with keys %v", name, sortedKeys(generated))
+			return true, nil
+		}
+		// the secret was created concurrently, so it is completed like any existing one
+		if !apierr.IsAlreadyExists(err) {
+			return false, permissionError(err, "create", secretResource, name.Namespace)
+		}
+	} else if err != nil {
+		return false, permissionError(err, "get", secretResource, name.Namespace)
+	}
+
+	var changed []string
+	err = k8sRetry.RetryOnConflict(k8sRetry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, name.Name, metav1.GetOptions{})
+		if err != nil {
+			return permissionError(err, "get", secretResource, name.Namespace)
+		}
+		changed = nil
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for _, key := range sortedKeys(generated) {
+			if len(secret.Data[key]) > 0 && !forceRotate {
+				continue
+			}
+			secret.Data[key] = generated[key]
+			changed = append(changed, key)
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return permissionError(err, "update", secretResource, name.Namespace)
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to complete secret %s", name)
+	}
+	if len(changed) == 0 {
+		logger.Debugf("Secret %s already contains all required keys", name)
+		return false, nil
+	}
+	if forceRotate {
+		logger.Infof("Rotated keys %v of secret %s", changed, name)
+	} else {
+		logger.Infof("Added missing keys %v to secret %s", changed, name)
+	}
+	return true, nil
+}
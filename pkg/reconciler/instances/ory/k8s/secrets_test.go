@@ -0,0 +1,164 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var ensuredSecretName = types.NamespacedName{Namespace: "kyma-system", Name: "ory-hydra-credentials"}
+
+func Test_EnsureSecret(t *testing.T) {
+	t.Run("should create an absent secret", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+
+		// when
+		changed, err := EnsureSecret(context.Background(), clientset, logger.NewTestLogger(t), ensuredSecretName,
+			fixSecretGenerator("new"), false)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, changed)
+		secret := getEnsuredSecret(t, clientset)
+		require.Equal(t, corev1.SecretTypeOpaque, secret.Type)
+		require.Equal(t, map[string][]byte{"secretsSystem": []byte("new"), "secretsCookie": []byte("new")}, secret.Data)
+	})
+
+	t.Run("should keep a complete secret", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixEnsuredSecret(map[string][]byte{
+			"secretsSystem": []byte("system"), "secretsCookie": []byte("cookie"), "dsn": []byte("memory")}))
+
+		// when
+		changed, err := EnsureSecret(context.Background(), clientset, logger.NewTestLogger(t), ensuredSecretName,
+			fixSecretGenerator("new"), false)
+
+		// then
+		require.NoError(t, err)
+		require.False(t, changed)
+		require.Equal(t, map[string][]byte{
+			"secretsSystem": []byte("system"), "secretsCookie": []byte("cookie"), "dsn": []byte("memory")},
+			getEnsuredSecret(t, clientset).Data)
+		for _, action := range clientset.Actions() {
+			require.NotEqual(t, "update", action.GetVerb())
+		}
+	})
+
+	t.Run("should add only the missing keys to a partially populated secret", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixEnsuredSecret(map[string][]byte{
+			"secretsSystem": []byte("system"), "secretsCookie": {}, "dsn": []byte("memory")}))
+
+		// when
+		changed, err := EnsureSecret(context.Background(), clientset, logger.NewTestLogger(t), ensuredSecretName,
+			fixSecretGenerator("new"), false)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, map[string][]byte{
+			"secretsSystem": []byte("system"), "secretsCookie": []byte("new"), "dsn": []byte("memory")},
+			getEnsuredSecret(t, clientset).Data)
+	})
+
+	t.Run("should replace all generated keys when rotation is forced", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixEnsuredSecret(map[string][]byte{
+			"secretsSystem": []byte("system"), "secretsCookie": []byte("cookie"), "dsn": []byte("memory")}))
+
+		// when
+		changed, err := EnsureSecret(context.Background(), clientset, logger.NewTestLogger(t), ensuredSecretName,
+			fixSecretGenerator("new"), true)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, map[string][]byte{
+			"secretsSystem": []byte("new"), "secretsCookie": []byte("new"), "dsn": []byte("memory")},
+			getEnsuredSecret(t, clientset).Data)
+	})
+
+	t.Run("should complete a secret created concurrently", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			// another reconciliation creates the secret between the lookup and the creation
+			err := clientset.Tracker().Add(fixEnsuredSecret(map[string][]byte{"secretsSystem": []byte("system")}))
+			require.NoError(t, err)
+			return true, nil, apierr.NewAlreadyExists(secretResource, ensuredSecretName.Name)
+		})
+
+		// when
+		changed, err := EnsureSecret(context.Background(), clientset, logger.NewTestLogger(t), ensuredSecretName,
+			fixSecretGenerator("new"), false)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, map[string][]byte{"secretsSystem": []byte("system"), "secretsCookie": []byte("new")},
+			getEnsuredSecret(t, clientset).Data)
+	})
+
+	t.Run("should not touch the secret when generating fails", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		generate := func() (map[string][]byte, error) {
+			return nil, errors.New("entropy exhausted")
+		}
+
+		// when
+		changed, err := EnsureSecret(context.Background(), clientset, logger.NewTestLogger(t), ensuredSecretName, generate, false)
+
+		// then
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "entropy exhausted")
+		require.False(t, changed)
+		require.Empty(t, clientset.Actions())
+	})
+
+	t.Run("should report missing permissions", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixEnsuredSecret(nil))
+		clientset.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(secretResource, ensuredSecretName.Name, errors.New("rbac"))
+		})
+
+		// when
+		changed, err := EnsureSecret(context.Background(), clientset, logger.NewTestLogger(t), ensuredSecretName,
+			fixSecretGenerator("new"), false)
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.False(t, changed)
+	})
+}
+
+func fixSecretGenerator(value string) SecretDataGenerator {
+	return func() (map[string][]byte, error) {
+		return map[string][]byte{"secretsSystem": []byte(value), "secretsCookie": []byte(value)}, nil
+	}
+}
+
+func fixEnsuredSecret(data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ensuredSecretName.Name, Namespace: ensuredSecretName.Namespace},
+		Data:       data,
+	}
+}
+
+func getEnsuredSecret(t *testing.T, clientset *fake.Clientset) *corev1.Secret {
+	secret, err := clientset.CoreV1().Secrets(ensuredSecretName.Namespace).Get(context.Background(), ensuredSecretName.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
@@ -0,0 +1,156 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/clock"
+)
+
+const (
+	ForcedDeletionKindPod = "Pod"
+	ForcedDeletionKindJob = "Job"
+)
+
+var (
+	podResource = corev1.Resource("pods")
+	jobResource = batchv1.Resource("jobs")
+
+	removeFinalizersPatch = []byte(`{"metadata":{"finalizers":null}}`)
+	// oryReleaseSelector selects the workloads of the ory release, the namespaces are shared with other components
+	oryReleaseSelector = metav1.ListOptions{LabelSelector: OryInstanceLabel + "=" + oryReleaseName}
+)
+
+// ForcedDeletion is a pod or job which was stuck in deletion and forcibly removed
+type ForcedDeletion struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// TerminatingSince is the deletion timestamp, which is the end of the grace period for pods
+	TerminatingSince time.Time
+	// RemovedFinalizers contains the finalizers which were removed before the deletion
+	RemovedFinalizers []string
+}
+
+func (d ForcedDeletion) String() string {
+	return fmt.Sprintf("%s %s/%s", d.Kind, d.Namespace, d.Name)
+}
+
+// ForceDeleteStuckWorkloads removes the pods and jobs of the ory release in the given namespaces which are terminating
+// for longer than the threshold, for example because their node disappeared. Pods are deleted without grace period
+// after their finalizers were removed, jobs only get their finalizers removed, as they are already being deleted.
+// Workloads of other components in the namespaces are left alone. The threshold has to be positive, so that pods
+// which are still shutting down are never killed.
+func ForceDeleteStuckWorkloads(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger,
+	namespaces []string, threshold time.Duration) ([]ForcedDeletion, error) {
+	return forceDeleteStuckWorkloads(ctx, clock.RealClock{}, client, logger, namespaces, threshold)
+}
+
+func forceDeleteStuckWorkloads(ctx context.Context, clock clock.PassiveClock, client kubernetes.Interface, logger *zap.SugaredLogger,
+	namespaces []string, threshold time.Duration) ([]ForcedDeletion, error) {
+	if threshold <= 0 {
+		return nil, errors.Errorf("threshold for stuck workloads has to be positive, got %s", threshold)
+	}
+
+	var deletions []ForcedDeletion
+	for _, namespace := range namespaces {
+		jobs, err := forceDeleteStuckJobs(ctx, clock, client, logger, namespace, threshold)
+		deletions = append(deletions, jobs...)
+		if err != nil {
+			return deletions, err
+		}
+		pods, err := forceDeleteStuckPods(ctx, clock, client, logger, namespace, threshold)
+		deletions = append(deletions, pods...)
+		if err != nil {
+			return deletions, err
+		}
+	}
+	return deletions, nil
+}
+
+func forceDeleteStuckJobs(ctx context.Context, clock clock.PassiveClock, client kubernetes.Interface, logger *zap.SugaredLogger,
+	namespace string, threshold time.Duration) ([]ForcedDeletion, error) {
+	jobs, err := client.BatchV1().Jobs(namespace).List(ctx, oryReleaseSelector)
+	if err != nil {
+		return nil, permissionError(err, "list", jobResource, namespace)
+	}
+
+	var deletions []ForcedDeletion
+	for _, job := range jobs.Items {
+		if !isStuck(clock, job.ObjectMeta, threshold) || len(job.Finalizers) == 0 {
+			continue
+		}
+		_, err := client.BatchV1().Jobs(namespace).Patch(ctx, job.Name, types.MergePatchType, removeFinalizersPatch, metav1.PatchOptions{})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deletions, permissionError(err, "patch", jobResource, namespace)
+		}
+		deletion := newForcedDeletion(ForcedDeletionKindJob, job.ObjectMeta)
+		logger.Warnf("Removed finalizers %v of %s stuck in deletion since %s", deletion.RemovedFinalizers, deletion,
+			deletion.TerminatingSince.UTC().Format(time.RFC3339))
+		deletions = append(deletions, deletion)
+	}
+	return deletions, nil
+}
+
+func forceDeleteStuckPods(ctx context.Context, clock clock.PassiveClock, client kubernetes.Interface, logger *zap.SugaredLogger,
+	namespace string, threshold time.Duration) ([]ForcedDeletion, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, oryReleaseSelector)
+	if err != nil {
+		return nil, permissionError(err, "list", podResource, namespace)
+	}
+
+	var deletions []ForcedDeletion
+	for _, pod := range pods.Items {
+		if !isStuck(clock, pod.ObjectMeta, threshold) {
+			continue
+		}
+		if len(pod.Finalizers) > 0 {
+			_, err := client.CoreV1().Pods(namespace).Patch(ctx, pod.Name, types.MergePatchType, removeFinalizersPatch, metav1.PatchOptions{})
+			if apierr.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return deletions, permissionError(err, "patch", podResource, namespace)
+			}
+		}
+		gracePeriod := int64(0)
+		err := client.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deletions, permissionError(err, "delete", podResource, namespace)
+		}
+		deletion := newForcedDeletion(ForcedDeletionKindPod, pod.ObjectMeta)
+		logger.Warnf("Force deleted %s on node '%s' stuck in deletion since %s", deletion, pod.Spec.NodeName,
+			deletion.TerminatingSince.UTC().Format(time.RFC3339))
+		deletions = append(deletions, deletion)
+	}
+	return deletions, nil
+}
+
+func isStuck(clock clock.PassiveClock, meta metav1.ObjectMeta, threshold time.Duration) bool {
+	return meta.DeletionTimestamp != nil && clock.Since(meta.DeletionTimestamp.Time) > threshold
+}
+
+func newForcedDeletion(kind string, meta metav1.ObjectMeta) ForcedDeletion {
+	return ForcedDeletion{
+		Kind:              kind,
+		Namespace:         meta.Namespace,
+		Name:              meta.Name,
+		TerminatingSince:  meta.DeletionTimestamp.Time,
+		RemovedFinalizers: meta.Finalizers,
+	}
+}
@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func Test_ForceDeleteStuckWorkloads(t *testing.T) {
+	t.Run("should force delete pods and jobs stuck longer than the threshold", func(t *testing.T) {
+		// given
+		stuckSince := time.Now().Add(-2 * time.Hour)
+		clientset := fake.NewSimpleClientset(
+			fixTerminatingPod("kyma-system", "ory-oathkeeper-abc", stuckSince),
+			fixTerminatingPod("kyma-system", "ory-hydra-maester-def", stuckSince, "batch.kubernetes.io/job-tracking"),
+			fixTerminatingPod("kyma-system", "ory-hydra-shutting-down", time.Now()),
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kyma-system", Name: "ory-hydra-running"}},
+			fixTerminatingJob("kyma-system", "ory-hydra-jwks-rotator", stuckSince, "foregroundDeletion"))
+
+		// when
+		deletions, err := ForceDeleteStuckWorkloads(context.Background(), clientset, logger.NewTestLogger(t), []string{"kyma-system"}, time.Hour)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, deletions, 3)
+		require.Equal(t, ForcedDeletion{Kind: ForcedDeletionKindJob, Namespace: "kyma-system", Name: "ory-hydra-jwks-rotator",
+			TerminatingSince: metav1.NewTime(stuckSince).Time, RemovedFinalizers: []string{"foregroundDeletion"}}, deletions[0])
+		require.ElementsMatch(t, []string{"ory-oathkeeper-abc", "ory-hydra-maester-def"}, []string{deletions[1].Name, deletions[2].Name})
+
+		pods, err := clientset.CoreV1().Pods("kyma-system").List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, pods.Items, 2)
+		job, err := clientset.BatchV1().Jobs("kyma-system").Get(context.Background(), "ory-hydra-jwks-rotator", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Empty(t, job.Finalizers)
+	})
+
+	t.Run("should delete pods without grace period", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixTerminatingPod("kyma-system", "ory-oathkeeper-abc", time.Now().Add(-time.Hour)))
+
+		// when
+		_, err := ForceDeleteStuckWorkloads(context.Background(), clientset, logger.NewTestLogger(t), []string{"kyma-system"}, time.Minute)
+
+		// then
+		require.NoError(t, err)
+		var deletes []k8stesting.DeleteActionImpl
+		for _, action := range clientset.Actions() {
+			if deleteAction, ok := action.(k8stesting.DeleteActionImpl); ok {
+				deletes = append(deletes, deleteAction)
+			}
+		}
+		require.Len(t, deletes, 1)
+		require.Equal(t, int64(0), *deletes[0].DeleteOptions.GracePeriodSeconds)
+	})
+
+	t.Run("should leave stuck workloads of other components alone", func(t *testing.T) {
+		// given
+		stuckSince := time.Now().Add(-2 * time.Hour)
+		otherPod := fixTerminatingPod("kyma-system", "eventing-controller-abc", stuckSince, "example.com/cleanup")
+		otherPod.Labels = map[string]string{OryInstanceLabel: "eventing"}
+		otherJob := fixTerminatingJob("kyma-system", "eventing-migration", stuckSince, "foregroundDeletion")
+		otherJob.Labels = nil
+		clientset := fake.NewSimpleClientset(otherPod, otherJob)
+
+		// when
+		deletions, err := ForceDeleteStuckWorkloads(context.Background(), clientset, logger.NewTestLogger(t), []string{"kyma-system"}, time.Hour)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, deletions)
+		pod, err := clientset.CoreV1().Pods("kyma-system").Get(context.Background(), "eventing-controller-abc", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"example.com/cleanup"}, pod.Finalizers)
+		job, err := clientset.BatchV1().Jobs("kyma-system").Get(context.Background(), "eventing-migration", metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"foregroundDeletion"}, job.Finalizers)
+	})
+
+	t.Run("should only force delete pods once they are terminating longer than the threshold", func(t *testing.T) {
+		// given
+		deleted := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+		fakeClock := testingclock.NewFakePassiveClock(deleted.Add(time.Hour))
+		clientset := fake.NewSimpleClientset(fixTerminatingPod("kyma-system", "ory-oathkeeper-abc", deleted))
+
+		// when
+		early, err := forceDeleteStuckWorkloads(context.Background(), fakeClock, clientset, logger.NewTestLogger(t), []string{"kyma-system"}, time.Hour)
+		require.NoError(t, err)
+		fakeClock.SetTime(deleted.Add(time.Hour + time.Second))
+		late, err := forceDeleteStuckWorkloads(context.Background(), fakeClock, clientset, logger.NewTestLogger(t), []string{"kyma-system"}, time.Hour)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, early)
+		require.Len(t, late, 1)
+		require.Equal(t, "ory-oathkeeper-abc", late[0].Name)
+	})
+
+	t.Run("should reject a threshold which would kill terminating pods", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixTerminatingPod("kyma-system", "ory-oathkeeper-abc", time.Now()))
+
+		// when
+		_, err := ForceDeleteStuckWorkloads(context.Background(), clientset, logger.NewTestLogger(t), []string{"kyma-system"}, 0)
+
+		// then
+		require.Error(t, err)
+		require.Empty(t, clientset.Actions())
+	})
+
+	t.Run("should report missing permissions", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(fixTerminatingPod("kyma-system", "ory-oathkeeper-abc", time.Now().Add(-time.Hour)))
+		clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(podResource, "ory-oathkeeper-abc", errors.New("rbac"))
+		})
+
+		// when
+		deletions, err := ForceDeleteStuckWorkloads(context.Background(), clientset, logger.NewTestLogger(t), []string{"kyma-system"}, time.Minute)
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), `grant delete on pods in namespace "kyma-system"`)
+		require.Empty(t, deletions)
+	})
+}
+
+func fixTerminatingPod(namespace, name string, deleted time.Time, finalizers ...string) *corev1.Pod {
+	deletionTimestamp := metav1.NewTime(deleted)
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{OryInstanceLabel: oryReleaseName},
+		DeletionTimestamp: &deletionTimestamp, Finalizers: finalizers}}
+}
+
+func fixTerminatingJob(namespace, name string, deleted time.Time, finalizers ...string) *batchv1.Job {
+	deletionTimestamp := metav1.NewTime(deleted)
+	return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{OryInstanceLabel: oryReleaseName},
+		DeletionTimestamp: &deletionTimestamp, Finalizers: finalizers}}
+}
@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// OryInstanceLabel is set by helm on all resources of the ory release
+	OryInstanceLabel = "app.kubernetes.io/instance"
+	oryReleaseName   = "ory"
+	// oryServicePrefix is shared by all services of the ory chart, which lives in a namespace together with other components
+	oryServicePrefix = "ory-"
+)
+
+var (
+	validatingWebhookResource = admissionv1.Resource("validatingwebhookconfigurations")
+	mutatingWebhookResource   = admissionv1.Resource("mutatingwebhookconfigurations")
+)
+
+// DeleteWebhookConfigurations deletes the validating and mutating webhook configurations of ory and returns their names.
+// A configuration belongs to ory if it carries the ory instance label or calls an ory service in the given namespace.
+// Configurations with webhooks calling a URL or a service outside the namespace are never deleted.
+func DeleteWebhookConfigurations(ctx context.Context, client kubernetes.Interface, logger *zap.SugaredLogger, namespace string) ([]string, error) {
+	admission := client.AdmissionregistrationV1()
+
+	validating, err := admission.ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, permissionError(err, "list", validatingWebhookResource, "")
+	}
+	var deleted []string
+	for _, config := range validating.Items {
+		var clientConfigs []admissionv1.WebhookClientConfig
+		for _, webhook := range config.Webhooks {
+			clientConfigs = append(clientConfigs, webhook.ClientConfig)
+		}
+		if !isOryWebhookConfiguration(config.ObjectMeta, clientConfigs, namespace) {
+			continue
+		}
+		err := admission.ValidatingWebhookConfigurations().Delete(ctx, config.Name, metav1.DeleteOptions{})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, permissionError(err, "delete", validatingWebhookResource, "")
+		}
+		logger.Infof("Deleted ValidatingWebhookConfiguration %s of ory", config.Name)
+		deleted = append(deleted, config.Name)
+	}
+
+	mutating, err := admission.MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return deleted, permissionError(err, "list", mutatingWebhookResource, "")
+	}
+	for _, config := range mutating.Items {
+		var clientConfigs []admissionv1.WebhookClientConfig
+		for _, webhook := range config.Webhooks {
+			clientConfigs = append(clientConfigs, webhook.ClientConfig)
+		}
+		if !isOryWebhookConfiguration(config.ObjectMeta, clientConfigs, namespace) {
+			continue
+		}
+		err := admission.MutatingWebhookConfigurations().Delete(ctx, config.Name, metav1.DeleteOptions{})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, permissionError(err, "delete", mutatingWebhookResource, "")
+		}
+		logger.Infof("Deleted MutatingWebhookConfiguration %s of ory", config.Name)
+		deleted = append(deleted, config.Name)
+	}
+
+	return deleted, nil
+}
+
+func isOryWebhookConfiguration(meta metav1.ObjectMeta, clientConfigs []admissionv1.WebhookClientConfig, namespace string) bool {
+	callsOry := false
+	for _, clientConfig := range clientConfigs {
+		service := clientConfig.Service
+		if service == nil || service.Namespace != namespace {
+			return false
+		}
+		if strings.HasPrefix(service.Name, oryServicePrefix) {
+			callsOry = true
+		}
+	}
+	return callsOry || meta.Labels[OryInstanceLabel] == oryReleaseName
+}
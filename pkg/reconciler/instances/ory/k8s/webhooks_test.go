@@ -0,0 +1,109 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-incubator/reconciler/pkg/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_DeleteWebhookConfigurations(t *testing.T) {
+	t.Run("should delete the webhook configurations of ory", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(
+			fixValidatingWebhookConfiguration("oathkeeper", nil, fixServiceClientConfig("kyma-system", "ory-oathkeeper-api")),
+			fixValidatingWebhookConfiguration("labeled", map[string]string{OryInstanceLabel: "ory"}),
+			fixMutatingWebhookConfiguration("hydra", fixServiceClientConfig("kyma-system", "ory-hydra-maester")),
+			fixValidatingWebhookConfiguration("istio", nil, fixServiceClientConfig("kyma-system", "istiod")),
+			fixMutatingWebhookConfiguration("other", fixServiceClientConfig("other", "ory-hydra")))
+
+		// when
+		deleted, err := DeleteWebhookConfigurations(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"oathkeeper", "labeled", "hydra"}, deleted)
+		validating, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, validating.Items, 1)
+		require.Equal(t, "istio", validating.Items[0].Name)
+		_, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), "other", metav1.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should keep configurations calling outside of the ory namespace", func(t *testing.T) {
+		// given
+		url := "https://webhook.example.com"
+		clientset := fake.NewSimpleClientset(
+			fixValidatingWebhookConfiguration("mixed", map[string]string{OryInstanceLabel: "ory"},
+				fixServiceClientConfig("kyma-system", "ory-oathkeeper-api"), fixServiceClientConfig("default", "foreign")),
+			fixValidatingWebhookConfiguration("url", map[string]string{OryInstanceLabel: "ory"}, admissionv1.WebhookClientConfig{URL: &url}))
+
+		// when
+		deleted, err := DeleteWebhookConfigurations(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+	})
+
+	t.Run("should tolerate configurations deleted concurrently", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset(
+			fixValidatingWebhookConfiguration("oathkeeper", nil, fixServiceClientConfig("kyma-system", "ory-oathkeeper-api")))
+		clientset.PrependReactor("delete", "validatingwebhookconfigurations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewNotFound(validatingWebhookResource, "oathkeeper")
+		})
+
+		// when
+		deleted, err := DeleteWebhookConfigurations(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system")
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+	})
+
+	t.Run("should report missing permissions", func(t *testing.T) {
+		// given
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("list", "mutatingwebhookconfigurations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewForbidden(mutatingWebhookResource, "", errors.New("rbac"))
+		})
+
+		// when
+		_, err := DeleteWebhookConfigurations(context.Background(), clientset, logger.NewTestLogger(t), "kyma-system")
+
+		// then
+		require.True(t, IsMissingPermissionError(err))
+		require.Contains(t, err.Error(), "grant list on mutatingwebhookconfigurations.admissionregistration.k8s.io cluster-wide")
+	})
+}
+
+func fixServiceClientConfig(namespace, name string) admissionv1.WebhookClientConfig {
+	return admissionv1.WebhookClientConfig{Service: &admissionv1.ServiceReference{Namespace: namespace, Name: name}}
+}
+
+func fixValidatingWebhookConfiguration(name string, labels map[string]string,
+	clientConfigs ...admissionv1.WebhookClientConfig) *admissionv1.ValidatingWebhookConfiguration {
+	config := &admissionv1.ValidatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	for _, clientConfig := range clientConfigs {
+		config.Webhooks = append(config.Webhooks, admissionv1.ValidatingWebhook{Name: name + ".ory.sh", ClientConfig: clientConfig})
+	}
+	return config
+}
+
+func fixMutatingWebhookConfiguration(name string, clientConfigs ...admissionv1.WebhookClientConfig) *admissionv1.MutatingWebhookConfiguration {
+	config := &admissionv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	for _, clientConfig := range clientConfigs {
+		config.Webhooks = append(config.Webhooks, admissionv1.MutatingWebhook{Name: name + ".ory.sh", ClientConfig: clientConfig})
+	}
+	return config
+}
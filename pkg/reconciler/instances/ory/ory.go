@@ -21,13 +21,16 @@ func init() {
 
 	reconciler.
 		WithPreReconcileAction(&preReconcileAction{
-			&oryAction{step: "pre-reconcile"},
+			&oryAction{step: "pre-reconcile"}, k8s.NewDefaultJWKSHandler(jwksAlg, jwksBits),
+		}).
+		WithPreDeleteAction(&preDeleteAction{
+			&oryAction{step: "pre-delete"}, newDefaultOryFinalizersHandler,
 		}).
 		WithPostDeleteAction(&postDeleteAction{
-			&oryAction{step: "post-delete"}, k8s.NewDefaultOryFinalizersHandler(),
+			&oryAction{step: "post-delete"}, newDefaultOryFinalizersHandler,
 		}).
 		WithPostReconcileAction(&postReconcileAction{
 			&oryAction{step: "post-reconcile"}, hydra.NewDefaultHydraSyncer(k8s.NewDefaultRolloutHandler()),
-			k8s.NewDefaultRolloutHandler(),
+			k8s.NewDefaultRolloutHandler(), k8s.NewDefaultReadinessChecker(), hydra.NewDefaultHealthChecker(),
 		})
 }
@@ -0,0 +1,401 @@
+package finalizers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kyma-incubator/reconciler/pkg/reconciler/kubernetes/retry"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	k8sRetry "k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
+)
+
+const defaultListAttempts = 5
+
+// FinalizersHandler removes the finalizers accepted by a matcher from all instances of arbitrary resources, e.g. to
+// unblock the deletion of custom resources whose controller was already uninstalled. Callers with needs of their own,
+// like backups or throttled writes, replace single steps of the removal with the hooks of the options.
+type FinalizersHandler struct {
+	client       dynamic.Interface
+	logger       *zap.SugaredLogger
+	matcher      *regexp.Regexp
+	listAttempts int
+	namespace    string
+
+	lister    Lister
+	backup    Backup
+	predicate Predicate
+	validator Validator
+	remover   Remover
+	writer    Writer
+	throttle  Throttle
+	retriable func(error) bool
+	budget    RetryBudget
+	sleep     func(context.Context, time.Duration) error
+	progress  Progress
+}
+
+type Option func(*FinalizersHandler)
+
+// WithLogger sets the logger of the handler, which logs nothing by default
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(h *FinalizersHandler) {
+		if logger != nil {
+			h.logger = logger
+		}
+	}
+}
+
+// WithMatcher selects the finalizers which are removed. It is required, as removing every finalizer skips the
+// cleanup of all controllers.
+func WithMatcher(matcher *regexp.Regexp) Option {
+	return func(h *FinalizersHandler) {
+		h.matcher = matcher
+	}
+}
+
+// WithListAttempts sets how often listing the instances is attempted on transient API errors
+func WithListAttempts(attempts int) Option {
+	return func(h *FinalizersHandler) {
+		if attempts > 0 {
+			h.listAttempts = attempts
+		}
+	}
+}
+
+// WithNamespace restricts the removal to the instances in the namespace, all namespaces are processed by default.
+// It must only be used with namespaced targets.
+func WithNamespace(namespace string) Option {
+	return func(h *FinalizersHandler) {
+		h.namespace = namespace
+	}
+}
+
+func NewFinalizersHandler(client dynamic.Interface, opts ...Option) *FinalizersHandler {
+	h := &FinalizersHandler{
+		client:       client,
+		logger:       zap.NewNop().Sugar(),
+		listAttempts: defaultListAttempts,
+		retriable:    retry.IsRetriableError,
+		sleep:        retry.SleepOnClock(clock.RealClock{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Result summarizes a call of RemoveFinalizers
+type Result struct {
+	// Targets contains the results in the order of the targets
+	Targets []TargetResult
+}
+
+// RemovedFinalizers returns the number of finalizers removed from all instances
+func (r *Result) RemovedFinalizers() int {
+	if r == nil {
+		return 0
+	}
+	removed := 0
+	for _, target := range r.Targets {
+		removed += target.RemovedFinalizers
+	}
+	return removed
+}
+
+// TargetResult summarizes the finalizer removal of all instances of a single resource
+type TargetResult struct {
+	GVR schema.GroupVersionResource
+	// Instances is the number of listed instances
+	Instances int
+	// RemovedFinalizers is the number of finalizers removed from all instances
+	RemovedFinalizers int
+	// Failures contains the instances whose finalizers could not be removed
+	Failures []Failure
+}
+
+// Failure identifies an instance whose finalizers could not be removed
+type Failure struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	Name      string
+	Err       error
+}
+
+func (f Failure) Error() string {
+	if f.Namespace == "" {
+		return fmt.Sprintf("%s \"%s\": %v", f.GVR.String(), f.Name, f.Err)
+	}
+	return fmt.Sprintf("%s \"%s/%s\": %v", f.GVR.String(), f.Namespace, f.Name, f.Err)
+}
+
+func (f Failure) Unwrap() error {
+	return f.Err
+}
+
+// RemovalError is returned if the finalizers of some instances could not be removed, the other instances
+// were processed nevertheless
+type RemovalError struct {
+	Failures []Failure
+}
+
+func (e *RemovalError) Error() string {
+	messages := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		messages = append(messages, failure.Error())
+	}
+	return fmt.Sprintf("failed to remove finalizers of %d resources: [%s]", len(e.Failures), strings.Join(messages, "; "))
+}
+
+func IsRemovalError(err error) bool {
+	var target *RemovalError
+	return errors.As(err, &target)
+}
+
+// RemoveFinalizers removes the matched finalizers from all instances of the targets, in all namespaces for namespaced
+// resources unless WithNamespace is set. The options apply to this call only. Targets which are not served by the
+// cluster are skipped. Listing errors abort the call, while failures of single instances are returned as RemovalError
+// after all instances were processed.
+func (h *FinalizersHandler) RemoveFinalizers(ctx context.Context, targets []schema.GroupVersionResource, opts ...Option) (*Result, error) {
+	call, err := h.withOptions(opts)
+	if err != nil {
+		return &Result{}, err
+	}
+
+	result := &Result{}
+	var failures []Failure
+	for _, gvr := range targets {
+		target, err := call.removeFinalizersOf(ctx, gvr)
+		result.Targets = append(result.Targets, target)
+		if err != nil {
+			return result, err
+		}
+		failures = append(failures, target.Failures...)
+	}
+	if len(failures) > 0 {
+		return result, &RemovalError{Failures: failures}
+	}
+	return result, nil
+}
+
+// RemoveInstanceFinalizers removes the matched finalizers from a single instance of the resource, e.g. one which was
+// watched, and returns the number of removed finalizers. The options apply to this call only.
+func (h *FinalizersHandler) RemoveInstanceFinalizers(ctx context.Context, gvr schema.GroupVersionResource,
+	instance unstructured.Unstructured, opts ...Option) (int, error) {
+	call, err := h.withOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+	return call.removeInstanceFinalizers(ctx, gvr, instance)
+}
+
+// withOptions returns a copy of the handler with the options of a call applied
+func (h *FinalizersHandler) withOptions(opts []Option) (*FinalizersHandler, error) {
+	call := *h
+	for _, opt := range opts {
+		opt(&call)
+	}
+	if call.matcher == nil && call.remover == nil {
+		return nil, errors.New("no finalizer matcher set")
+	}
+	return &call, nil
+}
+
+func (h *FinalizersHandler) removeFinalizersOf(ctx context.Context, gvr schema.GroupVersionResource) (TargetResult, error) {
+	result := TargetResult{GVR: gvr}
+	if h.backup != nil {
+		if err := h.backup(ctx, gvr); err != nil {
+			return result, err
+		}
+	}
+	list, err := h.list(ctx, gvr)
+	if apierr.IsNotFound(err) {
+		h.logger.Debugf("Resource %s is not served, skipping it", gvr.String())
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Instances = len(list.Items)
+	for i := range list.Items {
+		instance := InstanceResult{GVR: gvr, Instance: list.Items[i]}
+		if h.predicate != nil {
+			process, err := h.predicate(ctx, gvr, instance.Instance)
+			if err != nil {
+				return result, err
+			}
+			instance.Skipped = !process
+		}
+		if !instance.Skipped {
+			instance.RemovedFinalizers, instance.Err = h.removeInstanceFinalizers(ctx, gvr, instance.Instance)
+		}
+		if instance.Err != nil {
+			failure := Failure{GVR: gvr, Namespace: instance.Instance.GetNamespace(), Name: instance.Instance.GetName(), Err: instance.Err}
+			h.logger.Warnf("Failed to remove finalizers: %s", failure.Error())
+			result.Failures = append(result.Failures, failure)
+		}
+		result.RemovedFinalizers += instance.RemovedFinalizers
+		if h.progress != nil {
+			if err := h.progress(instance); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func (h *FinalizersHandler) list(ctx context.Context, gvr schema.GroupVersionResource) (*unstructured.UnstructuredList, error) {
+	if h.lister != nil {
+		return h.lister(ctx, gvr)
+	}
+	backoff := k8sRetry.DefaultBackoff
+	backoff.Steps = h.listAttempts
+	var list *unstructured.UnstructuredList
+	err := retry.OnTransient(ctx, backoff, func() (err error) {
+		list, err = h.client.Resource(gvr).Namespace(h.namespace).List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil && !apierr.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "failed to list %s", gvr.String())
+	}
+	return list, err
+}
+
+// removeInstanceFinalizers prepares and writes the instance until the write succeeds or fails with an error which
+// is not retried. By default every attempt reads the latest version of the instance, so that conflicting updates
+// and other transient errors are resolved by retrying.
+func (h *FinalizersHandler) removeInstanceFinalizers(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured) (int, error) {
+	validate := h.validator
+	if validate == nil {
+		validate = func(_ schema.GroupVersionResource, instance unstructured.Unstructured) error {
+			return ValidateInstance(instance)
+		}
+	}
+	if err := validate(gvr, instance); err != nil {
+		return 0, err
+	}
+	remove := h.remover
+	if remove == nil {
+		remove = h.removeMatched
+	}
+	write := h.writer
+	if write == nil {
+		write = h.update
+	}
+
+	removed := 0
+	attempt := 0
+	err := retry.OnError(ctx, h.sleep, k8sRetry.DefaultRetry, h.retriableAttempt, func() error {
+		removed = 0
+		res, matched, err := remove(ctx, gvr, instance, attempt)
+		attempt++
+		if err != nil || res == nil {
+			return err
+		}
+		if h.throttle != nil {
+			if err := h.throttle(ctx); err != nil {
+				return err
+			}
+		}
+		if err := write(ctx, gvr, res); err != nil {
+			return err
+		}
+		removed = matched
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "removing finalizers failed")
+	}
+	if removed > 0 {
+		h.logger.Debugf("Removed %d finalizers of \"%s\" %s", removed, instance.GetName(), gvr.String())
+	}
+	return removed, nil
+}
+
+// retriableAttempt reports whether a failed attempt is retried, which consumes the retry budget for conflicts
+func (h *FinalizersHandler) retriableAttempt(err error) bool {
+	if !h.retriable(err) {
+		return false
+	}
+	return !apierr.IsConflict(err) || h.budget == nil || h.budget.Take()
+}
+
+// removeMatched reads the latest version of the instance and drops the matched finalizers, a malformed finalizers
+// field is reset to an empty list
+func (h *FinalizersHandler) removeMatched(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured,
+	_ int) (*unstructured.Unstructured, int, error) {
+	res, err := h.client.Resource(gvr).Namespace(instance.GetNamespace()).Get(ctx, instance.GetName(), metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if HasMalformedFinalizers(*res) {
+		h.logger.Warnf("Found malformed finalizers field on \"%s\" %s, resetting it to an empty list", res.GetName(), gvr.String())
+		if err := unstructured.SetNestedStringSlice(res.Object, []string{}, "metadata", "finalizers"); err != nil {
+			return nil, 0, errors.Wrap(err, "failed to repair malformed finalizers field")
+		}
+		return res, 0, nil
+	}
+	remaining, matched := Split(res.GetFinalizers(), h.matcher)
+	if len(matched) == 0 {
+		return nil, 0, nil
+	}
+	res.SetFinalizers(remaining)
+	return res, len(matched), nil
+}
+
+func (h *FinalizersHandler) update(ctx context.Context, gvr schema.GroupVersionResource, res *unstructured.Unstructured) error {
+	_, err := h.client.Resource(gvr).Namespace(res.GetNamespace()).Update(ctx, res, metav1.UpdateOptions{})
+	if apierr.IsNotFound(err) {
+		// the instance was deleted after it was read, which is what removing its finalizers is meant to achieve
+		return nil
+	}
+	return err
+}
+
+// Split separates the finalizers matched by the matcher from the remaining ones. Both are nil if empty.
+func Split(finalizers []string, matcher *regexp.Regexp) (remaining, matched []string) {
+	for _, finalizer := range finalizers {
+		if matcher.MatchString(finalizer) {
+			matched = append(matched, finalizer)
+		} else {
+			remaining = append(remaining, finalizer)
+		}
+	}
+	return remaining, matched
+}
+
+// ValidateInstance checks that the metadata relied on for removing finalizers is present and well-formed
+func ValidateInstance(instance unstructured.Unstructured) error {
+	metadata, found, err := unstructured.NestedFieldNoCopy(instance.Object, "metadata")
+	if err != nil || !found {
+		return errors.New("metadata is missing")
+	}
+	if _, ok := metadata.(map[string]interface{}); !ok {
+		return errors.Errorf("metadata is of unexpected type %T", metadata)
+	}
+	if name, _, err := unstructured.NestedString(instance.Object, "metadata", "name"); err != nil || name == "" {
+		return errors.New("metadata.name is missing or not a string")
+	}
+	return nil
+}
+
+// HasMalformedFinalizers reports whether the raw finalizers field exists but is not a list of strings.
+// GetFinalizers silently returns nothing for a malformed field, which would leave the resource stuck forever.
+func HasMalformedFinalizers(res unstructured.Unstructured) bool {
+	_, _, err := unstructured.NestedStringSlice(res.Object, "metadata", "finalizers")
+	return err != nil
+}
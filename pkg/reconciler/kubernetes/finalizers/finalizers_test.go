@@ -0,0 +1,301 @@
+package finalizers
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	virtualServiceGVR = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "virtualservices"}
+	functionGVR       = schema.GroupVersionResource{Group: "serverless.kyma-project.io", Version: "v1alpha2", Resource: "functions"}
+	clusterRoleGVR    = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+	istioMatcher      = regexp.MustCompile(`^(networking\.)?istio\.io/`)
+)
+
+func Test_RemoveFinalizers(t *testing.T) {
+	t.Run("should remove only the matched finalizers of all targets", func(t *testing.T) {
+		// given
+		client := newFakeClient(
+			fixInstance(virtualServiceGVR, "VirtualService", "default", "vs1", "istio.io/cleanup", "kept"),
+			fixInstance(virtualServiceGVR, "VirtualService", "istio-system", "vs2", "networking.istio.io/cleanup"),
+			fixInstance(functionGVR, "Function", "default", "fn", "istio.io/cleanup"),
+		)
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR, functionGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.Targets, 2)
+		require.Equal(t, 2, result.Targets[0].Instances)
+		require.Equal(t, 2, result.Targets[0].RemovedFinalizers)
+		require.Equal(t, 1, result.Targets[1].RemovedFinalizers)
+		require.Equal(t, 3, result.RemovedFinalizers())
+		requireFinalizers(t, client, virtualServiceGVR, "default", "vs1", "kept")
+		requireFinalizers(t, client, virtualServiceGVR, "istio-system", "vs2")
+		requireFinalizers(t, client, functionGVR, "default", "fn")
+	})
+
+	t.Run("should remove finalizers of cluster-scoped resources", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(clusterRoleGVR, "ClusterRole", "", "role", "istio.io/cleanup"))
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{clusterRoleGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireFinalizers(t, client, clusterRoleGVR, "", "role")
+	})
+
+	t.Run("should not update instances without matched finalizers", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(functionGVR, "Function", "default", "fn", "serverless.kyma-project.io/deletion-hook"))
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{functionGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, result.RemovedFinalizers())
+		require.Zero(t, countVerb(client, "update"))
+	})
+
+	t.Run("should remove finalizers only in the given namespace", func(t *testing.T) {
+		// given
+		client := newFakeClient(
+			fixInstance(virtualServiceGVR, "VirtualService", "default", "vs1", "istio.io/cleanup"),
+			fixInstance(virtualServiceGVR, "VirtualService", "istio-system", "vs2", "istio.io/cleanup"),
+		)
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR},
+			WithNamespace("istio-system"))
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.Targets[0].Instances)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireFinalizers(t, client, virtualServiceGVR, "default", "vs1", "istio.io/cleanup")
+		requireFinalizers(t, client, virtualServiceGVR, "istio-system", "vs2")
+	})
+
+	t.Run("should apply the options of a call only to this call", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(functionGVR, "Function", "default", "fn", "serverless.kyma-project.io/deletion-hook"))
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{functionGVR},
+			WithMatcher(regexp.MustCompile(`^serverless\.kyma-project\.io/`)))
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, istioMatcher, handler.matcher)
+	})
+
+	t.Run("should retry a conflicting update with the latest version", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(virtualServiceGVR, "VirtualService", "default", "vs", "istio.io/cleanup"))
+		updates := 0
+		client.PrependReactor("update", virtualServiceGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			updates++
+			if updates == 1 {
+				return true, nil, apierr.NewConflict(virtualServiceGVR.GroupResource(), "vs", errors.New("modified"))
+			}
+			return false, nil, nil
+		})
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, updates)
+		require.Equal(t, 2, countVerb(client, "get"))
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireFinalizers(t, client, virtualServiceGVR, "default", "vs")
+	})
+
+	t.Run("should retry an update failing with a transient error", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(virtualServiceGVR, "VirtualService", "default", "vs", "istio.io/cleanup"))
+		updates := 0
+		client.PrependReactor("update", virtualServiceGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			updates++
+			if updates == 1 {
+				return true, nil, apierr.NewServiceUnavailable("etcd leader changed")
+			}
+			return false, nil, nil
+		})
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, updates)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		requireFinalizers(t, client, virtualServiceGVR, "default", "vs")
+	})
+
+	t.Run("should retry listing on transient errors", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(virtualServiceGVR, "VirtualService", "default", "vs", "istio.io/cleanup"))
+		lists := 0
+		client.PrependReactor("list", virtualServiceGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lists++
+			if lists == 1 {
+				return true, nil, apierr.NewServiceUnavailable("etcd leader changed")
+			}
+			return false, nil, nil
+		})
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, lists)
+		require.Equal(t, 1, result.RemovedFinalizers())
+	})
+
+	t.Run("should skip targets which are not served", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(functionGVR, "Function", "default", "fn", "istio.io/cleanup"))
+		client.PrependReactor("list", virtualServiceGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewNotFound(virtualServiceGVR.GroupResource(), "")
+		})
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR, functionGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, result.Targets, 2)
+		require.Equal(t, 1, result.RemovedFinalizers())
+	})
+
+	t.Run("should process all instances when some of them fail", func(t *testing.T) {
+		// given
+		client := newFakeClient(
+			fixInstance(virtualServiceGVR, "VirtualService", "default", "vs1", "istio.io/cleanup"),
+			fixInstance(virtualServiceGVR, "VirtualService", "default", "vs2", "istio.io/cleanup"),
+		)
+		client.PrependReactor("update", virtualServiceGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured).GetName() == "vs1" {
+				return true, nil, apierr.NewForbidden(virtualServiceGVR.GroupResource(), "vs1", errors.New("rbac"))
+			}
+			return false, nil, nil
+		})
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR})
+
+		// then
+		require.True(t, IsRemovalError(err))
+		require.Contains(t, err.Error(), `networking.istio.io/v1beta1, Resource=virtualservices "default/vs1"`)
+		require.Len(t, result.Targets[0].Failures, 1)
+		require.True(t, apierr.IsForbidden(result.Targets[0].Failures[0].Err))
+		requireFinalizers(t, client, virtualServiceGVR, "default", "vs1", "istio.io/cleanup")
+		requireFinalizers(t, client, virtualServiceGVR, "default", "vs2")
+	})
+
+	t.Run("should repair a malformed finalizers field", func(t *testing.T) {
+		// given
+		instance := fixInstance(functionGVR, "Function", "default", "fn")
+		instance.Object["metadata"].(map[string]interface{})["finalizers"] = "istio.io/cleanup"
+		client := newFakeClient(instance)
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher))
+
+		// when
+		_, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{functionGVR})
+
+		// then
+		require.NoError(t, err)
+		requireFinalizers(t, client, functionGVR, "default", "fn")
+	})
+
+	t.Run("should fail without a matcher", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(functionGVR, "Function", "default", "fn", "istio.io/cleanup"))
+		handler := NewFinalizersHandler(client)
+
+		// when
+		_, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{functionGVR})
+
+		// then
+		require.EqualError(t, err, "no finalizer matcher set")
+		require.Empty(t, client.Actions())
+	})
+}
+
+func Test_Split(t *testing.T) {
+	remaining, matched := Split([]string{"istio.io/cleanup", "kept", "networking.istio.io/cleanup"}, istioMatcher)
+	require.Equal(t, []string{"kept"}, remaining)
+	require.Equal(t, []string{"istio.io/cleanup", "networking.istio.io/cleanup"}, matched)
+
+	remaining, matched = Split([]string{"istio.io/cleanup"}, istioMatcher)
+	require.Nil(t, remaining)
+	require.Equal(t, []string{"istio.io/cleanup"}, matched)
+}
+
+func newFakeClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		virtualServiceGVR: "VirtualServiceList",
+		functionGVR:       "FunctionList",
+		clusterRoleGVR:    "ClusterRoleList",
+	}, objects...)
+}
+
+func fixInstance(gvr schema.GroupVersionResource, kind, namespace, name string, finalizers ...string) *unstructured.Unstructured {
+	res := &unstructured.Unstructured{}
+	res.SetAPIVersion(gvr.GroupVersion().String())
+	res.SetKind(kind)
+	res.SetNamespace(namespace)
+	res.SetName(name)
+	res.SetFinalizers(finalizers)
+	return res
+}
+
+func requireFinalizers(t *testing.T, client *dynamicfake.FakeDynamicClient, gvr schema.GroupVersionResource, namespace, name string, expected ...string) {
+	res, err := client.Resource(gvr).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	if len(expected) == 0 {
+		require.Empty(t, res.GetFinalizers())
+		return
+	}
+	require.Equal(t, expected, res.GetFinalizers())
+}
+
+func countVerb(client *dynamicfake.FakeDynamicClient, verb string) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == verb {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,137 @@
+package finalizers
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Lister lists the instances of a target. A NotFound error skips the target as not served.
+type Lister func(ctx context.Context, gvr schema.GroupVersionResource) (*unstructured.UnstructuredList, error)
+
+// Backup saves the instances of a target before their finalizers are removed, its error aborts the call
+type Backup func(ctx context.Context, gvr schema.GroupVersionResource) error
+
+// Predicate decides whether the finalizers of a listed instance are removed, its error aborts the call
+type Predicate func(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured) (bool, error)
+
+// Validator checks a listed instance before its finalizers are removed, the instance fails with its error
+type Validator func(gvr schema.GroupVersionResource, instance unstructured.Unstructured) error
+
+// Remover prepares an attempt of removing the finalizers of an instance, counted from 0. It returns the modified
+// instance to write and the number of finalizers the write removes, or nil if nothing needs to be written.
+type Remover func(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured,
+	attempt int) (*unstructured.Unstructured, int, error)
+
+// Writer writes an instance modified by the remover. A NotFound error must be ignored by the writer, the instance
+// was deleted then, which is what removing its finalizers is meant to achieve.
+type Writer func(ctx context.Context, gvr schema.GroupVersionResource, res *unstructured.Unstructured) error
+
+// Throttle is called before every write, e.g. to keep a minimum interval between the writes
+type Throttle func(ctx context.Context) error
+
+// RetryBudget caps the retries of conflicting writes of all instances
+type RetryBudget interface {
+	// Take reports whether another retry is allowed and consumes it
+	Take() bool
+}
+
+// Progress is called for every listed instance once its finalizers were removed or it was skipped. Its error stops
+// the call before the next instance.
+type Progress func(result InstanceResult) error
+
+// InstanceResult is the outcome of a single listed instance
+type InstanceResult struct {
+	GVR      schema.GroupVersionResource
+	Instance unstructured.Unstructured
+	// Skipped is set if the predicate rejected the instance
+	Skipped bool
+	// RemovedFinalizers is the number of finalizers removed from the instance
+	RemovedFinalizers int
+	// Err is the reason the finalizers of the instance could not be removed
+	Err error
+}
+
+// WithLister replaces the listing of the instances of a target, which lists them with the client by default
+func WithLister(lister Lister) Option {
+	return func(h *FinalizersHandler) {
+		h.lister = lister
+	}
+}
+
+// WithBackup saves the instances of every target before they are listed for removing their finalizers
+func WithBackup(backup Backup) Option {
+	return func(h *FinalizersHandler) {
+		h.backup = backup
+	}
+}
+
+// WithPredicate skips the listed instances the predicate rejects, all instances are processed by default
+func WithPredicate(predicate Predicate) Option {
+	return func(h *FinalizersHandler) {
+		h.predicate = predicate
+	}
+}
+
+// WithValidator replaces the check of the listed instances, ValidateInstance by default
+func WithValidator(validator Validator) Option {
+	return func(h *FinalizersHandler) {
+		h.validator = validator
+	}
+}
+
+// WithRemover replaces the preparation of the writes. By default every attempt reads the latest version of the
+// instance and drops the matched finalizers.
+func WithRemover(remover Remover) Option {
+	return func(h *FinalizersHandler) {
+		h.remover = remover
+	}
+}
+
+// WithWriter replaces the write of the modified instances, which updates them with the client by default
+func WithWriter(writer Writer) Option {
+	return func(h *FinalizersHandler) {
+		h.writer = writer
+	}
+}
+
+// WithThrottle delays every write by the throttle
+func WithThrottle(throttle Throttle) Option {
+	return func(h *FinalizersHandler) {
+		h.throttle = throttle
+	}
+}
+
+// WithRetriable selects the errors of an attempt which are retried, retry.IsRetriableError by default
+func WithRetriable(retriable func(error) bool) Option {
+	return func(h *FinalizersHandler) {
+		if retriable != nil {
+			h.retriable = retriable
+		}
+	}
+}
+
+// WithRetryBudget retries conflicts only as long as the budget allows, conflicts are retried without limit by default
+func WithRetryBudget(budget RetryBudget) Option {
+	return func(h *FinalizersHandler) {
+		h.budget = budget
+	}
+}
+
+// WithSleep replaces the sleep between the attempts, e.g. to sleep on a fake clock in tests
+func WithSleep(sleep func(ctx context.Context, d time.Duration) error) Option {
+	return func(h *FinalizersHandler) {
+		if sleep != nil {
+			h.sleep = sleep
+		}
+	}
+}
+
+// WithProgress reports the outcome of every listed instance
+func WithProgress(progress Progress) Option {
+	return func(h *FinalizersHandler) {
+		h.progress = progress
+	}
+}
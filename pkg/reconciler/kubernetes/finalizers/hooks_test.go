@@ -0,0 +1,186 @@
+package finalizers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_RemoveFinalizers_Hooks(t *testing.T) {
+	t.Run("should skip the instances rejected by the predicate and report every instance", func(t *testing.T) {
+		// given
+		client := newFakeClient(
+			fixInstance(virtualServiceGVR, "VirtualService", "default", "vs1", "istio.io/cleanup"),
+			fixInstance(virtualServiceGVR, "VirtualService", "istio-system", "vs2", "istio.io/cleanup"))
+		var reported []InstanceResult
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher),
+			WithPredicate(func(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured) (bool, error) {
+				return instance.GetNamespace() != "istio-system", nil
+			}),
+			WithProgress(func(result InstanceResult) error {
+				reported = append(reported, result)
+				return nil
+			}))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 2, result.Targets[0].Instances)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Len(t, reported, 2)
+		require.Equal(t, "vs1", reported[0].Instance.GetName())
+		require.False(t, reported[0].Skipped)
+		require.Equal(t, 1, reported[0].RemovedFinalizers)
+		require.Equal(t, "vs2", reported[1].Instance.GetName())
+		require.True(t, reported[1].Skipped)
+		requireFinalizers(t, client, virtualServiceGVR, "istio-system", "vs2", "istio.io/cleanup")
+	})
+
+	t.Run("should stop before the next instance when the progress fails", func(t *testing.T) {
+		// given
+		client := newFakeClient(
+			fixInstance(virtualServiceGVR, "VirtualService", "default", "vs1", "istio.io/cleanup"),
+			fixInstance(virtualServiceGVR, "VirtualService", "default", "vs2", "istio.io/cleanup"))
+		stopped := errors.New("stopped")
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher),
+			WithProgress(func(result InstanceResult) error { return stopped }))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{virtualServiceGVR})
+
+		// then
+		require.ErrorIs(t, err, stopped)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, 1, countVerb(client, "update"))
+	})
+
+	t.Run("should not list a target whose backup failed", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(functionGVR, "Function", "default", "fn", "istio.io/cleanup"))
+		backupErr := errors.New("backup failed")
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher),
+			WithBackup(func(ctx context.Context, gvr schema.GroupVersionResource) error { return backupErr }))
+
+		// when
+		_, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{functionGVR})
+
+		// then
+		require.ErrorIs(t, err, backupErr)
+		require.Empty(t, client.Actions())
+		requireFinalizers(t, client, functionGVR, "default", "fn", "istio.io/cleanup")
+	})
+
+	t.Run("should list, prepare and write the instances with the hooks", func(t *testing.T) {
+		// given
+		instance := fixInstance(functionGVR, "Function", "default", "fn", "istio.io/cleanup", "kept")
+		var written []string
+		throttled := 0
+		handler := NewFinalizersHandler(nil,
+			WithLister(func(ctx context.Context, gvr schema.GroupVersionResource) (*unstructured.UnstructuredList, error) {
+				return &unstructured.UnstructuredList{Items: []unstructured.Unstructured{*instance}}, nil
+			}),
+			WithRemover(func(ctx context.Context, gvr schema.GroupVersionResource, instance unstructured.Unstructured,
+				attempt int) (*unstructured.Unstructured, int, error) {
+				res := instance.DeepCopy()
+				res.SetFinalizers([]string{"kept"})
+				return res, 1, nil
+			}),
+			WithThrottle(func(ctx context.Context) error {
+				throttled++
+				return nil
+			}),
+			WithWriter(func(ctx context.Context, gvr schema.GroupVersionResource, res *unstructured.Unstructured) error {
+				written = append(written, res.GetFinalizers()...)
+				return nil
+			}))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{functionGVR})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 1, result.RemovedFinalizers())
+		require.Equal(t, []string{"kept"}, written)
+		require.Equal(t, 1, throttled)
+	})
+
+	t.Run("should fail instances rejected by the validator", func(t *testing.T) {
+		// given
+		client := newFakeClient(fixInstance(functionGVR, "Function", "default", "fn", "istio.io/cleanup"))
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher),
+			WithValidator(func(gvr schema.GroupVersionResource, instance unstructured.Unstructured) error {
+				return errors.New("not allowed")
+			}))
+
+		// when
+		result, err := handler.RemoveFinalizers(context.Background(), []schema.GroupVersionResource{functionGVR})
+
+		// then
+		require.True(t, IsRemovalError(err))
+		require.EqualError(t, result.Targets[0].Failures[0].Err, "not allowed")
+		require.Zero(t, countVerb(client, "update"))
+	})
+}
+
+func Test_RemoveInstanceFinalizers_RetryBudget(t *testing.T) {
+	t.Run("should retry conflicts only while the budget allows", func(t *testing.T) {
+		// given
+		instance := fixInstance(virtualServiceGVR, "VirtualService", "default", "vs", "istio.io/cleanup")
+		client := newFakeClient(instance)
+		client.PrependReactor("update", virtualServiceGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewConflict(virtualServiceGVR.GroupResource(), "vs", errors.New("modified"))
+		})
+		budget := &fixedBudget{retries: 2}
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher), WithRetryBudget(budget),
+			WithSleep(func(ctx context.Context, d time.Duration) error { return nil }))
+
+		// when
+		removed, err := handler.RemoveInstanceFinalizers(context.Background(), virtualServiceGVR, *instance)
+
+		// then
+		require.True(t, apierr.IsConflict(err))
+		require.Zero(t, removed)
+		require.Equal(t, 3, countVerb(client, "update"))
+		require.Zero(t, budget.retries)
+	})
+
+	t.Run("should retry only the errors accepted by the retriable check", func(t *testing.T) {
+		// given
+		instance := fixInstance(virtualServiceGVR, "VirtualService", "default", "vs", "istio.io/cleanup")
+		client := newFakeClient(instance)
+		client.PrependReactor("update", virtualServiceGVR.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierr.NewServiceUnavailable("etcd leader changed")
+		})
+		handler := NewFinalizersHandler(client, WithMatcher(istioMatcher), WithRetriable(apierr.IsConflict))
+
+		// when
+		_, err := handler.RemoveInstanceFinalizers(context.Background(), virtualServiceGVR, *instance)
+
+		// then
+		require.True(t, apierr.IsServiceUnavailable(err))
+		require.Equal(t, 1, countVerb(client, "update"))
+	})
+}
+
+// fixedBudget allows a fixed number of retries
+type fixedBudget struct {
+	retries int
+}
+
+func (b *fixedBudget) Take() bool {
+	if b.retries == 0 {
+		return false
+	}
+	b.retries--
+	return true
+}
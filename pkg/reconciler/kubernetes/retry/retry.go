@@ -0,0 +1,97 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// OnTransient calls fn until it succeeds, fails with an error which IsRetriableError rejects, or the steps of the
+// backoff are exhausted, returning the last error. A Retry-After delay sent by the API server extends the backoff
+// delay. The context interrupts the sleep between attempts, the returned error then wraps the context error.
+func OnTransient(ctx context.Context, backoff wait.Backoff, fn func() error) error {
+	return OnTransientWithClock(ctx, clock.RealClock{}, backoff, fn)
+}
+
+// OnTransientWithClock is OnTransient waiting for the timers of the clock between attempts, which allows tests to
+// step a fake clock instead of sleeping
+func OnTransientWithClock(ctx context.Context, clock clock.Clock, backoff wait.Backoff, fn func() error) error {
+	return OnError(ctx, SleepOnClock(clock), backoff, IsRetriableError, fn)
+}
+
+// IsRetriableError reports whether a failed API call is likely to succeed on a subsequent attempt. Retried are
+//   - Conflict, the next attempt is expected to read the latest version
+//   - TooManyRequests, after the Retry-After delay if the server sent one
+//   - ServerTimeout, Timeout, ServiceUnavailable and InternalError
+//   - EOF and connection resets of a connection which dropped
+//
+// All other errors are permanent, in particular Forbidden, Unauthorized, Invalid and NotFound.
+func IsRetriableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return apierr.IsConflict(err) ||
+		apierr.IsTooManyRequests(err) ||
+		apierr.IsServerTimeout(err) ||
+		apierr.IsTimeout(err) ||
+		apierr.IsServiceUnavailable(err) ||
+		apierr.IsInternalError(err) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		utilnet.IsProbableEOF(err) ||
+		utilnet.IsConnectionReset(err)
+}
+
+// OnError is the retry loop of OnTransient for callers which classify errors themselves or sleep differently, e.g.
+// on a clock which is stepped by Sleep. It retries as long as retriable accepts the error of fn.
+func OnError(ctx context.Context, sleep func(context.Context, time.Duration) error, backoff wait.Backoff,
+	retriable func(error) bool, fn func() error) error {
+	steps := backoff.Steps
+	if steps < 1 {
+		steps = 1
+	}
+	var err error
+	for attempt := 0; attempt < steps; attempt++ {
+		if attempt > 0 {
+			if sleepErr := sleep(ctx, delay(&backoff, err)); sleepErr != nil {
+				return errors.Wrapf(sleepErr, "retry interrupted after %v", err)
+			}
+		}
+		if err = fn(); err == nil || attempt == steps-1 || !retriable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// SleepOnClock returns a sleep for the duration on the clock, which ends early with the context error when the
+// context is done
+func SleepOnClock(clock clock.Clock) func(context.Context, time.Duration) error {
+	return func(ctx context.Context, d time.Duration) error {
+		timer := clock.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C():
+			return nil
+		}
+	}
+}
+
+// delay returns the next delay of the backoff, or the delay requested by the API server if that is longer
+func delay(backoff *wait.Backoff, err error) time.Duration {
+	next := backoff.Step()
+	if seconds, ok := apierr.SuggestsClientDelay(err); ok {
+		if requested := time.Duration(seconds) * time.Second; requested > next {
+			return requested
+		}
+	}
+	return next
+}
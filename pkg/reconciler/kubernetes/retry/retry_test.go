@@ -0,0 +1,172 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+var clientGVR = schema.GroupVersionResource{Group: "hydra.ory.sh", Version: "v1alpha1", Resource: "oauth2clients"}
+
+func Test_IsRetriableError(t *testing.T) {
+	gr := clientGVR.GroupResource()
+	tests := []struct {
+		name      string
+		err       error
+		retriable bool
+	}{
+		{name: "conflict", err: apierr.NewConflict(gr, "client", errors.New("modified")), retriable: true},
+		{name: "too many requests", err: apierr.NewTooManyRequests("slow down", 1), retriable: true},
+		{name: "server timeout", err: apierr.NewServerTimeout(gr, "list", 1), retriable: true},
+		{name: "timeout", err: apierr.NewTimeoutError("request timed out", 1), retriable: true},
+		{name: "service unavailable", err: apierr.NewServiceUnavailable("etcd leader changed"), retriable: true},
+		{name: "internal error", err: apierr.NewInternalError(errors.New("boom")), retriable: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, retriable: true},
+		{name: "wrapped EOF", err: errors.Wrap(io.EOF, "list failed"), retriable: true},
+		{name: "connection reset", err: errors.Wrap(syscall.ECONNRESET, "read tcp"), retriable: true},
+		{name: "forbidden", err: apierr.NewForbidden(gr, "client", errors.New("rbac"))},
+		{name: "unauthorized", err: apierr.NewUnauthorized("expired")},
+		{name: "invalid", err: apierr.NewInvalid(clientGVR.GroupVersion().WithKind("OAuth2Client").GroupKind(), "client", nil)},
+		{name: "not found", err: apierr.NewNotFound(gr, "client")},
+		{name: "bad request", err: apierr.NewBadRequest("malformed")},
+		{name: "other", err: errors.New("unknown")},
+		{name: "nil", err: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.retriable, IsRetriableError(tt.err))
+		})
+	}
+}
+
+func Test_OnTransient(t *testing.T) {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 5}
+
+	t.Run("should retry retriable errors until the call succeeds", func(t *testing.T) {
+		// given
+		calls := 0
+
+		// when
+		err := OnTransient(context.Background(), backoff, func() error {
+			if calls++; calls < 3 {
+				return apierr.NewServiceUnavailable("etcd leader changed")
+			}
+			return nil
+		})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("should return permanent errors without retrying", func(t *testing.T) {
+		// given
+		calls := 0
+
+		// when
+		err := OnTransient(context.Background(), backoff, func() error {
+			calls++
+			return apierr.NewForbidden(clientGVR.GroupResource(), "client", errors.New("rbac"))
+		})
+
+		// then
+		require.True(t, apierr.IsForbidden(err))
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("should return the last error when the steps are exhausted", func(t *testing.T) {
+		// given
+		calls := 0
+
+		// when
+		err := OnTransient(context.Background(), backoff, func() error {
+			calls++
+			return apierr.NewConflict(clientGVR.GroupResource(), "client", errors.New("modified"))
+		})
+
+		// then
+		require.True(t, apierr.IsConflict(err))
+		require.Equal(t, 5, calls)
+	})
+
+	t.Run("should wait for the delay requested by the server", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		var attempts []time.Time
+		done := make(chan error)
+
+		// when
+		go func() {
+			done <- OnTransientWithClock(context.Background(), fakeClock, backoff, func() error {
+				if attempts = append(attempts, fakeClock.Now()); len(attempts) == 1 {
+					return apierr.NewTooManyRequests("slow down", 1)
+				}
+				return nil
+			})
+		}()
+		require.Eventually(t, fakeClock.HasWaiters, time.Second, time.Millisecond)
+		fakeClock.Step(500 * time.Millisecond)
+		require.True(t, fakeClock.HasWaiters())
+		fakeClock.Step(500 * time.Millisecond)
+
+		// then
+		require.NoError(t, <-done)
+		require.Len(t, attempts, 2)
+		require.Equal(t, time.Second, attempts[1].Sub(attempts[0]))
+	})
+
+	t.Run("should back off on the clock between attempts", func(t *testing.T) {
+		// given
+		fakeClock := testingclock.NewFakeClock(time.Now())
+		start := fakeClock.Now()
+		calls := 0
+		done := make(chan error)
+
+		// when
+		go func() {
+			done <- OnTransientWithClock(context.Background(), fakeClock,
+				wait.Backoff{Duration: time.Minute, Factor: 2, Steps: 3}, func() error {
+					calls++
+					return apierr.NewServiceUnavailable("etcd leader changed")
+				})
+		}()
+		for _, delay := range []time.Duration{time.Minute, 2 * time.Minute} {
+			require.Eventually(t, fakeClock.HasWaiters, time.Second, time.Millisecond)
+			fakeClock.Step(delay)
+		}
+
+		// then
+		require.True(t, apierr.IsServiceUnavailable(<-done))
+		require.Equal(t, 3, calls)
+		require.Equal(t, 3*time.Minute, fakeClock.Since(start))
+	})
+
+	t.Run("should interrupt the backoff when the context is done", func(t *testing.T) {
+		// given
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+
+		// when
+		err := OnTransient(ctx, wait.Backoff{Duration: time.Hour, Steps: 2}, func() error {
+			calls++
+			return apierr.NewServiceUnavailable("etcd leader changed")
+		})
+
+		// then
+		require.ErrorIs(t, err, context.Canceled)
+		require.Contains(t, err.Error(), "etcd leader changed")
+		require.Equal(t, 1, calls)
+		require.Less(t, time.Since(start), 10*time.Second)
+	})
+}